@@ -1,3 +1,3 @@
 module github.com/geofduf/run-length
 
-go 1.19
+go 1.23
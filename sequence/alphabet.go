@@ -0,0 +1,160 @@
+package sequence
+
+import (
+	"errors"
+	"time"
+)
+
+// An Alphabet maps a set of user defined states to sequence values. It allows
+// multi-state users to work with their own representation of states instead of
+// raw uint8 values.
+type Alphabet[T comparable] struct {
+	codes  map[T]uint8
+	states []T
+	width  uint8
+}
+
+// NewAlphabet creates an Alphabet using unknown as the state associated to
+// StateUnknown, the value used to fill gaps in sequences, and states as the other
+// states. The first two states are associated to StateInactive and StateActive,
+// the next ones to the values following StateUnknown, in order. The alphabet uses
+// the smallest state width able to represent all states. It returns an error if
+// states are not unique or if there are less than 2 or more than 15 of them.
+func NewAlphabet[T comparable](unknown T, states ...T) (*Alphabet[T], error) {
+	n := len(states)
+	if n < 2 || n >= 1<<MaxStateWidth {
+		return nil, errors.New("invalid number of states")
+	}
+	a := Alphabet[T]{
+		codes:  make(map[T]uint8, n+1),
+		states: make([]T, n+1),
+		width:  MinStateWidth,
+	}
+	for n > 1<<a.width-1 {
+		a.width++
+	}
+	for i, x := range append([]T{unknown}, states...) {
+		code := StateUnknown
+		if i > 0 {
+			code = uint8(i - 1)
+			if code >= StateUnknown {
+				code++
+			}
+		}
+		if _, ok := a.codes[x]; ok {
+			return nil, errors.New("duplicate state")
+		}
+		a.codes[x] = code
+		a.states[code] = x
+	}
+	return &a, nil
+}
+
+// Encode returns the value associated to x. It returns an error if x is not
+// part of the alphabet.
+func (a *Alphabet[T]) Encode(x T) (uint8, error) {
+	v, ok := a.codes[x]
+	if !ok {
+		return 0, errors.New("unknown state")
+	}
+	return v, nil
+}
+
+// Decode returns the state associated to v. The second return value is
+// false if v is not associated to a state.
+func (a *Alphabet[T]) Decode(v uint8) (T, bool) {
+	if int(v) >= len(a.states) {
+		var zero T
+		return zero, false
+	}
+	return a.states[v], true
+}
+
+// Width returns the number of bits required to represent the states of
+// the alphabet.
+func (a *Alphabet[T]) Width() uint8 {
+	return a.width
+}
+
+// Wrap returns a TypedSequence backed by s. It returns an error if the
+// width of s is not large enough to represent the states of a.
+func (a *Alphabet[T]) Wrap(s *Sequence) (*TypedSequence[T], error) {
+	if s.Width() < a.width {
+		return nil, errors.New("invalid state width")
+	}
+	return &TypedSequence[T]{s: s, a: a}, nil
+}
+
+// A TypedSequence wraps a Sequence whose values are the states of an Alphabet.
+type TypedSequence[T comparable] struct {
+	s *Sequence
+	a *Alphabet[T]
+}
+
+// NewTyped creates and initializes a new TypedSequence using t rounded down
+// to the second as its reference timestamp, f as its frequency in seconds and
+// a as its alphabet. The sequence frequency will default to 1 if set to 0.
+func NewTyped[T comparable](t time.Time, f uint16, a *Alphabet[T]) *TypedSequence[T] {
+	s := New(t, f)
+	if a.width != flagBits {
+		s.width = a.width
+	}
+	return &TypedSequence[T]{s: s, a: a}
+}
+
+// Add executes Sequence.Add() using the value associated to x, returning an
+// error if x is not part of the alphabet or if the underlying operation returned
+// an error.
+func (s *TypedSequence[T]) Add(t time.Time, x T) error {
+	v, err := s.a.Encode(x)
+	if err != nil {
+		return err
+	}
+	return s.s.Add(t, v)
+}
+
+// Roll executes Sequence.Roll() using the value associated to x, returning an
+// error if x is not part of the alphabet or if the underlying operation returned
+// an error.
+func (s *TypedSequence[T]) Roll(t time.Time, x T) error {
+	v, err := s.a.Encode(x)
+	if err != nil {
+		return err
+	}
+	return s.s.Roll(t, v)
+}
+
+// All returns the states stored in the sequence. Values that are not associated
+// to a state are reported as the unknown state of the alphabet.
+func (s *TypedSequence[T]) All() []T {
+	return s.decode(s.s.All())
+}
+
+// Values executes Sequence.Values() and returns the states associated to the
+// resulting values. Values that are not associated to a state are reported as the
+// unknown state of the alphabet.
+func (s *TypedSequence[T]) Values(start, end time.Time) ([]T, int64, error) {
+	values, ts, err := s.s.Values(start, end)
+	if err != nil {
+		return []T{}, 0, err
+	}
+	return s.decode(values), ts, nil
+}
+
+// Sequence returns the underlying sequence.
+func (s *TypedSequence[T]) Sequence() *Sequence {
+	return s.s
+}
+
+// decode converts values to states.
+func (s *TypedSequence[T]) decode(values []uint8) []T {
+	states := make([]T, len(values))
+	for i, v := range values {
+		x, ok := s.a.Decode(v)
+		if !ok {
+			x = s.a.states[StateUnknown]
+		}
+		states[i] = x
+	}
+	return states
+}
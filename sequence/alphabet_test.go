@@ -0,0 +1,113 @@
+package sequence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewAlphabet(t *testing.T) {
+	tests := []struct {
+		id     int
+		states []string
+		width  uint8
+		err    bool
+	}{
+		{1, []string{"down"}, 0, true},
+		{2, []string{"down", "up"}, 2, false},
+		{3, []string{"down", "up", "maintenance"}, 2, false},
+		{4, []string{"critical", "ok", "warning", "maintenance"}, 3, false},
+		{5, []string{"a", "b", "c", "d", "e", "f", "g", "h"}, 4, false},
+		{6, []string{"down", "up", "down"}, 0, true},
+		{7, []string{"down", "unknown"}, 0, true},
+		{8, make([]string, 16), 0, true},
+	}
+	for _, tt := range tests {
+		a, err := NewAlphabet("unknown", tt.states...)
+		if err != nil {
+			if !tt.err {
+				t.Fatalf("test %d: got error %s, want error nil", tt.id, err)
+			}
+			continue
+		}
+		if tt.err {
+			t.Fatalf("test %d: got error nil, want non nil error", tt.id)
+		}
+		if got := a.Width(); got != tt.width {
+			t.Fatalf("test %d: got %d, want %d", tt.id, got, tt.width)
+		}
+	}
+}
+
+func TestAlphabetEncodeDecode(t *testing.T) {
+	a, _ := NewAlphabet("unknown", "critical", "ok", "warning", "maintenance")
+	tests := []struct {
+		state string
+		value uint8
+	}{
+		{"critical", StateInactive},
+		{"ok", StateActive},
+		{"unknown", StateUnknown},
+		{"warning", 3},
+		{"maintenance", 4},
+	}
+	for _, tt := range tests {
+		v, err := a.Encode(tt.state)
+		if err != nil {
+			t.Fatalf("%s: got error %s, want error nil", tt.state, err)
+		}
+		if v != tt.value {
+			t.Fatalf("%s: got %d, want %d", tt.state, v, tt.value)
+		}
+		x, ok := a.Decode(tt.value)
+		if !ok || x != tt.state {
+			t.Fatalf("%d: got (%s, %t), want (%s, true)", tt.value, x, ok, tt.state)
+		}
+	}
+	if _, err := a.Encode("other"); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if _, ok := a.Decode(5); ok {
+		t.Fatal("got true, want false")
+	}
+}
+
+func TestTypedSequence(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	a, _ := NewAlphabet("unknown", "critical", "ok", "warning", "maintenance")
+	s := NewTyped(x, testSequenceFrequency, a)
+	if got := s.Sequence().Width(); got != 3 {
+		t.Fatalf("got %d, want 3", got)
+	}
+	states := []string{"ok", "warning", "", "critical", "maintenance"}
+	for i, state := range states {
+		if state == "" {
+			continue
+		}
+		if err := s.Add(shift(s.Sequence(), i, 0), state); err != nil {
+			t.Fatalf("got error %s, want error nil", err)
+		}
+	}
+	if err := s.Roll(shift(s.Sequence(), 5, 0), "other"); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	want := []string{"ok", "warning", "unknown", "critical", "maintenance"}
+	got := s.All()
+	if len(got) != len(want) {
+		t.Fatalf("\ngot  %v\nwant %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("\ngot  %v\nwant %v", got, want)
+		}
+	}
+	values, ts, err := s.Values(shift(s.Sequence(), 3, 0), shift(s.Sequence(), 4, 0))
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if len(values) != 2 || values[0] != "critical" || values[1] != "maintenance" || ts != shift(s.Sequence(), 3, 0).Unix() {
+		t.Fatalf("got (%v, %d)", values, ts)
+	}
+	if _, err := a.Wrap(New(x, testSequenceFrequency)); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
@@ -0,0 +1,118 @@
+package sequence
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// A KeyCodec encodes identifiers of type K as store keys.
+type KeyCodec[K any] interface {
+	// AppendKey appends the encoding of k to dst and returns the
+	// extended buffer. Distinct identifiers must result in distinct
+	// encodings.
+	AppendKey(dst []byte, k K) []byte
+}
+
+// The KeyCodecFunc type is an adapter to allow the use of ordinary functions
+// as key codecs.
+type KeyCodecFunc[K any] func(dst []byte, k K) []byte
+
+// AppendKey implements the KeyCodec interface.
+func (f KeyCodecFunc[K]) AppendKey(dst []byte, k K) []byte {
+	return f(dst, k)
+}
+
+// A KeyedStore provides access to the sequences of a Store using identifiers
+// of type K encoded by a KeyCodec. Identifiers are encoded in reusable buffers,
+// so that accessing existing sequences does not allocate, which makes it suitable
+// for hot paths dealing with composite identifiers. A KeyedStore can be used
+// simultaneously from multiple goroutines.
+type KeyedStore[K any] struct {
+	s     *Store
+	codec KeyCodec[K]
+	pool  sync.Pool
+}
+
+// NewKeyedStore creates and initializes a new KeyedStore backed by s, using
+// codec to encode identifiers.
+func NewKeyedStore[K any](s *Store, codec KeyCodec[K]) *KeyedStore[K] {
+	return &KeyedStore[K]{
+		s:     s,
+		codec: codec,
+		pool: sync.Pool{
+			New: func() any {
+				buf := make([]byte, 0, 64)
+				return &buf
+			},
+		},
+	}
+}
+
+// Store returns the underlying store.
+func (s *KeyedStore[K]) Store() *Store {
+	return s.s
+}
+
+// Add executes Store.Add() using the encoding of k as key.
+func (s *KeyedStore[K]) Add(k K, x *Sequence) {
+	buf := s.encode(k)
+	defer s.pool.Put(buf)
+	s.s.Add(string(*buf), x)
+}
+
+// Delete executes Store.Delete() using the encoding of k as key.
+func (s *KeyedStore[K]) Delete(k K) {
+	buf := s.encode(k)
+	defer s.pool.Put(buf)
+	s.s.Delete(string(*buf))
+}
+
+// Get executes Store.Get() using the encoding of k as key.
+func (s *KeyedStore[K]) Get(k K) (*Sequence, bool) {
+	buf := s.encode(k)
+	defer s.pool.Put(buf)
+	s.s.mu.RLock()
+	defer s.s.mu.RUnlock()
+	x, ok := s.s.m[string(*buf)]
+	if !ok {
+		return nil, false
+	}
+	mu := keyLock(s.s, *buf)
+	mu.RLock()
+	defer mu.RUnlock()
+	return x.clone(), true
+}
+
+// Query executes Store.Query() using the encoding of k as key.
+func (s *KeyedStore[K]) Query(k K, start time.Time, end time.Time, d time.Duration, opts ...QueryOption) (QuerySet, error) {
+	buf := s.encode(k)
+	defer s.pool.Put(buf)
+	s.s.mu.RLock()
+	defer s.s.mu.RUnlock()
+	x, ok := s.s.m[string(*buf)]
+	if !ok {
+		return QuerySet{}, errors.New("key does not exist")
+	}
+	mu := keyLock(s.s, *buf)
+	mu.RLock()
+	defer mu.RUnlock()
+	return x.Query(start, end, d, opts...)
+}
+
+// Execute executes Store.Execute() using the encoding of k as key. The Key
+// field of statement is ignored, unless hooks are set on the store, in which
+// case it is set to the encoding of k before calling them.
+func (s *KeyedStore[K]) Execute(k K, statement Statement) error {
+	buf := s.encode(k)
+	defer s.pool.Put(buf)
+	return execute(s.s, *buf, statement)
+}
+
+// encode returns a buffer from the pool holding the encoding of k. The caller
+// is responsible for returning the buffer to the pool.
+func (s *KeyedStore[K]) encode(k K) *[]byte {
+	buf := s.pool.Get().(*[]byte)
+	*buf = s.codec.AppendKey((*buf)[:0], k)
+	return buf
+}
@@ -0,0 +1,92 @@
+package sequence
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+type testKey struct {
+	tenant uint32
+	device uint32
+	check  string
+}
+
+var testKeyCodec = KeyCodecFunc[testKey](func(dst []byte, k testKey) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, k.tenant)
+	dst = binary.BigEndian.AppendUint32(dst, k.device)
+	return append(dst, k.check...)
+})
+
+func TestKeyedStore(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	f := testSequenceFrequency
+	store := NewKeyedStore[testKey](NewStore(), testKeyCodec)
+	k1 := testKey{1, 2, "icmp"}
+	k2 := testKey{1, 2, "http"}
+	statement := Statement{
+		Key:                 "ignored",
+		Timestamp:           x,
+		Value:               StateActive,
+		CreateIfNotExists:   true,
+		CreateWithTimestamp: x,
+		CreateWithFrequency: f,
+	}
+	if err := store.Execute(k1, statement); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	statement.Timestamp = x.Add(time.Duration(f) * time.Second)
+	statement.CreateIfNotExists = false
+	if err := store.Execute(k1, statement); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if err := store.Execute(k2, statement); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if n := len(store.Store().Keys()); n != 1 {
+		t.Fatalf("got %d, want 1", n)
+	}
+	want := NewWithValues(x, f, []uint8{StateActive, StateActive})
+	got, ok := store.Get(k1)
+	if !ok {
+		t.Fatal("key should exist in store")
+	}
+	if !assertSequencesEqual(got, want) {
+		t.Fatalf("\ngot  %+v\nwant %+v", got, want)
+	}
+	if _, ok := store.Get(k2); ok {
+		t.Fatal("key should not exist in store")
+	}
+	qs, err := store.Query(k1, x, x.Add(time.Hour), time.Hour)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if qs.Sum[0] != 2 || qs.Count[0] != 2 {
+		t.Fatalf("got %+v", qs)
+	}
+	if _, err := store.Query(k2, x, x.Add(time.Hour), time.Hour); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	store.Add(k2, want)
+	if _, ok := store.Get(k2); !ok {
+		t.Fatal("key should exist in store")
+	}
+	store.Delete(k1)
+	if _, ok := store.Get(k1); ok {
+		t.Fatal("key should not exist in store")
+	}
+}
+
+func TestKeyedStoreExecuteAllocs(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	store := NewKeyedStore[testKey](NewStore(), testKeyCodec)
+	k := testKey{1, 2, "icmp"}
+	store.Add(k, New(x, testSequenceFrequency))
+	statement := Statement{Timestamp: x.Add(-time.Hour), Value: StateActive}
+	n := testing.AllocsPerRun(100, func() {
+		store.Execute(k, statement)
+	})
+	if n > 1 {
+		t.Fatalf("got %f allocations, want at most 1", n)
+	}
+}
@@ -0,0 +1,211 @@
+package sequence
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"sort"
+	"time"
+)
+
+// A Conformance holds reference vectors for the binary formats of the package,
+// as described in FORMAT.md. It is meant to be exported as JSON and used to
+// validate compatible implementations in other languages.
+type Conformance struct {
+	Sequences []SequenceVector `json:"sequences"`
+	Dumps     []DumpVector     `json:"dumps"`
+}
+
+// A SequenceVector associates the content of a sequence to its representation
+// as a slice of bytes.
+type SequenceVector struct {
+	// Name identifies the vector.
+	Name string `json:"name"`
+
+	// Timestamp, Frequency, Length, Width and MaxAge specify
+	// the parameters of the sequence, MaxAge in seconds.
+	Timestamp int64  `json:"timestamp"`
+	Frequency uint16 `json:"frequency"`
+	Length    uint32 `json:"length"`
+	Width     uint8  `json:"width"`
+	MaxAge    int64  `json:"max_age"`
+
+	// Checksum specifies whether the bytes include a checksum.
+	Checksum bool `json:"checksum"`
+
+	// Legacy specifies whether the bytes use the legacy layout,
+	// which readers must accept but writers must not produce.
+	Legacy bool `json:"legacy"`
+
+	// Runs holds the content of the sequence as pairs of
+	// number of values and value.
+	Runs [][2]uint32 `json:"runs"`
+
+	// Bytes holds the hexadecimal encoding of the sequence
+	// represented as a slice of bytes.
+	Bytes string `json:"bytes"`
+}
+
+// A DumpVector associates the content of a store to its representation as
+// a slice of bytes. Records are sorted by key, although readers must not rely
+// on any order.
+type DumpVector struct {
+	// Name identifies the vector.
+	Name string `json:"name"`
+
+	// Keys holds the keys of the store and Sequences the
+	// associated sequences.
+	Keys      []string         `json:"keys"`
+	Sequences []SequenceVector `json:"sequences"`
+
+	// Deleted holds the keys deleted in an incremental dump,
+	// encoded after the records of the sequences.
+	Deleted []string `json:"deleted,omitempty"`
+
+	// Legacy specifies whether the dump uses the legacy layout,
+	// without header nor checksum, which readers must accept
+	// but writers must not produce.
+	Legacy bool `json:"legacy"`
+
+	// Bytes holds the hexadecimal encoding of the dump.
+	Bytes string `json:"bytes"`
+}
+
+// ConformanceVectors returns reference vectors covering the binary formats of
+// the package.
+func ConformanceVectors() Conformance {
+	t := time.Unix(946782245, 0)
+	type run struct {
+		offset int
+		count  uint32
+		value  uint8
+	}
+	sequences := []struct {
+		name   string
+		t      time.Time
+		f      uint16
+		length uint32
+		width  uint8
+		maxAge time.Duration
+		runs   []run
+		crc    bool
+	}{
+		{"empty", t, 60, 0, 2, 0, nil, false},
+		{"single-value", t, 60, 0, 2, 0, []run{{0, 1, StateActive}}, false},
+		{"runs", t, 60, 0, 2, 0, []run{{0, 5, StateActive}, {5, 5, StateInactive}, {10, 5, StateActive}, {15, 4, StateUnknown}, {19, 1, StateInactive}}, false},
+		{"gap", t, 300, 0, 2, 0, []run{{0, 3, StateInactive}, {10, 2, StateActive}}, false},
+		{"length", t, 60, 1440, 2, 0, []run{{0, 129, StateInactive}, {129, 1311, StateActive}}, false},
+		{"long-run", t, 1, 0, 2, 0, []run{{0, 3764899923, StateActive}}, false},
+		{"negative-timestamp", time.Unix(-86400, 0), 3600, 24, 2, 0, []run{{0, 12, StateActive}, {12, 12, StateInactive}}, false},
+		{"width-3", t, 60, 0, 3, 0, []run{{0, 2, 4}, {2, 16, 7}, {18, 1, StateUnknown}, {19, 3, 5}}, false},
+		{"width-4", t, 60, 0, 4, 0, []run{{0, 7, 12}, {7, 1, 15}, {8, 300, StateActive}}, false},
+		{"max-age", t, 300, 0, 2, 720 * time.Hour, []run{{0, 10, StateActive}, {10, 1, StateInactive}}, false},
+		{"checksum", t, 60, 0, 2, 0, []run{{0, 5, StateActive}, {5, 5, StateInactive}}, true},
+		{"checksum-fields", t, 60, 0, 3, time.Hour, []run{{0, 5, 6}, {5, 5, StateInactive}}, true},
+	}
+	var c Conformance
+	vectors := make(map[string]SequenceVector)
+	for _, v := range sequences {
+		s, _ := NewWithWidth(v.t, v.f, v.width)
+		if v.length > 0 {
+			s.SetLength(v.length)
+		}
+		s.SetMaxAge(v.maxAge)
+		for _, r := range v.runs {
+			ts := time.Unix(s.ts+int64(r.offset)*int64(s.frequency), 0)
+			if err := s.AddRun(ts, r.count, r.value); err != nil {
+				panic("sequence: invalid conformance vector " + v.name + ": " + err.Error())
+			}
+		}
+		x := newSequenceVector(v.name, s, v.crc)
+		vectors[v.name] = x
+		c.Sequences = append(c.Sequences, x)
+	}
+	for _, name := range []string{"runs", "width-3", "checksum"} {
+		x := vectors[name]
+		data, _ := hex.DecodeString(x.Bytes)
+		s, _ := FromBytes(data)
+		x.Name = "legacy-" + name
+		x.Legacy = true
+		x.Bytes = hex.EncodeToString(legacyBytes(s, x.Checksum))
+		c.Sequences = append(c.Sequences, x)
+	}
+	dumps := []struct {
+		name    string
+		m       map[string]string
+		deleted []string
+		legacy  bool
+	}{
+		{"empty", nil, nil, false},
+		{"single", map[string]string{"k1": "runs"}, nil, false},
+		{"multiple", map[string]string{"dc1.host1.icmp": "runs", "dc1.host2.icmp": "gap", "": "empty", "k4": "width-3"}, nil, false},
+		{"incremental", map[string]string{"k1": "runs"}, []string{"k2", "k3"}, false},
+		{"legacy-empty", nil, nil, true},
+		{"legacy-multiple", map[string]string{"k1": "runs", "k2": "gap"}, nil, true},
+	}
+	for _, v := range dumps {
+		x := DumpVector{Name: v.name, Keys: []string{}, Sequences: []SequenceVector{}, Deleted: v.deleted, Legacy: v.legacy}
+		for k := range v.m {
+			x.Keys = append(x.Keys, k)
+		}
+		sort.Strings(x.Keys)
+		var buf []byte
+		if !v.legacy {
+			buf = appendDumpHeader(buf)
+		}
+		for _, k := range x.Keys {
+			s := vectors[v.m[k]]
+			data, _ := hex.DecodeString(s.Bytes)
+			buf = appendRecord(buf, k, data)
+			x.Sequences = append(x.Sequences, s)
+		}
+		for _, k := range v.deleted {
+			buf = appendTombstone(buf, k)
+		}
+		if !v.legacy {
+			buf = appendDumpTrailer(buf)
+		}
+		x.Bytes = hex.EncodeToString(buf)
+		c.Dumps = append(c.Dumps, x)
+	}
+	return c
+}
+
+// legacyBytes returns s represented as a slice of bytes using the legacy layout,
+// including a checksum if checksum is true.
+func legacyBytes(s *Sequence, checksum bool) []byte {
+	data := s.Bytes()
+	data = append(data[:indexFrequency], data[indexFrequency+sizeVersion:]...)
+	if !checksum {
+		return data
+	}
+	size := 0
+	if n := len(data); n > indexData && data[n-1] >= 0x80 {
+		size = int(data[n-1] & 0x7f)
+		data = data[:n-1]
+	}
+	data = append(data, extChecksum)
+	data = binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+	return append(data, 0x80|byte(size+5))
+}
+
+// newSequenceVector returns the vector associated to s, including a checksum
+// in its bytes if crc is true.
+func newSequenceVector(name string, s *Sequence, crc bool) SequenceVector {
+	x := SequenceVector{
+		Name:      name,
+		Timestamp: s.ts,
+		Frequency: s.frequency,
+		Length:    s.length,
+		Width:     s.bits(),
+		MaxAge:    s.maxAge,
+		Runs:      [][2]uint32{},
+		Checksum:  crc,
+		Bytes:     hex.EncodeToString(s.appendBytes(nil, crc)),
+	}
+	s.walk(0, int64(s.count)-1, func(j, n int64, v uint8) bool {
+		x.Runs = append(x.Runs, [2]uint32{uint32(n), uint32(v)})
+		return true
+	})
+	return x
+}
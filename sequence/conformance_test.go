@@ -0,0 +1,89 @@
+package sequence
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestConformanceVectors(t *testing.T) {
+	c := ConformanceVectors()
+	if len(c.Sequences) == 0 || len(c.Dumps) == 0 {
+		t.Fatal("expected vectors")
+	}
+	for _, v := range c.Sequences {
+		assertSequenceVector(t, v)
+	}
+	for _, v := range c.Dumps {
+		data, err := hex.DecodeString(v.Bytes)
+		if err != nil {
+			t.Fatalf("%s: got error %s, want error nil", v.Name, err)
+		}
+		store := NewStore()
+		if err := store.Load(data); err != nil {
+			t.Fatalf("%s: got error %s, want error nil", v.Name, err)
+		}
+		if n, m := len(store.m), len(v.Keys); n != m {
+			t.Fatalf("%s: got %d, want %d", v.Name, n, m)
+		}
+		for i, k := range v.Keys {
+			s, ok := store.m[k]
+			if !ok {
+				t.Fatalf("%s: key %s should exist in store", v.Name, k)
+			}
+			if got, want := hex.EncodeToString(s.Bytes()), v.Sequences[i].Bytes; got != want {
+				t.Fatalf("%s:\ngot  %s\nwant %s", v.Name, got, want)
+			}
+		}
+		if len(v.Deleted) > 0 {
+			for _, k := range v.Deleted {
+				store.m[k] = New(time.Unix(v.Sequences[0].Timestamp, 0), 60)
+			}
+			if err := store.LoadIncremental(data); err != nil {
+				t.Fatalf("%s: got error %s, want error nil", v.Name, err)
+			}
+			for _, k := range v.Deleted {
+				if _, ok := store.m[k]; ok {
+					t.Fatalf("%s: key %s should not exist in store", v.Name, k)
+				}
+			}
+		}
+		if v.Legacy || len(v.Keys)+len(v.Deleted) > 1 {
+			continue
+		}
+		dump, _ := store.Dump()
+		if got := hex.EncodeToString(dump); got != v.Bytes {
+			t.Fatalf("%s:\ngot  %s\nwant %s", v.Name, got, v.Bytes)
+		}
+	}
+}
+
+func assertSequenceVector(t *testing.T, v SequenceVector) {
+	t.Helper()
+	data, err := hex.DecodeString(v.Bytes)
+	if err != nil {
+		t.Fatalf("%s: got error %s, want error nil", v.Name, err)
+	}
+	s, err := FromBytes(data)
+	if err != nil {
+		t.Fatalf("%s: got error %s, want error nil", v.Name, err)
+	}
+	if s.ts != v.Timestamp || s.frequency != v.Frequency || s.length != v.Length || s.Width() != v.Width || s.maxAge != v.MaxAge {
+		t.Fatalf("%s: got %+v, want %+v", v.Name, s, v)
+	}
+	want, _ := NewWithWidth(time.Unix(v.Timestamp, 0), v.Frequency, v.Width)
+	want.SetLength(v.Length)
+	want.SetMaxAge(time.Duration(v.MaxAge) * time.Second)
+	for _, r := range v.Runs {
+		want.addSeries(r[0], uint8(r[1]))
+	}
+	if !assertSequencesEqual(s, want) {
+		t.Fatalf("%s:\ngot  %+v\nwant %+v", v.Name, s, want)
+	}
+	if v.Legacy {
+		return
+	}
+	if got := hex.EncodeToString(want.appendBytes(nil, v.Checksum)); got != v.Bytes {
+		t.Fatalf("%s:\ngot  %s\nwant %s", v.Name, got, v.Bytes)
+	}
+}
@@ -0,0 +1,83 @@
+package sequence
+
+import (
+	"errors"
+	"math"
+)
+
+// Diff returns the periods, in chronological order, during which a and b hold
+// different values, including periods during which only one of them holds a known
+// value. Values that are not stored are considered as StateUnknown. It returns
+// an error if the sequences do not share the same frequency or if their values
+// are not aligned.
+func Diff(a, b *Sequence) ([]Period, error) {
+	f := int64(a.frequency)
+	if a.frequency != b.frequency || (a.ts-b.ts)%f != 0 {
+		return nil, errors.New("incompatible sequences")
+	}
+	periods := []Period{}
+	x, y := newCursor(a), newCursor(b)
+	t := a.ts
+	if b.ts < t {
+		t = b.ts
+	}
+	end := a.ts + int64(a.count)*f
+	if v := b.ts + int64(b.count)*f; v > end {
+		end = v
+	}
+	for t < end {
+		u, e1 := x.at(t)
+		v, e2 := y.at(t)
+		next := e1
+		if e2 < next {
+			next = e2
+		}
+		if next > end {
+			next = end
+		}
+		if u != v {
+			if n := len(periods); n > 0 && periods[n-1].End == t {
+				periods[n-1].End = next
+			} else {
+				periods = append(periods, Period{Start: t, End: next})
+			}
+		}
+		t = next
+	}
+	return periods, nil
+}
+
+// A cursor provides sequential access to the series of a sequence in terms
+// of time.
+type cursor struct {
+	series []Period
+	values []uint8
+	i      int
+}
+
+// newCursor returns a cursor positioned at the first series of s.
+func newCursor(s *Sequence) *cursor {
+	c := cursor{}
+	f := int64(s.frequency)
+	s.walk(0, int64(s.count)-1, func(j, n int64, v uint8) bool {
+		c.series = append(c.series, Period{Start: s.ts + j*f, End: s.ts + (j+n)*f})
+		c.values = append(c.values, v)
+		return true
+	})
+	return &c
+}
+
+// at returns the value at t and the end of the period during which it is
+// held. Calls must use non decreasing values of t.
+func (c *cursor) at(t int64) (uint8, int64) {
+	for c.i < len(c.series) && c.series[c.i].End <= t {
+		c.i++
+	}
+	if c.i == len(c.series) {
+		return StateUnknown, math.MaxInt64
+	}
+	if p := c.series[c.i]; p.Start > t {
+		return StateUnknown, p.Start
+	}
+	return c.values[c.i], c.series[c.i].End
+}
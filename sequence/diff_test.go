@@ -0,0 +1,55 @@
+package sequence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	f := int64(testSequenceFrequency)
+	ts := x.Unix()
+	a := NewWithValues(x, testSequenceFrequency, testValues)
+	tests := []struct {
+		id   int
+		b    *Sequence
+		want []Period
+	}{
+		{1, NewWithValues(x, testSequenceFrequency, testValues), []Period{}},
+		{
+			2,
+			NewWithValues(x, testSequenceFrequency, []uint8{1, 1, 1, 1, 1, 0, 1, 0, 0, 0, 1, 1, 1, 1, 1, 2, 0, 2, 2, 0, 1}),
+			[]Period{{ts + 6*f, ts + 7*f}, {ts + 16*f, ts + 17*f}, {ts + 20*f, ts + 21*f}},
+		},
+		{
+			3,
+			NewWithValues(x.Add(-120*time.Second), testSequenceFrequency, []uint8{0, 2, 1, 1, 1, 0}),
+			[]Period{{ts - 2*f, ts - f}, {ts + 3*f, ts + 15*f}, {ts + 19*f, ts + 20*f}},
+		},
+		{
+			4,
+			NewWithValues(x.Add(600*time.Second), testSequenceFrequency, []uint8{0, 0, 0, 0, 0, 1, 1}),
+			[]Period{{ts, ts + 17*f}, {ts + 19*f, ts + 20*f}},
+		},
+		{5, New(x, testSequenceFrequency), []Period{{ts, ts + 15*f}, {ts + 19*f, ts + 20*f}}},
+	}
+	for _, tt := range tests {
+		got, err := Diff(a, tt.b)
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", tt.id, err)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("test %d:\ngot  %v\nwant %v", tt.id, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Fatalf("test %d:\ngot  %v\nwant %v", tt.id, got, tt.want)
+			}
+		}
+	}
+	for _, b := range []*Sequence{New(x, 30), New(x.Add(time.Second), testSequenceFrequency)} {
+		if _, err := Diff(a, b); err == nil {
+			t.Fatal("got error nil, want non nil error")
+		}
+	}
+}
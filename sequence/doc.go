@@ -18,6 +18,11 @@ Sequence values are represented as uint8. The only supported values are:
 
 Passing unsupported values to functions or methods will result in undefined behavior.
 
+Sequences that need to track richer enumerations can be created using NewWithWidth,
+representing each value using 3 or 4 bits instead of 2. Such sequences support values
+between 0 and 7 or 15 respectively, StateInactive, StateActive and StateUnknown keeping
+their meaning.
+
 A Store is essentially a wrapper around a map of sequences that provides convenience methods
 safe to use from multiple goroutines.
 */
@@ -0,0 +1,29 @@
+package sequence
+
+import "errors"
+
+// Errors returned when validating the configuration of a sequence.
+var (
+	// ErrInvalidLength indicates a length out of the accepted range.
+	ErrInvalidLength = errors.New("invalid length")
+
+	// ErrInvalidFrequency indicates a frequency out of the accepted range.
+	ErrInvalidFrequency = errors.New("invalid frequency")
+
+	// ErrInvalidHorizon indicates a time span, the length of a sequence
+	// multiplied by its frequency, out of the accepted range.
+	ErrInvalidHorizon = errors.New("invalid horizon")
+)
+
+// ErrInvalidChecksum is returned when decoding data whose checksum does not
+// match its content.
+var ErrInvalidChecksum = errors.New("invalid checksum")
+
+// ErrAlreadySet is returned when adding values to intervals that already hold
+// the same values. Replaying an operation therefore results in ErrAlreadySet
+// and leaves the sequence unchanged.
+var ErrAlreadySet = errors.New("value already set")
+
+// ErrSkipped is returned when executing a conditional statement whose conditions
+// are not met, in which case the store is left unchanged.
+var ErrSkipped = errors.New("statement skipped")
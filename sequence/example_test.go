@@ -2,6 +2,8 @@ package sequence_test
 
 import (
 	"fmt"
+	"regexp"
+	"sort"
 	"time"
 
 	"github.com/geofduf/run-length/sequence"
@@ -45,3 +47,18 @@ func ExampleQuerySet_Serialize() {
 	fmt.Printf("%s", qs.Serialize("2006-01-02 15:04", time.UTC, 2, flag))
 	// Output: [{"date":"2000-01-02 00:00","count":5,"mean":0.60},{"date":"2000-01-02 00:05","count":4,"mean":0.75}]
 }
+
+func ExampleStore_KeysFunc() {
+	t := time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	store := sequence.NewStore()
+	for _, key := range []string{"dc1.host1.http", "dc1.host1.icmp", "dc1.host2.http", "dc2.host1.http"} {
+		store.New(t, 60, key)
+	}
+
+	keys := store.KeysFunc(regexp.MustCompile(`^dc1\..*\.http$`).MatchString)
+	sort.Strings(keys)
+
+	fmt.Println(keys)
+	// Output: [dc1.host1.http dc1.host2.http]
+}
@@ -0,0 +1,395 @@
+package sequence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the files of a FileStore within its directory.
+const (
+	fileStoreSnapshot = "store.dump"
+	fileStoreWAL      = "store.wal" // followed by a dot and the generation of the log
+)
+
+// Snapshots of a FileStore start with a magic string followed by the generation
+// of the first log following the snapshot, see WAL.Generation, and by a dump of
+// the store. Snapshots made of a dump only, written by previous versions, are
+// accepted and followed by logs of any generation.
+const (
+	fileStoreMagic  = "\x89RLF"
+	fileStoreHeader = len(fileStoreMagic) + 8
+)
+
+// FileStoreOptions specifies the options of a FileStore.
+type FileStoreOptions struct {
+	// Sync specifies the sync policy of the log.
+	Sync SyncPolicy
+
+	// CreatePolicy specifies the create policy of the store, see
+	// Store.SetCreatePolicy, set before the log is replayed.
+	CreatePolicy CreatePolicy
+
+	// CompactInterval, if positive, specifies the interval between
+	// compactions of the log performed in the background.
+	CompactInterval time.Duration
+
+	// OnError, if not nil, is called from the background goroutine
+	// with the error of each failed compaction.
+	OnError func(error)
+}
+
+// A FileStore is a Store persisted in a directory, made of a snapshot of the
+// store and of write-ahead logs of the statements executed since then, one per
+// generation. Statements executed using Execute, Batch or Transaction, as well
+// as deletions, are appended to the log. The other modifications of the
+// FileStore, such as Add, Rename or Load, cannot be expressed as statements and
+// compact the store before returning, so that every modification is durable
+// once the method returns. Modifications made through the embedded Store are
+// only persisted by the next compaction. A FileStore can be used simultaneously
+// from multiple goroutines.
+type FileStore struct {
+	*Store
+	dir         string
+	sync        SyncPolicy
+	snapshotter *Snapshotter
+	compact     sync.Mutex // serializes compactions, guards closed
+	closed      bool
+}
+
+// OpenStore opens the store persisted in the directory named path, creating
+// the directory if it does not exist. The store is recovered by loading its last
+// snapshot and replaying its logs. It returns an error if the snapshot or the
+// logs cannot be read.
+func OpenStore(path string, opts FileStoreOptions) (*FileStore, error) {
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, err
+	}
+	s := NewStore()
+	s.SetCreatePolicy(opts.CreatePolicy)
+	data, err := os.ReadFile(filepath.Join(path, fileStoreSnapshot))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	var gen uint64
+	if bytes.HasPrefix(data, []byte(fileStoreMagic)) {
+		if len(data) < fileStoreHeader {
+			return nil, errors.New("invalid snapshot")
+		}
+		gen = binary.LittleEndian.Uint64(data[len(fileStoreMagic):])
+		data = data[fileStoreHeader:]
+	}
+	if err := s.Load(data); err != nil {
+		return nil, err
+	}
+	gens, err := fileStoreLogs(path)
+	if err != nil {
+		return nil, err
+	}
+	var wal *WAL
+	for _, g := range gens {
+		// Logs older than the snapshot hold statements already included in
+		// the snapshot, a crash having occurred before they were removed.
+		if g < gen {
+			if err := os.Remove(fileStoreLog(path, g)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if wal != nil {
+			wal.Close()
+		}
+		if wal, err = openFileStoreLog(path, g, opts.Sync); err != nil {
+			return nil, err
+		}
+		if _, err := wal.Replay(s); err != nil {
+			wal.Close()
+			return nil, err
+		}
+	}
+	if wal == nil {
+		if wal, err = openFileStoreLog(path, gen, opts.Sync); err != nil {
+			return nil, err
+		}
+	}
+	s.SetWAL(wal)
+	x := &FileStore{Store: s, dir: path, sync: opts.Sync}
+	if opts.CompactInterval > 0 {
+		x.snapshotter = &Snapshotter{OnError: opts.OnError, write: x.Compact, interval: opts.CompactInterval}
+		x.snapshotter.Start()
+	}
+	return x, nil
+}
+
+// fileStoreLog returns the path of the log of generation gen in dir.
+func fileStoreLog(dir string, gen uint64) string {
+	return filepath.Join(dir, fileStoreWAL+"."+strconv.FormatUint(gen, 10))
+}
+
+// fileStoreLogs returns the generations of the logs found in dir, in
+// ascending order.
+func fileStoreLogs(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var gens []uint64
+	for _, v := range entries {
+		suffix, ok := strings.CutPrefix(v.Name(), fileStoreWAL+".")
+		if !ok {
+			continue
+		}
+		if gen, err := strconv.ParseUint(suffix, 10, 64); err == nil {
+			gens = append(gens, gen)
+		}
+	}
+	slices.Sort(gens)
+	return gens, nil
+}
+
+// openFileStoreLog opens the log of generation gen in dir, creating it if it
+// does not exist.
+func openFileStoreLog(dir string, gen uint64, policy SyncPolicy) (*WAL, error) {
+	l, err := OpenWAL(fileStoreLog(dir, gen), policy)
+	if err != nil {
+		return nil, err
+	}
+	if l.Generation() != gen {
+		if err := l.truncate(gen); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Compact writes a snapshot of the store and removes the logs it includes.
+// Statements are only blocked while the store switches to the log of a new
+// generation and takes a Snapshot: the snapshot is written while statements are
+// appended to the new log, and the previous logs are removed once the snapshot
+// is durable, so that a crash at any point is recovered by replaying the logs
+// not included in the last snapshot. It returns an error if the store is closed.
+func (s *FileStore) Compact() error {
+	s.compact.Lock()
+	defer s.compact.Unlock()
+	if s.closed {
+		return errors.New("store is closed")
+	}
+	snap, gen, err := s.rotate()
+	if err != nil {
+		return err
+	}
+	err = s.writeSnapshot(snap, gen)
+	snap.Close()
+	if err != nil {
+		return err
+	}
+	return s.removeLogs(gen)
+}
+
+// rotate switches the store to a new log and returns a snapshot of the store
+// along with the generation of the new log. The previous log is closed but
+// kept until the snapshot is written.
+func (s *FileStore) rotate() (*Snapshot, uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	gen := s.wal.Generation() + 1
+	wal, err := openFileStoreLog(s.dir, gen, s.sync)
+	if err != nil {
+		return nil, 0, err
+	}
+	prev := s.wal
+	s.wal = wal
+	prev.Close()
+	return s.snapshotUnsafe(), gen, nil
+}
+
+// writeSnapshot writes snap as the snapshot of the store, recording gen as the
+// generation of the first log following the snapshot.
+func (s *FileStore) writeSnapshot(snap *Snapshot, gen uint64) error {
+	return writeFileAtomic(filepath.Join(s.dir, fileStoreSnapshot), func(w io.Writer) error {
+		header := binary.LittleEndian.AppendUint64([]byte(fileStoreMagic), gen)
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		return snap.DumpTo(w)
+	})
+}
+
+// removeLogs removes the logs of the store older than gen.
+func (s *FileStore) removeLogs(gen uint64) error {
+	gens, err := fileStoreLogs(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, g := range gens {
+		if g >= gen {
+			break
+		}
+		if err := os.Remove(fileStoreLog(s.dir, g)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops the background compactions, compacts the store and closes its
+// log. Statements executed afterwards return an error.
+func (s *FileStore) Close() error {
+	if s.snapshotter != nil {
+		s.snapshotter.Stop()
+	}
+	err := s.Compact()
+	s.compact.Lock()
+	defer s.compact.Unlock()
+	s.closed = true
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cerr := s.wal.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// persist compacts the store once a modification that cannot be logged
+// succeeded, returning err otherwise.
+func (s *FileStore) persist(err error) error {
+	if err != nil {
+		return err
+	}
+	return s.Compact()
+}
+
+// New works like Store.New and compacts the store.
+func (s *FileStore) New(t time.Time, f uint16, key string) error {
+	s.Store.New(t, f, key)
+	return s.Compact()
+}
+
+// Add works like Store.Add and compacts the store.
+func (s *FileStore) Add(key string, x *Sequence) error {
+	s.Store.Add(key, x)
+	return s.Compact()
+}
+
+// Delete works like Store.Delete and logs the deletion. It returns an error if
+// the deletion cannot be logged, in which case the key is not removed.
+func (s *FileStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.m[key]; !ok {
+		return nil
+	}
+	return s.deleteLoggedUnsafe([]string{key})
+}
+
+// DeleteFunc works like Store.DeleteFunc and logs the deletions. It returns an
+// error if the deletions cannot be logged, in which case no key is removed.
+func (s *FileStore) DeleteFunc(selector func(key string) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k := range s.m {
+		if selector(k) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	if err := s.deleteLoggedUnsafe(keys); err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// deleteLoggedUnsafe logs the deletion of keys and removes them from the
+// store. This method is not goroutine-safe. The caller is responsible for
+// properly acquiring / releasing the lock on the store.
+func (s *FileStore) deleteLoggedUnsafe(keys []string) error {
+	statements := make([]Statement, len(keys))
+	for i, k := range keys {
+		statements[i] = Statement{Key: k, Type: StatementDelete}
+	}
+	if err := s.wal.append(statements...); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		s.deleteUnsafe(k)
+	}
+	return nil
+}
+
+// Rename works like Store.Rename and compacts the store.
+func (s *FileStore) Rename(oldKey, newKey string) error {
+	return s.persist(s.Store.Rename(oldKey, newKey))
+}
+
+// Update works like Store.Update and compacts the store, fn being allowed to
+// modify the sequence before returning an error.
+func (s *FileStore) Update(key string, fn func(x *Sequence) error) error {
+	err := s.Store.Update(key, fn)
+	if cerr := s.Compact(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// SetLabels works like Store.SetLabels and compacts the store.
+func (s *FileStore) SetLabels(key string, labels map[string]string) error {
+	return s.persist(s.Store.SetLabels(key, labels))
+}
+
+// Load works like Store.Load and compacts the store.
+func (s *FileStore) Load(data []byte) error {
+	return s.persist(s.Store.Load(data))
+}
+
+// LoadFrom works like Store.LoadFrom and compacts the store.
+func (s *FileStore) LoadFrom(r io.Reader) error {
+	return s.persist(s.Store.LoadFrom(r))
+}
+
+// LoadIncremental works like Store.LoadIncremental and compacts the store.
+func (s *FileStore) LoadIncremental(data []byte) error {
+	return s.persist(s.Store.LoadIncremental(data))
+}
+
+// LoadPartition works like Store.LoadPartition and compacts the store.
+func (s *FileStore) LoadPartition(p, n int, data []byte) error {
+	return s.persist(s.Store.LoadPartition(p, n, data))
+}
+
+// LoadAppend works like Store.LoadAppend and compacts the store.
+func (s *FileStore) LoadAppend(data []byte, p MergePolicy) error {
+	return s.persist(s.Store.LoadAppend(data, p))
+}
+
+// Merge works like Store.Merge and compacts the store.
+func (s *FileStore) Merge(other *Store, p MergePolicy) error {
+	return s.persist(s.Store.Merge(other, p))
+}
+
+// TrimLeft works like Store.TrimLeft and compacts the store.
+func (s *FileStore) TrimLeft(t time.Time) error {
+	s.Store.TrimLeft(t)
+	return s.Compact()
+}
+
+// ApplyRetention works like Store.ApplyRetention and compacts the store if
+// sequences were trimmed.
+func (s *FileStore) ApplyRetention(now time.Time) (int, error) {
+	n := s.Store.ApplyRetention(now)
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.Compact()
+}
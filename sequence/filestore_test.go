@@ -0,0 +1,251 @@
+package sequence
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	statement := func(key string, offset int) Statement {
+		return Statement{
+			Key:                 key,
+			Timestamp:           x.Add(time.Duration(offset) * time.Minute),
+			Value:               StateActive,
+			Type:                StatementRoll,
+			CreateIfNotExists:   true,
+			CreateWithTimestamp: x,
+			CreateWithFrequency: 60,
+			CreateWithLength:    3,
+		}
+	}
+	s, err := OpenStore(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	s.Execute(statement("k1", 0))
+	s.Batch([]Statement{statement("k1", 2), statement("k2", 1)})
+	want, _ := s.Dump()
+
+	// Simulates a crash by reopening the store without closing it.
+	crashed, err := OpenStore(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	assertStoresEqual(t, crashed.Store, want)
+	if err := crashed.Compact(); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if size := assertFileStoreLogs(t, crashed); size != int64(walHeader) {
+		t.Fatalf("got log size %d, want %d", size, walHeader)
+	}
+	crashed.Execute(statement("k1", 4))
+	want, _ = crashed.Dump()
+	if err := crashed.Close(); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if err := crashed.Execute(statement("k1", 5)); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+
+	s, err = OpenStore(dir, FileStoreOptions{CompactInterval: time.Millisecond})
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	assertStoresEqual(t, s.Store, want)
+	s.Execute(statement("k3", 0))
+	for i := 0; i < 200; i++ {
+		s.mu.RLock()
+		info, _ := os.Stat(fileStoreLog(dir, s.wal.Generation()))
+		s.mu.RUnlock()
+		if info.Size() == int64(walHeader) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if size := assertFileStoreLogs(t, s); size != int64(walHeader) {
+		t.Fatalf("got log size %d, want %d", size, walHeader)
+	}
+	if err := s.Compact(); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	s, err = OpenStore(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if _, ok := s.Get("k3"); !ok {
+		t.Fatal("key k3 should exist in store")
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+
+	os.WriteFile(filepath.Join(dir, fileStoreSnapshot), []byte{0x01}, 0o644)
+	if _, err := OpenStore(dir, FileStoreOptions{}); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestFileStoreCompactCrash(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	s, err := OpenStore(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	s.Execute(Statement{
+		Key:                 "k1",
+		Timestamp:           x,
+		Value:               StateActive,
+		CreateIfNotExists:   true,
+		CreateWithTimestamp: x,
+	})
+	s.Execute(Statement{Key: "k1", Type: StatementDelete})
+	s.Store.Add("k1", NewWithValues(x, 60, testValues))
+
+	// Simulates a crash between the snapshot and the removal of the logs.
+	s.compact.Lock()
+	snap, gen, err := s.rotate()
+	if err == nil {
+		err = s.writeSnapshot(snap, gen)
+		snap.Close()
+	}
+	s.compact.Unlock()
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	s.Execute(Statement{Key: "k2", Timestamp: x, CreateIfNotExists: true, CreateWithTimestamp: x})
+	want, _ := s.Dump()
+
+	crashed, err := OpenStore(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	assertStoresEqual(t, crashed.Store, want)
+	if gens, _ := fileStoreLogs(dir); !slices.Equal(gens, []uint64{gen}) {
+		t.Fatalf("got logs %v, want [%d]", gens, gen)
+	}
+	crashed.Execute(Statement{Key: "k1", Type: StatementDelete})
+	crashed.wal.Close()
+
+	s, err = OpenStore(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	defer s.Close()
+	if _, ok := s.Get("k1"); ok {
+		t.Fatal("key k1 should not exist in store")
+	}
+}
+
+func TestFileStoreCreatePolicy(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	opts := FileStoreOptions{CreatePolicy: CreatePolicy{MinFrequency: 60}}
+	statement := func(key string, f uint16) Statement {
+		return Statement{
+			Key:                 key,
+			Timestamp:           x,
+			Value:               StateActive,
+			CreateIfNotExists:   true,
+			CreateWithTimestamp: x,
+			CreateWithFrequency: f,
+		}
+	}
+	s, err := OpenStore(dir, opts)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if err := s.Execute(statement("k1", 1)); !errors.Is(err, ErrInvalidFrequency) {
+		t.Fatalf("got error %v, want %s", err, ErrInvalidFrequency)
+	}
+	s.Batch([]Statement{statement("k2", 1), statement("k3", 60), statement("k3", 1)})
+
+	// Simulates a crash by reopening the store without closing it.
+	for _, v := range []FileStoreOptions{opts, {}} {
+		crashed, err := OpenStore(dir, v)
+		if err != nil {
+			t.Fatalf("got error %s, want error nil", err)
+		}
+		if keys := crashed.Keys(); len(keys) != 1 || keys[0] != "k3" {
+			t.Fatalf("got keys %v, want [k3]", keys)
+		}
+		crashed.wal.Close()
+	}
+}
+
+func TestFileStoreDurability(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	s, err := OpenStore(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	s.Execute(Statement{Key: "k1", Timestamp: x, CreateIfNotExists: true, CreateWithTimestamp: x})
+	s.Execute(Statement{Key: "k2", Timestamp: x, CreateIfNotExists: true, CreateWithTimestamp: x})
+	s.Execute(Statement{Key: "k3", Timestamp: x, CreateIfNotExists: true, CreateWithTimestamp: x})
+	if err := s.Delete("k1"); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if n, err := s.DeleteFunc(func(k string) bool { return k == "k2" }); err != nil || n != 1 {
+		t.Fatalf("got %d keys and error %v, want 1 key and error nil", n, err)
+	}
+	if err := s.Add("k4", NewWithValues(x, 60, testValues)); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if err := s.Rename("k3", "k5"); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if err := s.SetLabels("k5", map[string]string{"env": "test"}); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if err := s.TrimLeft(x.Add(time.Minute)); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	want, _ := s.Dump()
+
+	// Simulates a crash by reopening the store without closing it.
+	crashed, err := OpenStore(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	defer crashed.Close()
+	assertStoresEqual(t, crashed.Store, want)
+	if labels, _ := crashed.Labels("k5"); labels["env"] != "test" {
+		t.Fatalf("got labels %v, want map[env:test]", labels)
+	}
+}
+
+// assertFileStoreLogs checks that the closed or idle store s has a single log
+// and returns its size.
+func assertFileStoreLogs(t *testing.T, s *FileStore) int64 {
+	t.Helper()
+	gens, _ := fileStoreLogs(s.dir)
+	if len(gens) != 1 || gens[0] != s.wal.Generation() {
+		t.Fatalf("got logs %v, want [%d]", gens, s.wal.Generation())
+	}
+	info, _ := os.Stat(fileStoreLog(s.dir, gens[0]))
+	return info.Size()
+}
+
+func assertStoresEqual(t *testing.T, s *Store, dump []byte) {
+	t.Helper()
+	want := NewStore()
+	want.Load(dump)
+	if n, m := len(s.m), len(want.m); n != m {
+		t.Fatalf("got %d sequences, want %d", n, m)
+	}
+	for k := range want.m {
+		if v, ok := s.m[k]; !ok || !assertSequencesEqual(v, want.m[k]) {
+			t.Fatalf("key %q: got %+v, want %+v", k, v, want.m[k])
+		}
+	}
+}
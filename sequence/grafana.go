@@ -0,0 +1,133 @@
+package sequence
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GrafanaHandler returns an http.Handler implementing the Grafana simple JSON
+// datasource contract, also supported by the Infinity datasource, on top of s:
+//
+//   - GET / answers the connection test;
+//   - POST /search returns the keys of the store containing the requested target;
+//   - POST /query returns, for each requested target, the mean value of each
+//     group of a query on the associated sequence, null for groups without any
+//     value. The grouping interval is the requested interval, increased to honor
+//     the requested maximum number of data points and the sequence frequency;
+//   - POST /annotations returns an empty list.
+//
+// Targets that are not keys of the store are omitted from query responses. The
+// handler is expected to be mounted at the root of the datasource URL, see
+// http.StripPrefix.
+func (s *Store) GrafanaHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("POST /search", s.grafanaSearch)
+	mux.HandleFunc("POST /query", s.grafanaQuery)
+	mux.HandleFunc("POST /annotations", func(w http.ResponseWriter, r *http.Request) {
+		writeGrafanaJSON(w, []struct{}{})
+	})
+	return mux
+}
+
+// A grafanaQueryRequest holds the fields of a query request used by the handler.
+type grafanaQueryRequest struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	IntervalMs    int64 `json:"intervalMs"`
+	MaxDataPoints int64 `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+}
+
+// A grafanaSeries holds the response to a query target, data points being
+// (value, Unix time in milliseconds) pairs.
+type grafanaSeries struct {
+	Target     string   `json:"target"`
+	Datapoints [][2]any `json:"datapoints"`
+}
+
+func (s *Store) grafanaSearch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Target string `json:"target"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	keys := []string{}
+	for _, k := range s.Keys() {
+		if strings.Contains(k, req.Target) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	writeGrafanaJSON(w, keys)
+}
+
+func (s *Store) grafanaQuery(w http.ResponseWriter, r *http.Request) {
+	var req grafanaQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Range.To.Before(req.Range.From) {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	start, end := req.Range.From, req.Range.To
+	series := []grafanaSeries{}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range req.Targets {
+		x, ok := s.m[t.Target]
+		if !ok {
+			continue
+		}
+		mu := keyLock(s, t.Target)
+		mu.RLock()
+		d := grafanaInterval(x, start, end, req.IntervalMs, req.MaxDataPoints)
+		q, err := x.Query(start, end, d, AlignTo(d))
+		mu.RUnlock()
+		if err != nil {
+			q = QuerySet{}
+		}
+		points := make([][2]any, 0, len(q.Count))
+		for row := range q.Rows() {
+			var v any
+			if row.Count != 0 {
+				v = row.Mean
+			}
+			points = append(points, [2]any{v, row.Timestamp * 1000})
+		}
+		series = append(series, grafanaSeries{Target: t.Target, Datapoints: points})
+	}
+	writeGrafanaJSON(w, series)
+}
+
+// grafanaInterval returns the grouping interval used to query x between start
+// and end, given the interval and maximum number of data points requested.
+func grafanaInterval(x *Sequence, start, end time.Time, intervalMs, maxDataPoints int64) time.Duration {
+	f := int64(x.frequency)
+	size := intervalMs / 1000
+	if maxDataPoints > 0 {
+		if n := ceilInt64(end.Unix()-start.Unix()+1, maxDataPoints) / maxDataPoints; n > size {
+			size = n
+		}
+	}
+	size = ceilInt64(size, f)
+	if size < f {
+		size = f
+	}
+	return time.Duration(size) * time.Second
+}
+
+func writeGrafanaJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,57 @@
+package sequence
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGrafanaHandler(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", "2000-01-02 03:00:00")
+	store := NewStore()
+	store.Add("web1", NewWithValues(x, 60, []uint8{1, 1, 0, 1, 2, 2, 0, 0, 0, 0}))
+	store.Add("web2", NewWithValues(x, 60, []uint8{0}))
+	store.Add("db1", NewWithValues(x, 60, []uint8{1}))
+	h := store.GrafanaHandler()
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		want               string
+	}{
+		{"GET", "/", "", http.StatusOK, ""},
+		{"POST", "/search", `{"target":"web"}`, http.StatusOK, `["web1","web2"]`},
+		{"POST", "/search", "", http.StatusOK, `["db1","web1","web2"]`},
+		{"POST", "/annotations", `{}`, http.StatusOK, `[]`},
+		{
+			"POST",
+			"/query",
+			`{"range":{"from":"2000-01-02T03:00:00Z","to":"2000-01-02T03:09:59Z"},"intervalMs":240000,"targets":[{"target":"web1"},{"target":"missing"}]}`,
+			http.StatusOK,
+			`[{"target":"web1","datapoints":[[0.75,946782000000],[0,946782240000],[0,946782480000]]}]`,
+		},
+		{
+			"POST",
+			"/query",
+			`{"range":{"from":"2000-01-02T03:00:00Z","to":"2000-01-02T03:09:59Z"},"intervalMs":1000,"maxDataPoints":2,"targets":[{"target":"web1"},{"target":"web2"}]}`,
+			http.StatusOK,
+			`[{"target":"web1","datapoints":[[0.75,946782000000],[0,946782300000]]},` +
+				`{"target":"web2","datapoints":[[0,946782000000],[null,946782300000]]}]`,
+		},
+		{"POST", "/query", `{"range":{"from":"2000-01-02T04:00:00Z","to":"2000-01-02T03:00:00Z"}}`, http.StatusBadRequest, "invalid request"},
+		{"POST", "/query", `{`, http.StatusBadRequest, "invalid request"},
+		{"GET", "/query", "", http.StatusMethodNotAllowed, ""},
+	}
+	for i, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		if w.Code != tt.status {
+			t.Fatalf("test %d: got status %d, want %d", i+1, w.Code, tt.status)
+		}
+		if got := strings.TrimSpace(w.Body.String()); tt.want != "" && got != tt.want {
+			t.Fatalf("test %d:\ngot  %s\nwant %s", i+1, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package sequence
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// SerializeGraphite returns an encoding of the time series using the Graphite
+// plaintext protocol. Each group holding values is encoded as a line
+// "path value timestamp", its value being the mean value of the group and its
+// timestamp the one of the group. It returns an error if path is not a valid
+// metric path.
+func (q QuerySet) SerializeGraphite(path string) ([]byte, error) {
+	if !validGraphitePath(path) {
+		return nil, errors.New("invalid metric path")
+	}
+	var buf []byte
+	for r := range q.Rows() {
+		if r.Count == 0 {
+			continue
+		}
+		buf = append(buf, path...)
+		buf = append(buf, ' ')
+		buf = strconv.AppendFloat(buf, r.Mean, 'g', -1, 64)
+		buf = append(buf, ' ')
+		buf = strconv.AppendInt(buf, r.Timestamp, 10)
+		buf = append(buf, '\n')
+	}
+	return buf, nil
+}
+
+// validGraphitePath reports whether s is a non-empty metric path made of non-empty
+// dot separated nodes without whitespace.
+func validGraphitePath(s string) bool {
+	if s == "" || strings.ContainsAny(s, " \t\r\n") {
+		return false
+	}
+	for _, node := range strings.Split(s, ".") {
+		if node == "" {
+			return false
+		}
+	}
+	return true
+}
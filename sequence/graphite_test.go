@@ -0,0 +1,20 @@
+package sequence
+
+import "testing"
+
+func TestQuerySetSerializeGraphite(t *testing.T) {
+	q := QuerySet{Timestamp: 946782245, Frequency: 300, Sum: []int64{5, 0, 1}, Count: []int64{5, 0, 4}}
+	got, err := q.SerializeGraphite("availability.web-1.up")
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	want := "availability.web-1.up 1 946782245\navailability.web-1.up 0.25 946782845\n"
+	if string(got) != want {
+		t.Fatalf("\ngot  %s\nwant %s", got, want)
+	}
+	for i, path := range []string{"", "a..b", ".a", "a.", "a b", "a\nb"} {
+		if _, err := q.SerializeGraphite(path); err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", i+1)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package sequence
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// A Heatmap represents the downtime or the availability of one or more
+// sequences bucketed by calendar day and hour of the day.
+type Heatmap struct {
+	// Location specifies the time zone used to define
+	// days and hours.
+	Location *time.Location
+
+	// Days holds the first instant of each day covered
+	// by the heatmap.
+	Days []time.Time
+
+	// Downtime holds, for each day and hour of the day, the
+	// number of minutes spent in the inactive state.
+	Downtime [][24]float64
+
+	// Active and Count hold, for each day and hour of the day,
+	// the number of active values and of valid values. They are
+	// only set by AvailabilityHeatmap.
+	Active, Count [][24]int64
+}
+
+// DowntimeHeatmap returns the downtime of s bucketed by calendar day and hour
+// of the day in loc, using start and end as closed interval filter. Each value
+// is accounted for in the bucket of its timestamp. On days with daylight saving
+// time transitions, repeated hours share the same bucket. If loc is nil, UTC is
+// used.
+func (s *Sequence) DowntimeHeatmap(start, end time.Time, loc *time.Location) (Heatmap, error) {
+	h, err := newHeatmap(start, end, loc)
+	if err != nil {
+		return Heatmap{}, err
+	}
+	s.addDowntime(&h, start, end)
+	return h, nil
+}
+
+// DowntimeHeatmap executes Sequence.DowntimeHeatmap() on the sequences associated
+// to keys, summing their downtime. It returns an error if one of the keys does not
+// exist or if the underlying operation returned an error.
+func (s *Store) DowntimeHeatmap(keys []string, start, end time.Time, loc *time.Location) (Heatmap, error) {
+	h, err := newHeatmap(start, end, loc)
+	if err != nil {
+		return Heatmap{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range keys {
+		x, ok := s.m[key]
+		if !ok {
+			return Heatmap{}, errors.New("key does not exist")
+		}
+		mu := keyLock(s, key)
+		mu.RLock()
+		x.addDowntime(&h, start, end)
+		mu.RUnlock()
+	}
+	return h, nil
+}
+
+// AvailabilityHeatmap works like DowntimeHeatmap but counts the active and valid
+// values of s in each bucket, instead of the downtime.
+func (s *Sequence) AvailabilityHeatmap(start, end time.Time, loc *time.Location) (Heatmap, error) {
+	h, err := newHeatmap(start, end, loc)
+	if err != nil {
+		return Heatmap{}, err
+	}
+	h.Active = make([][24]int64, len(h.Days))
+	h.Count = make([][24]int64, len(h.Days))
+	s.addAvailability(&h, start, end)
+	return h, nil
+}
+
+// AvailabilityHeatmap executes Sequence.AvailabilityHeatmap() on the sequences
+// associated to keys, summing their counts. It returns an error if one of the
+// keys does not exist or if the underlying operation returned an error.
+func (s *Store) AvailabilityHeatmap(keys []string, start, end time.Time, loc *time.Location) (Heatmap, error) {
+	h, err := newHeatmap(start, end, loc)
+	if err != nil {
+		return Heatmap{}, err
+	}
+	h.Active = make([][24]int64, len(h.Days))
+	h.Count = make([][24]int64, len(h.Days))
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range keys {
+		x, ok := s.m[key]
+		if !ok {
+			return Heatmap{}, errors.New("key does not exist")
+		}
+		mu := keyLock(s, key)
+		mu.RLock()
+		x.addAvailability(&h, start, end)
+		mu.RUnlock()
+	}
+	return h, nil
+}
+
+// Serialize is a convenience method that returns a JSON encoding of the heatmap
+// using layout as time layout for days and n as precision level for float values.
+// As a special case, if layout is an empty string days will be represented as
+// Unix times instead of textual representations.
+func (h Heatmap) Serialize(layout string, n int) []byte {
+	return h.serialize(layout, n, `,"downtime":[`, func(buf []byte, i, hour int) []byte {
+		return strconv.AppendFloat(buf, h.Downtime[i][hour], 'f', n, 64)
+	})
+}
+
+// SerializeAvailability works like Serialize but encodes the percentage of
+// active values of each bucket, null for buckets without any valid value, as
+// {"date":...,"availability":[...]}.
+func (h Heatmap) SerializeAvailability(layout string, n int) []byte {
+	return h.serialize(layout, n, `,"availability":[`, func(buf []byte, i, hour int) []byte {
+		if i >= len(h.Count) || h.Count[i][hour] == 0 {
+			return append(buf, "null"...)
+		}
+		return strconv.AppendFloat(buf, 100*float64(h.Active[i][hour])/float64(h.Count[i][hour]), 'f', n, 64)
+	})
+}
+
+// serialize returns a JSON encoding of the heatmap, field starting the array of
+// values of each day and value appending the value of a bucket to buf.
+func (h Heatmap) serialize(layout string, n int, field string, value func(buf []byte, i, hour int) []byte) []byte {
+	if len(h.Days) == 0 {
+		return []byte("[]")
+	}
+	if layout != "" {
+		layout = `"` + layout + `"`
+	}
+	buf := make([]byte, 0, 2+len(h.Days)*(40+len(layout)+24*(n+3)))
+	buf = append(buf, serializerBasePrefix)
+	for i, day := range h.Days {
+		buf = append(buf, serializerRowPrefix...)
+		if layout != "" {
+			buf = append(buf, day.Format(layout)...)
+		} else {
+			buf = strconv.AppendInt(buf, day.Unix(), 10)
+		}
+		buf = append(buf, field...)
+		for hour := 0; hour < 24; hour++ {
+			if hour > 0 {
+				buf = append(buf, ',')
+			}
+			buf = value(buf, i, hour)
+		}
+		buf = append(buf, ']')
+		buf = append(buf, serializerRowSuffix...)
+	}
+	buf[len(buf)-1] = serializerBaseSuffix
+	return buf
+}
+
+// newHeatmap returns an empty heatmap covering the calendar days between start
+// and end in loc.
+func newHeatmap(start, end time.Time, loc *time.Location) (Heatmap, error) {
+	if start.After(end) {
+		return Heatmap{}, errors.New("invalid arguments")
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	first := start.In(loc)
+	n := civilDay(end.In(loc)) - civilDay(first) + 1
+	h := Heatmap{
+		Location: loc,
+		Days:     make([]time.Time, n),
+		Downtime: make([][24]float64, n),
+	}
+	for i := range h.Days {
+		h.Days[i] = time.Date(first.Year(), first.Month(), first.Day()+i, 0, 0, 0, 0, loc)
+	}
+	return h, nil
+}
+
+// addDowntime adds to h the downtime of s using start and end as closed
+// interval filter.
+func (s *Sequence) addDowntime(h *Heatmap, start, end time.Time) {
+	f := int64(s.frequency)
+	s.buckets(h, start, end, func(d, hour int, n int64, v uint8) {
+		if v == StateInactive {
+			h.Downtime[d][hour] += float64(n*f) / 60
+		}
+	})
+}
+
+// addAvailability adds to h the number of active and valid values of s using
+// start and end as closed interval filter.
+func (s *Sequence) addAvailability(h *Heatmap, start, end time.Time) {
+	s.buckets(h, start, end, func(d, hour int, n int64, v uint8) {
+		if v == StateUnknown {
+			return
+		}
+		if v == StateActive {
+			h.Active[d][hour] += n
+		}
+		h.Count[d][hour] += n
+	})
+}
+
+// buckets calls fn for each series of n consecutive values v of s between start
+// and end falling into the same bucket of h, d being the index of the day.
+func (s *Sequence) buckets(h *Heatmap, start, end time.Time, fn func(d, hour int, n int64, v uint8)) {
+	x, y, ok := s.offsets(start, end)
+	if !ok {
+		return
+	}
+	f := int64(s.frequency)
+	first := civilDay(h.Days[0])
+	s.walk(x, y, func(j, n int64, v uint8) bool {
+		for n > 0 {
+			ts := s.ts + j*f
+			t := time.Unix(ts, 0).In(h.Location)
+			boundary := ts - int64(t.Minute()*60+t.Second()) + 3600
+			m := ceilInt64(boundary-ts, f) / f
+			if m > n {
+				m = n
+			}
+			fn(civilDay(t)-first, t.Hour(), m, v)
+			j += m
+			n -= m
+		}
+		return true
+	})
+}
+
+// civilDay returns the number of days between January 1, 1970 and the
+// calendar date of t.
+func civilDay(t time.Time) int {
+	return int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
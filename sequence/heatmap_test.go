@@ -0,0 +1,143 @@
+package sequence
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSequenceDowntimeHeatmap(t *testing.T) {
+	x := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	values := newSliceOfValues(48*60, StateActive)
+	for i := 30; i < 40; i++ {
+		values[i] = StateInactive
+	}
+	for i := 23 * 60; i < 24*60+30; i++ {
+		values[i] = StateInactive
+	}
+	s := NewWithValues(x, 60, values)
+	tests := []struct {
+		id    int
+		start time.Time
+		end   time.Time
+		loc   *time.Location
+		want  map[[2]int]float64
+		days  int
+	}{
+		{1, x, x.Add(48*time.Hour - time.Second), time.UTC, map[[2]int]float64{{0, 0}: 10, {0, 23}: 60, {1, 0}: 30}, 2},
+		{2, x.Add(35 * time.Minute), x.Add(23*time.Hour + 29*time.Minute), time.UTC, map[[2]int]float64{{0, 0}: 5, {0, 23}: 30}, 1},
+		{3, x, x.Add(48*time.Hour - time.Second), time.FixedZone("UTC+1", 3600), map[[2]int]float64{{0, 1}: 10, {1, 0}: 60, {1, 1}: 30}, 3},
+		{4, x.Add(-72 * time.Hour), x.Add(-48 * time.Hour), time.UTC, map[[2]int]float64{}, 2},
+	}
+	for _, tt := range tests {
+		got, err := s.DowntimeHeatmap(tt.start, tt.end, tt.loc)
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", tt.id, err)
+		}
+		if len(got.Days) != tt.days || len(got.Downtime) != tt.days {
+			t.Fatalf("test %d: got %d days, want %d", tt.id, len(got.Days), tt.days)
+		}
+		for i := range got.Downtime {
+			for j, v := range got.Downtime[i] {
+				if w := tt.want[[2]int{i, j}]; v != w {
+					t.Fatalf("test %d: day %d hour %d: got %f, want %f", tt.id, i, j, v, w)
+				}
+			}
+		}
+	}
+	if _, err := s.DowntimeHeatmap(x.Add(time.Hour), x, time.UTC); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestStoreDowntimeHeatmap(t *testing.T) {
+	x := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewStore()
+	store.Add("k1", NewWithValues(x, 60, []uint8{0, 0, 1, 1}))
+	store.Add("k2", NewWithValues(x, 30, []uint8{0, 1, 0, 2}))
+	got, err := store.DowntimeHeatmap([]string{"k1", "k2"}, x, x.Add(time.Hour), time.UTC)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if v := got.Downtime[0][0]; v != 3 {
+		t.Fatalf("got %f, want 3", v)
+	}
+	if _, err := store.DowntimeHeatmap([]string{"k1", "k3"}, x, x.Add(time.Hour), time.UTC); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestHeatmapSerialize(t *testing.T) {
+	x := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := Heatmap{
+		Location: time.UTC,
+		Days:     []time.Time{x, x.AddDate(0, 0, 1)},
+		Downtime: make([][24]float64, 2),
+	}
+	h.Downtime[0][0] = 1.5
+	h.Downtime[1][23] = 60
+	want := `[{"date":"2023-01-01","downtime":[1.5,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0]},` +
+		`{"date":"2023-01-02","downtime":[0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,0.0,60.0]}]`
+	if got := h.Serialize("2006-01-02", 1); !bytes.Equal(got, []byte(want)) {
+		t.Fatalf("\ngot  %s\nwant %s", got, want)
+	}
+	if got := (Heatmap{}).Serialize("", 1); !bytes.Equal(got, []byte("[]")) {
+		t.Fatalf("got %s, want []", got)
+	}
+}
+
+func TestAvailabilityHeatmap(t *testing.T) {
+	x := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	values := newSliceOfValues(48*60, StateActive)
+	for i := 30; i < 45; i++ {
+		values[i] = StateInactive
+	}
+	for i := 60; i < 120; i++ {
+		values[i] = StateUnknown
+	}
+	s := NewWithValues(x, 60, values)
+	h, err := s.AvailabilityHeatmap(x, x.Add(36*time.Hour-time.Second), time.UTC)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if len(h.Days) != 2 {
+		t.Fatalf("got %d days, want 2", len(h.Days))
+	}
+	tests := []struct {
+		day, hour     int
+		active, count int64
+	}{
+		{0, 0, 45, 60},
+		{0, 1, 0, 0},
+		{0, 2, 60, 60},
+		{1, 11, 60, 60},
+		{1, 12, 0, 0},
+	}
+	for i, tt := range tests {
+		if a, c := h.Active[tt.day][tt.hour], h.Count[tt.day][tt.hour]; a != tt.active || c != tt.count {
+			t.Fatalf("test %d: got %d/%d, want %d/%d", i+1, a, c, tt.active, tt.count)
+		}
+	}
+	got := string(h.SerializeAvailability("2006-01-02", 1))
+	want := `[{"date":"2023-01-01","availability":[75.0,null,100.0,`
+	if len(got) < len(want) || got[:len(want)] != want {
+		t.Fatalf("got %s, want prefix %s", got, want)
+	}
+
+	store := NewStore()
+	store.Add("k1", NewWithValues(x, 60, []uint8{0, 0, 1, 1}))
+	store.Add("k2", NewWithValues(x, 30, []uint8{0, 1, 0, 2}))
+	h, err = store.AvailabilityHeatmap([]string{"k1", "k2"}, x, x.Add(time.Hour), time.UTC)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if a, c := h.Active[0][0], h.Count[0][0]; a != 3 || c != 7 {
+		t.Fatalf("got %d/%d, want 3/7", a, c)
+	}
+	if _, err := store.AvailabilityHeatmap([]string{"k3"}, x, x.Add(time.Hour), time.UTC); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if got := (Heatmap{Days: []time.Time{x}}).SerializeAvailability("", 0); !bytes.Contains(got, []byte(`"availability":[null,null,`)) {
+		t.Fatalf("got %s", got)
+	}
+}
@@ -0,0 +1,97 @@
+package sequence
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A History provides read access to a live Store extended with historical
+// snapshots of the same collection of sequences, such as older dumps kept
+// for long-term reporting. A History can be used simultaneously from multiple
+// goroutines.
+type History struct {
+	live      *Store
+	snapshots []*Store
+	mu        sync.RWMutex
+}
+
+// NewHistory creates and initializes a new History backed by live.
+func NewHistory(live *Store) *History {
+	return &History{live: live}
+}
+
+// Open loads the content of a store previously exported using the Store.Dump
+// method and adds it to the snapshots of the history.
+func (h *History) Open(data []byte) error {
+	x := NewStore()
+	if err := x.Load(data); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	h.snapshots = append(h.snapshots, x)
+	h.mu.Unlock()
+	return nil
+}
+
+// Query executes Sequence.Query() on the sequence associated to key in the
+// live store and extends the result using the snapshots of the history when the
+// requested interval predates the sequence. Sequences are stitched at their
+// reference timestamp: values of an older sequence are only used for intervals
+// preceding the first value of the more recent ones. It returns an error if the
+// key does not exist, if the sequences result in groups of different sizes or if
+// the underlying operation returned an error.
+func (h *History) Query(key string, start, end time.Time, d time.Duration) (QuerySet, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	h.live.mu.RLock()
+	defer h.live.mu.RUnlock()
+	var sequences []*Sequence
+	if x, ok := h.live.m[key]; ok {
+		mu := keyLock(h.live, key)
+		mu.RLock()
+		defer mu.RUnlock()
+		sequences = append(sequences, x)
+	}
+	for _, store := range h.snapshots {
+		if x, ok := store.m[key]; ok {
+			sequences = append(sequences, x)
+		}
+	}
+	if len(sequences) == 0 {
+		return QuerySet{}, errors.New("key does not exist")
+	}
+	sort.SliceStable(sequences, func(i, j int) bool {
+		return sequences[i].ts > sequences[j].ts
+	})
+	qs, err := sequences[0].Query(start, end, d)
+	if err != nil {
+		return QuerySet{}, err
+	}
+	boundary := sequences[0].ts
+	for _, x := range sequences[1:] {
+		if boundary <= start.Unix() {
+			break
+		}
+		e := end
+		if t := time.Unix(boundary-1, 0); t.Before(end) {
+			e = t
+		}
+		v, err := x.Query(start, e, d)
+		if err != nil {
+			return QuerySet{}, err
+		}
+		if v.Frequency != qs.Frequency {
+			return QuerySet{}, errors.New("incompatible sequences")
+		}
+		for i := range v.Count {
+			qs.Sum[i] += v.Sum[i]
+			qs.Count[i] += v.Count[i]
+		}
+		if x.ts < boundary {
+			boundary = x.ts
+		}
+	}
+	return qs, nil
+}
@@ -0,0 +1,65 @@
+package sequence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistoryQuery(t *testing.T) {
+	x := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	live := NewStore()
+	live.Add("k1", NewWithValues(x.Add(10*time.Minute), 60, newSliceOfValues(10, StateActive)))
+	h := NewHistory(live)
+	older := NewStore()
+	older.Add("k1", NewWithValues(x.Add(-10*time.Minute), 60, newSliceOfValues(15, StateInactive)))
+	older.Add("k2", NewWithValues(x, 60, newSliceOfValues(5, StateActive)))
+	old := NewStore()
+	old.Add("k1", NewWithValues(x.Add(-20*time.Minute), 60, newSliceOfValues(20, StateActive)))
+	for _, store := range []*Store{old, older} {
+		dump, _ := store.Dump()
+		if err := h.Open(dump); err != nil {
+			t.Fatalf("got error %s, want error nil", err)
+		}
+	}
+	tests := []struct {
+		id    int
+		key   string
+		start time.Time
+		end   time.Time
+		want  QuerySet
+	}{
+		{
+			1,
+			"k1",
+			x.Add(-20 * time.Minute),
+			x.Add(20*time.Minute - time.Second),
+			QuerySet{x.Add(-20 * time.Minute).Unix(), 300, []int64{5, 5, 0, 0, 0, 0, 5, 5}, []int64{5, 5, 5, 5, 5, 0, 5, 5}, nil, nil, nil, nil},
+		},
+		{
+			2,
+			"k1",
+			x.Add(10 * time.Minute),
+			x.Add(20*time.Minute - time.Second),
+			QuerySet{x.Add(10 * time.Minute).Unix(), 300, []int64{5, 5}, []int64{5, 5}, nil, nil, nil, nil},
+		},
+		{
+			3,
+			"k2",
+			x,
+			x.Add(10*time.Minute - time.Second),
+			QuerySet{x.Unix(), 300, []int64{5, 0}, []int64{5, 0}, nil, nil, nil, nil},
+		},
+	}
+	for _, tt := range tests {
+		got, err := h.Query(tt.key, tt.start, tt.end, 5*time.Minute)
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", tt.id, err)
+		}
+		if !assertQuerySetEqual(got, tt.want) {
+			t.Fatalf("test %d:\ngot  %+v\nwant %+v", tt.id, got, tt.want)
+		}
+	}
+	if _, err := h.Query("k3", x, x.Add(time.Hour), time.Hour); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
@@ -1,5 +1,7 @@
 package sequence
 
+import "time"
+
 // interval represents a closed interval.
 type interval struct {
 	start int64
@@ -24,3 +26,15 @@ func (x interval) intersect(y interval) (interval, bool) {
 	}
 	return interval{}, false
 }
+
+// A Period represents the half-open time interval [Start, End) using Unix
+// times.
+type Period struct {
+	Start int64
+	End   int64
+}
+
+// Duration returns the duration of the period.
+func (p Period) Duration() time.Duration {
+	return time.Duration(p.End-p.Start) * time.Second
+}
@@ -2,6 +2,7 @@ package sequence
 
 import (
 	"testing"
+	"time"
 )
 
 func TestIntervalIntersect(t *testing.T) {
@@ -34,3 +35,10 @@ func TestIntervalIntersect(t *testing.T) {
 		t.Fatalf("expected no intersection, got %v", got)
 	}
 }
+
+func TestPeriodDuration(t *testing.T) {
+	p := Period{Start: 946782245, End: 946782245 + 90}
+	if got := p.Duration(); got != 90*time.Second {
+		t.Fatalf("got %s, want %s", got, 90*time.Second)
+	}
+}
@@ -0,0 +1,183 @@
+package sequence
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultMaintenanceBatchSize is the number of sequences processed per
+// acquisition of the lock of the store by a Maintainer whose batch size is 0.
+const DefaultMaintenanceBatchSize = 1000
+
+// A MaintenanceResult holds the outcome of a maintenance run.
+type MaintenanceResult struct {
+	// Trimmed is the number of sequences trimmed by retention policies.
+	Trimmed int
+
+	// Deleted is the number of empty sequences removed from the store.
+	Deleted int
+
+	// Shrunk is the number of shrunk sequences.
+	Shrunk int
+}
+
+// A Maintainer periodically performs maintenance tasks on a store in the
+// background. Sequences are processed in batches, the lock of the store being
+// released between batches, so that maintenance does not stall other operations
+// on large stores. The fields must not be modified once the maintainer is
+// started. A Maintainer can be used simultaneously from multiple goroutines.
+type Maintainer struct {
+	// Retention enables the enforcement of the retention policies of the
+	// store, see Store.SetRetention.
+	Retention bool
+
+	// DeleteEmpty enables the removal of the sequences holding no values,
+	// including the ones just created, once retention policies are applied.
+	DeleteEmpty bool
+
+	// Shrink enables the shrinking of the sequences, see Sequence.Shrink.
+	Shrink bool
+
+	// BatchSize specifies the number of sequences processed per batch,
+	// DefaultMaintenanceBatchSize if 0.
+	BatchSize int
+
+	// Pause specifies the time to wait between two batches.
+	Pause time.Duration
+
+	// OnRun, if not nil, is called from the background goroutine with the
+	// result of each run.
+	OnRun func(MaintenanceResult)
+
+	store    *Store
+	interval time.Duration
+	now      func() time.Time
+	mu       sync.Mutex // serializes runs
+	state    sync.Mutex // guards stop and done
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewMaintainer returns a Maintainer running the enabled maintenance tasks on s
+// every interval. No task is enabled by default.
+func NewMaintainer(s *Store, interval time.Duration) *Maintainer {
+	return &Maintainer{store: s, interval: interval, now: time.Now}
+}
+
+// Start starts running maintenance in the background, the first run taking
+// place after one interval. It returns an error if the interval is not positive
+// or if the maintainer is already started.
+func (x *Maintainer) Start() error {
+	if x.interval <= 0 {
+		return errors.New("invalid interval")
+	}
+	x.state.Lock()
+	defer x.state.Unlock()
+	if x.stop != nil {
+		return errors.New("already started")
+	}
+	x.stop = make(chan struct{})
+	x.done = make(chan struct{})
+	go x.run(x.stop, x.done)
+	return nil
+}
+
+// Stop stops running maintenance in the background, interrupting an ongoing
+// run after its current batch. It has no effect if the maintainer is not
+// started.
+func (x *Maintainer) Stop() {
+	x.state.Lock()
+	defer x.state.Unlock()
+	if x.stop == nil {
+		return
+	}
+	close(x.stop)
+	<-x.done
+	x.stop, x.done = nil, nil
+}
+
+// Run performs maintenance immediately and returns its result. It waits for an
+// ongoing background run to complete first.
+func (x *Maintainer) Run() MaintenanceResult {
+	return x.maintain(nil)
+}
+
+func (x *Maintainer) run(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(x.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			result := x.maintain(stop)
+			if x.OnRun != nil {
+				x.OnRun(result)
+			}
+		}
+	}
+}
+
+// maintain performs maintenance on the keys of the store, one batch at a time,
+// returning early once stop is closed.
+func (x *Maintainer) maintain(stop <-chan struct{}) MaintenanceResult {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	var result MaintenanceResult
+	if !x.Retention && !x.DeleteEmpty && !x.Shrink {
+		return result
+	}
+	n := x.BatchSize
+	if n <= 0 {
+		n = DefaultMaintenanceBatchSize
+	}
+	keys := x.store.Keys()
+	now := x.now()
+	for i := 0; i < len(keys); i += n {
+		if i > 0 && x.Pause > 0 {
+			timer := time.NewTimer(x.Pause)
+			select {
+			case <-stop:
+				timer.Stop()
+				return result
+			case <-timer.C:
+			}
+		}
+		select {
+		case <-stop:
+			return result
+		default:
+		}
+		x.batch(keys[i:min(i+n, len(keys))], now, &result)
+	}
+	return result
+}
+
+// batch performs maintenance on keys under a single lock of the store, adding
+// the outcome to result.
+func (x *Maintainer) batch(keys []string, now time.Time, result *MaintenanceResult) {
+	s := x.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		v, ok := s.m[k]
+		if !ok {
+			continue
+		}
+		if x.Retention && s.retainUnsafe(k, v, now) {
+			result.Trimmed++
+		}
+		if x.DeleteEmpty && v.count == 0 {
+			s.deleteUnsafe(k)
+			result.Deleted++
+			continue
+		}
+		if x.Shrink && cap(v.data) > len(v.data) {
+			s.preserveUnsafe(v)
+			v.Shrink()
+			result.Shrunk++
+		}
+	}
+}
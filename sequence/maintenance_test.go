@@ -0,0 +1,72 @@
+package sequence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintainerRun(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	for _, k := range []string{"k1", "k2", "k3"} {
+		store.Add(k, NewWithValues(x, 60, newSliceOfValues(10, StateActive)))
+	}
+	store.New(x, 60, "k4")
+	store.m["k3"].data = append(make([]byte, 0, 64), store.m["k3"].data...)
+	store.SetRetention("k1", RetentionPolicy{MaxAge: 5 * time.Minute})
+	store.SetRetention("k2", RetentionPolicy{MaxAge: time.Minute})
+	m := NewMaintainer(store, time.Hour)
+	if got := m.Run(); got != (MaintenanceResult{}) {
+		t.Fatalf("got %+v, want %+v", got, MaintenanceResult{})
+	}
+	m.Retention, m.DeleteEmpty, m.Shrink = true, true, true
+	m.BatchSize = 1
+	m.now = func() time.Time { return x.Add(time.Hour) }
+	want := MaintenanceResult{Trimmed: 2, Deleted: 3, Shrunk: 1}
+	if got := m.Run(); got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	if keys := store.Keys(); len(keys) != 1 || keys[0] != "k3" {
+		t.Fatalf("got %v, want [k3]", keys)
+	}
+	if n, m := cap(store.m["k3"].data), len(store.m["k3"].data); n != m {
+		t.Fatalf("got capacity %d, want %d", n, m)
+	}
+}
+
+func TestMaintainerStartStop(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	store.New(x, testSequenceFrequency, "k1")
+	m := NewMaintainer(store, 5*time.Millisecond)
+	m.DeleteEmpty = true
+	results := make(chan MaintenanceResult, 1)
+	m.OnRun = func(r MaintenanceResult) {
+		select {
+		case results <- r:
+		default:
+		}
+	}
+	if err := m.Start(); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if err := m.Start(); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	select {
+	case r := <-results:
+		if r.Deleted != 1 {
+			t.Fatalf("got %d deleted sequences, want 1", r.Deleted)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("maintenance did not run")
+	}
+	m.Stop()
+	m.Stop()
+	if store.Len() != 0 {
+		t.Fatalf("got %d sequences, want 0", store.Len())
+	}
+	if err := NewMaintainer(store, 0).Start(); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
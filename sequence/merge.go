@@ -0,0 +1,147 @@
+package sequence
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"time"
+)
+
+// A MergePolicy defines how conflicts are resolved when importing into a store
+// a sequence whose key already exists.
+type MergePolicy uint8
+
+// Merge policies.
+const (
+	MergeSkip      MergePolicy = iota // keep the existing sequence
+	MergeReplace                      // replace the existing sequence
+	MergeSequences                    // combine both sequences, see Merge
+	mergeUnknown
+)
+
+// Merge imports the sequences of other into the store, resolving conflicts on
+// existing keys according to p. Sequences are copied, along with their labels
+// unless the existing sequence is kept or combined, in which case its labels are
+// kept. Using MergeSequences, the values of an existing sequence take precedence
+// over those of the imported sequence, which only fill the intervals where the
+// existing sequence holds StateUnknown or no value. The combined sequence retains
+// the length and the maximum age of the existing sequence, the oldest values
+// being discarded first. It returns an error if p is not a valid policy or if
+// sequences to combine do not share the same frequency, width and alignment, in
+// which case the store is left untouched.
+func (s *Store) Merge(other *Store, p MergePolicy) error {
+	if p >= mergeUnknown {
+		return errors.New("invalid merge policy")
+	}
+	if other == s {
+		return nil
+	}
+	other.rlockAll()
+	m := make(map[string]*Sequence, len(other.m))
+	labels := make(map[string]map[string]string, len(other.labels))
+	for k, x := range other.m {
+		m[k] = x.clone()
+		if v := other.labels[k]; v != nil {
+			labels[k] = maps.Clone(v)
+		}
+	}
+	other.runlockAll()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mergeUnsafe(m, labels, p)
+}
+
+// LoadAppend loads the content of a store previously exported using the Dump
+// method into the store like Merge, leaving the keys missing from the dump
+// untouched, instead of replacing the content of the store like Load. It returns
+// an error if the dump cannot be decoded or under the conditions described by
+// Merge, in which case the store is left untouched.
+func (s *Store) LoadAppend(data []byte, p MergePolicy) error {
+	if p >= mergeUnknown {
+		return errors.New("invalid merge policy")
+	}
+	m, labels, err := decodeDump(bytes.NewReader(data), false)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mergeUnsafe(m, labels, p)
+}
+
+// mergeUnsafe imports the sequences of m and their labels into the store as
+// described by Merge, taking ownership of them. This method is not
+// goroutine-safe. The caller is responsible for properly acquiring / releasing
+// the lock on the store.
+func (s *Store) mergeUnsafe(m map[string]*Sequence, labels map[string]map[string]string, p MergePolicy) error {
+	if p == MergeSequences {
+		for k, x := range m {
+			old, ok := s.m[k]
+			if !ok {
+				continue
+			}
+			y, err := mergeSequences(old, x)
+			if err != nil {
+				return err
+			}
+			m[k] = y
+		}
+	}
+	for k, x := range m {
+		_, ok := s.m[k]
+		switch {
+		case !ok || p == MergeReplace:
+			s.setUnsafe(k, x)
+			s.setLabelsUnsafe(k, labels[k])
+		case p == MergeSequences:
+			s.setUnsafe(k, x)
+		}
+	}
+	return nil
+}
+
+// mergeSequences returns a sequence combining the values of a and b, the values
+// of a taking precedence unless they are StateUnknown. The returned sequence
+// retains the length and the maximum age of a. It returns an error if a and b do
+// not share the same frequency, width and alignment.
+func mergeSequences(a, b *Sequence) (*Sequence, error) {
+	f := int64(a.frequency)
+	if a.frequency != b.frequency || a.bits() != b.bits() || (a.ts-b.ts)%f != 0 {
+		return nil, errors.New("incompatible sequences")
+	}
+	if b.count == 0 {
+		return a.clone(), nil
+	}
+	start, end := a.ts, a.ts+int64(a.count)*f
+	if a.count == 0 {
+		start, end = b.ts, b.ts
+	}
+	start = min(start, b.ts)
+	end = max(end, b.ts+int64(b.count)*f)
+	n := (end - start) / f
+	if n > int64(a.length) {
+		start += (n - int64(a.length)) * f
+		n = int64(a.length)
+	}
+	values := make([]uint8, n)
+	for i := range values {
+		values[i] = StateUnknown
+	}
+	for _, x := range []*Sequence{b, a} {
+		for i, v := range x.All() {
+			j := (x.ts-start)/f + int64(i)
+			if j >= 0 && v != StateUnknown {
+				values[j] = v
+			}
+		}
+	}
+	x := New(time.Unix(start, 0), a.frequency)
+	x.width = a.width
+	x.maxAge = a.maxAge
+	x.length = a.length
+	x.addValues(values)
+	if x.count > 0 {
+		x.drop(x.expired(int64(x.count) - 1))
+	}
+	return x, nil
+}
@@ -0,0 +1,108 @@
+package sequence
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStoreMerge(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	f := time.Duration(testSequenceFrequency) * time.Second
+	newStores := func() (*Store, *Store) {
+		dst, src := NewStore(), NewStore()
+		dst.Add("k1", NewWithValues(x, testSequenceFrequency, []uint8{1, 2, 1}))
+		dst.SetLabels("k1", map[string]string{"agent": "dst"})
+		src.Add("k1", NewWithValues(x.Add(f), testSequenceFrequency, []uint8{0, 0, 0, 1}))
+		src.SetLabels("k1", map[string]string{"agent": "src"})
+		src.Add("k2", NewWithValues(x, testSequenceFrequency, []uint8{1}))
+		src.SetLabels("k2", map[string]string{"agent": "src"})
+		return dst, src
+	}
+	tests := []struct {
+		policy MergePolicy
+		want   []uint8
+		agent  string
+	}{
+		{MergeSkip, []uint8{1, 2, 1}, "dst"},
+		{MergeReplace, []uint8{0, 0, 0, 1}, "src"},
+		{MergeSequences, []uint8{1, 0, 1, 0, 1}, "dst"},
+	}
+	for i, tt := range tests {
+		dst, src := newStores()
+		if err := dst.Merge(src, tt.policy); err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", i+1, err)
+		}
+		if got := dst.m["k1"].All(); !slices.Equal(got, tt.want) {
+			t.Fatalf("test %d: got %v, want %v", i+1, got, tt.want)
+		}
+		if got, _ := dst.Labels("k1"); got["agent"] != tt.agent {
+			t.Fatalf("test %d: got agent %q, want %q", i+1, got["agent"], tt.agent)
+		}
+		if got, _ := dst.Labels("k2"); got["agent"] != "src" || dst.m["k2"] == src.m["k2"] {
+			t.Fatalf("test %d: k2 should be a copy of the sequence of src", i+1)
+		}
+	}
+	dst, src := newStores()
+	src.Add("k1", NewWithValues(x, 120, []uint8{1}))
+	if err := dst.Merge(src, MergeSequences); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if _, ok := dst.m["k2"]; ok {
+		t.Fatal("store was modified")
+	}
+	if err := dst.Merge(src, mergeUnknown); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestStoreLoadAppend(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	f := time.Duration(testSequenceFrequency) * time.Second
+	src := NewStore()
+	src.Add("k1", NewWithValues(x.Add(f), testSequenceFrequency, []uint8{0, 0, 0}))
+	src.Add("k2", NewWithValues(x, testSequenceFrequency, []uint8{1}))
+	src.SetLabels("k2", map[string]string{"agent": "src"})
+	dump, _ := src.Dump()
+	dst := NewStore()
+	dst.Add("k1", NewWithValues(x, testSequenceFrequency, []uint8{1, 2}))
+	dst.Add("k3", NewWithValues(x, testSequenceFrequency, []uint8{0}))
+	if err := dst.LoadAppend(dump, MergeSequences); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if keys := dst.KeysWithPrefix(""); !slices.Equal(keys, []string{"k1", "k2", "k3"}) {
+		t.Fatalf("got %v, want [k1 k2 k3]", keys)
+	}
+	if got := dst.m["k1"].All(); !slices.Equal(got, []uint8{1, 0, 0, 0}) {
+		t.Fatalf("got %v, want [1 0 0 0]", got)
+	}
+	if got, _ := dst.Labels("k2"); got["agent"] != "src" {
+		t.Fatalf("got %v, want labels of k2", got)
+	}
+	for _, p := range []MergePolicy{MergeSkip, mergeUnknown} {
+		if err := dst.LoadAppend(dump[:len(dump)-1], p); err == nil {
+			t.Fatal("got error nil, want non nil error")
+		}
+	}
+}
+
+func TestMergeSequences(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	f := time.Duration(testSequenceFrequency) * time.Second
+	a := NewWithValues(x.Add(2*f), testSequenceFrequency, []uint8{1, 2, 1})
+	a.SetLength(4)
+	b := NewWithValues(x, testSequenceFrequency, []uint8{0, 0, 0, 0, 0, 0})
+	got, err := mergeSequences(a, b)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if want := []uint8{1, 0, 1, 0}; !slices.Equal(got.All(), want) {
+		t.Fatalf("got %v, want %v", got.All(), want)
+	}
+	if got.Timestamp() != x.Add(2*f).Unix() || got.Length() != 4 {
+		t.Fatalf("got timestamp %d and length %d, want %d and 4", got.Timestamp(), got.Length(), x.Add(2*f).Unix())
+	}
+	if _, err := mergeSequences(a, New(x.Add(time.Second), testSequenceFrequency)); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
@@ -0,0 +1,473 @@
+package sequence
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// MessagePack encodings use maps keyed by the field names of sequence.proto, a
+// Sequence being encoded as:
+//
+//	{"timestamp": int, "frequency": int, "length": int, "width": int,
+//	 "max_age": int, "runs": [[count, value], ...]}
+//
+// and a QuerySet as:
+//
+//	{"timestamp": int, "frequency": int, "sum": [int, ...], "count": [int, ...],
+//	 "timestamps": [int, ...], "active": [float, ...], "transitions": [int, ...],
+//	 "seconds": {state: [int, ...], ...}}
+//
+// where the optional timestamps, active, transitions and seconds entries are
+// omitted when unset. Unknown keys are ignored when decoding.
+
+// errInvalidMsgpack is returned when a MessagePack value cannot be decoded.
+var errInvalidMsgpack = errors.New("cannot decode the message")
+
+// MarshalMsgpack returns the MessagePack encoding of s.
+func (s *Sequence) MarshalMsgpack() []byte {
+	buf := msgpackAppendMap(nil, 6)
+	buf = msgpackAppendString(buf, "timestamp")
+	buf = msgpackAppendInt(buf, s.ts)
+	buf = msgpackAppendString(buf, "frequency")
+	buf = msgpackAppendInt(buf, int64(s.frequency))
+	buf = msgpackAppendString(buf, "length")
+	buf = msgpackAppendInt(buf, int64(s.length))
+	buf = msgpackAppendString(buf, "width")
+	buf = msgpackAppendInt(buf, int64(s.bits()))
+	buf = msgpackAppendString(buf, "max_age")
+	buf = msgpackAppendInt(buf, s.maxAge)
+	buf = msgpackAppendString(buf, "runs")
+	var runs []byte
+	n := 0
+	s.walk(0, int64(s.count)-1, func(j, k int64, v uint8) bool {
+		runs = msgpackAppendArray(runs, 2)
+		runs = msgpackAppendInt(runs, k)
+		runs = msgpackAppendInt(runs, int64(v))
+		n++
+		return true
+	})
+	buf = msgpackAppendArray(buf, n)
+	return append(buf, runs...)
+}
+
+// UnmarshalMsgpackSequence creates a Sequence using data, the MessagePack
+// encoding of a sequence. It returns an error if data cannot be decoded or if it
+// doesn't describe a valid sequence.
+func UnmarshalMsgpackSequence(data []byte) (*Sequence, error) {
+	d := msgpackDecoder{b: data}
+	var ts, f, length, w, maxAge int64
+	var runs []Run
+	n := d.readMap()
+	for i := 0; i < n && d.err == nil; i++ {
+		switch d.readString() {
+		case "timestamp":
+			ts = d.readInt()
+		case "frequency":
+			f = d.readInt()
+		case "length":
+			length = d.readInt()
+		case "width":
+			w = d.readInt()
+		case "max_age":
+			maxAge = d.readInt()
+		case "runs":
+			m := d.readArray()
+			for j := 0; j < m && d.err == nil; j++ {
+				if d.readArray() != 2 {
+					d.fail()
+				}
+				count, v := d.readInt(), d.readInt()
+				if count < 0 || count > math.MaxUint32 || v < 0 || v > math.MaxUint8 {
+					d.fail()
+				}
+				runs = append(runs, Run{uint32(count), uint8(v)})
+			}
+		default:
+			d.skip()
+		}
+	}
+	if err := d.done(); err != nil {
+		return nil, err
+	}
+	if w == 0 {
+		w = flagBits
+	}
+	if f <= 0 || f > math.MaxUint16 || w < 0 || w > MaxStateWidth || length < 0 || length > math.MaxUint32 || maxAge < 0 {
+		return nil, errors.New("invalid sequence")
+	}
+	s, err := NewWithWidth(time.Unix(ts, 0), uint16(f), uint8(w))
+	if err != nil {
+		return nil, errors.New("invalid sequence")
+	}
+	if length != 0 {
+		if err := s.Resize(uint32(length)); err != nil {
+			return nil, err
+		}
+	}
+	s.maxAge = maxAge
+	for _, r := range runs {
+		if r.Count == 0 {
+			continue
+		}
+		if r.Value >= 1<<s.bits() || r.Count > s.length-s.count {
+			return nil, errors.New("invalid sequence")
+		}
+		s.addSeries(r.Count, r.Value)
+	}
+	return s, nil
+}
+
+// MarshalMsgpack returns the MessagePack encoding of q.
+func (q QuerySet) MarshalMsgpack() []byte {
+	n := 4
+	for _, ok := range []bool{q.Timestamps != nil, q.Active != nil, q.Transitions != nil, q.Seconds != nil} {
+		if ok {
+			n++
+		}
+	}
+	buf := msgpackAppendMap(nil, n)
+	buf = msgpackAppendString(buf, "timestamp")
+	buf = msgpackAppendInt(buf, q.Timestamp)
+	buf = msgpackAppendString(buf, "frequency")
+	buf = msgpackAppendInt(buf, q.Frequency)
+	buf = msgpackAppendString(buf, "sum")
+	buf = msgpackAppendInts(buf, q.Sum)
+	buf = msgpackAppendString(buf, "count")
+	buf = msgpackAppendInts(buf, q.Count)
+	if q.Timestamps != nil {
+		buf = msgpackAppendString(buf, "timestamps")
+		buf = msgpackAppendInts(buf, q.Timestamps)
+	}
+	if q.Active != nil {
+		buf = msgpackAppendString(buf, "active")
+		buf = msgpackAppendArray(buf, len(q.Active))
+		for _, v := range q.Active {
+			buf = append(buf, 0xcb)
+			buf = binary.BigEndian.AppendUint64(buf, math.Float64bits(v))
+		}
+	}
+	if q.Transitions != nil {
+		buf = msgpackAppendString(buf, "transitions")
+		buf = msgpackAppendInts(buf, q.Transitions)
+	}
+	if q.Seconds != nil {
+		buf = msgpackAppendString(buf, "seconds")
+		states := make([]int, 0, len(q.Seconds))
+		for k := range q.Seconds {
+			states = append(states, int(k))
+		}
+		sort.Ints(states)
+		buf = msgpackAppendMap(buf, len(states))
+		for _, k := range states {
+			buf = msgpackAppendInt(buf, int64(k))
+			buf = msgpackAppendInts(buf, q.Seconds[uint8(k)])
+		}
+	}
+	return buf
+}
+
+// UnmarshalMsgpackQuerySet creates a QuerySet using data, the MessagePack
+// encoding of a query set. It returns an error if data cannot be decoded.
+func UnmarshalMsgpackQuerySet(data []byte) (QuerySet, error) {
+	d := msgpackDecoder{b: data}
+	q := QuerySet{Sum: []int64{}, Count: []int64{}}
+	n := d.readMap()
+	for i := 0; i < n && d.err == nil; i++ {
+		switch d.readString() {
+		case "timestamp":
+			q.Timestamp = d.readInt()
+		case "frequency":
+			q.Frequency = d.readInt()
+		case "sum":
+			q.Sum = d.readInts()
+		case "count":
+			q.Count = d.readInts()
+		case "timestamps":
+			q.Timestamps = d.readInts()
+		case "active":
+			m := d.readArray()
+			q.Active = make([]float64, 0, min(m, len(d.b)))
+			for j := 0; j < m && d.err == nil; j++ {
+				q.Active = append(q.Active, d.readFloat())
+			}
+		case "transitions":
+			q.Transitions = d.readInts()
+		case "seconds":
+			m := d.readMap()
+			q.Seconds = make(map[uint8][]int64, min(m, len(d.b)))
+			for j := 0; j < m && d.err == nil; j++ {
+				k := d.readInt()
+				if k < 0 || k > math.MaxUint8 {
+					d.fail()
+				}
+				q.Seconds[uint8(k)] = d.readInts()
+			}
+		default:
+			d.skip()
+		}
+	}
+	if err := d.done(); err != nil {
+		return QuerySet{}, err
+	}
+	if err := checkQuerySet(q); err != nil {
+		return QuerySet{}, err
+	}
+	return q, nil
+}
+
+// msgpackAppendInt appends the most compact encoding of v to dst.
+func msgpackAppendInt(dst []byte, v int64) []byte {
+	switch {
+	case v >= 0 && v <= 0x7f:
+		return append(dst, byte(v))
+	case v < 0 && v >= -32:
+		return append(dst, byte(v))
+	case v >= 0 && v <= math.MaxUint8:
+		return append(dst, 0xcc, byte(v))
+	case v >= 0 && v <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(dst, 0xcd), uint16(v))
+	case v >= 0 && v <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(dst, 0xce), uint32(v))
+	case v >= math.MinInt8 && v < 0:
+		return append(dst, 0xd0, byte(v))
+	case v >= math.MinInt16 && v < 0:
+		return binary.BigEndian.AppendUint16(append(dst, 0xd1), uint16(v))
+	case v >= math.MinInt32 && v < 0:
+		return binary.BigEndian.AppendUint32(append(dst, 0xd2), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(dst, 0xd3), uint64(v))
+}
+
+// msgpackAppendInts appends the encoding of the array x to dst.
+func msgpackAppendInts(dst []byte, x []int64) []byte {
+	dst = msgpackAppendArray(dst, len(x))
+	for _, v := range x {
+		dst = msgpackAppendInt(dst, v)
+	}
+	return dst
+}
+
+// msgpackAppendString appends the encoding of the string s to dst.
+func msgpackAppendString(dst []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		dst = append(dst, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		dst = append(dst, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		dst = binary.BigEndian.AppendUint16(append(dst, 0xda), uint16(n))
+	default:
+		dst = binary.BigEndian.AppendUint32(append(dst, 0xdb), uint32(n))
+	}
+	return append(dst, s...)
+}
+
+// msgpackAppendArray appends the header of an array of n elements to dst.
+func msgpackAppendArray(dst []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(dst, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(dst, 0xdc), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(dst, 0xdd), uint32(n))
+}
+
+// msgpackAppendMap appends the header of a map of n entries to dst.
+func msgpackAppendMap(dst []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(dst, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(dst, 0xde), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(dst, 0xdf), uint32(n))
+}
+
+// A msgpackDecoder reads MessagePack values from b. The first error is kept in
+// err and subsequent reads return zero values.
+type msgpackDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *msgpackDecoder) fail() {
+	if d.err == nil {
+		d.err = errInvalidMsgpack
+	}
+	d.b = nil
+}
+
+// done returns the decoding error, if any, or an error if bytes remain.
+func (d *msgpackDecoder) done() error {
+	if d.err == nil && len(d.b) > 0 {
+		d.fail()
+	}
+	return d.err
+}
+
+// next consumes and returns the next n bytes.
+func (d *msgpackDecoder) next(n int) []byte {
+	if d.err != nil || n < 0 || len(d.b) < n {
+		d.fail()
+		return nil
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b
+}
+
+// byte consumes and returns the next byte, 0 if there is none.
+func (d *msgpackDecoder) byte() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+// uint returns the big-endian unsigned integer of the next n bytes.
+func (d *msgpackDecoder) uint(n int) uint64 {
+	var v uint64
+	for _, c := range d.next(n) {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func (d *msgpackDecoder) readInt() int64 {
+	c := d.byte()
+	switch {
+	case c <= 0x7f:
+		return int64(c)
+	case c >= 0xe0:
+		return int64(int8(c))
+	}
+	switch c {
+	case 0xcc:
+		return int64(d.uint(1))
+	case 0xcd:
+		return int64(d.uint(2))
+	case 0xce:
+		return int64(d.uint(4))
+	case 0xcf:
+		v := d.uint(8)
+		if v > math.MaxInt64 {
+			d.fail()
+		}
+		return int64(v)
+	case 0xd0:
+		return int64(int8(d.uint(1)))
+	case 0xd1:
+		return int64(int16(d.uint(2)))
+	case 0xd2:
+		return int64(int32(d.uint(4)))
+	case 0xd3:
+		return int64(d.uint(8))
+	}
+	d.fail()
+	return 0
+}
+
+func (d *msgpackDecoder) readInts() []int64 {
+	n := d.readArray()
+	x := make([]int64, 0, min(n, len(d.b)))
+	for i := 0; i < n && d.err == nil; i++ {
+		x = append(x, d.readInt())
+	}
+	return x
+}
+
+func (d *msgpackDecoder) readFloat() float64 {
+	switch d.byte() {
+	case 0xca:
+		return float64(math.Float32frombits(uint32(d.uint(4))))
+	case 0xcb:
+		return math.Float64frombits(d.uint(8))
+	}
+	d.fail()
+	return 0
+}
+
+func (d *msgpackDecoder) readString() string {
+	c := d.byte()
+	var n int
+	switch {
+	case c&0xe0 == 0xa0:
+		n = int(c & 0x1f)
+	case c == 0xd9:
+		n = int(d.uint(1))
+	case c == 0xda:
+		n = int(d.uint(2))
+	case c == 0xdb:
+		n = int(d.uint(4))
+	default:
+		d.fail()
+	}
+	return string(d.next(n))
+}
+
+func (d *msgpackDecoder) readArray() int {
+	c := d.byte()
+	switch {
+	case c&0xf0 == 0x90:
+		return int(c & 0x0f)
+	case c == 0xdc:
+		return int(d.uint(2))
+	case c == 0xdd:
+		return int(d.uint(4))
+	}
+	d.fail()
+	return 0
+}
+
+func (d *msgpackDecoder) readMap() int {
+	c := d.byte()
+	switch {
+	case c&0xf0 == 0x80:
+		return int(c & 0x0f)
+	case c == 0xde:
+		return int(d.uint(2))
+	case c == 0xdf:
+		return int(d.uint(4))
+	}
+	d.fail()
+	return 0
+}
+
+// skip consumes the next value, whatever its type.
+func (d *msgpackDecoder) skip() {
+	if d.err != nil || len(d.b) == 0 {
+		d.fail()
+		return
+	}
+	c := d.b[0]
+	switch {
+	case c <= 0x7f || c >= 0xe0 || c >= 0xcc && c <= 0xd3:
+		d.readInt()
+	case c == 0xca || c == 0xcb:
+		d.readFloat()
+	case c&0xe0 == 0xa0 || c == 0xd9 || c == 0xda || c == 0xdb:
+		d.readString()
+	case c&0xf0 == 0x90 || c == 0xdc || c == 0xdd:
+		n := d.readArray()
+		for i := 0; i < n && d.err == nil; i++ {
+			d.skip()
+		}
+	case c&0xf0 == 0x80 || c == 0xde || c == 0xdf:
+		n := d.readMap()
+		for i := 0; i < 2*n && d.err == nil; i++ {
+			d.skip()
+		}
+	case c == 0xc0 || c == 0xc2 || c == 0xc3:
+		d.next(1)
+	case c == 0xc4 || c == 0xc5 || c == 0xc6:
+		d.next(1)
+		n := d.uint(1 << (c - 0xc4))
+		d.next(int(n))
+	default:
+		d.fail()
+	}
+}
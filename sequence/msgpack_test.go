@@ -0,0 +1,94 @@
+package sequence
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSequenceMsgpack(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s1 := NewWithValues(x, 60, []uint8{0, 0, 1, 1, 1, 2, 0})
+	s2, _ := NewWithWidth(x, 300, 4)
+	s2.Add(x, 9)
+	s2.Add(x.Add(600*time.Second), 15)
+	s2.SetLength(10)
+	s2.SetMaxAge(time.Hour)
+	tests := []*Sequence{New(x, 60), s1, s2}
+	for i, s := range tests {
+		got, err := UnmarshalMsgpackSequence(s.MarshalMsgpack())
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", i+1, err)
+		}
+		if !bytes.Equal(got.Bytes(), s.Bytes()) {
+			t.Fatalf("test %d: got %v, want %v", i+1, got.Bytes(), s.Bytes())
+		}
+	}
+	// {"frequency": 60, "extra": [nil, true], "runs": [[3, 1]]}
+	b := []byte{0x83, 0xa9, 'f', 'r', 'e', 'q', 'u', 'e', 'n', 'c', 'y', 0x3c,
+		0xa5, 'e', 'x', 't', 'r', 'a', 0x92, 0xc0, 0xc3,
+		0xa4, 'r', 'u', 'n', 's', 0x91, 0x92, 0x03, 0x01}
+	got, err := UnmarshalMsgpackSequence(b)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if want := NewWithValues(time.Unix(0, 0), 60, []uint8{1, 1, 1}); !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Fatalf("got %v, want %v", got.Bytes(), want.Bytes())
+	}
+	invalid := [][]byte{
+		{},
+		{0x80},
+		{0x81, 0xa9, 'f', 'r', 'e', 'q', 'u', 'e', 'n', 'c', 'y', 0x00},
+		{0x81, 0xa9, 'f', 'r', 'e', 'q', 'u', 'e', 'n', 'c', 'y', 0xcd, 0x01},
+		{0x82, 0xa9, 'f', 'r', 'e', 'q', 'u', 'e', 'n', 'c', 'y', 0x3c, 0xa4, 'r', 'u', 'n', 's', 0x91, 0x92, 0x01, 0x04},
+		{0x82, 0xa9, 'f', 'r', 'e', 'q', 'u', 'e', 'n', 'c', 'y', 0x3c, 0xa4, 'r', 'u', 'n', 's', 0x91, 0x91, 0x01},
+		{0x81, 0xa9, 'f', 'r', 'e', 'q', 'u', 'e', 'n', 'c', 'y', 0x3c, 0x00},
+		{0x81, 0xdb, 0xff, 0xff, 0xff, 0xff},
+	}
+	for i, b := range invalid {
+		if _, err := UnmarshalMsgpackSequence(b); err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", i+1)
+		}
+	}
+}
+
+func TestQuerySetMsgpack(t *testing.T) {
+	tests := []QuerySet{
+		{Timestamp: 946782245, Frequency: 300, Sum: []int64{}, Count: []int64{}},
+		{Timestamp: -1, Frequency: 300, Sum: []int64{5, 0, 1 << 40}, Count: []int64{5, 0, math.MinInt64}},
+		{
+			Timestamp:   946782245,
+			Frequency:   300,
+			Sum:         []int64{2, 0},
+			Count:       []int64{3, 0},
+			Timestamps:  []int64{946782245, 946782545},
+			Seconds:     map[uint8][]int64{StateInactive: {60, 0}, StateActive: {120, -200}},
+			Active:      []float64{0.25, 0},
+			Transitions: []int64{1, 0},
+		},
+	}
+	for i, q := range tests {
+		got, err := UnmarshalMsgpackQuerySet(q.MarshalMsgpack())
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", i+1, err)
+		}
+		if !reflect.DeepEqual(got, q) {
+			t.Fatalf("test %d: got %v, want %v", i+1, got, q)
+		}
+	}
+	invalid := [][]byte{
+		{0x81, 0xa3, 's', 'u', 'm', 0x91, 0x01},
+		{0x81, 0xa3, 's', 'u', 'm', 0x92, 0x01},
+		{0x81, 0xa6, 'a', 'c', 't', 'i', 'v', 'e', 0x91, 0x01},
+		{0x81, 0xa3, 's', 'u', 'm', 0xdd, 0xff, 0xff, 0xff, 0xff},
+		{0x83, 0xa3, 's', 'u', 'm', 0x91, 0x01, 0xa5, 'c', 'o', 'u', 'n', 't', 0x91, 0x01, 0xaa, 't', 'i', 'm', 'e', 's', 't', 'a', 'm', 'p', 's', 0x92, 0x01, 0x02},
+		{0x83, 0xa3, 's', 'u', 'm', 0x91, 0x01, 0xa5, 'c', 'o', 'u', 'n', 't', 0x91, 0x01, 0xa7, 's', 'e', 'c', 'o', 'n', 'd', 's', 0x81, 0x01, 0x90},
+	}
+	for i, b := range invalid {
+		if _, err := UnmarshalMsgpackQuerySet(b); err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", i+1)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package sequence
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sort"
+)
+
+// Parquet files written by WriteParquet hold a single row group of uncompressed
+// PLAIN encoded columns, one data page per column:
+//
+//	key        BYTE_ARRAY (UTF8), only written by WriteParquet
+//	timestamp  INT64 (TIMESTAMP_MILLIS)
+//	count      INT64
+//	sum        INT64
+//	mean       optional DOUBLE, null for groups without any value
+
+// Parquet physical types, converted types and encodings.
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetPlain = 0
+	parquetRLE   = 3
+)
+
+// Thrift compact protocol types.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// parquetMagic starts and ends Parquet files.
+const parquetMagic = "PAR1"
+
+// WriteParquet writes q to w as a Parquet file, see WriteParquet for the schema.
+func (q QuerySet) WriteParquet(w io.Writer) error {
+	return writeParquet(w, nil, []QuerySet{q})
+}
+
+// WriteParquet writes sets to w as a single Parquet file holding one row per
+// group, rows being ordered by key then by timestamp. Columns are key,
+// timestamp (in milliseconds), count, sum and mean, the latter being null for
+// groups without any value. It returns an error if a query set is malformed or
+// the first error returned by w.
+func WriteParquet(w io.Writer, sets map[string]QuerySet) error {
+	keys := make([]string, 0, len(sets))
+	for k := range sets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	x := make([]QuerySet, len(keys))
+	for i, k := range keys {
+		x[i] = sets[k]
+	}
+	return writeParquet(w, keys, x)
+}
+
+// A parquetColumn holds the encoded values of a column.
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32 // -1 if none
+	optional  bool
+	values    []byte
+	levels    []bool // definition levels of optional columns
+}
+
+func writeParquet(w io.Writer, keys []string, sets []QuerySet) error {
+	var rows int64
+	for _, q := range sets {
+		if len(q.Sum) != len(q.Count) || q.Timestamps != nil && len(q.Timestamps) != len(q.Count) {
+			return errors.New("invalid query set")
+		}
+		rows += int64(len(q.Count))
+	}
+	columns := []*parquetColumn{
+		{name: "timestamp", typ: parquetInt64, converted: parquetTimestampMillis},
+		{name: "count", typ: parquetInt64, converted: -1},
+		{name: "sum", typ: parquetInt64, converted: -1},
+		{name: "mean", typ: parquetDouble, converted: -1, optional: true},
+	}
+	if keys != nil {
+		columns = append([]*parquetColumn{{name: "key", typ: parquetByteArray, converted: parquetUTF8}}, columns...)
+	}
+	for i, q := range sets {
+		for r := range q.Rows() {
+			c := columns
+			if keys != nil {
+				c[0].values = binary.LittleEndian.AppendUint32(c[0].values, uint32(len(keys[i])))
+				c[0].values = append(c[0].values, keys[i]...)
+				c = c[1:]
+			}
+			c[0].values = binary.LittleEndian.AppendUint64(c[0].values, uint64(r.Timestamp*1000))
+			c[1].values = binary.LittleEndian.AppendUint64(c[1].values, uint64(r.Count))
+			c[2].values = binary.LittleEndian.AppendUint64(c[2].values, uint64(r.Sum))
+			c[3].levels = append(c[3].levels, r.Count != 0)
+			if r.Count != 0 {
+				c[3].values = binary.LittleEndian.AppendUint64(c[3].values, math.Float64bits(r.Mean))
+			}
+		}
+	}
+
+	buf := []byte(parquetMagic)
+	offsets := make([]int64, len(columns))
+	sizes := make([]int64, len(columns))
+	var total int64
+	for i, c := range columns {
+		var page []byte
+		if c.optional {
+			levels := appendParquetLevels(nil, c.levels)
+			page = binary.LittleEndian.AppendUint32(page, uint32(len(levels)))
+			page = append(page, levels...)
+		}
+		page = append(page, c.values...)
+		if rows > math.MaxInt32 || len(page) > math.MaxInt32 {
+			return errors.New("column too large")
+		}
+
+		var e thriftEncoder
+		e.i32(1, 0) // DATA_PAGE
+		e.i32(2, int32(len(page)))
+		e.i32(3, int32(len(page)))
+		e.begin(5)
+		e.i32(1, int32(rows))
+		e.i32(2, parquetPlain)
+		e.i32(3, parquetRLE)
+		e.i32(4, parquetRLE)
+		e.end()
+		header := e.stop()
+
+		offsets[i] = int64(len(buf))
+		sizes[i] = int64(len(header) + len(page))
+		buf = append(buf, header...)
+		buf = append(buf, page...)
+		total += sizes[i]
+	}
+
+	var e thriftEncoder
+	e.i32(1, 1)
+	e.list(2, thriftStruct, len(columns)+1)
+	e.field(4, thriftBinary)
+	e.str("schema")
+	e.i32(5, int32(len(columns)))
+	e.structEnd()
+	for _, c := range columns {
+		e.i32(1, c.typ)
+		repetition := int32(0) // REQUIRED
+		if c.optional {
+			repetition = 1 // OPTIONAL
+		}
+		e.i32(3, repetition)
+		e.field(4, thriftBinary)
+		e.str(c.name)
+		if c.converted >= 0 {
+			e.i32(6, c.converted)
+		}
+		e.structEnd()
+	}
+	e.i64(3, rows)
+	e.list(4, thriftStruct, 1)
+	e.list(1, thriftStruct, len(columns))
+	for i, c := range columns {
+		e.i64(2, offsets[i])
+		e.begin(3)
+		e.i32(1, c.typ)
+		e.list(2, thriftI32, 2)
+		e.varint(parquetPlain)
+		e.varint(parquetRLE)
+		e.list(3, thriftBinary, 1)
+		e.str(c.name)
+		e.i32(4, 0) // UNCOMPRESSED
+		e.i64(5, rows)
+		e.i64(6, sizes[i])
+		e.i64(7, sizes[i])
+		e.i64(9, offsets[i])
+		e.end()
+		e.structEnd()
+	}
+	e.i64(2, total)
+	e.i64(3, rows)
+	e.structEnd()
+	e.field(6, thriftBinary)
+	e.str("github.com/geofduf/run-length")
+	footer := e.stop()
+
+	buf = append(buf, footer...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(footer)))
+	buf = append(buf, parquetMagic...)
+	_, err := w.Write(buf)
+	return err
+}
+
+// appendParquetLevels appends the RLE encoding of the definition levels of an
+// optional column, using a bit width of 1.
+func appendParquetLevels(dst []byte, levels []bool) []byte {
+	for i := 0; i < len(levels); {
+		j := i + 1
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		dst = binary.AppendUvarint(dst, uint64(j-i)<<1)
+		if levels[i] {
+			dst = append(dst, 1)
+		} else {
+			dst = append(dst, 0)
+		}
+		i = j
+	}
+	return dst
+}
+
+// A thriftEncoder encodes structs using the Thrift compact protocol. Fields are
+// written to the current struct, begin and end delimiting nested structs. List
+// elements of type struct are delimited by structEnd.
+type thriftEncoder struct {
+	buf   []byte
+	last  int
+	stack []int
+}
+
+// field writes the header of field id of type typ.
+func (e *thriftEncoder) field(id int, typ byte) {
+	if d := id - e.last; d > 0 && d <= 15 {
+		e.buf = append(e.buf, byte(d)<<4|typ)
+	} else {
+		e.buf = append(e.buf, typ)
+		e.buf = binary.AppendVarint(e.buf, int64(id))
+	}
+	e.last = id
+}
+
+func (e *thriftEncoder) varint(v int64) {
+	e.buf = binary.AppendVarint(e.buf, v)
+}
+
+func (e *thriftEncoder) str(s string) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *thriftEncoder) i32(id int, v int32) {
+	e.field(id, thriftI32)
+	e.varint(int64(v))
+}
+
+func (e *thriftEncoder) i64(id int, v int64) {
+	e.field(id, thriftI64)
+	e.varint(v)
+}
+
+// list writes the header of field id, a list of n elements of type typ. If typ
+// is thriftStruct, each element starts a new struct.
+func (e *thriftEncoder) list(id int, typ byte, n int) {
+	e.field(id, thriftList)
+	if n < 15 {
+		e.buf = append(e.buf, byte(n)<<4|typ)
+	} else {
+		e.buf = append(e.buf, 0xf0|typ)
+		e.buf = binary.AppendUvarint(e.buf, uint64(n))
+	}
+	if typ == thriftStruct {
+		e.stack = append(e.stack, e.last)
+		e.last = 0
+		e.stack = append(e.stack, -n)
+	}
+}
+
+// begin starts field id, a nested struct.
+func (e *thriftEncoder) begin(id int) {
+	e.field(id, thriftStruct)
+	e.stack = append(e.stack, e.last)
+	e.last = 0
+}
+
+// end terminates the current nested struct.
+func (e *thriftEncoder) end() {
+	e.buf = append(e.buf, 0)
+	e.last = e.stack[len(e.stack)-1]
+	e.stack = e.stack[:len(e.stack)-1]
+}
+
+// structEnd terminates the current element of a list of structs, and the list
+// itself after its last element.
+func (e *thriftEncoder) structEnd() {
+	e.buf = append(e.buf, 0)
+	e.last = 0
+	k := len(e.stack) - 1
+	if e.stack[k]++; e.stack[k] == 0 {
+		e.last = e.stack[k-1]
+		e.stack = e.stack[:k-1]
+	}
+}
+
+// stop terminates the top-level struct and returns the encoding.
+func (e *thriftEncoder) stop() []byte {
+	return append(e.buf, 0)
+}
@@ -0,0 +1,157 @@
+package sequence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestWriteParquet(t *testing.T) {
+	a := QuerySet{Timestamp: 946782245, Frequency: 300, Sum: []int64{5, 0, 1}, Count: []int64{5, 0, 4}}
+	b := QuerySet{Timestamp: 946782245, Frequency: 300, Sum: []int64{0}, Count: []int64{2}}
+
+	var buf bytes.Buffer
+	if err := WriteParquet(&buf, map[string]QuerySet{"web": a, "db": b}); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	columns := readParquet(t, buf.Bytes())
+	want := map[string][]any{
+		"key":       {"db", "web", "web", "web"},
+		"timestamp": {int64(946782245000), int64(946782245000), int64(946782545000), int64(946782845000)},
+		"count":     {int64(2), int64(5), int64(0), int64(4)},
+		"sum":       {int64(0), int64(5), int64(0), int64(1)},
+		"mean":      {0.0, 1.0, nil, 0.25},
+	}
+	if !reflect.DeepEqual(columns, want) {
+		t.Fatalf("got %v, want %v", columns, want)
+	}
+
+	buf.Reset()
+	if err := a.WriteParquet(&buf); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	columns = readParquet(t, buf.Bytes())
+	if _, ok := columns["key"]; ok || !reflect.DeepEqual(columns["mean"], []any{1.0, nil, 0.25}) {
+		t.Fatalf("got %v", columns)
+	}
+
+	if err := (QuerySet{Sum: []int64{1}}).WriteParquet(&buf); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if err := a.WriteParquet(failingWriter{}); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+// readParquet decodes the columns of a Parquet file written by WriteParquet.
+func readParquet(t *testing.T, data []byte) map[string][]any {
+	t.Helper()
+	if len(data) < 12 || string(data[:4]) != parquetMagic || string(data[len(data)-4:]) != parquetMagic {
+		t.Fatal("invalid magic bytes")
+	}
+	n := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := data[len(data)-8-n : len(data)-8]
+	meta, rest := readThriftStruct(t, footer)
+	if len(rest) != 0 {
+		t.Fatal("trailing bytes in footer")
+	}
+	rows := meta[3].(int64)
+	schema := meta[2].([]any)
+	columns := make(map[string][]any)
+	for i, c := range meta[4].([]any)[0].(map[int]any)[1].([]any) {
+		element := schema[i+1].(map[int]any)
+		name := element[4].(string)
+		md := c.(map[int]any)[3].(map[int]any)
+		header, page := readThriftStruct(t, data[md[9].(int64):])
+		if size := header[2].(int64); int64(len(page)) < size {
+			t.Fatal("truncated page")
+		} else {
+			page = page[:size]
+		}
+		var levels []bool
+		if element[3].(int64) == 1 {
+			n := binary.LittleEndian.Uint32(page)
+			for l := page[4 : 4+n]; len(l) > 0; l = l[2:] {
+				for j := 0; j < int(l[0]>>1); j++ {
+					levels = append(levels, l[1] == 1)
+				}
+			}
+			page = page[4+n:]
+		}
+		for j := int64(0); j < rows; j++ {
+			if levels != nil && !levels[j] {
+				columns[name] = append(columns[name], nil)
+				continue
+			}
+			switch element[1].(int64) {
+			case parquetInt64:
+				columns[name] = append(columns[name], int64(binary.LittleEndian.Uint64(page)))
+				page = page[8:]
+			case parquetDouble:
+				columns[name] = append(columns[name], math.Float64frombits(binary.LittleEndian.Uint64(page)))
+				page = page[8:]
+			case parquetByteArray:
+				n := binary.LittleEndian.Uint32(page)
+				columns[name] = append(columns[name], string(page[4:4+n]))
+				page = page[4+n:]
+			}
+		}
+		if len(page) != 0 {
+			t.Fatalf("column %s: trailing bytes in page", name)
+		}
+	}
+	return columns
+}
+
+// readThriftStruct decodes a struct encoded with the Thrift compact protocol,
+// supporting the types used by WriteParquet.
+func readThriftStruct(t *testing.T, b []byte) (map[int]any, []byte) {
+	t.Helper()
+	m := make(map[int]any)
+	id := 0
+	for {
+		h := b[0]
+		b = b[1:]
+		if h == 0 {
+			return m, b
+		}
+		if h>>4 != 0 {
+			id += int(h >> 4)
+		} else {
+			v, n := binary.Varint(b)
+			id, b = int(v), b[n:]
+		}
+		m[id], b = readThriftValue(t, h&0x0f, b)
+	}
+}
+
+func readThriftValue(t *testing.T, typ byte, b []byte) (any, []byte) {
+	t.Helper()
+	switch typ {
+	case thriftI32, thriftI64:
+		v, n := binary.Varint(b)
+		return v, b[n:]
+	case thriftBinary:
+		v, n := binary.Uvarint(b)
+		return string(b[n : n+int(v)]), b[n+int(v):]
+	case thriftStruct:
+		return readThriftStruct(t, b)
+	case thriftList:
+		h := b[0]
+		b = b[1:]
+		size := int(h >> 4)
+		if size == 15 {
+			v, n := binary.Uvarint(b)
+			size, b = int(v), b[n:]
+		}
+		x := make([]any, size)
+		for i := range x {
+			x[i], b = readThriftValue(t, h&0x0f, b)
+		}
+		return x, b
+	}
+	t.Fatalf("unsupported thrift type %d", typ)
+	return nil, nil
+}
@@ -0,0 +1,138 @@
+package sequence
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SerializePrometheus returns an encoding of the time series using the Prometheus
+// text exposition format. Each group holding values is exposed as a sample of the
+// gauge name with labels, its value being the mean value of the group and its
+// timestamp the one of the group. It returns an error if name or a label name is
+// not valid.
+func (q QuerySet) SerializePrometheus(name string, labels map[string]string) ([]byte, error) {
+	prefix, err := prometheusPrefix(name, labels)
+	if err != nil {
+		return nil, err
+	}
+	buf := appendPrometheusHeader(nil, name)
+	for r := range q.Rows() {
+		if r.Count == 0 {
+			continue
+		}
+		buf = append(buf, prefix...)
+		buf = strconv.AppendFloat(buf, r.Mean, 'g', -1, 64)
+		buf = append(buf, ' ')
+		buf = strconv.AppendInt(buf, r.Timestamp*1000, 10)
+		buf = append(buf, '\n')
+	}
+	return buf, nil
+}
+
+// SerializePrometheus returns an encoding of the latest value stored in the
+// sequences whose key satisfies selector using the Prometheus text exposition
+// format, so that the store can be scraped directly. Each sequence is exposed as
+// a sample of the gauge name with labels and an additional key label, its value
+// being the state of the latest value and its timestamp the one of the value.
+// Sequences without any value are omitted. It returns an error if name or a
+// label name is not valid.
+func (s *Store) SerializePrometheus(name string, labels map[string]string, selector func(key string) bool) ([]byte, error) {
+	if _, ok := labels["key"]; ok {
+		return nil, errors.New("invalid label name")
+	}
+	if _, err := prometheusPrefix(name, labels); err != nil {
+		return nil, err
+	}
+	withKey := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		withKey[k] = v
+	}
+	s.rlockAll()
+	defer s.runlockAll()
+	keys := make([]string, 0, len(s.m))
+	for k := range s.m {
+		if selector(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	buf := appendPrometheusHeader(nil, name)
+	for _, k := range keys {
+		values, ts := s.m[k].LastN(1)
+		if len(values) == 0 {
+			continue
+		}
+		withKey["key"] = k
+		prefix, _ := prometheusPrefix(name, withKey)
+		buf = append(buf, prefix...)
+		buf = strconv.AppendUint(buf, uint64(values[0]), 10)
+		buf = append(buf, ' ')
+		buf = strconv.AppendInt(buf, ts*1000, 10)
+		buf = append(buf, '\n')
+	}
+	return buf, nil
+}
+
+// appendPrometheusHeader appends the metadata lines of the gauge name to dst.
+func appendPrometheusHeader(dst []byte, name string) []byte {
+	dst = append(dst, "# TYPE "...)
+	dst = append(dst, name...)
+	return append(dst, " gauge\n"...)
+}
+
+// prometheusPrefix returns the metric name and the sorted labels of a sample,
+// followed by a space.
+func prometheusPrefix(name string, labels map[string]string) (string, error) {
+	if !validPrometheusName(name, true) {
+		return "", errors.New("invalid metric name")
+	}
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		if !validPrometheusName(k, false) || strings.HasPrefix(k, "__") {
+			return "", errors.New("invalid label name")
+		}
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString(name)
+	if len(names) > 0 {
+		b.WriteByte('{')
+		for i, k := range names {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(k)
+			b.WriteString(`="`)
+			b.WriteString(prometheusEscaper.Replace(labels[k]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	return b.String(), nil
+}
+
+// prometheusEscaper escapes label values.
+var prometheusEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// validPrometheusName reports whether s is a valid metric name, or label name if
+// metric is false.
+func validPrometheusName(s string, metric bool) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case c == ':' && metric:
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
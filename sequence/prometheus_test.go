@@ -0,0 +1,72 @@
+package sequence
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuerySetSerializePrometheus(t *testing.T) {
+	q := QuerySet{Timestamp: 946782245, Frequency: 300, Sum: []int64{5, 0, 1}, Count: []int64{5, 0, 4}}
+	got, err := q.SerializePrometheus("service_up", map[string]string{"job": "web", "dc": `par"1`})
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	want := strings.Join([]string{
+		"# TYPE service_up gauge",
+		`service_up{dc="par\"1",job="web"} 1 946782245000`,
+		`service_up{dc="par\"1",job="web"} 0.25 946782845000`,
+		"",
+	}, "\n")
+	if string(got) != want {
+		t.Fatalf("\ngot  %s\nwant %s", got, want)
+	}
+	got, _ = q.SerializePrometheus("up", nil)
+	if want := "# TYPE up gauge\nup 1 946782245000\nup 0.25 946782845000\n"; string(got) != want {
+		t.Fatalf("\ngot  %s\nwant %s", got, want)
+	}
+	tests := []struct {
+		name   string
+		labels map[string]string
+	}{
+		{"", nil},
+		{"1up", nil},
+		{"service-up", nil},
+		{"up", map[string]string{"a:b": "x"}},
+		{"up", map[string]string{"__name": "x"}},
+	}
+	for i, tt := range tests {
+		if _, err := q.SerializePrometheus(tt.name, tt.labels); err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", i+1)
+		}
+	}
+}
+
+func TestStoreSerializePrometheus(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	store := NewStore()
+	store.Add("web2", NewWithValues(x, 60, []uint8{1, 0}))
+	store.Add("web1", NewWithValues(x, 60, []uint8{0, 1, 1}))
+	store.Add("web3", New(x, 60))
+	store.Add("db1", NewWithValues(x, 60, []uint8{1}))
+	web := func(key string) bool { return strings.HasPrefix(key, "web") }
+	got, err := store.SerializePrometheus("service_state", map[string]string{"env": "prod"}, web)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	want := strings.Join([]string{
+		"# TYPE service_state gauge",
+		`service_state{env="prod",key="web1"} 1 946782365000`,
+		`service_state{env="prod",key="web2"} 0 946782305000`,
+		"",
+	}, "\n")
+	if string(got) != want {
+		t.Fatalf("\ngot  %s\nwant %s", got, want)
+	}
+	if _, err := store.SerializePrometheus("up", map[string]string{"key": "x"}, web); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if _, err := store.SerializePrometheus("up-", nil, web); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
@@ -0,0 +1,415 @@
+package sequence
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// Protocol buffers wire types.
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+// errInvalidProto is returned when a protocol buffers message cannot be decoded.
+var errInvalidProto = errors.New("cannot decode the message")
+
+// MarshalProto returns the encoding of s as a Sequence message, as defined in
+// sequence.proto.
+func (s *Sequence) MarshalProto() []byte {
+	var buf []byte
+	buf = protoAppendVarint(buf, 1, uint64(s.ts))
+	buf = protoAppendVarint(buf, 2, uint64(s.frequency))
+	buf = protoAppendVarint(buf, 3, uint64(s.length))
+	buf = protoAppendVarint(buf, 4, uint64(s.bits()))
+	buf = protoAppendVarint(buf, 5, uint64(s.maxAge))
+	var run []byte
+	s.walk(0, int64(s.count)-1, func(j, n int64, v uint8) bool {
+		run = protoAppendVarint(run[:0], 1, uint64(n))
+		run = protoAppendVarint(run, 2, uint64(v))
+		buf = protoAppendBytes(buf, 6, run)
+		return true
+	})
+	return buf
+}
+
+// UnmarshalProtoSequence creates a Sequence using data, a Sequence message as
+// defined in sequence.proto. It returns an error if data cannot be decoded or if
+// the message doesn't describe a valid sequence.
+func UnmarshalProtoSequence(data []byte) (*Sequence, error) {
+	var ts, maxAge int64
+	var f, length, w uint64
+	var runs []Run
+	err := protoFields(data, func(num int, wt int, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			ts = int64(v)
+		case 2:
+			f = v
+		case 3:
+			length = v
+		case 4:
+			w = v
+		case 5:
+			maxAge = int64(v)
+		case 6:
+			if wt != protoBytes {
+				return errInvalidProto
+			}
+			var r Run
+			err := protoFields(b, func(num int, wt int, v uint64, b []byte) error {
+				switch num {
+				case 1:
+					r.Count = uint32(v)
+				case 2:
+					r.Value = uint8(v)
+					if v > math.MaxUint8 {
+						return errInvalidProto
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			runs = append(runs, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if w == 0 {
+		w = flagBits
+	}
+	if f == 0 || f > math.MaxUint16 || w > MaxStateWidth || length > math.MaxUint32 || maxAge < 0 {
+		return nil, errors.New("invalid sequence")
+	}
+	s, err := NewWithWidth(time.Unix(ts, 0), uint16(f), uint8(w))
+	if err != nil {
+		return nil, errors.New("invalid sequence")
+	}
+	if length != 0 {
+		if err := s.Resize(uint32(length)); err != nil {
+			return nil, err
+		}
+	}
+	s.maxAge = maxAge
+	for _, r := range runs {
+		if r.Count == 0 {
+			continue
+		}
+		if r.Value >= 1<<s.bits() || r.Count > s.length-s.count {
+			return nil, errors.New("invalid sequence")
+		}
+		s.addSeries(r.Count, r.Value)
+	}
+	return s, nil
+}
+
+// MarshalProto returns the encoding of q as a QuerySet message, as defined in
+// sequence.proto.
+func (q QuerySet) MarshalProto() []byte {
+	var buf []byte
+	buf = protoAppendVarint(buf, 1, uint64(q.Timestamp))
+	buf = protoAppendVarint(buf, 2, uint64(q.Frequency))
+	buf = protoAppendPacked(buf, 3, q.Sum)
+	buf = protoAppendPacked(buf, 4, q.Count)
+	buf = protoAppendPacked(buf, 5, q.Timestamps)
+	if len(q.Active) > 0 {
+		b := make([]byte, 0, 8*len(q.Active))
+		for _, v := range q.Active {
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+		}
+		buf = protoAppendBytes(buf, 6, b)
+	}
+	buf = protoAppendPacked(buf, 7, q.Transitions)
+	states := make([]int, 0, len(q.Seconds))
+	for k := range q.Seconds {
+		states = append(states, int(k))
+	}
+	sort.Ints(states)
+	var b []byte
+	for _, k := range states {
+		b = protoAppendVarint(b[:0], 1, uint64(k))
+		b = protoAppendPacked(b, 2, q.Seconds[uint8(k)])
+		buf = protoAppendBytes(buf, 8, b)
+	}
+	return buf
+}
+
+// UnmarshalProtoQuerySet creates a QuerySet using data, a QuerySet message as
+// defined in sequence.proto. It returns an error if data cannot be decoded.
+func UnmarshalProtoQuerySet(data []byte) (QuerySet, error) {
+	var q QuerySet
+	err := protoFields(data, func(num int, wt int, v uint64, b []byte) error {
+		var err error
+		switch num {
+		case 1:
+			q.Timestamp = int64(v)
+		case 2:
+			q.Frequency = int64(v)
+		case 3:
+			q.Sum, err = protoInt64s(q.Sum, wt, v, b)
+		case 4:
+			q.Count, err = protoInt64s(q.Count, wt, v, b)
+		case 5:
+			q.Timestamps, err = protoInt64s(q.Timestamps, wt, v, b)
+		case 6:
+			q.Active, err = protoFloat64s(q.Active, wt, v, b)
+		case 7:
+			q.Transitions, err = protoInt64s(q.Transitions, wt, v, b)
+		case 8:
+			if wt != protoBytes {
+				return errInvalidProto
+			}
+			var state uint64
+			var seconds []int64
+			err = protoFields(b, func(num int, wt int, v uint64, b []byte) error {
+				var err error
+				switch num {
+				case 1:
+					state = v
+				case 2:
+					seconds, err = protoInt64s(seconds, wt, v, b)
+				}
+				return err
+			})
+			if err == nil && state > math.MaxUint8 {
+				err = errInvalidProto
+			}
+			if err == nil {
+				if q.Seconds == nil {
+					q.Seconds = make(map[uint8][]int64)
+				}
+				q.Seconds[uint8(state)] = seconds
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return QuerySet{}, err
+	}
+	if err := checkQuerySet(q); err != nil {
+		return QuerySet{}, err
+	}
+	if q.Sum == nil {
+		q.Sum, q.Count = []int64{}, []int64{}
+	}
+	return q, nil
+}
+
+// MarshalProto returns the encoding of s as a Statement message, as defined in
+// sequence.proto.
+func (s Statement) MarshalProto() []byte {
+	var buf []byte
+	buf = protoAppendBytes(buf, 1, []byte(s.Key))
+	buf = protoAppendTime(buf, 2, s.Timestamp)
+	buf = protoAppendVarint(buf, 3, uint64(s.Value))
+	buf = protoAppendVarint(buf, 4, uint64(s.Type))
+	if s.CreateIfNotExists {
+		buf = protoAppendVarint(buf, 5, 1)
+	}
+	buf = protoAppendTime(buf, 6, s.CreateWithTimestamp)
+	buf = protoAppendVarint(buf, 7, uint64(s.CreateWithFrequency))
+	buf = protoAppendVarint(buf, 8, uint64(s.CreateWithLength))
+	buf = protoAppendVarint(buf, 9, uint64(s.Length))
+	buf = protoAppendVarint(buf, 10, uint64(s.Count))
+	if s.OnlyIfChanged {
+		buf = protoAppendVarint(buf, 11, 1)
+	}
+	if s.OnlyIfOlderThan > 0 {
+		buf = protoAppendVarint(buf, 12, uint64(s.OnlyIfOlderThan/time.Second))
+	}
+	return buf
+}
+
+// UnmarshalProtoStatement creates a Statement using data, a Statement message as
+// defined in sequence.proto. It returns an error if data cannot be decoded.
+func UnmarshalProtoStatement(data []byte) (Statement, error) {
+	var s Statement
+	err := protoFields(data, func(num int, wt int, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			if wt != protoBytes {
+				return errInvalidProto
+			}
+			s.Key = string(b)
+		case 2:
+			s.Timestamp = time.Unix(int64(v), 0)
+		case 3:
+			s.Value = uint8(v)
+		case 4:
+			s.Type = uint8(v)
+		case 5:
+			s.CreateIfNotExists = v != 0
+		case 6:
+			s.CreateWithTimestamp = time.Unix(int64(v), 0)
+		case 7:
+			s.CreateWithFrequency = uint16(v)
+		case 8:
+			s.CreateWithLength = uint32(v)
+		case 9:
+			s.Length = uint32(v)
+		case 10:
+			s.Count = uint32(v)
+		case 11:
+			s.OnlyIfChanged = v != 0
+		case 12:
+			s.OnlyIfOlderThan = time.Duration(v) * time.Second
+		}
+		if (num == 3 || num == 4) && v > math.MaxUint8 || num == 7 && v > math.MaxUint16 || num >= 8 && num <= 10 && v > math.MaxUint32 || num == 12 && v > math.MaxInt64/uint64(time.Second) {
+			return errInvalidProto
+		}
+		return nil
+	})
+	if err != nil {
+		return Statement{}, err
+	}
+	return s, nil
+}
+
+// protoAppendVarint appends field num holding v to dst, omitting it if v is 0.
+func protoAppendVarint(dst []byte, num int, v uint64) []byte {
+	if v == 0 {
+		return dst
+	}
+	dst = binary.AppendUvarint(dst, uint64(num)<<3|protoVarint)
+	return binary.AppendUvarint(dst, v)
+}
+
+// protoAppendBytes appends field num holding b to dst, omitting it if b is empty.
+func protoAppendBytes(dst []byte, num int, b []byte) []byte {
+	if len(b) == 0 {
+		return dst
+	}
+	dst = binary.AppendUvarint(dst, uint64(num)<<3|protoBytes)
+	dst = binary.AppendUvarint(dst, uint64(len(b)))
+	return append(dst, b...)
+}
+
+// protoAppendPacked appends the packed repeated field num holding x to dst,
+// omitting it if x is empty.
+func protoAppendPacked(dst []byte, num int, x []int64) []byte {
+	if len(x) == 0 {
+		return dst
+	}
+	n := 0
+	for _, v := range x {
+		n += uvarintSize(uint64(v))
+	}
+	dst = binary.AppendUvarint(dst, uint64(num)<<3|protoBytes)
+	dst = binary.AppendUvarint(dst, uint64(n))
+	for _, v := range x {
+		dst = binary.AppendUvarint(dst, uint64(v))
+	}
+	return dst
+}
+
+// protoAppendTime appends field num holding the Unix time of t to dst, omitting
+// it if t is the zero time. The field is present even if the Unix time is 0.
+func protoAppendTime(dst []byte, num int, t time.Time) []byte {
+	if t.IsZero() {
+		return dst
+	}
+	dst = binary.AppendUvarint(dst, uint64(num)<<3|protoVarint)
+	return binary.AppendUvarint(dst, uint64(t.Unix()))
+}
+
+// uvarintSize returns the number of bytes of the varint encoding of v.
+func uvarintSize(v uint64) int {
+	n := 1
+	for v >= 0x80 {
+		v >>= 7
+		n++
+	}
+	return n
+}
+
+// protoFields calls fn for each field of the message data with the number and
+// the wire type of the field. v holds the value of numeric fields and b the
+// content of length-delimited fields.
+func protoFields(data []byte, fn func(num int, wt int, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 || tag>>3 == 0 || tag>>3 > math.MaxInt32 {
+			return errInvalidProto
+		}
+		data = data[n:]
+		num, wt := int(tag>>3), int(tag&7)
+		var v uint64
+		var b []byte
+		switch wt {
+		case protoVarint:
+			v, n = binary.Uvarint(data)
+			if n <= 0 {
+				return errInvalidProto
+			}
+			data = data[n:]
+		case protoFixed64:
+			if len(data) < 8 {
+				return errInvalidProto
+			}
+			v, data = binary.LittleEndian.Uint64(data), data[8:]
+		case protoBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return errInvalidProto
+			}
+			b, data = data[n:n+int(size)], data[n+int(size):]
+		case protoFixed32:
+			if len(data) < 4 {
+				return errInvalidProto
+			}
+			v, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		default:
+			return errInvalidProto
+		}
+		if err := fn(num, wt, v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// protoInt64s appends to dst the values of a repeated int64 field, packed or not.
+func protoInt64s(dst []int64, wt int, v uint64, b []byte) ([]int64, error) {
+	switch wt {
+	case protoVarint:
+		return append(dst, int64(v)), nil
+	case protoBytes:
+		for len(b) > 0 {
+			x, n := binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errInvalidProto
+			}
+			dst = append(dst, int64(x))
+			b = b[n:]
+		}
+		return dst, nil
+	}
+	return nil, errInvalidProto
+}
+
+// protoFloat64s appends to dst the values of a repeated double field, packed or
+// not.
+func protoFloat64s(dst []float64, wt int, v uint64, b []byte) ([]float64, error) {
+	switch wt {
+	case protoFixed64:
+		return append(dst, math.Float64frombits(v)), nil
+	case protoBytes:
+		if len(b)%8 != 0 {
+			return nil, errInvalidProto
+		}
+		for ; len(b) > 0; b = b[8:] {
+			dst = append(dst, math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		}
+		return dst, nil
+	}
+	return nil, errInvalidProto
+}
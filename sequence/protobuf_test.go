@@ -0,0 +1,113 @@
+package sequence
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSequenceProto(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s1 := NewWithValues(x, 60, []uint8{0, 0, 1, 1, 1, 2, 0})
+	s2, _ := NewWithWidth(x, 300, 4)
+	s2.Add(x, 9)
+	s2.Add(x.Add(600*time.Second), 15)
+	s2.SetLength(10)
+	s2.SetMaxAge(time.Hour)
+	tests := []*Sequence{New(x, 60), s1, s2}
+	for i, s := range tests {
+		got, err := UnmarshalProtoSequence(s.MarshalProto())
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", i+1, err)
+		}
+		if !bytes.Equal(got.Bytes(), s.Bytes()) {
+			t.Fatalf("test %d: got %v, want %v", i+1, got.Bytes(), s.Bytes())
+		}
+	}
+	invalid := [][]byte{
+		{0x08},
+		{0x10, 0x00},
+		{0x10, 0x3c, 0x20, 0x05},
+		{0x10, 0x3c, 0x32, 0x04, 0x08, 0x01, 0x10, 0x04},
+		{0x10, 0x3c, 0x18, 0x01, 0x32, 0x02, 0x08, 0x02},
+		{0x10, 0x3c, 0x32, 0x05, 0x08, 0x01},
+	}
+	for i, b := range invalid {
+		if _, err := UnmarshalProtoSequence(b); err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", i+1)
+		}
+	}
+}
+
+func TestQuerySetProto(t *testing.T) {
+	tests := []QuerySet{
+		{Timestamp: 946782245, Frequency: 300, Sum: []int64{}, Count: []int64{}},
+		{Timestamp: 946782245, Frequency: 300, Sum: []int64{5, 0, 1}, Count: []int64{5, 0, 4}},
+		{
+			Timestamp:   946782245,
+			Frequency:   300,
+			Sum:         []int64{2, 0},
+			Count:       []int64{3, 0},
+			Timestamps:  []int64{946782245, 946782545},
+			Seconds:     map[uint8][]int64{StateInactive: {60, 0}, StateActive: {120, 0}},
+			Active:      []float64{0.25, 0},
+			Transitions: []int64{1, 0},
+		},
+	}
+	for i, q := range tests {
+		got, err := UnmarshalProtoQuerySet(q.MarshalProto())
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", i+1, err)
+		}
+		if !reflect.DeepEqual(got, q) {
+			t.Fatalf("test %d: got %v, want %v", i+1, got, q)
+		}
+	}
+	invalid := [][]byte{
+		{0x1a, 0x01, 0x05},
+		{0x1a, 0x02, 0x80},
+		{0x32, 0x03, 0x00, 0x00, 0x00},
+		{0x08, 0x80, 0xe2, 0xcf, 0xaa, 0x06, 0x28, 0xd8, 0x04, 0x1a, 0x07, 0x03, 0x04, 0x55, 0x03, 0x04, 0x00, 0x00, 0x22, 0x07, 0x07, 0x69, 0x06, 0x07, 0x07, 0x00, 0x00},
+	}
+	for i, b := range invalid {
+		if _, err := UnmarshalProtoQuerySet(b); err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", i+1)
+		}
+	}
+}
+
+func TestStatementProto(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	tests := []Statement{
+		{},
+		{"key", x, StateActive, StatementAdd, false, time.Time{}, 0, 0, 0, 0, false, 0},
+		{"key", x, StateUnknown, StatementAdd, true, time.Unix(0, 0), 60, 1440, 0, 0, false, 0},
+		{"key", time.Time{}, 0, StatementSetLength, false, time.Time{}, 0, 0, 1440, 0, false, 0},
+		{"key", x, StateActive, StatementAdd, false, time.Time{}, 0, 0, 0, 120, false, 0},
+		{"key", x, StateActive, StatementRoll, false, time.Time{}, 0, 0, 0, 0, true, time.Hour},
+	}
+	for i, s := range tests {
+		got, err := UnmarshalProtoStatement(s.MarshalProto())
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", i+1, err)
+		}
+		if got.Key != s.Key || !got.Timestamp.Equal(s.Timestamp) || got.Timestamp.IsZero() != s.Timestamp.IsZero() ||
+			got.Value != s.Value || got.Type != s.Type || got.CreateIfNotExists != s.CreateIfNotExists ||
+			!got.CreateWithTimestamp.Equal(s.CreateWithTimestamp) || got.CreateWithTimestamp.IsZero() != s.CreateWithTimestamp.IsZero() ||
+			got.CreateWithFrequency != s.CreateWithFrequency || got.CreateWithLength != s.CreateWithLength {
+			t.Fatalf("test %d: got %v, want %v", i+1, got, s)
+		}
+	}
+	invalid := [][]byte{
+		{0x0a, 0x05, 'k'},
+		{0x18, 0x80, 0x02},
+		{0x38, 0x80, 0x80, 0x04},
+		{0x0f},
+	}
+	for i, b := range invalid {
+		if _, err := UnmarshalProtoStatement(b); err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", i+1)
+		}
+	}
+}
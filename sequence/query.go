@@ -2,6 +2,8 @@ package sequence
 
 import (
 	"errors"
+	"iter"
+	"math"
 	"time"
 )
 
@@ -23,6 +25,30 @@ type QuerySet struct {
 	// Count holds the number of valid values in
 	// in each group.
 	Count []int64
+
+	// Timestamps holds the unix time associated to
+	// each element of the time series when groups
+	// don't have a fixed size, in which case
+	// Frequency is 0. It is only set by
+	// QueryCalendar.
+	Timestamps []int64
+
+	// Seconds holds for each state the time
+	// spent in that state in each group, in
+	// seconds. It is only set when requested
+	// with WithDurations.
+	Seconds map[uint8][]int64
+
+	// Active holds the percentage of active values
+	// in each group, NaN if the group has no value
+	// to account for. It is only set when
+	// requested with WithActive.
+	Active []float64
+
+	// Transitions holds the number of state
+	// changes in each group. It is only set
+	// when requested with WithTransitions.
+	Transitions []int64
 }
 
 // Values returns raw values stored in the sequence using start and end as
@@ -87,16 +113,389 @@ func (s *Sequence) Values(start, end time.Time) ([]uint8, int64, error) {
 	return data, s.ts + x*f, nil
 }
 
+// LastN returns the last n values stored in s, or all of them if s stores less
+// than n values. The second return value is the Unix time associated to the
+// first element of the slice, or 0 if the slice is empty. Runs are read from the
+// end of the sequence so that the cost doesn't depend on its length.
+func (s *Sequence) LastN(n int) ([]uint8, int64) {
+	if n < 1 || s.count == 0 {
+		return []uint8{}, 0
+	}
+	if int64(n) > int64(s.count) {
+		n = int(s.count)
+	}
+	data := make([]uint8, n)
+	i := n
+	end := len(s.data)
+	for i > 0 && end > 0 {
+		p := end - 1
+		for p > 0 && s.data[p-1] >= 0x80 {
+			p--
+		}
+		count, v, _ := s.next(p)
+		for k := uint32(0); k < count && i > 0; k++ {
+			i--
+			data[i] = v
+		}
+		end = p
+	}
+	return data, s.ts + (int64(s.count)-int64(n))*int64(s.frequency)
+}
+
+// ValuesPage works like Values but returns at most limit values, starting with
+// the value at index offset of the unrestricted result, so that values can be
+// paged through without retrieving them entirely. It returns an empty slice if
+// offset is beyond the last value, and an error if offset is negative or limit
+// is not positive.
+func (s *Sequence) ValuesPage(start, end time.Time, offset, limit int) ([]uint8, int64, error) {
+	if start.After(end) || offset < 0 || limit < 1 {
+		return []uint8{}, 0, errors.New("invalid arguments")
+	}
+	x, y, ok := s.offsets(start, end)
+	if !ok {
+		return []uint8{}, 0, errors.New("out of bounds")
+	}
+	x += int64(offset)
+	if x > y {
+		return []uint8{}, 0, nil
+	}
+	if v := x + int64(limit) - 1; v < y {
+		y = v
+	}
+	f := int64(s.frequency)
+	return s.Values(time.Unix(s.ts+x*f, 0), time.Unix(s.ts+y*f, 0))
+}
+
+// A ThinningMode defines how ValuesMax picks a value for a series of
+// consecutive values.
+type ThinningMode uint8
+
+// Thinning modes.
+const (
+	ThinStride ThinningMode = iota // first value of the series
+	ThinWorst                      // worst value of the series
+	thinningModeInvalid
+)
+
+// ValuesMax works like Values but returns at most n values, each of them
+// representing a series of consecutive values according to m. When using
+// ThinWorst, StateInactive is considered worse than StateUnknown, itself worse
+// than any other state. The third return value is the number of seconds between
+// two elements of the slice. It returns an error if n is not positive or m is
+// not a valid mode.
+func (s *Sequence) ValuesMax(start, end time.Time, n int, m ThinningMode) ([]uint8, int64, int64, error) {
+	if start.After(end) || n < 1 {
+		return []uint8{}, 0, 0, errors.New("invalid arguments")
+	}
+	if m >= thinningModeInvalid {
+		return []uint8{}, 0, 0, errors.New("invalid thinning mode")
+	}
+
+	x, y, ok := s.offsets(start, end)
+	if !ok {
+		return []uint8{}, 0, 0, errors.New("out of bounds")
+	}
+
+	f := int64(s.frequency)
+	k := ceilInt64(y-x+1, int64(n)) / int64(n)
+	data := make([]uint8, (y-x)/k+1)
+	for i := range data {
+		data[i] = StateUnknown
+	}
+
+	stored := make([]bool, len(data))
+	s.walk(x, y, func(j, count int64, v uint8) bool {
+		a, b := (j-x)/k, (j+count-1-x)/k
+		for i := a; i <= b; i++ {
+			if m == ThinStride {
+				if x+i*k >= j {
+					data[i] = v
+				}
+				continue
+			}
+			if !stored[i] || worse(v, data[i]) {
+				data[i] = v
+			}
+			stored[i] = true
+		}
+		return true
+	})
+
+	if m == ThinWorst {
+		for i := range data {
+			last := x + (int64(i)+1)*k - 1
+			if last > y {
+				last = y
+			}
+			if last >= int64(s.count) && worse(StateUnknown, data[i]) {
+				data[i] = StateUnknown
+			}
+		}
+	}
+
+	return data, s.ts + x*f, k * f, nil
+}
+
+// worse reports whether state a is worse than state b as defined by ThinWorst.
+func worse(a, b uint8) bool {
+	rank := func(v uint8) int {
+		switch v {
+		case StateInactive:
+			return 0
+		case StateUnknown:
+			return 1
+		}
+		return 2
+	}
+	return rank(a) < rank(b)
+}
+
+// A QueryOption configures the behavior of a query.
+type QueryOption func(*queryOptions)
+
+// queryOptions holds the configuration of a query.
+type queryOptions struct {
+	align     time.Duration
+	aligned   bool
+	state     uint8
+	filtered  bool
+	policy    UnknownPolicy
+	active    bool
+	durations bool
+	flaps     bool
+	window    time.Duration
+	offset    int
+	limit     int
+	paged     bool
+}
+
+// AlignTo aligns groups on multiples of a since the Unix epoch instead of
+// start, so that for instance hourly groups begin on the hour whatever the
+// value of start. The first group may begin before start but values outside of
+// the interval filter are not accounted for. The query fails if a is less than
+// a second.
+func AlignTo(a time.Duration) QueryOption {
+	return func(o *queryOptions) {
+		o.align, o.aligned = a, true
+	}
+}
+
+// CountState sets Sum to the number of values x in each group and Count to
+// the number of values stored in each group, whatever their state, so that for
+// instance StateUnknown values can be counted.
+func CountState(x uint8) QueryOption {
+	return func(o *queryOptions) {
+		o.state, o.filtered = x, true
+	}
+}
+
+// WithActive sets Active to the percentage of Sum to Count in each group,
+// accounting for StateUnknown values according to p. The query fails if p is
+// not a valid policy.
+func WithActive(p UnknownPolicy) QueryOption {
+	return func(o *queryOptions) {
+		o.policy, o.active = p, true
+	}
+}
+
+// WithDurations sets Seconds to the time spent in each state in each group.
+func WithDurations() QueryOption {
+	return func(o *queryOptions) {
+		o.durations = true
+	}
+}
+
+// WithTransitions sets Transitions to the number of state changes in each
+// group as defined by Sequence.Transitions, a change being accounted for in the
+// group of the first value in the new state.
+func WithTransitions() QueryOption {
+	return func(o *queryOptions) {
+		o.flaps = true
+	}
+}
+
+// Moving makes each group aggregate the values of a window of duration w
+// starting at the group, instead of the values of the group only, so that groups
+// overlap and their means form a moving average. The grouping interval acts as
+// the step between windows. Windows of the last groups extend past end, so that
+// each of them covers w seconds of values, the window of the last group being
+// shortened by the same amount as the group itself when end does not fall on a
+// group boundary. The query fails if w is not a multiple of the grouping
+// interval, or if Moving is combined with Page, WithActive, WithDurations or
+// WithTransitions.
+func Moving(w time.Duration) QueryOption {
+	return func(o *queryOptions) {
+		o.window = w
+	}
+}
+
+// Page restricts the result of a query to at most limit groups, starting with
+// the group at index offset of the unrestricted result, so that results can be
+// paged through without computing them entirely. The Timestamp of the QuerySet
+// is the one of its first group. The query fails if offset is negative or limit
+// is not positive.
+func Page(offset, limit int) QueryOption {
+	return func(o *queryOptions) {
+		o.offset, o.limit, o.paged = offset, limit, true
+	}
+}
+
 // Query executes a query on s using start, end as closed interval filter
 // and d as grouping interval. The grouping interval is silently floored to
-// the frequency of s. Groups are aligned on start. It returns a QuerySet covering
-// all groups between start and end.
-func (s *Sequence) Query(start, end time.Time, d time.Duration) (QuerySet, error) {
-	// TODO: review + clean method
+// the frequency of s. Groups are aligned on start unless AlignTo is used. It
+// returns a QuerySet covering all groups between start and end, whose content
+// can be extended using opts.
+func (s *Sequence) Query(start, end time.Time, d time.Duration, opts ...QueryOption) (QuerySet, error) {
+	return s.queryWith(QuerySet{}, start, end, d, opts)
+}
+
+// QueryInto works like Query but stores the result in dst, reusing the memory
+// of dst.Sum and dst.Count when large enough, so that repeated queries don't
+// allocate new slices. Other aggregates of dst are reset. On error, dst is left
+// unchanged.
+func (s *Sequence) QueryInto(dst *QuerySet, start, end time.Time, d time.Duration, opts ...QueryOption) error {
+	qs, err := s.queryWith(*dst, start, end, d, opts)
+	if err != nil {
+		return err
+	}
+	*dst = qs
+	return nil
+}
+
+// QueryAligned is a shorthand for Query with AlignTo(a).
+func (s *Sequence) QueryAligned(start, end time.Time, d, a time.Duration) (QuerySet, error) {
+	return s.Query(start, end, d, AlignTo(a))
+}
+
+// QueryState is a shorthand for Query with CountState(x).
+func (s *Sequence) QueryState(start, end time.Time, d time.Duration, x uint8) (QuerySet, error) {
+	return s.Query(start, end, d, CountState(x))
+}
+
+// QueryDurations is a shorthand for Query with WithDurations(). Only stored
+// values are taken into account and states without any value in the interval
+// filter are omitted.
+func (s *Sequence) QueryDurations(start, end time.Time, d time.Duration) (QuerySet, error) {
+	return s.Query(start, end, d, WithDurations())
+}
+
+// QueryActive is a shorthand for Query with WithActive(p). StateUnknown values
+// stored in s are accounted for according to p.
+func (s *Sequence) QueryActive(start, end time.Time, d time.Duration, p UnknownPolicy) (QuerySet, error) {
+	return s.Query(start, end, d, WithActive(p))
+}
+
+// queryWith executes a query on s configured by opts, reusing the memory of
+// dst as defined by query.
+func (s *Sequence) queryWith(dst QuerySet, start, end time.Time, d time.Duration, opts []QueryOption) (QuerySet, error) {
 	if start.After(end) {
 		return QuerySet{}, errors.New("invalid time filter")
 	}
 
+	var o queryOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	origin := start.Unix()
+	if o.aligned {
+		step := int64(o.align / time.Second)
+		if step < 1 {
+			return QuerySet{}, errors.New("invalid alignment")
+		}
+		origin -= origin % step
+		if origin > start.Unix() {
+			origin -= step
+		}
+	}
+
+	if o.active && o.policy >= unknownPolicyInvalid {
+		return QuerySet{}, errors.New("invalid unknown policy")
+	}
+
+	if o.paged {
+		if o.offset < 0 || o.limit < 1 {
+			return QuerySet{}, errors.New("invalid page")
+		}
+		f := int64(s.frequency)
+		size := int64(d.Seconds()) / f * f
+		if size < 1 {
+			return QuerySet{}, errors.New("invalid grouping interval")
+		}
+		origin += int64(o.offset) * size
+		if origin > end.Unix() {
+			return QuerySet{Timestamp: origin, Frequency: size, Sum: dst.Sum[:0], Count: dst.Count[:0]}, nil
+		}
+		if t := time.Unix(origin, 0); t.After(start) {
+			start = t
+		}
+		if t := time.Unix(origin+int64(o.limit)*size-1, 0); t.Before(end) {
+			end = t
+		}
+	}
+
+	var k int
+	var size int64
+	if o.window != 0 {
+		if o.paged || o.active || o.durations || o.flaps {
+			return QuerySet{}, errors.New("incompatible query options")
+		}
+		f := int64(s.frequency)
+		size = int64(d.Seconds()) / f * f
+		w := int64(o.window / time.Second)
+		if size < 1 || w < size || w%size != 0 || time.Duration(w)*time.Second != o.window {
+			return QuerySet{}, errors.New("invalid moving window")
+		}
+		k = int(w / size)
+	}
+
+	fn := aggregateActive
+	if o.filtered {
+		fn = func(qs *QuerySet, i, n int64, v uint8) {
+			if v == o.state {
+				qs.Sum[i] += n
+			}
+			qs.Count[i] += n
+		}
+	}
+
+	scan := end
+	if k > 1 {
+		scan = end.Add(o.window - time.Duration(size)*time.Second)
+	}
+
+	qs, err := s.query(dst, start, scan, d, origin, fn)
+	if err != nil {
+		return QuerySet{}, err
+	}
+
+	if k > 1 {
+		movingSum(qs.Sum, k)
+		movingSum(qs.Count, k)
+		n := (end.Unix()-origin)/size + 1
+		qs.Sum, qs.Count = qs.Sum[:n], qs.Count[:n]
+	}
+
+	x, y, ok := s.offsets(start, end)
+	if o.durations {
+		s.durations(&qs, x, y, ok)
+	}
+	if o.active {
+		s.active(&qs, x, y, ok, o.policy)
+	}
+	if o.flaps {
+		s.transitions(&qs, x, y, ok)
+	}
+
+	return qs, nil
+}
+
+// query executes a query on s using start, end as closed interval filter, d as
+// grouping interval, origin as the Unix time of the first group and fn to
+// aggregate each series of n consecutive values v falling into group i. The
+// memory of dst.Sum and dst.Count is reused when large enough. The caller must
+// ensure origin is not after start and start is not after end.
+func (s *Sequence) query(dst QuerySet, start, end time.Time, d time.Duration, origin int64, fn func(qs *QuerySet, i, n int64, v uint8)) (QuerySet, error) {
 	f := int64(s.frequency)
 
 	aggregation := int64(d.Seconds()) / f
@@ -105,78 +504,548 @@ func (s *Sequence) Query(start, end time.Time, d time.Duration) (QuerySet, error
 		return QuerySet{}, errors.New("invalid grouping interval")
 	}
 
-	ts := start.Unix()
+	size := f * aggregation
+	numberOfValues := (end.Unix()-origin)/size + 1
 
-	numberOfValues := (end.Unix()-ts)/f/aggregation + 1
+	qs := QuerySet{
+		Timestamp: origin,
+		Frequency: size,
+		Sum:       resizeInt64(dst.Sum, numberOfValues),
+		Count:     resizeInt64(dst.Count, numberOfValues),
+	}
+
+	x, y, ok := s.offsets(start, end)
+	if !ok {
+		return qs, nil
+	}
+
+	s.groups(x, y, origin, size, func(i, n int64, v uint8) bool {
+		fn(&qs, i, n, v)
+		return true
+	})
+
+	return qs, nil
+}
+
+// aggregateActive adds n values v to group i of qs, counting StateActive values
+// in Sum and known values in Count.
+func aggregateActive(qs *QuerySet, i, n int64, v uint8) {
+	if v == StateUnknown {
+		return
+	}
+	if v == StateActive {
+		qs.Sum[i] += n
+	}
+	qs.Count[i] += n
+}
+
+// groups calls fn for each series of n consecutive values v stored in s between
+// offsets x and y that fall into the same group i, groups being size seconds
+// long and the first one starting at the Unix time origin. Iteration stops if fn
+// returns false.
+func (s *Sequence) groups(x, y, origin, size int64, fn func(i, n int64, v uint8) bool) {
+	f := int64(s.frequency)
+	s.walk(x, y, func(j, n int64, v uint8) bool {
+		for n > 0 {
+			k := s.ts + j*f - origin
+			m := (size-k%size-1)/f + 1
+			if m > n {
+				m = n
+			}
+			if !fn(k/size, m, v) {
+				return false
+			}
+			j += m
+			n -= m
+		}
+		return true
+	})
+}
+
+// A CalendarUnit represents a calendar period used as grouping interval.
+type CalendarUnit uint8
+
+// Calendar units.
+const (
+	CalendarDay   CalendarUnit = iota // from midnight to midnight
+	CalendarWeek                      // from Monday to Monday
+	CalendarMonth                     // from the first day of a month to the next
+	calendarUnitInvalid
+)
+
+// QueryCalendar executes a query on s using start, end as closed interval filter
+// and u in location loc as grouping interval, so that groups follow calendar
+// boundaries and account for daylight saving time changes and variable month
+// lengths. The first group is the one containing start. As groups don't have a
+// fixed size, the returned QuerySet holds the Unix time of each group in
+// Timestamps and its Frequency is 0. It returns an error if u is not a valid
+// unit or loc is nil.
+func (s *Sequence) QueryCalendar(start, end time.Time, u CalendarUnit, loc *time.Location) (QuerySet, error) {
+	if start.After(end) {
+		return QuerySet{}, errors.New("invalid time filter")
+	}
+	if u >= calendarUnitInvalid {
+		return QuerySet{}, errors.New("invalid calendar unit")
+	}
+	if loc == nil {
+		return QuerySet{}, errors.New("invalid location")
+	}
+
+	year, month, day := start.In(loc).Date()
+	var b time.Time
+	switch u {
+	case CalendarDay:
+		b = time.Date(year, month, day, 0, 0, 0, 0, loc)
+	case CalendarWeek:
+		b = time.Date(year, month, day, 0, 0, 0, 0, loc)
+		b = b.AddDate(0, 0, -(int(b.Weekday())+6)%7)
+	case CalendarMonth:
+		b = time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	}
+
+	var timestamps []int64
+	for !b.After(end) {
+		timestamps = append(timestamps, b.Unix())
+		switch u {
+		case CalendarDay:
+			b = b.AddDate(0, 0, 1)
+		case CalendarWeek:
+			b = b.AddDate(0, 0, 7)
+		case CalendarMonth:
+			b = b.AddDate(0, 1, 0)
+		}
+	}
+	limit := b.Unix()
 
 	qs := QuerySet{
-		Timestamp: ts,
-		Frequency: f * aggregation,
-		Sum:       make([]int64, numberOfValues),
-		Count:     make([]int64, numberOfValues),
+		Timestamp:  timestamps[0],
+		Sum:        make([]int64, len(timestamps)),
+		Count:      make([]int64, len(timestamps)),
+		Timestamps: timestamps,
 	}
 
-	r, ok := s.interval().intersect(interval{start: start.Unix(), end: end.Unix()})
+	x, y, ok := s.offsets(start, end)
 	if !ok {
 		return qs, nil
 	}
 
-	x := ceilInt64(r.start-s.ts, f) / f
-	y := (r.end - s.ts) / f
+	f := int64(s.frequency)
+	i := 0
+	s.walk(x, y, func(j, n int64, v uint8) bool {
+		for n > 0 {
+			t := s.ts + j*f
+			next := limit
+			for i+1 < len(timestamps) && t >= timestamps[i+1] {
+				i++
+			}
+			if i+1 < len(timestamps) {
+				next = timestamps[i+1]
+			}
+			k := (next - t + f - 1) / f
+			if k > n {
+				k = n
+			}
+			aggregateActive(&qs, int64(i), k, v)
+			j += k
+			n -= k
+		}
+		return true
+	})
 
-	src := int64(0)
-	shift := int64(0)
-	if ts < s.ts {
-		shift = (s.ts - ts) / f
+	return qs, nil
+}
+
+// durations sets qs.Seconds to the time spent in each state in each group of
+// qs by the values of s between offsets x and y, if ok.
+func (s *Sequence) durations(qs *QuerySet, x, y int64, ok bool) {
+	qs.Seconds = make(map[uint8][]int64)
+	if !ok {
+		return
 	}
+	f := int64(s.frequency)
+	s.groups(x, y, qs.Timestamp, qs.Frequency, func(i, n int64, v uint8) bool {
+		if qs.Seconds[v] == nil {
+			qs.Seconds[v] = make([]int64, len(qs.Count))
+		}
+		qs.Seconds[v][i] += n * f
+		return true
+	})
+}
 
-	p := 0
-	for p < len(s.data) {
-		n, v, bytesRead := s.next(p)
-		p += bytesRead
+// transitions sets qs.Transitions to the number of state changes in each group
+// of qs between the values of s between offsets x and y, if ok.
+func (s *Sequence) transitions(qs *QuerySet, x, y int64, ok bool) {
+	qs.Transitions = make([]int64, len(qs.Count))
+	if !ok {
+		return
+	}
+	last := StateUnknown
+	s.groups(x, y, qs.Timestamp, qs.Frequency, func(i, n int64, v uint8) bool {
+		if v == StateUnknown {
+			return true
+		}
+		if last != StateUnknown && v != last {
+			qs.Transitions[i]++
+		}
+		last = v
+		return true
+	})
+}
 
-		next := src + int64(n)
+// active sets qs.Active to the percentage of qs.Sum to qs.Count in each group of
+// qs, accounting for the StateUnknown values of s between offsets x and y, if ok,
+// according to p.
+func (s *Sequence) active(qs *QuerySet, x, y int64, ok bool, p UnknownPolicy) {
+	unknown := make([]int64, len(qs.Count))
 
-		if x >= next || v == StateUnknown {
-			src = next
+	if ok && p != UnknownExcluded {
+		s.groups(x, y, qs.Timestamp, qs.Frequency, func(i, n int64, v uint8) bool {
+			if v == StateUnknown {
+				unknown[i] += n
+			}
+			return true
+		})
+	}
+
+	qs.Active = make([]float64, len(qs.Count))
+	for i := range qs.Active {
+		sum, count := qs.Sum[i], qs.Count[i]
+		switch p {
+		case UnknownDown:
+			count += unknown[i]
+		case UnknownUp:
+			sum += unknown[i]
+			count += unknown[i]
+		}
+		if count == 0 {
+			qs.Active[i] = math.NaN()
 			continue
 		}
+		qs.Active[i] = 100 * float64(sum) / float64(count)
+	}
+}
+
+// CombineQuerySets returns a QuerySet whose Sum and Count are the sums of the
+// Sum and Count of sets, for instance to compute the availability of a group of
+// sequences. Other aggregates are not combined. It returns an error if sets is
+// empty or if the query sets don't share the same timestamps and frequency.
+func CombineQuerySets(sets ...QuerySet) (QuerySet, error) {
+	if err := checkQuerySets(sets); err != nil {
+		return QuerySet{}, err
+	}
+	qs := QuerySet{
+		Timestamp:  sets[0].Timestamp,
+		Frequency:  sets[0].Frequency,
+		Sum:        make([]int64, len(sets[0].Count)),
+		Count:      make([]int64, len(sets[0].Count)),
+		Timestamps: sets[0].Timestamps,
+	}
+	for _, v := range sets {
+		for i := range v.Count {
+			qs.Sum[i] += v.Sum[i]
+			qs.Count[i] += v.Count[i]
+		}
+	}
+	return qs, nil
+}
 
-		first := true
+// A Row represents a group of a QuerySet.
+type Row struct {
+	// Timestamp specifies the unix time
+	// associated to the group.
+	Timestamp int64
 
-		if x > src {
-			src = x
+	// Sum and Count hold the sum and the
+	// number of valid values in the group.
+	Sum   int64
+	Count int64
+
+	// Mean holds Sum divided by Count,
+	// NaN if the group has no value.
+	Mean float64
+}
+
+// Rows returns an iterator over the groups of q in chronological order.
+func (q QuerySet) Rows() iter.Seq[Row] {
+	return func(yield func(Row) bool) {
+		ts := q.Timestamp
+		for i := range q.Count {
+			if q.Timestamps != nil {
+				ts = q.Timestamps[i]
+			}
+			r := Row{Timestamp: ts, Sum: q.Sum[i], Count: q.Count[i], Mean: math.NaN()}
+			if r.Count != 0 {
+				r.Mean = float64(r.Sum) / float64(r.Count)
+			}
+			if !yield(r) {
+				return
+			}
+			ts += q.Frequency
 		}
+	}
+}
 
-		target := next
-		if y < next {
-			target = y + 1
+// Means returns the mean value of each group, that is Sum divided by Count.
+// Groups without any value are considered to hold StateUnknown values only and
+// their mean depends on p: NaN with UnknownExcluded, 0 with UnknownDown and 1
+// with UnknownUp.
+func (q QuerySet) Means(p UnknownPolicy) []float64 {
+	means := make([]float64, len(q.Count))
+	for i := range means {
+		if q.Count[i] != 0 {
+			means[i] = float64(q.Sum[i]) / float64(q.Count[i])
+			continue
+		}
+		switch p {
+		case UnknownDown:
+			means[i] = 0
+		case UnknownUp:
+			means[i] = 1
+		default:
+			means[i] = math.NaN()
 		}
+	}
+	return means
+}
 
-		for src < target {
-			dst := (shift + src - x) / aggregation
-			n := aggregation
-			if first {
-				n -= (shift + src - x) % aggregation
-				first = false
+// ToSequence returns a new Sequence holding one value per group of q, so that
+// downsampled archives can be built from query results. A group is StateActive
+// if its mean value is greater than or equal to threshold, StateInactive if it
+// is lower and StateUnknown if the group has no value. It returns an error if
+// the groups of q don't have a fixed size or if their size is not a valid
+// sequence frequency.
+func (q QuerySet) ToSequence(threshold float64) (*Sequence, error) {
+	if q.Timestamps != nil || q.Frequency < 1 || q.Frequency > math.MaxUint16 {
+		return nil, errors.New("invalid query set frequency")
+	}
+	var runs []Run
+	for i := range q.Count {
+		v := StateUnknown
+		if q.Count[i] != 0 {
+			v = StateInactive
+			if float64(q.Sum[i])/float64(q.Count[i]) >= threshold {
+				v = StateActive
 			}
-			if src+n > target {
-				n = target - src
+		}
+		if k := len(runs) - 1; k >= 0 && runs[k].Value == v {
+			runs[k].Count++
+			continue
+		}
+		runs = append(runs, Run{Count: 1, Value: v})
+	}
+	return NewFromRuns(time.Unix(q.Timestamp, 0), uint16(q.Frequency), runs), nil
+}
+
+// Merge returns the combination of q and x as defined by CombineQuerySets.
+func (q QuerySet) Merge(x QuerySet) (QuerySet, error) {
+	return CombineQuerySets(q, x)
+}
+
+// Concat returns a QuerySet holding the groups of q followed by the groups of x,
+// for instance to stitch results computed on consecutive time chunks. Aggregates
+// other than Sum and Count are kept only if set in both query sets, transitions
+// between the last value of a chunk and the first value of the next one being
+// unknown to both. It returns an error if the groups of x don't immediately
+// follow those of q, or for query sets without fixed size groups, if they don't
+// start after those of q.
+func (q QuerySet) Concat(x QuerySet) (QuerySet, error) {
+	if q.Frequency != x.Frequency || (q.Timestamps == nil) != (x.Timestamps == nil) {
+		return QuerySet{}, errors.New("incompatible query sets")
+	}
+	if q.Timestamps == nil && x.Timestamp != q.Timestamp+int64(len(q.Count))*q.Frequency {
+		return QuerySet{}, errors.New("incompatible query sets")
+	}
+	if n := len(q.Timestamps); n > 0 && len(x.Timestamps) > 0 && x.Timestamps[0] <= q.Timestamps[n-1] {
+		return QuerySet{}, errors.New("incompatible query sets")
+	}
+	qs := QuerySet{
+		Timestamp: q.Timestamp,
+		Frequency: q.Frequency,
+		Sum:       append(append([]int64{}, q.Sum...), x.Sum...),
+		Count:     append(append([]int64{}, q.Count...), x.Count...),
+	}
+	if len(q.Count) == 0 {
+		qs.Timestamp = x.Timestamp
+	}
+	if q.Timestamps != nil {
+		qs.Timestamps = append(append([]int64{}, q.Timestamps...), x.Timestamps...)
+	}
+	if q.Active != nil && x.Active != nil {
+		qs.Active = append(append([]float64{}, q.Active...), x.Active...)
+	}
+	if q.Transitions != nil && x.Transitions != nil {
+		qs.Transitions = append(append([]int64{}, q.Transitions...), x.Transitions...)
+	}
+	if q.Seconds != nil && x.Seconds != nil {
+		qs.Seconds = make(map[uint8][]int64)
+		for _, m := range []map[uint8][]int64{q.Seconds, x.Seconds} {
+			for k := range m {
+				if qs.Seconds[k] != nil {
+					continue
+				}
+				v := make([]int64, len(qs.Count))
+				copy(v, q.Seconds[k])
+				if x.Seconds[k] != nil {
+					copy(v[len(q.Count):], x.Seconds[k])
+				}
+				qs.Seconds[k] = v
 			}
-			if v == 1 {
-				qs.Sum[dst] += n
+		}
+	}
+	return qs, nil
+}
+
+// MeanOfMeans returns for each group the mean of the mean values of sets, so
+// that each query set has the same weight whatever its number of values. Query
+// sets without any value in a group are ignored for that group, and the result
+// is NaN if there is none. It returns an error if sets is empty or if the query
+// sets don't share the same timestamps and frequency.
+func MeanOfMeans(sets ...QuerySet) ([]float64, error) {
+	if err := checkQuerySets(sets); err != nil {
+		return nil, err
+	}
+	means := make([]float64, len(sets[0].Count))
+	for i := range means {
+		var sum float64
+		var n int
+		for _, v := range sets {
+			if v.Count[i] == 0 {
+				continue
 			}
-			qs.Count[dst] += n
-			src += n
+			sum += float64(v.Sum[i]) / float64(v.Count[i])
+			n++
+		}
+		if n == 0 {
+			means[i] = math.NaN()
+			continue
 		}
+		means[i] = sum / float64(n)
+	}
+	return means, nil
+}
 
-		if next > y {
-			break
+// checkQuerySet returns an error if the per-group slices of q, when set, don't
+// all have the length of q.Count.
+func checkQuerySet(q QuerySet) error {
+	n := len(q.Count)
+	invalid := len(q.Sum) != n ||
+		q.Timestamps != nil && len(q.Timestamps) != n ||
+		q.Active != nil && len(q.Active) != n ||
+		q.Transitions != nil && len(q.Transitions) != n
+	for _, v := range q.Seconds {
+		invalid = invalid || v != nil && len(v) != n
+	}
+	if invalid {
+		return errors.New("invalid query set")
+	}
+	return nil
+}
+
+// checkQuerySets returns an error if sets is empty or if the query sets don't
+// share the same timestamps and frequency.
+func checkQuerySets(sets []QuerySet) error {
+	if len(sets) == 0 {
+		return errors.New("no query set")
+	}
+	x := sets[0]
+	for _, v := range sets[1:] {
+		if v.Timestamp != x.Timestamp || v.Frequency != x.Frequency || len(v.Count) != len(x.Count) || len(v.Timestamps) != len(x.Timestamps) {
+			return errors.New("incompatible query sets")
 		}
+		for i := range v.Timestamps {
+			if v.Timestamps[i] != x.Timestamps[i] {
+				return errors.New("incompatible query sets")
+			}
+		}
+	}
+	return nil
+}
 
+// QueryFunc executes a query on s like Query but calls fn for each group in
+// chronological order with the Unix time of the group, the sum and the count of
+// its values instead of materializing a QuerySet, so that very large intervals can
+// be processed with bounded memory. Iteration stops if fn returns false.
+func (s *Sequence) QueryFunc(start, end time.Time, d time.Duration, fn func(ts int64, sum, count int64) bool) error {
+	if start.After(end) {
+		return errors.New("invalid time filter")
 	}
 
-	return qs, nil
+	f := int64(s.frequency)
+
+	aggregation := int64(d.Seconds()) / f
+
+	if aggregation < 1 {
+		return errors.New("invalid grouping interval")
+	}
+
+	origin := start.Unix()
+	size := f * aggregation
+	numberOfValues := (end.Unix()-origin)/size + 1
+
+	var current, sum, count int64
+	flush := func(i int64) bool {
+		for current < i {
+			if !fn(origin+current*size, sum, count) {
+				return false
+			}
+			current++
+			sum, count = 0, 0
+		}
+		return true
+	}
+
+	stopped := false
+	if x, y, ok := s.offsets(start, end); ok {
+		s.groups(x, y, origin, size, func(i, n int64, v uint8) bool {
+			if !flush(i) {
+				stopped = true
+				return false
+			}
+			if v != StateUnknown {
+				if v == StateActive {
+					sum += n
+				}
+				count += n
+			}
+			return true
+		})
+	}
+
+	if !stopped {
+		flush(numberOfValues)
+	}
+
+	return nil
+}
+
+// movingSum replaces in place each element of x with the sum of the k elements
+// starting at that element, or less at the end of x.
+func movingSum(x []int64, k int) {
+	var acc int64
+	for i := 0; i < k && i < len(x); i++ {
+		acc += x[i]
+	}
+	for i := range x {
+		v := x[i]
+		x[i] = acc
+		acc -= v
+		if i+k < len(x) {
+			acc += x[i+k]
+		}
+	}
+}
+
+// resizeInt64 returns a slice of n zero values, reusing the memory of x when
+// its capacity is large enough.
+func resizeInt64(x []int64, n int64) []int64 {
+	if int64(cap(x)) < n {
+		return make([]int64, n)
+	}
+	x = x[:n]
+	for i := range x {
+		x[i] = 0
+	}
+	return x
 }
 
 // ceilInt64 returns the least integer value greater than or
@@ -2,6 +2,7 @@ package sequence
 
 import (
 	"fmt"
+	"math"
 	"testing"
 	"time"
 )
@@ -43,6 +44,155 @@ func TestSequenceValues(t *testing.T) {
 	}
 }
 
+func TestSequenceLastN(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	s.AddRun(shift(s, 20, 0), 200, StateActive)
+	f := int64(s.frequency)
+	all := append(append([]uint8{}, testValues...), newSliceOfValues(200, StateActive)...)
+	type result struct {
+		values    []uint8
+		timestamp int64
+	}
+	tests := []struct {
+		id   int
+		n    int
+		want result
+	}{
+		{1, 0, result{[]uint8{}, 0}},
+		{2, 1, result{[]uint8{1}, s.ts + 219*f}},
+		{3, 201, result{all[19:], s.ts + 19*f}},
+		{4, 206, result{all[14:], s.ts + 14*f}},
+		{5, 220, result{all, s.ts}},
+		{6, 1000, result{all, s.ts}},
+	}
+	for _, tt := range tests {
+		var got result
+		got.values, got.timestamp = s.LastN(tt.n)
+		if !assertValuesEqual(got.values, tt.want.values) || got.timestamp != tt.want.timestamp {
+			t.Fatalf("test %d:\ngot  %+v\nwant %+v", tt.id, got, tt.want)
+		}
+	}
+	if got, ts := New(x, testSequenceFrequency).LastN(5); len(got) != 0 || ts != 0 {
+		t.Fatalf("got %v %d, want [] 0", got, ts)
+	}
+}
+
+func TestSequenceValuesPage(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	start, end := shift(s, -5, 0), shift(s, 25, -1)
+	all, _, _ := s.Values(start, end)
+	for _, limit := range []int{1, 3, 7, 25, 100} {
+		var got []uint8
+		for offset := 0; ; offset += limit {
+			values, ts, err := s.ValuesPage(start, end, offset, limit)
+			if err != nil {
+				t.Fatalf("limit %d, offset %d: got error %s, want error nil", limit, offset, err)
+			}
+			if len(values) == 0 {
+				break
+			}
+			if len(values) > limit {
+				t.Fatalf("limit %d, offset %d: got %d values", limit, offset, len(values))
+			}
+			if want := s.ts + int64(offset)*int64(s.frequency); ts != want {
+				t.Fatalf("limit %d, offset %d: got %d, want %d", limit, offset, ts, want)
+			}
+			got = append(got, values...)
+		}
+		if !assertValuesEqual(got, all) {
+			t.Fatalf("limit %d:\ngot  %v\nwant %v", limit, got, all)
+		}
+	}
+	for _, p := range [][2]int{{-1, 1}, {0, 0}} {
+		if _, _, err := s.ValuesPage(start, end, p[0], p[1]); err == nil {
+			t.Fatalf("page %v: got error nil, want non nil error", p)
+		}
+	}
+}
+
+func TestSequenceQueryPage(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	f := int64(testSequenceFrequency)
+	d := time.Duration(f*3) * time.Second
+	start, end := shift(s, -5, -1), shift(s, 25, -1)
+	all, _ := s.Query(start, end, d)
+	for _, limit := range []int{1, 2, 4, 11, 20} {
+		got := QuerySet{Timestamp: all.Timestamp, Frequency: all.Frequency}
+		for offset := 0; ; offset += limit {
+			qs, err := s.Query(start, end, d, Page(offset, limit))
+			if err != nil {
+				t.Fatalf("limit %d, offset %d: got error %s, want error nil", limit, offset, err)
+			}
+			if len(qs.Count) == 0 {
+				break
+			}
+			if want := all.Timestamp + int64(offset)*all.Frequency; qs.Timestamp != want || len(qs.Count) > limit {
+				t.Fatalf("limit %d, offset %d: got %+v", limit, offset, qs)
+			}
+			got.Sum = append(got.Sum, qs.Sum...)
+			got.Count = append(got.Count, qs.Count...)
+		}
+		if !assertQuerySetEqual(got, all) {
+			t.Fatalf("limit %d:\ngot  %+v\nwant %+v", limit, got, all)
+		}
+	}
+	for _, p := range [][2]int{{-1, 1}, {0, 0}} {
+		if _, err := s.Query(start, end, d, Page(p[0], p[1])); err == nil {
+			t.Fatalf("page %v: got error nil, want non nil error", p)
+		}
+	}
+}
+
+func TestSequenceValuesMax(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	f := int64(s.frequency)
+	type result struct {
+		values    []uint8
+		timestamp int64
+		step      int64
+	}
+	tests := []struct {
+		id    int
+		start time.Time
+		end   time.Time
+		n     int
+		mode  ThinningMode
+		want  result
+	}{
+		{1, shift(s, -5, 0), shift(s, 25, -1), 5, ThinStride, result{[]uint8{1, 0, 1, 2, 2}, s.ts, 5 * f}},
+		{2, shift(s, -5, 0), shift(s, 25, -1), 5, ThinWorst, result{[]uint8{1, 0, 1, 0, 2}, s.ts, 5 * f}},
+		{3, shift(s, -5, 0), shift(s, 25, -1), 3, ThinStride, result{[]uint8{1, 0, 2}, s.ts, 9 * f}},
+		{4, shift(s, -5, 0), shift(s, 25, -1), 3, ThinWorst, result{[]uint8{0, 0, 0}, s.ts, 9 * f}},
+		{5, shift(s, -5, 0), shift(s, 25, -1), 100, ThinWorst, result{append(testValues, []uint8{2, 2, 2, 2, 2}...), s.ts, f}},
+		{6, shift(s, 4, 0), shift(s, 10, 0), 2, ThinStride, result{[]uint8{1, 0}, s.ts + 4*f, 4 * f}},
+		{7, shift(s, 4, 0), shift(s, 10, 0), 2, ThinWorst, result{[]uint8{0, 0}, s.ts + 4*f, 4 * f}},
+		{8, shift(s, 10, 0), shift(s, 16, 0), 1, ThinWorst, result{[]uint8{2}, s.ts + 10*f, 7 * f}},
+		{9, shift(s, 10, 0), shift(s, 21, 0), 2, ThinWorst, result{[]uint8{2, 0}, s.ts + 10*f, 6 * f}},
+	}
+	for _, tt := range tests {
+		prefix := fmt.Sprintf("test %d (%s, %s, %d)", tt.id, tt.start, tt.end, tt.n)
+		var got result
+		var err error
+		got.values, got.timestamp, got.step, err = s.ValuesMax(tt.start, tt.end, tt.n, tt.mode)
+		if err != nil {
+			t.Fatalf("%s: got error %s, want error nil", prefix, err)
+		}
+		if !assertValuesEqual(got.values, tt.want.values) || got.timestamp != tt.want.timestamp || got.step != tt.want.step {
+			t.Fatalf("%s:\ngot  %+v\nwant %+v", prefix, got, tt.want)
+		}
+	}
+	if _, _, _, err := s.ValuesMax(shift(s, 0, 0), shift(s, 1, 0), 0, ThinStride); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if _, _, _, err := s.ValuesMax(shift(s, 0, 0), shift(s, 1, 0), 1, thinningModeInvalid); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
 func TestSequenceQuery(t *testing.T) {
 	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
 	s := NewWithValues(x, testSequenceFrequency, testValues)
@@ -59,42 +209,42 @@ func TestSequenceQuery(t *testing.T) {
 			shift(s, -5, -1),
 			shift(s, 25, -1),
 			time.Duration(f*5) * time.Second,
-			QuerySet{shift(s, -5, -1).Unix(), f * 5, []int64{0, 5, 0, 5, 0, 0, 0}, []int64{0, 5, 5, 5, 1, 0, 0}},
+			QuerySet{shift(s, -5, -1).Unix(), f * 5, []int64{0, 5, 0, 5, 0, 0, 0}, []int64{0, 5, 5, 5, 1, 0, 0}, nil, nil, nil, nil},
 		},
 		{
 			2,
 			shift(s, 3, -1),
 			shift(s, 12, 1),
 			time.Duration(f*5) * time.Second,
-			QuerySet{shift(s, 3, -1).Unix(), f * 5, []int64{2, 3}, []int64{5, 5}},
+			QuerySet{shift(s, 3, -1).Unix(), f * 5, []int64{2, 3}, []int64{5, 5}, nil, nil, nil, nil},
 		},
 		{
 			3,
 			shift(s, 5, -1),
 			shift(s, 12, 1),
 			time.Duration(f*3) * time.Second,
-			QuerySet{shift(s, 5, -1).Unix(), f * 3, []int64{0, 1, 2}, []int64{3, 3, 2}},
+			QuerySet{shift(s, 5, -1).Unix(), f * 3, []int64{0, 1, 2}, []int64{3, 3, 2}, nil, nil, nil, nil},
 		},
 		{
 			4,
 			shift(s, -15, -1),
 			shift(s, 80, -1),
 			time.Duration(f*25) * time.Second,
-			QuerySet{shift(s, -15, -1).Unix(), f * 25, []int64{5, 5, 0, 0}, []int64{10, 6, 0, 0}},
+			QuerySet{shift(s, -15, -1).Unix(), f * 25, []int64{5, 5, 0, 0}, []int64{10, 6, 0, 0}, nil, nil, nil, nil},
 		},
 		{
 			5,
 			shift(s, -10, 0),
 			shift(s, -5, -1),
 			time.Duration(f*2) * time.Second,
-			QuerySet{shift(s, -10, 0).Unix(), f * 2, []int64{0, 0, 0}, []int64{0, 0, 0}},
+			QuerySet{shift(s, -10, 0).Unix(), f * 2, []int64{0, 0, 0}, []int64{0, 0, 0}, nil, nil, nil, nil},
 		},
 		{
 			6,
 			shift(s, 100, 1),
 			shift(s, 105, 0),
 			time.Duration(f) * time.Second,
-			QuerySet{shift(s, 100, 1).Unix(), f, []int64{0, 0, 0, 0, 0}, []int64{0, 0, 0, 0, 0}},
+			QuerySet{shift(s, 100, 1).Unix(), f, []int64{0, 0, 0, 0, 0}, []int64{0, 0, 0, 0, 0}, nil, nil, nil, nil},
 		},
 	}
 	for _, tt := range tests {
@@ -110,6 +260,628 @@ func TestSequenceQuery(t *testing.T) {
 
 }
 
+func TestSequenceQueryOptions(t *testing.T) {
+	x := time.Date(2000, 1, 2, 3, 57, 0, 0, time.UTC)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	start, end := x.Add(time.Minute), x.Add(19*time.Minute)
+	got, err := s.Query(start, end, 10*time.Minute, AlignTo(10*time.Minute), CountState(StateUnknown), WithActive(UnknownExcluded), WithDurations())
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	want := QuerySet{Timestamp: x.Add(-7 * time.Minute).Unix(), Frequency: 600, Sum: []int64{0, 0, 4}, Count: []int64{2, 10, 7}}
+	if !assertQuerySetEqual(got, want) {
+		t.Fatalf("\ngot  %+v\nwant %+v", got, want)
+	}
+	if a := []float64{0, 0, 400.0 / 7}; len(got.Active) != 3 || got.Active[0] != a[0] || got.Active[1] != a[1] || got.Active[2] != a[2] {
+		t.Fatalf("got %v, want %v", got.Active, a)
+	}
+	if v := got.Seconds[StateUnknown]; !assertValuesEqual(v, []int64{0, 0, 240}) {
+		t.Fatalf("got %v, want %v", v, []int64{0, 0, 240})
+	}
+	for i, opt := range []QueryOption{AlignTo(0), AlignTo(time.Millisecond), WithActive(unknownPolicyInvalid)} {
+		if _, err := s.Query(start, end, time.Hour, opt); err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", i+1)
+		}
+	}
+}
+
+func TestSequenceQueryTransitions(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	f := int64(testSequenceFrequency)
+	tests := []struct {
+		id    int
+		start time.Time
+		end   time.Time
+		size  int64
+		want  []int64
+	}{
+		{1, shift(s, -5, -1), shift(s, 25, -1), 5, []int64{0, 0, 1, 1, 1, 0, 0}},
+		{2, shift(s, 6, 0), shift(s, 12, 0), 2, []int64{0, 0, 1, 0}},
+		{3, shift(s, 100, 1), shift(s, 105, 0), 2, []int64{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		got, err := s.Query(tt.start, tt.end, time.Duration(f*tt.size)*time.Second, WithTransitions())
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", tt.id, err)
+		}
+		if !assertValuesEqual(got.Transitions, tt.want) {
+			t.Fatalf("test %d: got %v, want %v", tt.id, got.Transitions, tt.want)
+		}
+		var sum int64
+		for _, v := range got.Transitions {
+			sum += v
+		}
+		if n, _ := s.Transitions(tt.start, tt.end); int64(n) != sum {
+			t.Fatalf("test %d: got %d, want %d", tt.id, sum, n)
+		}
+	}
+}
+
+func TestSequenceQueryMoving(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	f := int64(testSequenceFrequency)
+	start, end := shift(s, 0, 0), shift(s, 20, -1)
+	step := time.Duration(f*2) * time.Second
+	tests := []struct {
+		id     int
+		end    time.Time
+		window time.Duration
+		want   QuerySet
+	}{
+		{1, end, step, QuerySet{Timestamp: start.Unix(), Frequency: 2 * f, Sum: []int64{2, 2, 1, 0, 0, 2, 2, 1, 0, 0}, Count: []int64{2, 2, 2, 2, 2, 2, 2, 1, 0, 1}}},
+		{2, end, 3 * step, QuerySet{Timestamp: start.Unix(), Frequency: 2 * f, Sum: []int64{5, 3, 1, 2, 4, 5, 3, 1, 0, 0}, Count: []int64{6, 6, 6, 6, 6, 5, 3, 2, 1, 1}}},
+		{3, end, 20 * step, QuerySet{Timestamp: start.Unix(), Frequency: 2 * f, Sum: []int64{10, 8, 6, 5, 5, 5, 3, 1, 0, 0}, Count: []int64{16, 14, 12, 10, 8, 6, 4, 2, 1, 1}}},
+		{4, shift(s, 10, -1), 3 * step, QuerySet{Timestamp: start.Unix(), Frequency: 2 * f, Sum: []int64{5, 3, 1, 2, 4}, Count: []int64{6, 6, 6, 6, 6}}},
+		{5, shift(s, 9, -1), 3 * step, QuerySet{Timestamp: start.Unix(), Frequency: 2 * f, Sum: []int64{5, 3, 1, 2, 3}, Count: []int64{6, 6, 6, 6, 5}}},
+	}
+	for _, tt := range tests {
+		got, err := s.Query(start, tt.end, step, Moving(tt.window))
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", tt.id, err)
+		}
+		if !assertQuerySetEqual(got, tt.want) {
+			t.Fatalf("test %d:\ngot  %+v\nwant %+v", tt.id, got, tt.want)
+		}
+	}
+	for i, opts := range [][]QueryOption{
+		{Moving(step / 2)},
+		{Moving(3 * step / 2)},
+		{Moving(step), WithActive(UnknownExcluded)},
+		{Moving(step), Page(0, 1)},
+	} {
+		if _, err := s.Query(start, end, step, opts...); err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", i+1)
+		}
+	}
+}
+
+func TestSequenceQueryInto(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	f := int64(testSequenceFrequency)
+	d := time.Duration(f*5) * time.Second
+	var qs QuerySet
+	if err := s.QueryInto(&qs, shift(s, -5, -1), shift(s, 25, -1), d); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	sum, count := &qs.Sum[0], &qs.Count[0]
+	tests := []struct {
+		id    int
+		start time.Time
+		end   time.Time
+	}{
+		{1, shift(s, -5, -1), shift(s, 25, -1)},
+		{2, shift(s, 3, -1), shift(s, 12, 1)},
+		{3, shift(s, 100, 1), shift(s, 105, 0)},
+	}
+	for _, tt := range tests {
+		if err := s.QueryInto(&qs, tt.start, tt.end, d); err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", tt.id, err)
+		}
+		if want, _ := s.Query(tt.start, tt.end, d); !assertQuerySetEqual(qs, want) {
+			t.Fatalf("test %d:\ngot  %+v\nwant %+v", tt.id, qs, want)
+		}
+		if &qs.Sum[0] != sum || &qs.Count[0] != count {
+			t.Fatalf("test %d: slices should be reused", tt.id)
+		}
+	}
+	want := qs
+	if err := s.QueryInto(&qs, shift(s, 1, 0), shift(s, 0, 0), d); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if err := s.QueryInto(&qs, shift(s, 0, 0), shift(s, 1, 0), time.Second); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if !assertQuerySetEqual(qs, want) {
+		t.Fatalf("\ngot  %+v\nwant %+v", qs, want)
+	}
+}
+
+func TestSequenceQueryFunc(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	f := int64(testSequenceFrequency)
+	d := time.Duration(f*5) * time.Second
+	tests := []struct {
+		id    int
+		start time.Time
+		end   time.Time
+	}{
+		{1, shift(s, -5, -1), shift(s, 25, -1)},
+		{2, shift(s, 3, -1), shift(s, 12, 1)},
+		{3, shift(s, -10, 0), shift(s, -5, -1)},
+		{4, shift(s, 100, 1), shift(s, 105, 0)},
+	}
+	for _, tt := range tests {
+		got := QuerySet{Timestamp: tt.start.Unix(), Frequency: 5 * f}
+		var timestamps []int64
+		err := s.QueryFunc(tt.start, tt.end, d, func(ts int64, sum, count int64) bool {
+			timestamps = append(timestamps, ts)
+			got.Sum = append(got.Sum, sum)
+			got.Count = append(got.Count, count)
+			return true
+		})
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", tt.id, err)
+		}
+		if want, _ := s.Query(tt.start, tt.end, d); !assertQuerySetEqual(got, want) {
+			t.Fatalf("test %d:\ngot  %+v\nwant %+v", tt.id, got, want)
+		}
+		for i, ts := range timestamps {
+			if want := tt.start.Unix() + int64(i)*5*f; ts != want {
+				t.Fatalf("test %d: got %d, want %d", tt.id, ts, want)
+			}
+		}
+	}
+	n := 0
+	s.QueryFunc(shift(s, -5, -1), shift(s, 25, -1), d, func(ts int64, sum, count int64) bool {
+		n++
+		return n < 2
+	})
+	if n != 2 {
+		t.Fatalf("got %d calls, want 2", n)
+	}
+	if err := s.QueryFunc(shift(s, 1, 0), shift(s, 0, 0), d, nil); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if err := s.QueryFunc(shift(s, 0, 0), shift(s, 1, 0), time.Second, nil); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestSequenceQueryAligned(t *testing.T) {
+	x := time.Date(2000, 1, 2, 3, 57, 0, 0, time.UTC)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	start := x.Add(time.Minute)
+	end := x.Add(19 * time.Minute)
+	tests := []struct {
+		id       int
+		start    time.Time
+		interval time.Duration
+		align    time.Duration
+		want     QuerySet
+	}{
+		{1, start, 10 * time.Minute, 10 * time.Minute, QuerySet{x.Add(-7 * time.Minute).Unix(), 600, []int64{2, 5, 2}, []int64{2, 10, 3}, nil, nil, nil, nil}},
+		{2, start, 10 * time.Minute, time.Hour, QuerySet{x.Add(-57 * time.Minute).Unix(), 600, []int64{0, 0, 0, 0, 0, 2, 5, 2}, []int64{0, 0, 0, 0, 0, 2, 10, 3}, nil, nil, nil, nil}},
+		{3, start.Add(30 * time.Second), 10 * time.Minute, time.Hour, QuerySet{x.Add(-57 * time.Minute).Unix(), 600, []int64{0, 0, 0, 0, 0, 1, 5, 2}, []int64{0, 0, 0, 0, 0, 1, 10, 3}, nil, nil, nil, nil}},
+		{4, x.Add(3 * time.Minute), 5 * time.Minute, 5 * time.Minute, QuerySet{x.Add(3 * time.Minute).Unix(), 300, []int64{2, 3, 2, 0}, []int64{5, 5, 2, 1}, nil, nil, nil, nil}},
+	}
+	for _, tt := range tests {
+		prefix := fmt.Sprintf("test %d (%s, %d, %d)", tt.id, tt.start, int(tt.interval.Seconds()), int(tt.align.Seconds()))
+		got, err := s.QueryAligned(tt.start, end, tt.interval, tt.align)
+		if err != nil {
+			t.Fatalf("%s: got error %s, want error nil", prefix, err)
+		}
+		if !assertQuerySetEqual(got, tt.want) {
+			t.Fatalf("%s:\ngot  %+v\nwant %+v", prefix, got, tt.want)
+		}
+	}
+	if _, err := s.QueryAligned(start, end, time.Hour, 0); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if _, err := s.QueryAligned(end, start, time.Hour, time.Hour); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestSequenceQueryCalendar(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+	values := func(n int) []uint8 {
+		v := make([]uint8, n)
+		for i := range v {
+			v[i] = StateActive
+		}
+		v[0] = StateInactive
+		return v
+	}
+	hourly := NewWithValues(time.Date(2023, 3, 25, 0, 0, 0, 0, paris), 3600, values(72))
+	long := NewWithValues(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), 3600, values(2160))
+	tests := []struct {
+		id    int
+		s     *Sequence
+		start time.Time
+		end   time.Time
+		unit  CalendarUnit
+		loc   *time.Location
+		sum   []int64
+		count []int64
+		first time.Time
+	}{
+		{
+			1,
+			hourly,
+			time.Date(2023, 3, 25, 0, 0, 0, 0, paris),
+			time.Date(2023, 3, 28, 0, 0, 0, 0, paris),
+			CalendarDay,
+			paris,
+			[]int64{23, 23, 24, 1},
+			[]int64{24, 23, 24, 1},
+			time.Date(2023, 3, 25, 0, 0, 0, 0, paris),
+		},
+		{
+			2,
+			hourly,
+			time.Date(2023, 3, 25, 12, 0, 0, 0, paris),
+			time.Date(2023, 3, 26, 12, 0, 0, 0, paris),
+			CalendarDay,
+			time.UTC,
+			[]int64{13, 11},
+			[]int64{13, 11},
+			time.Date(2023, 3, 25, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			3,
+			long,
+			time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, 3, 31, 23, 0, 0, 0, time.UTC),
+			CalendarMonth,
+			time.UTC,
+			[]int64{0, 743, 672, 744},
+			[]int64{0, 744, 672, 744},
+			time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			4,
+			long,
+			time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2023, 1, 15, 23, 0, 0, 0, time.UTC),
+			CalendarWeek,
+			time.UTC,
+			[]int64{23, 168, 168},
+			[]int64{24, 168, 168},
+			time.Date(2022, 12, 26, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		prefix := fmt.Sprintf("test %d (%s, %s)", tt.id, tt.start, tt.end)
+		got, err := tt.s.QueryCalendar(tt.start, tt.end, tt.unit, tt.loc)
+		if err != nil {
+			t.Fatalf("%s: got error %s, want error nil", prefix, err)
+		}
+		if got.Timestamp != tt.first.Unix() || got.Frequency != 0 || len(got.Timestamps) != len(tt.count) {
+			t.Fatalf("%s: got %+v", prefix, got)
+		}
+		if !assertValuesEqual(got.Sum, tt.sum) || !assertValuesEqual(got.Count, tt.count) {
+			t.Fatalf("%s:\ngot  %v %v\nwant %v %v", prefix, got.Sum, got.Count, tt.sum, tt.count)
+		}
+		for i := 1; i < len(got.Timestamps); i++ {
+			if h := time.Unix(got.Timestamps[i], 0).In(tt.loc); h.Hour() != 0 || h.Minute() != 0 {
+				t.Fatalf("%s: got group starting at %s", prefix, h)
+			}
+		}
+	}
+	x := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := long.QueryCalendar(x, x, calendarUnitInvalid, time.UTC); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if _, err := long.QueryCalendar(x, x, CalendarDay, nil); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestSequenceQueryDurations(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	f := int64(testSequenceFrequency)
+	tests := []struct {
+		id    int
+		start time.Time
+		end   time.Time
+		want  map[uint8][]int64
+	}{
+		{
+			1,
+			shift(s, -5, -1),
+			shift(s, 25, -1),
+			map[uint8][]int64{
+				StateInactive: {0, 0, 5 * f, 0, f, 0, 0},
+				StateActive:   {0, 5 * f, 0, 5 * f, 0, 0, 0},
+				StateUnknown:  {0, 0, 0, 0, 4 * f, 0, 0},
+			},
+		},
+		{2, shift(s, 3, -1), shift(s, 12, 1), map[uint8][]int64{StateInactive: {3 * f, 2 * f}, StateActive: {2 * f, 3 * f}}},
+		{3, shift(s, 100, 1), shift(s, 105, 0), map[uint8][]int64{}},
+	}
+	for _, tt := range tests {
+		prefix := fmt.Sprintf("test %d (%s, %s)", tt.id, tt.start, tt.end)
+		d := time.Duration(f*5) * time.Second
+		got, err := s.QueryDurations(tt.start, tt.end, d)
+		if err != nil {
+			t.Fatalf("%s: got error %s, want error nil", prefix, err)
+		}
+		if want, _ := s.Query(tt.start, tt.end, d); !assertQuerySetEqual(got, want) {
+			t.Fatalf("%s:\ngot  %+v\nwant %+v", prefix, got, want)
+		}
+		if len(got.Seconds) != len(tt.want) {
+			t.Fatalf("%s:\ngot  %v\nwant %v", prefix, got.Seconds, tt.want)
+		}
+		for k, v := range tt.want {
+			if !assertValuesEqual(got.Seconds[k], v) {
+				t.Fatalf("%s:\ngot  %v\nwant %v", prefix, got.Seconds, tt.want)
+			}
+		}
+	}
+	if _, err := s.QueryDurations(shift(s, 1, 0), shift(s, 0, 0), time.Hour); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestSequenceQueryState(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	f := int64(testSequenceFrequency)
+	start, end := shift(s, -5, -1), shift(s, 25, -1)
+	d := time.Duration(f*5) * time.Second
+	tests := []struct {
+		id    int
+		value uint8
+		want  QuerySet
+	}{
+		{1, StateUnknown, QuerySet{Timestamp: start.Unix(), Frequency: f * 5, Sum: []int64{0, 0, 0, 0, 4, 0, 0}, Count: []int64{0, 5, 5, 5, 5, 0, 0}}},
+		{2, StateInactive, QuerySet{Timestamp: start.Unix(), Frequency: f * 5, Sum: []int64{0, 0, 5, 0, 1, 0, 0}, Count: []int64{0, 5, 5, 5, 5, 0, 0}}},
+		{3, StateNotUsed, QuerySet{Timestamp: start.Unix(), Frequency: f * 5, Sum: []int64{0, 0, 0, 0, 0, 0, 0}, Count: []int64{0, 5, 5, 5, 5, 0, 0}}},
+	}
+	for _, tt := range tests {
+		got, err := s.QueryState(start, end, d, tt.value)
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", tt.id, err)
+		}
+		if !assertQuerySetEqual(got, tt.want) {
+			t.Fatalf("test %d:\ngot  %+v\nwant %+v", tt.id, got, tt.want)
+		}
+	}
+	if _, err := s.QueryState(end, start, d, StateActive); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestSequenceQueryActive(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	f := int64(testSequenceFrequency)
+	nan := math.NaN()
+	tests := []struct {
+		id       int
+		start    time.Time
+		end      time.Time
+		interval time.Duration
+		policy   UnknownPolicy
+		want     []float64
+	}{
+		{1, shift(s, -5, -1), shift(s, 25, -1), time.Duration(f*5) * time.Second, UnknownExcluded, []float64{nan, 100, 0, 100, 0, nan, nan}},
+		{2, shift(s, -5, -1), shift(s, 25, -1), time.Duration(f*5) * time.Second, UnknownDown, []float64{nan, 100, 0, 100, 0, nan, nan}},
+		{3, shift(s, -5, -1), shift(s, 25, -1), time.Duration(f*5) * time.Second, UnknownUp, []float64{nan, 100, 0, 100, 80, nan, nan}},
+		{4, shift(s, 3, -1), shift(s, 12, 1), time.Duration(f*5) * time.Second, UnknownUp, []float64{40, 60}},
+		{5, shift(s, 14, 0), shift(s, 19, 0), time.Duration(f*3) * time.Second, UnknownExcluded, []float64{100, 0}},
+		{6, shift(s, 14, 0), shift(s, 19, 0), time.Duration(f*3) * time.Second, UnknownDown, []float64{100.0 / 3, 0}},
+		{7, shift(s, 14, 0), shift(s, 19, 0), time.Duration(f*3) * time.Second, UnknownUp, []float64{100, 200.0 / 3}},
+	}
+	for _, tt := range tests {
+		prefix := fmt.Sprintf("test %d (%s, %s, %d)", tt.id, tt.start, tt.end, int(tt.interval.Seconds()))
+		got, err := s.QueryActive(tt.start, tt.end, tt.interval, tt.policy)
+		if err != nil {
+			t.Fatalf("%s: got error %s, want error nil", prefix, err)
+		}
+		want, _ := s.Query(tt.start, tt.end, tt.interval)
+		if !assertQuerySetEqual(got, want) {
+			t.Fatalf("%s:\ngot  %+v\nwant %+v", prefix, got, want)
+		}
+		if len(got.Active) != len(tt.want) {
+			t.Fatalf("%s:\ngot  %v\nwant %v", prefix, got.Active, tt.want)
+		}
+		for i, v := range tt.want {
+			if got.Active[i] != v && !(math.IsNaN(got.Active[i]) && math.IsNaN(v)) {
+				t.Fatalf("%s:\ngot  %v\nwant %v", prefix, got.Active, tt.want)
+			}
+		}
+	}
+	if _, err := s.QueryActive(shift(s, 0, 0), shift(s, 1, 0), time.Hour, unknownPolicyInvalid); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestCombineQuerySets(t *testing.T) {
+	a := QuerySet{Timestamp: 300, Frequency: 300, Sum: []int64{5, 0, 1}, Count: []int64{5, 0, 4}}
+	b := QuerySet{Timestamp: 300, Frequency: 300, Sum: []int64{0, 2, 1}, Count: []int64{5, 2, 1}}
+	got, err := CombineQuerySets(a, b)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	want := QuerySet{Timestamp: 300, Frequency: 300, Sum: []int64{5, 2, 2}, Count: []int64{10, 2, 5}}
+	if !assertQuerySetEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	means, err := MeanOfMeans(a, b, QuerySet{Timestamp: 300, Frequency: 300, Sum: []int64{0, 0, 0}, Count: []int64{0, 0, 0}})
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if wantMeans := []float64{0.5, 1, 0.625}; len(means) != 3 || means[0] != wantMeans[0] || means[1] != wantMeans[1] || means[2] != wantMeans[2] {
+		t.Fatalf("got %v, want %v", means, wantMeans)
+	}
+	tests := [][]QuerySet{
+		{},
+		{a, {Timestamp: 0, Frequency: 300, Sum: []int64{0, 0, 0}, Count: []int64{0, 0, 0}}},
+		{a, {Timestamp: 300, Frequency: 60, Sum: []int64{0, 0, 0}, Count: []int64{0, 0, 0}}},
+		{a, {Timestamp: 300, Frequency: 300, Sum: []int64{0}, Count: []int64{0}}},
+		{{Timestamps: []int64{0}, Sum: []int64{0}, Count: []int64{0}}, {Timestamps: []int64{1}, Sum: []int64{0}, Count: []int64{0}}},
+	}
+	for i, sets := range tests {
+		if _, err := CombineQuerySets(sets...); err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", i+1)
+		}
+		if _, err := MeanOfMeans(sets...); err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", i+1)
+		}
+	}
+}
+
+func TestQuerySetMeans(t *testing.T) {
+	q := QuerySet{Timestamp: 0, Frequency: 300, Sum: []int64{5, 0, 1}, Count: []int64{5, 0, 4}}
+	nan := math.NaN()
+	tests := []struct {
+		policy UnknownPolicy
+		want   []float64
+	}{
+		{UnknownExcluded, []float64{1, nan, 0.25}},
+		{UnknownDown, []float64{1, 0, 0.25}},
+		{UnknownUp, []float64{1, 1, 0.25}},
+	}
+	for _, tt := range tests {
+		got := q.Means(tt.policy)
+		if len(got) != len(tt.want) {
+			t.Fatalf("policy %d: got %v, want %v", tt.policy, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] && !(math.IsNaN(got[i]) && math.IsNaN(tt.want[i])) {
+				t.Fatalf("policy %d: got %v, want %v", tt.policy, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestQuerySetRows(t *testing.T) {
+	tests := []struct {
+		id   int
+		q    QuerySet
+		want []Row
+	}{
+		{
+			1,
+			QuerySet{Timestamp: 600, Frequency: 300, Sum: []int64{5, 0, 1}, Count: []int64{5, 0, 4}},
+			[]Row{{600, 5, 5, 1}, {900, 0, 0, math.NaN()}, {1200, 1, 4, 0.25}},
+		},
+		{
+			2,
+			QuerySet{Timestamp: 600, Sum: []int64{1, 2}, Count: []int64{2, 2}, Timestamps: []int64{600, 1000}},
+			[]Row{{600, 1, 2, 0.5}, {1000, 2, 2, 1}},
+		},
+		{3, QuerySet{}, nil},
+	}
+	for _, tt := range tests {
+		var got []Row
+		for r := range tt.q.Rows() {
+			got = append(got, r)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("test %d: got %v, want %v", tt.id, got, tt.want)
+		}
+		for i, r := range got {
+			w := tt.want[i]
+			if r.Timestamp != w.Timestamp || r.Sum != w.Sum || r.Count != w.Count || (r.Mean != w.Mean && !(math.IsNaN(r.Mean) && math.IsNaN(w.Mean))) {
+				t.Fatalf("test %d: got %v, want %v", tt.id, got, tt.want)
+			}
+		}
+	}
+	n := 0
+	for range (QuerySet{Frequency: 1, Sum: make([]int64, 10), Count: make([]int64, 10)}).Rows() {
+		n++
+		if n == 3 {
+			break
+		}
+	}
+	if n != 3 {
+		t.Fatalf("got %d, want 3", n)
+	}
+}
+
+func TestQuerySetToSequence(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, []uint8{1, 1, 1, 0, 1, 0, 0, 0, 2, 2, 1, 1})
+	q, _ := s.Query(shift(s, 0, 0), shift(s, 15, -1), 2*time.Minute)
+	tests := []struct {
+		threshold float64
+		want      []uint8
+	}{
+		{0.5, []uint8{1, 1, 1, 0, 2, 1, 2, 2}},
+		{1, []uint8{1, 0, 0, 0, 2, 1, 2, 2}},
+		{0, []uint8{1, 1, 1, 1, 2, 1, 2, 2}},
+	}
+	for _, tt := range tests {
+		got, err := q.ToSequence(tt.threshold)
+		if err != nil {
+			t.Fatalf("threshold %f: got error %s, want error nil", tt.threshold, err)
+		}
+		want := NewWithValues(time.Unix(q.Timestamp, 0), 120, tt.want)
+		if !assertSequencesEqual(got, want) {
+			t.Fatalf("threshold %f:\ngot  %+v\nwant %+v", tt.threshold, got, want)
+		}
+	}
+	for i, q := range []QuerySet{{Frequency: 0}, {Frequency: 1 << 16}, {Frequency: 60, Timestamps: []int64{}}} {
+		if _, err := q.ToSequence(0.5); err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", i+1)
+		}
+	}
+}
+
+func TestQuerySetMergeConcat(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	f := int64(testSequenceFrequency)
+	d := time.Duration(f*5) * time.Second
+	opts := []QueryOption{WithDurations(), WithTransitions(), WithActive(UnknownUp)}
+	all, _ := s.Query(shift(s, 0, 0), shift(s, 25, -1), d, opts...)
+	a, _ := s.Query(shift(s, 0, 0), shift(s, 10, -1), d, opts...)
+	b, _ := s.Query(shift(s, 10, 0), shift(s, 25, -1), d, opts...)
+	got, err := a.Concat(b)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if !assertQuerySetEqual(got, all) || !assertValuesEqual(got.Transitions, append(a.Transitions, b.Transitions...)) {
+		t.Fatalf("\ngot  %+v\nwant %+v", got, all)
+	}
+	for k, v := range all.Seconds {
+		if !assertValuesEqual(got.Seconds[k], v) {
+			t.Fatalf("state %d: got %v, want %v", k, got.Seconds[k], v)
+		}
+	}
+	if len(got.Active) != len(all.Active) {
+		t.Fatalf("got %v, want %v", got.Active, all.Active)
+	}
+	if _, err := b.Concat(a); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if _, err := a.Concat(QuerySet{Timestamp: b.Timestamp, Frequency: f}); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	merged, err := a.Merge(a)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	for i := range a.Count {
+		if merged.Sum[i] != 2*a.Sum[i] || merged.Count[i] != 2*a.Count[i] {
+			t.Fatalf("\ngot  %+v\nwant twice %+v", merged, a)
+		}
+	}
+	if _, err := a.Merge(b); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
 func shift(s *Sequence, steps, seconds int) time.Time {
 	return time.Unix(s.ts, 0).Add(time.Duration(steps*int(s.frequency)+seconds) * time.Second)
 }
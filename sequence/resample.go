@@ -0,0 +1,183 @@
+package sequence
+
+import (
+	"errors"
+	"time"
+)
+
+// A ResamplePolicy defines how the values of a sequence covering a new
+// interval are combined into a single value.
+type ResamplePolicy uint8
+
+// Resample policies. Unless stated otherwise, StateUnknown is only used if
+// no other value is available.
+const (
+	ResampleWorst    ResamplePolicy = iota // StateInactive first, StateActive last
+	ResampleBest                           // StateActive first, StateInactive last
+	ResampleFirst                          // first value, including StateUnknown
+	ResampleMajority                       // most frequent value, ties resolved as ResampleWorst
+	resampleUnknown
+)
+
+// Resample returns a copy of s using f as its frequency in seconds and length as
+// its maximum length, combining values according to p. A new value covers all
+// values whose timestamps fall into its interval, or the value covering its timestamp
+// if there is none. If length is 0, the length of the copy is set to retain the time
+// horizon of s. If the resampled values overflow the maximum length of the copy,
+// the oldest ones are discarded. The frequency of the copy will default to 1 if set
+// to 0. It returns an error if p is not a valid policy.
+func (s *Sequence) Resample(f uint16, length uint32, p ResamplePolicy) (*Sequence, error) {
+	if p >= resampleUnknown {
+		return nil, errors.New("invalid resample policy")
+	}
+	if f == 0 {
+		f = 1
+	}
+	if length == 0 {
+		length = MaxSequenceLength
+		if s.length != MaxSequenceLength {
+			if v := ceilInt64(int64(s.length)*int64(s.frequency), int64(f)) / int64(f); v < MaxSequenceLength {
+				length = uint32(v)
+			}
+		}
+	}
+	x := New(time.Unix(s.ts, 0), f)
+	x.width = s.width
+	x.maxAge = s.maxAge
+	x.length = length
+	from, to := int64(s.frequency), int64(f)
+	n := ceilInt64(int64(s.count)*from, to) / to
+	offset := int64(0)
+	if n > int64(length) {
+		offset = n - int64(length)
+		x.ts += offset * to
+	}
+	values := make([]uint8, 0, n-offset)
+	c := combiner{p: p}
+	i := offset
+	last := StateUnknown
+	// flush appends the values of the new intervals preceding interval j.
+	// Intervals covering no values hold the value covering their timestamp.
+	flush := func(j int64) {
+		for ; i < j; i++ {
+			if c.n == 0 {
+				values = append(values, last)
+				continue
+			}
+			values = append(values, c.value())
+			c.reset()
+		}
+	}
+	s.walk(offset*to/from, int64(s.count)-1, func(j, m int64, v uint8) bool {
+		for m > 0 {
+			k := j * from / to
+			flush(k)
+			end := ceilInt64((k+1)*to, from) / from
+			if end > j+m {
+				end = j + m
+			}
+			if k >= offset {
+				c.add(v, end-j)
+			}
+			last = v
+			m -= end - j
+			j = end
+		}
+		return true
+	})
+	flush(n)
+	x.addValues(values)
+	return x, nil
+}
+
+// ConvertDump converts the content of a store previously exported using the Dump
+// method, resampling every sequence using f as frequency, length as maximum length
+// and p as policy. Labels are preserved. It returns a dump of the resulting store.
+func ConvertDump(data []byte, f uint16, length uint32, p ResamplePolicy) ([]byte, error) {
+	src := NewStore()
+	if err := src.Load(data); err != nil {
+		return nil, err
+	}
+	dst := NewStore()
+	for k, v := range src.m {
+		x, err := v.Resample(f, length, p)
+		if err != nil {
+			return nil, err
+		}
+		dst.setUnsafe(k, x)
+		dst.setLabelsUnsafe(k, src.labels[k])
+	}
+	return dst.Dump()
+}
+
+// A combiner combines values into a single value according to a policy.
+type combiner struct {
+	p      ResamplePolicy
+	n      int64
+	first  uint8
+	counts [1 << MaxStateWidth]int64
+}
+
+// add adds n values v to the combination.
+func (c *combiner) add(v uint8, n int64) {
+	if c.n == 0 {
+		c.first = v
+	}
+	c.n += n
+	c.counts[v] += n
+}
+
+// value returns the combination of the values added since the last reset.
+// StateUnknown is returned if there are none.
+func (c *combiner) value() uint8 {
+	if c.n == 0 {
+		return StateUnknown
+	}
+	if c.p == ResampleFirst {
+		return c.first
+	}
+	result := StateUnknown
+	for v, n := range c.counts {
+		x := uint8(v)
+		if n == 0 || x == StateUnknown {
+			continue
+		}
+		if result == StateUnknown {
+			result = x
+			continue
+		}
+		switch c.p {
+		case ResampleWorst:
+			if rank(x) < rank(result) {
+				result = x
+			}
+		case ResampleBest:
+			if rank(x) > rank(result) {
+				result = x
+			}
+		case ResampleMajority:
+			if n > c.counts[result] || n == c.counts[result] && rank(x) < rank(result) {
+				result = x
+			}
+		}
+	}
+	return result
+}
+
+// reset discards the values added to the combination.
+func (c *combiner) reset() {
+	c.n = 0
+	c.counts = [1 << MaxStateWidth]int64{}
+}
+
+// rank returns the rank of known value v from the worst to the best state.
+// StateInactive ranks first, StateActive last, other values in between.
+func rank(v uint8) int {
+	switch v {
+	case StateInactive:
+		return 0
+	case StateActive:
+		return 1 << MaxStateWidth
+	}
+	return int(v)
+}
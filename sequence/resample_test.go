@@ -0,0 +1,90 @@
+package sequence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSequenceResample(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	values := []uint8{1, 1, 0, 1, 1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 2, 0, 2}
+	s := NewWithValues(x, 60, values)
+	tests := []struct {
+		id        int
+		values    []uint8
+		frequency uint16
+		length    uint32
+		policy    ResamplePolicy
+		want      []uint8
+		timestamp int64
+	}{
+		{1, values, 300, 0, ResampleWorst, []uint8{0, 1, 2, 0}, x.Unix()},
+		{2, values, 300, 0, ResampleBest, []uint8{1, 1, 2, 0}, x.Unix()},
+		{3, []uint8{2, 0, 1, 1, 1}, 300, 0, ResampleFirst, []uint8{2}, x.Unix()},
+		{4, []uint8{0, 1, 3, 1, 1}, 300, 0, ResampleMajority, []uint8{1}, x.Unix()},
+		{5, []uint8{0, 1, 3, 3, 1}, 300, 0, ResampleMajority, []uint8{3}, x.Unix()},
+		{6, []uint8{0, 1, 3, 1, 0}, 300, 0, ResampleMajority, []uint8{0}, x.Unix()},
+		{7, []uint8{1, 0}, 30, 0, ResampleWorst, []uint8{1, 1, 0, 0}, x.Unix()},
+		{8, []uint8{1, 0, 1, 1}, 90, 0, ResampleWorst, []uint8{0, 1, 1}, x.Unix()},
+		{9, values, 300, 2, ResampleWorst, []uint8{2, 0}, x.Unix() + 600},
+		{10, []uint8{1, 0}, 90, 0, ResampleFirst, []uint8{1, 0}, x.Unix()},
+		{11, []uint8{1, 0}, 90, 0, ResampleWorst, []uint8{0, 0}, x.Unix()},
+		{12, []uint8{1, 0, 1}, 40, 0, ResampleBest, []uint8{1, 0, 0, 1, 1}, x.Unix()},
+		{13, []uint8{1, 0, 0, 1, 1, 1}, 150, 0, ResampleMajority, []uint8{0, 1, 1}, x.Unix()},
+		{14, []uint8{1, 0, 1}, 40, 2, ResampleFirst, []uint8{1, 1}, x.Unix() + 120},
+		{15, []uint8{2, 2, 1}, 90, 0, ResampleFirst, []uint8{2, 1}, x.Unix()},
+	}
+	for _, tt := range tests {
+		s := NewWithValues(x, 60, tt.values)
+		got, err := s.Resample(tt.frequency, tt.length, tt.policy)
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", tt.id, err)
+		}
+		if got.frequency != tt.frequency || got.ts != tt.timestamp {
+			t.Fatalf("test %d: got (%d, %d), want (%d, %d)", tt.id, got.frequency, got.ts, tt.frequency, tt.timestamp)
+		}
+		if v := got.All(); !assertValuesEqual(v, tt.want) {
+			t.Fatalf("test %d:\ngot  %v\nwant %v", tt.id, v, tt.want)
+		}
+	}
+	s.SetLength(140)
+	got, _ := s.Resample(300, 0, ResampleWorst)
+	if got.length != 28 {
+		t.Fatalf("got %d, want 28", got.length)
+	}
+	if _, err := s.Resample(300, 0, resampleUnknown); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestConvertDump(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	src := NewStore()
+	src.Add("k1", NewWithValues(x, 60, testValues))
+	src.Add("k2", NewWithValues(x, 30, []uint8{1, 1, 0, 1, 2, 2, 2, 2, 2, 2, 2, 2, 1}))
+	src.SetLabels("k1", map[string]string{"host": "a"})
+	dump, _ := src.Dump()
+	converted, err := ConvertDump(dump, 120, 1440, ResampleWorst)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	dst := NewStore()
+	if err := dst.Load(converted); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if n := len(dst.m); n != 2 {
+		t.Fatalf("got %d, want 2", n)
+	}
+	for k, v := range src.m {
+		want, _ := v.Resample(120, 1440, ResampleWorst)
+		if !assertSequencesEqual(dst.m[k], want) {
+			t.Fatalf("\ngot  %+v\nwant %+v", dst.m[k], want)
+		}
+	}
+	if labels, _ := dst.Labels("k1"); labels["host"] != "a" {
+		t.Fatalf("got labels %v, want host a", labels)
+	}
+	if labels, _ := dst.Labels("k2"); len(labels) != 0 {
+		t.Fatalf("got labels %v, want no labels", labels)
+	}
+}
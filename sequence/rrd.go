@@ -0,0 +1,159 @@
+package sequence
+
+import (
+	"encoding/xml"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportRRD returns a dump of s using the XML format of rrdtool dump, so that it
+// can be loaded with rrdtool restore. The dump holds a single GAUGE data source
+// named name and a single AVERAGE archive with one row per value, StateUnknown
+// values being exported as NaN. As rrdtool aligns rows on multiples of the step,
+// timestamps of sequences that are not aligned are rounded down by ImportRRD.
+func (s *Sequence) ExportRRD(name string) []byte {
+	f := int64(s.frequency)
+	last := s.ts
+	if s.count > 0 {
+		last += (int64(s.count) - 1) * f
+	}
+	var b strings.Builder
+	b.WriteString("<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<rrd>\n")
+	b.WriteString("\t<version>0003</version>\n")
+	b.WriteString("\t<step>" + strconv.FormatInt(f, 10) + "</step>\n")
+	b.WriteString("\t<lastupdate>" + strconv.FormatInt(last, 10) + "</lastupdate>\n")
+	b.WriteString("\t<ds>\n\t\t<name> ")
+	xml.EscapeText(&b, []byte(name))
+	b.WriteString(" </name>\n\t\t<type> GAUGE </type>\n")
+	b.WriteString("\t\t<minimal_heartbeat>" + strconv.FormatInt(2*f, 10) + "</minimal_heartbeat>\n")
+	b.WriteString("\t\t<min>0.0000000000e+00</min>\n")
+	b.WriteString("\t\t<max>" + formatRRDValue(float64(int(1)<<s.bits()-1)) + "</max>\n")
+	b.WriteString("\t\t<last_ds>U</last_ds>\n\t\t<value>NaN</value>\n\t\t<unknown_sec>0</unknown_sec>\n\t</ds>\n")
+	b.WriteString("\t<rra>\n\t\t<cf>AVERAGE</cf>\n\t\t<pdp_per_row>1</pdp_per_row>\n")
+	b.WriteString("\t\t<params><xff>5.0000000000e-01</xff></params>\n")
+	b.WriteString("\t\t<cdp_prep><ds><primary_value>NaN</primary_value><secondary_value>NaN</secondary_value>")
+	b.WriteString("<value>NaN</value><unknown_datapoints>0</unknown_datapoints></ds></cdp_prep>\n")
+	b.WriteString("\t\t<database>\n")
+	s.walk(0, int64(s.count)-1, func(j, n int64, v uint8) bool {
+		value := math.NaN()
+		if v != StateUnknown {
+			value = float64(v)
+		}
+		row := "<row><v>" + formatRRDValue(value) + "</v></row>\n"
+		for k := j; k < j+n; k++ {
+			ts := s.ts + k*f
+			b.WriteString("\t\t\t<!-- " + time.Unix(ts, 0).UTC().Format("2006-01-02 15:04:05 MST") +
+				" / " + strconv.FormatInt(ts, 10) + " --> " + row)
+		}
+		return true
+	})
+	b.WriteString("\t\t</database>\n\t</rra>\n</rrd>\n")
+	return []byte(b.String())
+}
+
+// formatRRDValue formats x the way rrdtool dump does.
+func formatRRDValue(x float64) string {
+	if math.IsNaN(x) {
+		return "NaN"
+	}
+	return strconv.FormatFloat(x, 'e', 10, 64)
+}
+
+// An rrdDump holds the parts of an rrdtool dump used by ImportRRD.
+type rrdDump struct {
+	Step       int64 `xml:"step"`
+	LastUpdate int64 `xml:"lastupdate"`
+	DS         []struct {
+		Name string `xml:"name"`
+	} `xml:"ds"`
+	RRA []struct {
+		CF        string `xml:"cf"`
+		PdpPerRow int64  `xml:"pdp_per_row"`
+		Rows      []struct {
+			V []string `xml:"v"`
+		} `xml:"database>row"`
+	} `xml:"rra"`
+}
+
+// ImportRRD creates a Sequence using data, a dump in the XML format of rrdtool
+// dump, and the data source named name, the first one if name is an empty
+// string. Values come from the AVERAGE archive with the finest resolution, or
+// any archive if there is none, and are rounded to the nearest state, NaN values
+// being imported as StateUnknown. Leading NaN values, typically archive slots
+// that were never filled, are ignored. The sequence uses the smallest state
+// width able to hold the imported states. It returns an error if data cannot be
+// decoded, if the data source does not exist or if a value is not a valid state.
+func ImportRRD(data []byte, name string) (*Sequence, error) {
+	var d rrdDump
+	if err := xml.Unmarshal(data, &d); err != nil {
+		return nil, errors.New("cannot decode the dump")
+	}
+	ds := -1
+	for i, x := range d.DS {
+		if name == "" || strings.TrimSpace(x.Name) == name {
+			ds = i
+			break
+		}
+	}
+	if ds < 0 {
+		return nil, errors.New("data source does not exist")
+	}
+	rra := -1
+	for i, x := range d.RRA {
+		if x.PdpPerRow < 1 {
+			return nil, errors.New("invalid archive")
+		}
+		if rra < 0 {
+			rra = i
+			continue
+		}
+		best := d.RRA[rra]
+		avg, bestAvg := strings.TrimSpace(x.CF) == "AVERAGE", strings.TrimSpace(best.CF) == "AVERAGE"
+		if avg && !bestAvg || avg == bestAvg && x.PdpPerRow < best.PdpPerRow {
+			rra = i
+		}
+	}
+	if rra < 0 {
+		return nil, errors.New("no archive")
+	}
+	size := d.Step * d.RRA[rra].PdpPerRow
+	if size < 1 || size > math.MaxUint16 {
+		return nil, errors.New("invalid step")
+	}
+	rows := d.RRA[rra].Rows
+	values := make([]uint8, 0, len(rows))
+	var state uint8
+	for _, row := range rows {
+		if ds >= len(row.V) {
+			return nil, errors.New("invalid row")
+		}
+		x, err := strconv.ParseFloat(strings.TrimSpace(row.V[ds]), 64)
+		if err != nil {
+			return nil, errors.New("invalid value")
+		}
+		if math.IsNaN(x) {
+			if len(values) > 0 {
+				values = append(values, StateUnknown)
+			}
+			continue
+		}
+		x = math.Round(x)
+		if x < 0 || x >= 1<<MaxStateWidth {
+			return nil, errors.New("invalid value")
+		}
+		values = append(values, uint8(x))
+		state = max(state, uint8(x))
+	}
+	w := uint8(MinStateWidth)
+	for state >= 1<<w {
+		w++
+	}
+	last := d.LastUpdate - d.LastUpdate%size
+	t := time.Unix(last-int64(len(values)-1)*size, 0)
+	s, _ := NewWithWidth(t, uint16(size), w)
+	s.addValues(values)
+	return s, nil
+}
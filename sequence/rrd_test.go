@@ -0,0 +1,73 @@
+package sequence
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRRD(t *testing.T) {
+	x := time.Unix(946782000, 0)
+	s1 := NewWithValues(x, 300, []uint8{1, 1, 0, 2, 1})
+	s2, _ := NewWithWidth(x, 60, 4)
+	s2.addValues([]uint8{9, 0, 12})
+	for i, s := range []*Sequence{s1, s2} {
+		got, err := ImportRRD(s.ExportRRD("status"), "status")
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", i+1, err)
+		}
+		if !bytes.Equal(got.Bytes(), s.Bytes()) {
+			t.Fatalf("test %d: got %v, want %v", i+1, got.All(), s.All())
+		}
+	}
+	dump := string(s1.ExportRRD(`a<b`))
+	for _, want := range []string{
+		"<step>300</step>",
+		"<lastupdate>946783200</lastupdate>",
+		"<name> a&lt;b </name>",
+		"<!-- 2000-01-02 03:00:00 UTC / 946782000 --> <row><v>1.0000000000e+00</v></row>",
+		"<!-- 2000-01-02 03:15:00 UTC / 946782900 --> <row><v>NaN</v></row>",
+	} {
+		if !strings.Contains(dump, want) {
+			t.Fatalf("dump does not contain %q:\n%s", want, dump)
+		}
+	}
+
+	// two data sources, leading unfilled slots and a coarser MAX archive
+	data := `<rrd><step>60</step><lastupdate>946782250</lastupdate>
+		<ds><name> latency </name></ds><ds><name> up </name></ds>
+		<rra><cf>MAX</cf><pdp_per_row>1</pdp_per_row><database>
+			<row><v>1</v><v>0</v></row></database></rra>
+		<rra><cf> AVERAGE </cf><pdp_per_row>5</pdp_per_row><database>
+			<row><v>NaN</v><v>NaN</v></row>
+			<row><v>12</v><v>0.8</v></row>
+			<row><v>15</v><v>NaN</v></row>
+			<row><v>11</v><v>0.4</v></row>
+		</database></rra></rrd>`
+	got, err := ImportRRD([]byte(data), "up")
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if want := NewWithValues(time.Unix(946781400, 0), 300, []uint8{1, 2, 0}); !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Fatalf("got %d %v, want %d %v", got.Timestamp(), got.All(), want.Timestamp(), want.All())
+	}
+	if got, _ := ImportRRD([]byte(data), ""); got.Width() != 4 {
+		t.Fatalf("got width %d, want 4", got.Width())
+	}
+
+	invalid := []string{
+		`<rrd`,
+		strings.Replace(data, "up", "down", 1),
+		strings.Replace(data, "<step>60</step>", "<step>20000</step>", 1),
+		strings.Replace(data, "0.8", "-3", 1),
+		strings.Replace(data, "0.8", "x", 1),
+		strings.Replace(data, "<v>12</v><v>0.8</v>", "<v>12</v>", 1),
+		`<rrd><step>60</step><ds><name>up</name></ds></rrd>`,
+	}
+	for i, data := range invalid {
+		if _, err := ImportRRD([]byte(data), "up"); err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", i+1)
+		}
+	}
+}
@@ -1,8 +1,15 @@
 package sequence
 
 import (
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
+	"hash/crc32"
+	"math"
+	"sort"
+	"strings"
 	"time"
+	"unsafe"
 )
 
 const (
@@ -17,8 +24,23 @@ const (
 	indexCounter   = indexLength + sizeLength
 	indexData      = indexCounter + sizeCounter
 
-	flagBits     = 2
-	flagBitsMask = 1<<flagBits - 1
+	// Versioned sequences hold a marker in place of the frequency of the legacy
+	// layout, which is never 0, followed by the version of the format. The fields
+	// following the timestamp are shifted accordingly. The high bit of the
+	// version is set if the sequence holds a checksum, so that its presence
+	// does not depend on the trailer of the sequence.
+	sizeVersion     = sizeFrequency + 1
+	indexVersion    = indexFrequency + sizeFrequency
+	formatVersion   = 1
+	versionChecksum = 0x80
+
+	flagBits = 2
+
+	// Identifiers of optional fields encoded after the data of a sequence
+	// represented as a slice of bytes.
+	extWidth    = 1
+	extMaxAge   = 2
+	extChecksum = 3
 )
 
 // Internal representation of sequence values.
@@ -33,6 +55,25 @@ const (
 // in a sequence.
 const MaxSequenceLength = 4294967295
 
+// Bounds of the number of bits used to represent a value in a sequence.
+// A sequence using w bits can hold values between 0 and 1<<w - 1.
+const (
+	MinStateWidth = flagBits
+	MaxStateWidth = 4
+)
+
+// A Point represents a value and its timestamp.
+type Point struct {
+	T time.Time
+	V uint8
+}
+
+// A Run represents a series of identical consecutive values.
+type Run struct {
+	Count uint32
+	Value uint8
+}
+
 // A Sequence represents a time series of regularly spaced binary states.
 // The maximum length of a sequence is 4294967295.
 type Sequence struct {
@@ -41,6 +82,8 @@ type Sequence struct {
 	count     uint32
 	frequency uint16
 	data      []byte
+	width     uint8
+	maxAge    int64
 }
 
 // New creates and intializes a new Sequence using t rounded down to
@@ -58,6 +101,31 @@ func New(t time.Time, f uint16) *Sequence {
 	return &s
 }
 
+// NewWithLength creates and initializes a new Sequence like New but using length
+// as its maximum length. It returns ErrInvalidLength if length is 0 and
+// ErrInvalidHorizon if the time span of the sequence overflows Unix time.
+func NewWithLength(t time.Time, f uint16, length uint32) (*Sequence, error) {
+	s := New(t, f)
+	if err := s.Resize(length); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewWithWidth creates and initializes a new Sequence like New but using w
+// bits to represent each value instead of the default 2 bits. It returns an
+// error if w is not between MinStateWidth and MaxStateWidth.
+func NewWithWidth(t time.Time, f uint16, w uint8) (*Sequence, error) {
+	if w < MinStateWidth || w > MaxStateWidth {
+		return nil, errors.New("invalid state width")
+	}
+	s := New(t, f)
+	if w != flagBits {
+		s.width = w
+	}
+	return s, nil
+}
+
 // NewWithValues creates a new Sequence using t rounded down to the second
 // as its reference timestamp, f as its frequency in seconds and values as its
 // initial content. The sequence frequency will default to 1 if set to 0. If the
@@ -65,68 +133,222 @@ func New(t time.Time, f uint16) *Sequence {
 // elements will be silently ignored.
 func NewWithValues(t time.Time, f uint16, values []uint8) *Sequence {
 	s := New(t, f)
-	n := len(values)
-	if n == 0 {
-		return s
-	}
-	if n > MaxSequenceLength {
-		n = MaxSequenceLength
-	}
-	count := uint32(1)
-	x := values[0]
-	for i := 1; i < n; i++ {
-		if values[i] != x {
-			s.addSeries(count, x)
-			count = 0
-			x = values[i]
+	s.addValues(values)
+	return s
+}
+
+// NewFromRuns creates a new Sequence like NewWithValues but using runs as its
+// initial content, without expanding them. Runs of zero values are ignored. If the
+// total number of values is greater than the maximum length of the sequence the
+// trailing values will be silently ignored.
+func NewFromRuns(t time.Time, f uint16, runs []Run) *Sequence {
+	s := New(t, f)
+	for _, r := range runs {
+		count := r.Count
+		if max := s.length - s.count; count > max {
+			count = max
 		}
-		count++
+		if count == 0 {
+			continue
+		}
+		s.addSeries(count, r.Value)
 	}
-	s.addSeries(count, x)
 	return s
 }
 
 // FromBytes creates a Sequence using data, a Sequence represented as
-// a slice of bytes.
+// a slice of bytes. Both the versioned layout produced by Bytes and the legacy
+// layout, without version, are supported. If data includes a checksum, it returns
+// ErrInvalidChecksum when the checksum does not match the content or, using the
+// versioned layout, when the checksum cannot be found.
 func FromBytes(data []byte) (*Sequence, error) {
 	n := len(data)
 	if n < indexData {
 		return nil, errors.New("cannot decode the sequence")
 	}
+	h := 0
+	checksum := false
+	if data[indexFrequency] == 0 && data[indexFrequency+1] == 0 {
+		if data[indexVersion]&^versionChecksum != formatVersion {
+			return nil, errors.New("unsupported format version")
+		}
+		h = sizeVersion
+		if n < indexData+h {
+			return nil, errors.New("cannot decode the sequence")
+		}
+		checksum = data[indexVersion]&versionChecksum != 0
+	}
+	// Optional fields are encoded after the data, followed by a byte holding
+	// their size with the high bit set. As the last byte of the data is always
+	// lower than 0x80, their presence can be detected unambiguously.
+	var ext []byte
+	if n > indexData+h && data[n-1] >= 0x80 {
+		size := int(data[n-1] & 0x7f)
+		if n-1-size < indexData+h {
+			return nil, errors.New("cannot decode the sequence")
+		}
+		ext = data[n-1-size : n-1]
+		n -= size + 1
+	}
 	s := Sequence{
-		data: make([]byte, n-indexData),
+		data: make([]byte, n-indexData-h),
+	}
+	copy(s.data, data[indexData+h:n])
+	for i := 0; i < len(ext); {
+		switch ext[i] {
+		case extWidth:
+			if i+1 >= len(ext) || ext[i+1] < MinStateWidth || ext[i+1] > MaxStateWidth {
+				return nil, errors.New("cannot decode the sequence")
+			}
+			if ext[i+1] != flagBits {
+				s.width = ext[i+1]
+			}
+			i += 2
+		case extMaxAge:
+			v, n := binary.Uvarint(ext[i+1:])
+			if n <= 0 || v > math.MaxInt64 {
+				return nil, errors.New("cannot decode the sequence")
+			}
+			s.maxAge = int64(v)
+			i += n + 1
+		case extChecksum:
+			if i+5 != len(ext) {
+				return nil, errors.New("cannot decode the sequence")
+			}
+			if crc32.ChecksumIEEE(data[:n+i+1]) != binary.LittleEndian.Uint32(ext[i+1:]) {
+				return nil, ErrInvalidChecksum
+			}
+			checksum = false
+			i += 5
+		default:
+			return nil, errors.New("cannot decode the sequence")
+		}
+	}
+	if checksum {
+		return nil, ErrInvalidChecksum
 	}
-	copy(s.data, data[indexData:])
 	i := indexTimestamp
 	s.ts = int64(data[i]) | int64(data[i+1])<<8 | int64(data[i+2])<<16 | int64(data[i+3])<<24 |
 		int64(data[i+4])<<32 | int64(data[i+5])<<40 | int64(data[i+6])<<48 | int64(data[i+7])<<56
-	i = indexFrequency
+	i = indexFrequency + h
 	s.frequency = uint16(data[i]) | uint16(data[i+1])<<8
-	i = indexLength
+	i = indexLength + h
 	s.length = uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
 	if s.length == 0 {
 		s.length = MaxSequenceLength
 	}
-	i = indexCounter
+	i = indexCounter + h
 	s.count = uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
 	return &s, nil
 }
 
 // Add adds a value to the sequence, returning an error if outside the
 // time boundaries of the sequence or if an entry already exists for the
-// interval.
+// interval. If the entry holds x, the error is ErrAlreadySet.
 func (s *Sequence) Add(t time.Time, x uint8) error {
+	return s.AddRun(t, 1, x)
+}
+
+// AddRun adds count identical values to the sequence, the first one at the
+// interval of t. It returns an error if count is 0, if the values are outside the
+// time boundaries of the sequence or if an entry already exists for the interval
+// of t. If entries holding x already exist for all the intervals, the error is
+// ErrAlreadySet.
+func (s *Sequence) AddRun(t time.Time, count uint32, x uint8) error {
+	if count == 0 {
+		return errors.New("invalid count")
+	}
 	offset := (t.Unix()-s.ts)/int64(s.frequency) + 1
-	if offset < 1 || offset > int64(s.length) {
+	if offset < 1 {
 		return errors.New("out of bounds")
 	}
 	if offset <= int64(s.count) {
+		if s.holds(offset-1, int64(count), x) {
+			return ErrAlreadySet
+		}
 		return errors.New("cannot overwrite value")
 	}
+	n := s.expired(offset + int64(count) - 2)
+	if offset-n+int64(count)-1 > int64(s.length) {
+		return errors.New("out of bounds")
+	}
+	if n > 0 {
+		if k := n - offset + 1; k > 0 {
+			count -= uint32(k)
+			offset += k
+		}
+		s.drop(n)
+		offset -= n
+	}
 	if delta := offset - int64(s.count); delta > 1 {
 		s.addSeries(uint32(delta)-1, StateUnknown)
 	}
-	s.addSeries(1, x)
+	s.addSeries(count, x)
+	return nil
+}
+
+// AddBatch adds multiple values to the sequence in one pass, coalescing identical
+// consecutive values. Points do not need to be sorted and are not modified. It
+// returns an error, without adding any value, if a point is outside the time
+// boundaries of the sequence, if an entry already exists for its interval or if
+// several points share the same interval.
+func (s *Sequence) AddBatch(points []Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	type entry struct {
+		offset int64
+		value  uint8
+	}
+	f := int64(s.frequency)
+	entries := make([]entry, len(points))
+	sorted := true
+	for i, p := range points {
+		entries[i] = entry{(p.T.Unix()-s.ts)/f + 1, p.V}
+		if i > 0 && entries[i].offset < entries[i-1].offset {
+			sorted = false
+		}
+	}
+	if !sorted {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].offset < entries[j].offset
+		})
+	}
+	n := s.expired(entries[len(entries)-1].offset - 1)
+	if entries[0].offset < 1 || entries[len(entries)-1].offset-n > int64(s.length) {
+		return errors.New("out of bounds")
+	}
+	if entries[0].offset <= int64(s.count) {
+		return errors.New("cannot overwrite value")
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].offset == entries[i-1].offset {
+			return errors.New("duplicate interval")
+		}
+	}
+	s.drop(n)
+	offset := int64(s.count)
+	count := uint32(0)
+	var x uint8
+	for _, e := range entries {
+		if e.offset -= n; e.offset < 1 {
+			continue
+		}
+		if delta := e.offset - offset; delta > 1 {
+			if count > 0 {
+				s.addSeries(count, x)
+			}
+			count, x = uint32(delta)-1, StateUnknown
+		}
+		if count > 0 && e.value != x {
+			s.addSeries(count, x)
+			count = 0
+		}
+		x = e.value
+		count++
+		offset = e.offset
+	}
+	s.addSeries(count, x)
 	return nil
 }
 
@@ -134,25 +356,34 @@ func (s *Sequence) Add(t time.Time, x uint8) error {
 // that it automatically discards oldest values if the add operation overflows
 // the maximum capacity of the sequence. It returns an error if t is less than
 // the timestamp of the sequence or if an entry already exists for the interval.
+// If the entry holds x, the error is ErrAlreadySet.
 func (s *Sequence) Roll(t time.Time, x uint8) error {
 	offset := (t.Unix()-s.ts)/int64(s.frequency) + 1
 	if offset < 1 {
 		return errors.New("out of bounds")
 	}
 	if offset <= int64(s.count) {
+		if s.holds(offset-1, 1, x) {
+			return ErrAlreadySet
+		}
 		return errors.New("cannot overwrite value")
 	}
+	if n := s.expired(offset - 1); n > 0 {
+		s.drop(n)
+		offset -= n
+	}
 	delta := offset - int64(s.count)
 	if offset > int64(s.length) {
 		n := offset - int64(s.length)
+		w := s.bits()
 		if n >= int64(s.count) {
-			x &= flagBitsMask
-			s.data = append(encode(s.length-1, StateUnknown), 1<<flagBits|x)
+			x &= 1<<w - 1
+			s.data = append(encode(s.length-1, StateUnknown, w), 1<<w|x)
 			s.count = s.length
 			s.ts += n * int64(s.frequency)
 			return nil
 		}
-		if delta == 1 && len(s.data) == 1 && s.data[0]&flagBitsMask == x&flagBitsMask {
+		if delta == 1 && len(s.data) == 1 && s.data[0]&(1<<w-1) == x&(1<<w-1) {
 			s.ts += int64(s.frequency)
 			return nil
 		}
@@ -165,6 +396,38 @@ func (s *Sequence) Roll(t time.Time, x uint8) error {
 	return nil
 }
 
+// RollReplace is similar to Roll but replaces the most recent value of the sequence
+// if t belongs to its interval instead of returning an error, which allows producers
+// to send several samples for the same interval. It returns an error if t is less
+// than the timestamp of the sequence or if t belongs to the interval of an older value.
+func (s *Sequence) RollReplace(t time.Time, x uint8) error {
+	offset := (t.Unix()-s.ts)/int64(s.frequency) + 1
+	if offset < 1 || offset != int64(s.count) {
+		return s.Roll(t, x)
+	}
+	c, v, n := s.last()
+	s.data = s.data[:len(s.data)-n]
+	if c > 1 {
+		s.data = append(s.data, encode(c-1, v, s.bits())...)
+	}
+	s.count--
+	s.addSeries(1, x)
+	return nil
+}
+
+// Backfill replaces the StateUnknown value stored at the interval of t with x,
+// allowing late values to fill gaps left by Add or Roll. It returns an error if
+// t is outside the stored values of the sequence or if the interval does not hold
+// StateUnknown. Backfilling may leave adjacent series holding identical values,
+// see Compact.
+func (s *Sequence) Backfill(t time.Time, x uint8) error {
+	offset := (t.Unix() - s.ts) / int64(s.frequency)
+	if t.Unix() < s.ts || offset >= int64(s.count) {
+		return errors.New("out of bounds")
+	}
+	return s.set(offset, x, StateUnknown)
+}
+
 // TrimLeft drops all values older than t and updates the timestamp of
 // the sequence to the first offset greater or equal to t. It returns
 // an error if t is less than the timestamp of the sequence.
@@ -174,27 +437,19 @@ func (s *Sequence) TrimLeft(t time.Time) error {
 	if x < 0 {
 		return errors.New("out of bounds")
 	}
-	if x == 0 {
-		return nil
-	}
-	if x >= int64(s.count) {
-		s.ts += x * f
-		s.count = 0
-		s.data = []byte{}
-		return nil
-	}
-	s.trimLeft(uint32(x))
+	s.drop(x)
 	return nil
 }
 
 // addSeries adds a series of values to the sequence, using count as the
 // length of the series and x as the value.
 func (s *Sequence) addSeries(count uint32, x uint8) {
-	x &= flagBitsMask
+	w := s.bits()
+	x &= 1<<w - 1
 	if s.count != 0 {
 		c, v, n := s.last()
 		if v == x {
-			buf := encode(c+count, x)
+			buf := encode(c+count, x, w)
 			index := len(s.data) - n
 			for i := 0; i < len(buf); i++ {
 				if i >= n {
@@ -208,13 +463,121 @@ func (s *Sequence) addSeries(count uint32, x uint8) {
 		}
 	}
 	if count == 1 {
-		s.data = append(s.data, 1<<flagBits|x)
+		s.data = append(s.data, 1<<w|x)
 	} else {
-		s.data = append(s.data, encode(count, x)...)
+		s.data = append(s.data, encode(count, x, w)...)
 	}
 	s.count += count
 }
 
+// addValues adds values to the sequence, coalescing identical consecutive values
+// into series. Values overflowing the maximum length of the sequence are silently
+// ignored.
+func (s *Sequence) addValues(values []uint8) {
+	n := len(values)
+	if n == 0 {
+		return
+	}
+	if max := int64(s.length) - int64(s.count); int64(n) > max {
+		n = int(max)
+	}
+	if n == 0 {
+		return
+	}
+	count := uint32(1)
+	x := values[0]
+	for i := 1; i < n; i++ {
+		if values[i] != x {
+			s.addSeries(count, x)
+			count = 0
+			x = values[i]
+		}
+		count++
+	}
+	s.addSeries(count, x)
+}
+
+// set replaces the value stored at offset j with x, splitting the series holding
+// it. It returns an error if the current value differs from old. The caller must ensure j
+// is lower than the number of values in the sequence.
+func (s *Sequence) set(j int64, x uint8, old uint8) error {
+	w := s.bits()
+	x &= 1<<w - 1
+	src := int64(0)
+	p := 0
+	for p < len(s.data) {
+		count, v, bytesRead := s.next(p)
+		next := src + int64(count)
+		if j >= next {
+			src = next
+			p += bytesRead
+			continue
+		}
+		if v != old {
+			return errors.New("cannot overwrite value")
+		}
+		if v == x {
+			return nil
+		}
+		buf := make([]byte, 0, len(s.data)+10)
+		buf = append(buf, s.data[:p]...)
+		if n := j - src; n > 0 {
+			buf = append(buf, encode(uint32(n), v, w)...)
+		}
+		buf = append(buf, 1<<w|x)
+		if n := next - j - 1; n > 0 {
+			buf = append(buf, encode(uint32(n), v, w)...)
+		}
+		s.data = append(buf, s.data[p+bytesRead:]...)
+		return nil
+	}
+	return errors.New("out of bounds")
+}
+
+// holds reports whether the n values starting at offset j are stored in the
+// sequence and equal to x.
+func (s *Sequence) holds(j, n int64, x uint8) bool {
+	if j+n > int64(s.count) {
+		return false
+	}
+	x &= 1<<s.bits() - 1
+	ok := true
+	s.walk(j, j+n-1, func(_, _ int64, v uint8) bool {
+		ok = v == x
+		return ok
+	})
+	return ok
+}
+
+// expired returns the number of values to discard from the beginning of the
+// sequence for the value at offset j to be the most recent one within the maximum
+// age of the sequence.
+func (s *Sequence) expired(j int64) int64 {
+	if s.maxAge == 0 {
+		return 0
+	}
+	f := int64(s.frequency)
+	if n := j + 1 - ceilInt64(s.maxAge, f)/f; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// drop removes the n first values of the sequence, n being possibly greater
+// than the number of values, and updates its timestamp accordingly.
+func (s *Sequence) drop(n int64) {
+	if n <= 0 {
+		return
+	}
+	if n >= int64(s.count) {
+		s.ts += n * int64(s.frequency)
+		s.count = 0
+		s.data = []byte{}
+		return
+	}
+	s.trimLeft(uint32(n))
+}
+
 // trimLeft removes the x first values of the sequence and updates
 // its timestamp accordingly.
 func (s *Sequence) trimLeft(x uint32) {
@@ -230,7 +593,7 @@ func (s *Sequence) trimLeft(x uint32) {
 			break
 		}
 		if y > x {
-			buf := encode(y-x, value)
+			buf := encode(y-x, value, s.bits())
 			offset := bytesRead - len(buf)
 			for i := 0; i < len(buf); i++ {
 				s.data[p+offset+i] = buf[i]
@@ -266,38 +629,119 @@ func (s *Sequence) All() []uint8 {
 
 // Bytes returns s represented as a slice of bytes.
 func (s *Sequence) Bytes() []byte {
-	x := make([]byte, indexData+len(s.data))
-	i := indexTimestamp
-	x[i] = byte(s.ts)
-	x[i+1] = byte(s.ts >> 8)
-	x[i+2] = byte(s.ts >> 16)
-	x[i+3] = byte(s.ts >> 24)
-	x[i+4] = byte(s.ts >> 32)
-	x[i+5] = byte(s.ts >> 40)
-	x[i+6] = byte(s.ts >> 48)
-	x[i+7] = byte(s.ts >> 56)
-	i = indexFrequency
-	x[i], x[i+1] = byte(s.frequency), byte(s.frequency>>8)
-	if v := s.length; v != MaxSequenceLength {
-		i = indexLength
-		x[i], x[i+1], x[i+2], x[i+3] = byte(v), byte(v>>8), byte(v>>16), byte(v>>24)
-	}
-	if v := s.count; v != 0 {
-		i = indexCounter
-		x[i], x[i+1], x[i+2], x[i+3] = byte(v), byte(v>>8), byte(v>>16), byte(v>>24)
-	}
-	if len(s.data) > 0 {
-		copy(x[indexData:], s.data)
-	}
-	return x
+	return s.appendBytes(nil, false)
+}
+
+// BytesWithChecksum returns s represented as a slice of bytes like Bytes but
+// including a CRC-32 checksum, which is verified by FromBytes.
+func (s *Sequence) BytesWithChecksum() []byte {
+	return s.appendBytes(nil, true)
+}
+
+// AppendBytes appends s represented as a slice of bytes, as returned by Bytes,
+// to dst and returns the extended buffer. It does not allocate if dst has enough
+// capacity.
+func (s *Sequence) AppendBytes(dst []byte) []byte {
+	return s.appendBytes(dst, false)
+}
+
+// stringPrefix starts the strings returned by EncodeString, its digit being the
+// version of the text encoding.
+const stringPrefix = "rl1."
+
+// EncodeString returns s represented as a short string safe to use in text
+// columns, log lines, URLs and configuration files: the bytes returned by
+// BytesWithChecksum encoded with unpadded URL-safe base64, prefixed by a
+// version. It can be decoded using DecodeString.
+func (s *Sequence) EncodeString() string {
+	return stringPrefix + base64.RawURLEncoding.EncodeToString(s.BytesWithChecksum())
+}
+
+// DecodeString creates a Sequence using x, a Sequence represented as a string by
+// EncodeString. It returns an error if x cannot be decoded, including
+// ErrInvalidChecksum if it was altered.
+func DecodeString(x string) (*Sequence, error) {
+	if !strings.HasPrefix(x, stringPrefix) {
+		return nil, errors.New("unsupported string encoding")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(x[len(stringPrefix):])
+	if err != nil {
+		return nil, errors.New("cannot decode the sequence")
+	}
+	return FromBytes(data)
+}
+
+// appendBytes appends s represented as a slice of bytes to dst, including a
+// CRC-32 checksum of the preceding bytes as the last optional field if checksum
+// is true.
+func (s *Sequence) appendBytes(dst []byte, checksum bool) []byte {
+	var buf [16]byte
+	ext := buf[:0]
+	if s.width != 0 && s.width != flagBits {
+		ext = append(ext, extWidth, s.width)
+	}
+	if s.maxAge > 0 {
+		ext = append(ext, extMaxAge)
+		ext = binary.AppendUvarint(ext, uint64(s.maxAge))
+	}
+	n := len(ext)
+	if checksum {
+		n += 5
+	}
+	size := indexData + sizeVersion + len(s.data)
+	if n > 0 {
+		size += n + 1
+	}
+	if cap(dst)-len(dst) < size {
+		x := make([]byte, len(dst), len(dst)+size)
+		copy(x, dst)
+		dst = x
+	}
+	p := len(dst)
+	length := s.length
+	if length == MaxSequenceLength {
+		length = 0
+	}
+	dst = binary.LittleEndian.AppendUint64(dst, uint64(s.ts))
+	if checksum {
+		dst = append(dst, 0, 0, formatVersion|versionChecksum)
+	} else {
+		dst = append(dst, 0, 0, formatVersion)
+	}
+	dst = binary.LittleEndian.AppendUint16(dst, s.frequency)
+	dst = binary.LittleEndian.AppendUint32(dst, length)
+	dst = binary.LittleEndian.AppendUint32(dst, s.count)
+	dst = append(dst, s.data...)
+	dst = append(dst, ext...)
+	if checksum {
+		dst = append(dst, extChecksum)
+		dst = binary.LittleEndian.AppendUint32(dst, crc32.ChecksumIEEE(dst[p:]))
+	}
+	if n > 0 {
+		dst = append(dst, 0x80|byte(n))
+	}
+	return dst
 }
 
 // SetLength sets the length of the sequence to x, silently right trimming
-// values if needed.
+// values if needed. It has no effect if x is not a valid length, see Resize.
 func (s *Sequence) SetLength(x uint32) {
+	s.Resize(x)
+}
+
+// Resize works like SetLength but returns ErrInvalidLength if x is 0 and
+// ErrInvalidHorizon if the resulting time span of the sequence overflows Unix
+// time. In both cases the sequence is left unchanged.
+func (s *Sequence) Resize(x uint32) error {
+	if x == 0 {
+		return ErrInvalidLength
+	}
+	if s.ts > math.MaxInt64-(int64(x)-1)*int64(s.frequency) {
+		return ErrInvalidHorizon
+	}
 	s.length = x
 	if x >= s.count {
-		return
+		return nil
 	}
 	v := uint32(0)
 	p := 0
@@ -311,7 +755,7 @@ func (s *Sequence) SetLength(x uint32) {
 			break
 		}
 		if v > x {
-			last := encode(count-(v-x), value)
+			last := encode(count-(v-x), value, s.bits())
 			buf := make([]byte, p+len(last))
 			copy(buf, append(s.data[:p], last...))
 			s.data = buf
@@ -320,6 +764,7 @@ func (s *Sequence) SetLength(x uint32) {
 		p += bytesRead
 	}
 	s.count = x
+	return nil
 }
 
 // Shrink aims at freeing up memory by resetting the sequence's underlying
@@ -333,6 +778,73 @@ func (s *Sequence) Shrink() {
 	s.data = data
 }
 
+// Invert swaps StateActive and StateInactive values in place, leaving other
+// values unchanged.
+func (s *Sequence) Invert() {
+	for p := 0; p < len(s.data); {
+		_, v, bytesRead := s.next(p)
+		if v == StateActive || v == StateInactive {
+			s.data[p] ^= StateActive
+		}
+		p += bytesRead
+	}
+}
+
+// Compact re-encodes the values of the sequence using the minimal representation,
+// merging adjacent series holding identical values such as the ones left by
+// Backfill. It returns the number of bytes saved.
+func (s *Sequence) Compact() int {
+	w := s.bits()
+	data := make([]byte, 0, len(s.data))
+	var count uint32
+	var x uint8
+	for p := 0; p < len(s.data); {
+		c, v, bytesRead := s.next(p)
+		p += bytesRead
+		if count > 0 && v != x {
+			data = append(data, encode(count, x, w)...)
+			count = 0
+		}
+		count += c
+		x = v
+	}
+	if count > 0 {
+		data = append(data, encode(count, x, w)...)
+	}
+	saved := len(s.data) - len(data)
+	s.data = data
+	return saved
+}
+
+// SetMaxAge sets the maximum age of the values of the sequence to d, rounded down
+// to the second. Once set, Add, AddRun, AddBatch and Roll automatically discard the
+// values whose timestamps are d or more older than the most recent value, so that
+// the sequence covers at most d regardless of its frequency. Values already beyond
+// the maximum age are discarded immediately. The maximum age is applied in addition
+// to the length of the sequence. A value of 0 disables the mechanism. It returns an
+// error if d is negative.
+func (s *Sequence) SetMaxAge(d time.Duration) error {
+	if d < 0 {
+		return errors.New("invalid maximum age")
+	}
+	s.maxAge = int64(d / time.Second)
+	if s.count > 0 {
+		s.drop(s.expired(int64(s.count) - 1))
+	}
+	return nil
+}
+
+// MaxAge returns the maximum age of the values of the sequence, 0 if disabled.
+func (s *Sequence) MaxAge() time.Duration {
+	return time.Duration(s.maxAge) * time.Second
+}
+
+// SizeBytes returns the approximate number of bytes of memory used by the
+// sequence, including the unused capacity of its underlying structures.
+func (s *Sequence) SizeBytes() int {
+	return int(unsafe.Sizeof(*s)) + cap(s.data)
+}
+
 // Timestamp returns the sequence reference timestamp as a Unix time.
 func (s *Sequence) Timestamp() int64 {
 	return s.ts
@@ -348,6 +860,19 @@ func (s *Sequence) Length() uint32 {
 	return s.length
 }
 
+// Width returns the number of bits used to represent a value in the sequence.
+func (s *Sequence) Width() uint8 {
+	return s.bits()
+}
+
+// bits returns the number of bits used to represent a value in s.
+func (s *Sequence) bits() uint8 {
+	if s.width == 0 {
+		return flagBits
+	}
+	return s.width
+}
+
 // last returns the length and value of the last series in the sequence.
 // The third return value represents the number of bytes read.
 func (s *Sequence) last() (uint32, uint8, int) {
@@ -362,8 +887,9 @@ func (s *Sequence) last() (uint32, uint8, int) {
 // next returns the length and value of the next series in the sequence.
 // The third return value represents the number of bytes read.
 func (s *Sequence) next(p int) (uint32, uint8, int) {
-	x := uint32(s.data[p] & 0x7f >> flagBits)
-	shift := 7 - flagBits
+	w := s.bits()
+	x := uint32(s.data[p] & 0x7f >> w)
+	shift := 7 - w
 	i := p
 	for i < len(s.data)-1 {
 		if s.data[i] < 0x80 {
@@ -373,7 +899,34 @@ func (s *Sequence) next(p int) (uint32, uint8, int) {
 		shift += 7
 		i++
 	}
-	return x, s.data[p] & flagBitsMask, i - p + 1
+	return x, s.data[p] & (1<<w - 1), i - p + 1
+}
+
+// walk calls fn for each series of values stored in s intersecting the closed
+// interval of offsets [x, y]. Series are clipped to the interval, j being the
+// offset of the first value of the series and n the number of values. Adjacent
+// series may hold the same value. Walking stops if fn returns false.
+func (s *Sequence) walk(x, y int64, fn func(j, n int64, v uint8) bool) {
+	src := int64(0)
+	p := 0
+	for p < len(s.data) && src <= y {
+		count, v, bytesRead := s.next(p)
+		p += bytesRead
+		next := src + int64(count)
+		if next > x {
+			a, b := src, next-1
+			if a < x {
+				a = x
+			}
+			if b > y {
+				b = y
+			}
+			if !fn(a, b-a+1, v) {
+				return
+			}
+		}
+		src = next
+	}
 }
 
 // interval returns the closed time interval associated to the sequence.
@@ -381,6 +934,19 @@ func (s *Sequence) interval() interval {
 	return interval{start: s.ts, end: s.ts + (int64(s.length)-1)*int64(s.frequency)}
 }
 
+// offsets returns the closed interval of offsets of s whose timestamps are
+// between start and end. The third return value is false if there is none.
+func (s *Sequence) offsets(start, end time.Time) (int64, int64, bool) {
+	r, ok := s.interval().intersect(interval{start: start.Unix(), end: end.Unix()})
+	if !ok {
+		return 0, 0, false
+	}
+	f := int64(s.frequency)
+	x := ceilInt64(r.start-s.ts, f) / f
+	y := (r.end - s.ts) / f
+	return x, y, x <= y
+}
+
 // clone returns a copy of s.
 func (s *Sequence) clone() *Sequence {
 	clone := Sequence{
@@ -389,16 +955,18 @@ func (s *Sequence) clone() *Sequence {
 		length:    s.length,
 		count:     s.count,
 		data:      make([]uint8, len(s.data)),
+		width:     s.width,
+		maxAge:    s.maxAge,
 	}
 	copy(clone.data, s.data)
 	return &clone
 }
 
-// encode encodes count and value as bytes. As value represents a 2-bit value
-// in a sequence, the caller must ensure it is not greater than 0b11.
-func encode(count uint32, value uint8) []byte {
-	s := make([]uint8, 5)
-	x := int64(count) << flagBits
+// encode encodes count and value as bytes using w bits to represent value.
+// The caller must ensure value is not greater than 1<<w - 1.
+func encode(count uint32, value uint8, w uint8) []byte {
+	s := make([]uint8, 6)
+	x := int64(count) << w
 	i := 0
 	for x >= 0x80 {
 		s[i] = byte(x) | 0x80
@@ -410,13 +978,14 @@ func encode(count uint32, value uint8) []byte {
 	return s[:i+1]
 }
 
-// decode decodes values encoded using the encode function.
-func decode(buf []byte) (uint32, uint8, int) {
+// decode decodes values encoded using the encode function with w bits
+// representing the value.
+func decode(buf []byte, w uint8) (uint32, uint8, int) {
 	if buf[len(buf)-1] >= 0x80 {
 		return 0, 0, 0
 	}
-	x := uint32(buf[0] & 0x7f >> flagBits)
-	shift := 7 - flagBits
+	x := uint32(buf[0] & 0x7f >> w)
+	shift := 7 - w
 	i := 0
 	for i < len(buf)-1 {
 		if buf[i] < 0x80 {
@@ -426,5 +995,5 @@ func decode(buf []byte) (uint32, uint8, int) {
 		shift += 7
 		i++
 	}
-	return x, buf[0] & flagBitsMask, i + 1
+	return x, buf[0] & (1<<w - 1), i + 1
 }
@@ -2,6 +2,9 @@ package sequence
 
 import (
 	"bytes"
+	"errors"
+	"math"
+	"strings"
 	"testing"
 	"time"
 )
@@ -10,17 +13,43 @@ var (
 	testSequenceTimestamp  = "2000-01-02 03:04:05"
 	testSequenceFrequency  = uint16(60)
 	testSequenceBasePrefix = []byte{0x25, 0xc0, 0x6e, 0x38, 0x0, 0x0, 0x0, 0x0, 0x3c, 0x0, 0x0, 0x0, 0x0, 0x0}
+	testSequenceV1Prefix   = []byte{0x25, 0xc0, 0x6e, 0x38, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x3c, 0x0, 0x0, 0x0, 0x0, 0x0}
 	testValues             = []uint8{1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 0}
 )
 
 func TestEncode(t *testing.T) {
 	want := []byte{0b11001101, 0b10000010, 0b11111101, 0b10001100, 0b00111000}
-	got := encode(3764899923, StateActive)
+	got := encode(3764899923, StateActive, flagBits)
 	if !bytes.Equal(got, want) {
 		t.Fatalf("\ngot  %08b\nwant %08b\n", got, want)
 	}
 }
 
+func TestEncodeWidth(t *testing.T) {
+	tests := []struct {
+		id    int
+		count uint32
+		value uint8
+		width uint8
+		want  []byte
+	}{
+		{1, 1, 5, 3, []byte{0b00001101}},
+		{2, 16, 5, 3, []byte{0b10000101, 0b00000001}},
+		{3, 7, 12, 4, []byte{0b01111100}},
+		{4, 4294967295, 15, 4, []byte{0b11111111, 0b11111111, 0b11111111, 0b11111111, 0b11111111, 0b00000001}},
+	}
+	for _, tt := range tests {
+		got := encode(tt.count, tt.value, tt.width)
+		if !bytes.Equal(got, tt.want) {
+			t.Fatalf("test %d:\ngot  %08b\nwant %08b\n", tt.id, got, tt.want)
+		}
+		count, value, n := decode(got, tt.width)
+		if count != tt.count || value != tt.value || n != len(tt.want) {
+			t.Fatalf("test %d: got (%d, %d, %d), want (%d, %d, %d)", tt.id, count, value, n, tt.count, tt.value, len(tt.want))
+		}
+	}
+}
+
 func TestDecode(t *testing.T) {
 	type result struct {
 		count uint32
@@ -29,7 +58,7 @@ func TestDecode(t *testing.T) {
 	}
 	want := result{3764899923, StateActive, 5}
 	var got result
-	got.count, got.value, got.n = decode([]byte{0b11001101, 0b10000010, 0b11111101, 0b10001100, 0b00111000})
+	got.count, got.value, got.n = decode([]byte{0b11001101, 0b10000010, 0b11111101, 0b10001100, 0b00111000}, flagBits)
 	if got != want {
 		t.Fatalf("got %+v, want %+v", got, want)
 	}
@@ -49,6 +78,50 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNewWithWidth(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	for _, w := range []uint8{0, 1, 5} {
+		if _, err := NewWithWidth(x, testSequenceFrequency, w); err == nil {
+			t.Fatalf("width %d: got error nil, want non nil error", w)
+		}
+	}
+	for _, w := range []uint8{2, 3, 4} {
+		s, err := NewWithWidth(x, testSequenceFrequency, w)
+		if err != nil {
+			t.Fatalf("width %d: got error %s, want error nil", w, err)
+		}
+		if got := s.Width(); got != w {
+			t.Fatalf("got %d, want %d", got, w)
+		}
+	}
+}
+
+func TestSequenceWidth(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	values := []uint8{4, 4, 4, 1, 0, 7, 7, 2, 15, 15, 15, 3}
+	for _, w := range []uint8{3, 4} {
+		s, _ := NewWithWidth(x, testSequenceFrequency, w)
+		want := make([]uint8, len(values))
+		for i, v := range values {
+			v &= 1<<w - 1
+			want[i] = v
+			if err := s.Add(shift(s, i, 0), v); err != nil {
+				t.Fatalf("width %d: got error %s, want error nil", w, err)
+			}
+		}
+		if got := s.All(); !assertValuesEqual(got, want) {
+			t.Fatalf("width %d:\ngot  %v\nwant %v", w, got, want)
+		}
+		got, err := FromBytes(s.Bytes())
+		if err != nil {
+			t.Fatalf("width %d: got error %s, want error nil", w, err)
+		}
+		if !assertSequencesEqual(got, s) {
+			t.Fatalf("width %d:\ngot  %+v\nwant %+v", w, got, s)
+		}
+	}
+}
+
 func TestNewWithValues(t *testing.T) {
 	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
 	want := &Sequence{
@@ -64,6 +137,23 @@ func TestNewWithValues(t *testing.T) {
 	}
 }
 
+func TestNewFromRuns(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	runs := []Run{{5, StateActive}, {2, StateInactive}, {0, StateActive}, {3, StateInactive}, {5, StateActive}, {4, StateUnknown}, {1, StateInactive}}
+	got := NewFromRuns(x, testSequenceFrequency, runs)
+	want := NewWithValues(x, testSequenceFrequency, testValues)
+	if !assertSequencesEqual(got, want) {
+		t.Fatalf("\ngot  %+v\nwant %+v", got, want)
+	}
+	got = NewFromRuns(x, testSequenceFrequency, []Run{{MaxSequenceLength - 1, StateActive}, {2, StateInactive}, {3, StateActive}})
+	want = New(x, testSequenceFrequency)
+	want.addSeries(MaxSequenceLength-1, StateActive)
+	want.addSeries(1, StateInactive)
+	if !assertSequencesEqual(got, want) {
+		t.Fatalf("\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
 func TestFromBytes(t *testing.T) {
 	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
 	want := &Sequence{
@@ -73,12 +163,67 @@ func TestFromBytes(t *testing.T) {
 		count:     129,
 		data:      []byte{0x4, 0x2},
 	}
-	got, err := FromBytes(append(testSequenceBasePrefix, []byte{0x81, 0x0, 0x0, 0x0, 0x4, 0x2}...))
-	if err != nil {
-		t.Fatalf("got error %s, want error nil", err)
+	for _, prefix := range [][]byte{testSequenceBasePrefix, testSequenceV1Prefix} {
+		got, err := FromBytes(append(prefix, []byte{0x81, 0x0, 0x0, 0x0, 0x4, 0x2}...))
+		if err != nil {
+			t.Fatalf("got error %s, want error nil", err)
+		}
+		if !assertSequencesEqual(got, want) {
+			t.Fatalf("\ngot  %+v\nwant %+v", got, want)
+		}
 	}
-	if !assertSequencesEqual(got, want) {
-		t.Fatalf("\ngot  %+v\nwant %+v", got, want)
+	data := append(append([]byte{}, testSequenceV1Prefix...), []byte{0x81, 0x0, 0x0, 0x0, 0x4, 0x2}...)
+	data[indexVersion] = formatVersion + 1
+	if _, err := FromBytes(data); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if _, err := FromBytes(append(testSequenceV1Prefix, 0x0, 0x0)); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestSequenceBytesWithChecksum(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	w, _ := NewWithWidth(x, testSequenceFrequency, 4)
+	w.AddRun(x, 10, 12)
+	w.SetMaxAge(time.Hour)
+	for i, s := range []*Sequence{New(x, testSequenceFrequency), NewWithValues(x, testSequenceFrequency, testValues), w} {
+		data := s.BytesWithChecksum()
+		if n := len(data) - len(s.Bytes()); n != 5 && n != 6 {
+			t.Fatalf("test %d: got %d additional bytes, want 5 or 6", i, n)
+		}
+		got, err := FromBytes(data)
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", i, err)
+		}
+		if !assertSequencesEqual(got, s) {
+			t.Fatalf("test %d:\ngot  %+v\nwant %+v", i, got, s)
+		}
+		for _, j := range []int{0, indexCounter, len(data) - 3} {
+			corrupted := append([]byte{}, data...)
+			corrupted[j] ^= 0x01
+			if _, err := FromBytes(corrupted); err != ErrInvalidChecksum {
+				t.Fatalf("test %d: byte %d: got error %v, want ErrInvalidChecksum", i, j, err)
+			}
+		}
+	}
+	data := NewWithValues(x, testSequenceFrequency, testValues).BytesWithChecksum()
+	n := len(data)
+	data = append(data[:n-1], extWidth, 3, 0x80|7)
+	if _, err := FromBytes(data); err == nil || err == ErrInvalidChecksum {
+		t.Fatalf("got error %v, want decoding error", err)
+	}
+}
+
+func TestSequenceBytesWithChecksumTrailer(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	data := NewWithValues(x, testSequenceFrequency, testValues).BytesWithChecksum()
+	for _, v := range []byte{0x00, 0x05, 0x7f} {
+		corrupted := append([]byte{}, data...)
+		corrupted[len(corrupted)-1] = v
+		if _, err := FromBytes(corrupted); err != ErrInvalidChecksum {
+			t.Fatalf("trailer %#x: got error %v, want ErrInvalidChecksum", v, err)
+		}
 	}
 }
 
@@ -195,13 +340,311 @@ func TestSequenceAdd(t *testing.T) {
 		if tt.want.err {
 			t.Fatalf("test %d: got error nil, want non nil error", tt.id)
 		}
-		want := &Sequence{x.Unix(), MaxSequenceLength, uint32(tt.shift + 1), testSequenceFrequency, tt.want.data}
+		want := &Sequence{x.Unix(), MaxSequenceLength, uint32(tt.shift + 1), testSequenceFrequency, tt.want.data, 0, 0}
 		if !assertSequencesEqual(got, want) {
 			t.Fatalf("test %d:\ngot  %+v\nwant %+v", tt.id, got, want)
 		}
 	}
 }
 
+func TestSequenceAddRun(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	s := New(x, testSequenceFrequency)
+	s.SetLength(140)
+	tests := []struct {
+		id    int
+		shift int
+		count uint32
+		value uint8
+		want  []uint8
+		err   bool
+	}{
+		{1, 2, 3, StateActive, []uint8{2, 2, 1, 1, 1}, false},
+		{2, 5, 2, StateActive, []uint8{2, 2, 1, 1, 1, 1, 1}, false},
+		{3, 8, 2, StateInactive, []uint8{2, 2, 1, 1, 1, 1, 1, 2, 0, 0}, false},
+		{4, 9, 1, StateActive, nil, true},
+		{5, 10, 0, StateActive, nil, true},
+		{6, 10, 131, StateActive, nil, true},
+		{7, -1, 1, StateActive, nil, true},
+		{8, 10, 130, StateActive, append([]uint8{2, 2, 1, 1, 1, 1, 1, 2, 0, 0}, newSliceOfValues(130, 1)...), false},
+	}
+	for _, tt := range tests {
+		before := s.clone()
+		err := s.AddRun(shift(s, tt.shift, 0), tt.count, tt.value)
+		if err != nil {
+			if !tt.err {
+				t.Fatalf("test %d: got error %s, want error nil", tt.id, err)
+			}
+			if !assertSequencesEqual(s, before) {
+				t.Fatalf("test %d: sequence should not be modified", tt.id)
+			}
+			continue
+		}
+		if tt.err {
+			t.Fatalf("test %d: got error nil, want non nil error", tt.id)
+		}
+		if got := s.All(); !assertValuesEqual(got, tt.want) {
+			t.Fatalf("test %d:\ngot  %v\nwant %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestSequenceAddBatch(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	s := New(x, testSequenceFrequency)
+	s.SetLength(200)
+	s.Add(shift(s, 2, 0), StateActive)
+	steps := []int{130, 4, 3, 5, 140, 131, 139, 6}
+	values := []uint8{1, 1, 0, 1, 0, 1, 0, 1}
+	points := make([]Point, len(steps))
+	for i := range steps {
+		points[i] = Point{shift(s, steps[i], 0), values[i]}
+	}
+	want := s.clone()
+	for _, i := range []int{2, 1, 3, 7, 0, 5, 6, 4} {
+		if err := want.Add(points[i].T, points[i].V); err != nil {
+			t.Fatalf("got error %s, want error nil", err)
+		}
+	}
+	got := s.clone()
+	if err := got.AddBatch(points); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if !assertSequencesEqual(got, want) {
+		t.Fatalf("\ngot  %+v\nwant %+v", got, want)
+	}
+	if points[0].T != shift(s, 130, 0) {
+		t.Fatal("points should not be modified")
+	}
+	tests := []struct {
+		id    int
+		steps []int
+	}{
+		{1, []int{5, 2}},
+		{2, []int{5, 200}},
+		{3, []int{5, -1}},
+		{4, []int{5, 6, 5}},
+	}
+	for _, tt := range tests {
+		got := s.clone()
+		points := make([]Point, len(tt.steps))
+		for i := range tt.steps {
+			points[i] = Point{shift(s, tt.steps[i], 0), StateActive}
+		}
+		if err := got.AddBatch(points); err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", tt.id)
+		}
+		if !assertSequencesEqual(got, s) {
+			t.Fatalf("test %d: sequence should not be modified", tt.id)
+		}
+	}
+}
+
+func TestSequenceBackfill(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	tests := []struct {
+		id    int
+		shift int
+		value uint8
+		err   bool
+	}{
+		{1, 16, StateActive, false},
+		{2, 15, StateInactive, false},
+		{3, 18, StateActive, false},
+		{4, 17, StateUnknown, false},
+		{5, 16, StateInactive, true},
+		{6, 3, StateInactive, true},
+		{7, 20, StateActive, true},
+		{8, -1, StateActive, true},
+	}
+	want := append([]uint8{}, testValues...)
+	for _, tt := range tests {
+		err := s.Backfill(shift(s, tt.shift, 0), tt.value)
+		if err != nil {
+			if !tt.err {
+				t.Fatalf("test %d: got error %s, want error nil", tt.id, err)
+			}
+			continue
+		}
+		if tt.err {
+			t.Fatalf("test %d: got error nil, want non nil error", tt.id)
+		}
+		want[tt.shift] = tt.value
+		if got := s.All(); !assertValuesEqual(got, want) {
+			t.Fatalf("test %d:\ngot  %v\nwant %v", tt.id, got, want)
+		}
+		if s.count != uint32(len(testValues)) {
+			t.Fatalf("test %d: got %d, want %d", tt.id, s.count, len(testValues))
+		}
+	}
+	if err := s.Add(shift(s, 20, 0), StateActive); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if got := s.All(); !assertValuesEqual(got, append(want, StateActive)) {
+		t.Fatalf("\ngot  %v\nwant %v", got, append(want, StateActive))
+	}
+}
+
+func TestSequenceCompact(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	tests := []struct {
+		id    int
+		data  []byte
+		count uint32
+		want  []byte
+		saved int
+	}{
+		{1, []byte{}, 0, []byte{}, 0},
+		{2, []byte{0x15, 0x14}, 10, []byte{0x15, 0x14}, 0},
+		{3, []byte{0x09, 0x05, 0x0d}, 6, []byte{0x19}, 2},
+		{4, []byte{0x85, 0x00, 0x80, 0x80, 0x00}, 1, []byte{0x05}, 4},
+		{5, []byte{0xfd, 0x7f, 0x05, 0x10}, 4100, []byte{0x81, 0x80, 0x01, 0x10}, 0},
+	}
+	for _, tt := range tests {
+		s := &Sequence{x.Unix(), MaxSequenceLength, tt.count, testSequenceFrequency, tt.data, 0, 0}
+		want := s.All()
+		if saved := s.Compact(); saved != tt.saved {
+			t.Fatalf("test %d: got %d, want %d", tt.id, saved, tt.saved)
+		}
+		if !bytes.Equal(s.data, tt.want) {
+			t.Fatalf("test %d:\ngot  %v\nwant %v", tt.id, s.data, tt.want)
+		}
+		if got := s.All(); !assertValuesEqual(got, want) {
+			t.Fatalf("test %d:\ngot  %v\nwant %v", tt.id, got, want)
+		}
+	}
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	s.Backfill(shift(s, 15, 0), StateActive)
+	s.Backfill(shift(s, 16, 0), StateActive)
+	values := append([]uint8{}, testValues...)
+	values[15], values[16] = StateActive, StateActive
+	want := NewWithValues(x, testSequenceFrequency, values)
+	if saved := s.Compact(); saved != 2 {
+		t.Fatalf("got %d, want 2", saved)
+	}
+	if !assertSequencesEqual(s, want) {
+		t.Fatalf("\ngot  %+v\nwant %+v", s, want)
+	}
+}
+
+func TestSequenceInvert(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	s.AddRun(shift(s, 20, 0), 300, StateActive)
+	want := make([]uint8, 0, s.count)
+	for _, v := range s.All() {
+		if v < StateUnknown {
+			v ^= 1
+		}
+		want = append(want, v)
+	}
+	s.Invert()
+	if got := s.All(); !assertValuesEqual(got, want) {
+		t.Fatalf("\ngot  %v\nwant %v", got, want)
+	}
+	w, _ := NewWithWidth(x, testSequenceFrequency, 3)
+	for i, v := range []uint8{1, 0, 2, 7, 5} {
+		w.Add(shift(w, i, 0), v)
+	}
+	w.Invert()
+	if got, want := w.All(), []uint8{0, 1, 2, 7, 5}; !assertValuesEqual(got, want) {
+		t.Fatalf("\ngot  %v\nwant %v", got, want)
+	}
+}
+
+func TestSequenceSetMaxAge(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	if err := s.SetMaxAge(-time.Second); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if err := s.SetMaxAge(10*time.Minute + 30*time.Second); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if got, want := s.MaxAge(), 630*time.Second; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	want := NewWithValues(x.Add(9*time.Minute), testSequenceFrequency, testValues[9:])
+	want.maxAge = 630
+	if !assertSequencesEqual(s, want) {
+		t.Fatalf("\ngot  %+v\nwant %+v", s, want)
+	}
+	type result struct {
+		values    []uint8
+		timestamp int64
+	}
+	tests := []struct {
+		id   int
+		op   func(s *Sequence) error
+		want result
+	}{
+		{
+			1,
+			func(s *Sequence) error { return s.Add(shift(s, 12, 0), StateActive) },
+			result{[]uint8{1, 1, 1, 1, 2, 2, 2, 2, 0, 2, 1}, 11},
+		},
+		{
+			2,
+			func(s *Sequence) error { return s.Roll(shift(s, 13, 0), StateInactive) },
+			result{[]uint8{1, 1, 1, 2, 2, 2, 2, 0, 2, 2, 0}, 12},
+		},
+		{
+			3,
+			func(s *Sequence) error { return s.AddRun(shift(s, 11, 0), 3, StateInactive) },
+			result{[]uint8{1, 1, 1, 2, 2, 2, 2, 0, 0, 0, 0}, 12},
+		},
+		{
+			4,
+			func(s *Sequence) error { return s.AddRun(shift(s, 20, 0), 15, StateActive) },
+			result{[]uint8{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, 33},
+		},
+		{
+			5,
+			func(s *Sequence) error {
+				return s.AddBatch([]Point{{shift(s, 12, 0), StateInactive}, {shift(s, 30, 0), StateActive}})
+			},
+			result{[]uint8{2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1}, 29},
+		},
+	}
+	for _, tt := range tests {
+		s := want.clone()
+		if err := tt.op(s); err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", tt.id, err)
+		}
+		if got := s.All(); !assertValuesEqual(got, tt.want.values) {
+			t.Fatalf("test %d:\ngot  %v\nwant %v", tt.id, got, tt.want.values)
+		}
+		if got, want := s.ts, x.Unix()+tt.want.timestamp*int64(s.frequency); got != want {
+			t.Fatalf("test %d: got %d, want %d", tt.id, got, want)
+		}
+	}
+	got, err := FromBytes(s.Bytes())
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if !assertSequencesEqual(got, s) {
+		t.Fatalf("\ngot  %+v\nwant %+v", got, s)
+	}
+	s.SetMaxAge(0)
+	if err := s.Add(shift(s, 100, 0), StateActive); err != nil || s.count != 101 {
+		t.Fatalf("got (%d, %v), want (101, nil)", s.count, err)
+	}
+}
+
+func TestSequenceSizeBytes(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	s := New(x, testSequenceFrequency)
+	n := s.SizeBytes()
+	if n == 0 {
+		t.Fatal("got 0, want non zero size")
+	}
+	s.data = make([]byte, 5, 64)
+	if got := s.SizeBytes(); got != n+64 {
+		t.Fatalf("got %d, want %d", got, n+64)
+	}
+}
+
 func TestSequenceBytes(t *testing.T) {
 	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
 	s := &Sequence{
@@ -212,12 +655,71 @@ func TestSequenceBytes(t *testing.T) {
 		data:      []byte{0x15, 0x14, 0x15, 0x12, 0x4},
 	}
 	got := s.Bytes()
-	want := append(testSequenceBasePrefix, []byte{0x14, 0x0, 0x0, 0x0, 0x15, 0x14, 0x15, 0x12, 0x4}...)
+	want := append(testSequenceV1Prefix, []byte{0x14, 0x0, 0x0, 0x0, 0x15, 0x14, 0x15, 0x12, 0x4}...)
 	if !bytes.Equal(got, want) {
 		t.Errorf("\ngot  %v\nwant %v", got, want)
 	}
 }
 
+func TestSequenceAppendBytes(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	s.SetMaxAge(time.Hour)
+	want := append([]byte{0xff, 0xfe}, s.Bytes()...)
+	got := s.AppendBytes([]byte{0xff, 0xfe})
+	if !bytes.Equal(got, want) {
+		t.Fatalf("\ngot  %v\nwant %v", got, want)
+	}
+	buf := bytes.Repeat([]byte{0xff}, 64)
+	buf[1] = 0xfe
+	got = s.AppendBytes(buf[:2])
+	if !bytes.Equal(got, want) {
+		t.Fatalf("\ngot  %v\nwant %v", got, want)
+	}
+	s = New(x, testSequenceFrequency)
+	if got := s.AppendBytes(buf[:0]); !bytes.Equal(got, s.Bytes()) {
+		t.Fatalf("\ngot  %v\nwant %v", got, s.Bytes())
+	}
+	allocs := testing.AllocsPerRun(10, func() {
+		s.AppendBytes(buf[:0])
+	})
+	if allocs != 0 {
+		t.Fatalf("got %.0f allocations, want 0", allocs)
+	}
+}
+
+func TestSequenceEncodeString(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s1 := NewWithValues(x, 60, []uint8{0, 0, 1, 1, 1, 2, 0})
+	s2, _ := NewWithWidth(x, 300, 4)
+	s2.Add(x, 9)
+	s2.SetMaxAge(time.Hour)
+	for i, s := range []*Sequence{New(x, 60), s1, s2} {
+		v := s.EncodeString()
+		if !strings.HasPrefix(v, "rl1.") || strings.ContainsAny(v, "+/=\n ") {
+			t.Fatalf("test %d: got %q", i+1, v)
+		}
+		got, err := DecodeString(v)
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", i+1, err)
+		}
+		if !bytes.Equal(got.Bytes(), s.Bytes()) {
+			t.Fatalf("test %d: got %v, want %v", i+1, got.Bytes(), s.Bytes())
+		}
+	}
+	v := s1.EncodeString()
+	k := len(v) - 3
+	altered := v[:k] + string(v[k]^1) + v[k+1:]
+	if _, err := DecodeString(altered); err != ErrInvalidChecksum {
+		t.Fatalf("got error %v, want ErrInvalidChecksum", err)
+	}
+	for i, v := range []string{"", "rl2." + v[4:], v[4:], v + "*", "rl1."} {
+		if _, err := DecodeString(v); err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", i+1)
+		}
+	}
+}
+
 func TestSequenceAll(t *testing.T) {
 	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
 	s := NewWithValues(x, testSequenceFrequency, testValues)
@@ -235,9 +737,9 @@ func TestSequenceSetLength(t *testing.T) {
 		length uint32
 		want   *Sequence
 	}{
-		{1, 1440, &Sequence{x.Unix(), 1440, 20, testSequenceFrequency, []byte{0x15, 0x14, 0x15, 0x12, 0x4}}},
-		{2, 12, &Sequence{x.Unix(), 12, 12, testSequenceFrequency, []byte{0x15, 0x14, 0x9}}},
-		{3, 8, &Sequence{x.Unix(), 8, 8, testSequenceFrequency, []byte{0x15, 0xc}}},
+		{1, 1440, &Sequence{x.Unix(), 1440, 20, testSequenceFrequency, []byte{0x15, 0x14, 0x15, 0x12, 0x4}, 0, 0}},
+		{2, 12, &Sequence{x.Unix(), 12, 12, testSequenceFrequency, []byte{0x15, 0x14, 0x9}, 0, 0}},
+		{3, 8, &Sequence{x.Unix(), 8, 8, testSequenceFrequency, []byte{0x15, 0xc}, 0, 0}},
 	}
 	for _, tt := range tests {
 		got := &Sequence{
@@ -254,6 +756,42 @@ func TestSequenceSetLength(t *testing.T) {
 	}
 }
 
+func TestSequenceResize(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	if err := s.Resize(0); !errors.Is(err, ErrInvalidLength) {
+		t.Fatalf("got error %v, want %s", err, ErrInvalidLength)
+	}
+	s.ts = math.MaxInt64 - 100*int64(s.frequency)
+	if err := s.Resize(102); !errors.Is(err, ErrInvalidHorizon) {
+		t.Fatalf("got error %v, want %s", err, ErrInvalidHorizon)
+	}
+	if s.length != MaxSequenceLength || s.count != uint32(len(testValues)) {
+		t.Fatal("sequence should not be modified")
+	}
+	if err := s.Resize(101); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	s.SetLength(0)
+	if s.length != 101 {
+		t.Fatal("sequence should not be modified")
+	}
+}
+
+func TestNewWithLength(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	if _, err := NewWithLength(x, testSequenceFrequency, 0); !errors.Is(err, ErrInvalidLength) {
+		t.Fatalf("got error %v, want %s", err, ErrInvalidLength)
+	}
+	s, err := NewWithLength(x, testSequenceFrequency, 1440)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if s.Length() != 1440 {
+		t.Fatalf("got %d, want 1440", s.Length())
+	}
+}
+
 func TestSequenceRoll(t *testing.T) {
 	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
 	f := testSequenceFrequency
@@ -269,12 +807,12 @@ func TestSequenceRoll(t *testing.T) {
 		timestamp time.Time
 		want      *Sequence
 	}{
-		{1, shift(s, 134+1, 0), &Sequence{x.Unix(), 140, 136, f, []byte{0x15, 0x14, 0xf9, 0x3}}},
-		{2, shift(s, 134+5+7, 0), &Sequence{x.Unix() + 7*int64(f), 140, 140, f, []byte{0xc, 0xf5, 0x3, 0x2e, 0x5}}},
-		{3, shift(s, 134+5+10, 0), &Sequence{x.Unix() + 10*int64(f), 140, 140, f, []byte{0xf5, 0x3, 0x3a, 0x5}}},
-		{4, shift(s, 134+5+12, 0), &Sequence{x.Unix() + 12*int64(f), 140, 140, f, []byte{0xed, 0x3, 0x42, 0x5}}},
-		{5, shift(s, 134+5+130, 0), &Sequence{x.Unix() + 130*int64(f), 140, 140, f, []byte{0x15, 0x9a, 0x4, 0x5}}},
-		{6, shift(s, 134+5+4000, 0), &Sequence{x.Unix() + 4000*int64(f), 140, 140, f, []byte{0xae, 0x4, 0x5}}},
+		{1, shift(s, 134+1, 0), &Sequence{x.Unix(), 140, 136, f, []byte{0x15, 0x14, 0xf9, 0x3}, 0, 0}},
+		{2, shift(s, 134+5+7, 0), &Sequence{x.Unix() + 7*int64(f), 140, 140, f, []byte{0xc, 0xf5, 0x3, 0x2e, 0x5}, 0, 0}},
+		{3, shift(s, 134+5+10, 0), &Sequence{x.Unix() + 10*int64(f), 140, 140, f, []byte{0xf5, 0x3, 0x3a, 0x5}, 0, 0}},
+		{4, shift(s, 134+5+12, 0), &Sequence{x.Unix() + 12*int64(f), 140, 140, f, []byte{0xed, 0x3, 0x42, 0x5}, 0, 0}},
+		{5, shift(s, 134+5+130, 0), &Sequence{x.Unix() + 130*int64(f), 140, 140, f, []byte{0x15, 0x9a, 0x4, 0x5}, 0, 0}},
+		{6, shift(s, 134+5+4000, 0), &Sequence{x.Unix() + 4000*int64(f), 140, 140, f, []byte{0xae, 0x4, 0x5}, 0, 0}},
 	}
 	for _, tt := range tests {
 		got := s.clone()
@@ -288,6 +826,76 @@ func TestSequenceRoll(t *testing.T) {
 	}
 }
 
+func TestSequenceAlreadySet(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	want := s.clone()
+	tests := []struct {
+		id         int
+		op         func(s *Sequence) error
+		alreadySet bool
+	}{
+		{1, func(s *Sequence) error { return s.Add(shift(s, 5, 0), StateInactive) }, true},
+		{2, func(s *Sequence) error { return s.Add(shift(s, 5, 0), StateActive) }, false},
+		{3, func(s *Sequence) error { return s.AddRun(shift(s, 3, 0), 2, StateActive) }, true},
+		{4, func(s *Sequence) error { return s.AddRun(shift(s, 3, 0), 3, StateActive) }, false},
+		{5, func(s *Sequence) error { return s.AddRun(shift(s, 19, 0), 2, StateInactive) }, false},
+		{6, func(s *Sequence) error { return s.Roll(shift(s, 19, 0), StateInactive) }, true},
+		{7, func(s *Sequence) error { return s.Roll(shift(s, 16, 0), StateUnknown) }, true},
+		{8, func(s *Sequence) error { return s.Roll(shift(s, 16, 0), StateActive) }, false},
+	}
+	for _, tt := range tests {
+		err := tt.op(s)
+		if err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", tt.id)
+		}
+		if errors.Is(err, ErrAlreadySet) != tt.alreadySet {
+			t.Fatalf("test %d: got error %s, want ErrAlreadySet %t", tt.id, err, tt.alreadySet)
+		}
+		if !assertSequencesEqual(s, want) {
+			t.Fatalf("test %d:\ngot  %+v\nwant %+v", tt.id, s, want)
+		}
+	}
+}
+
+func TestSequenceRollReplace(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	s.SetLength(22)
+	tests := []struct {
+		id    int
+		shift int
+		value uint8
+		want  []uint8
+		err   bool
+	}{
+		{1, 19, StateActive, []uint8{1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 1}, false},
+		{2, 19, StateUnknown, []uint8{1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 2}, false},
+		{3, 18, StateActive, nil, true},
+		{4, 21, StateInactive, []uint8{1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 2, 2, 0}, false},
+		{5, 21, StateActive, []uint8{1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 2, 2, 1}, false},
+		{6, 22, StateActive, []uint8{1, 1, 1, 1, 0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 2, 2, 1, 1}, false},
+		{7, 22, StateInactive, []uint8{1, 1, 1, 1, 0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 2, 2, 1, 0}, false},
+	}
+	for _, tt := range tests {
+		err := s.RollReplace(x.Add(time.Duration(tt.shift)*time.Minute), tt.value)
+		if err != nil {
+			if !tt.err {
+				t.Fatalf("test %d: got error %s, want error nil", tt.id, err)
+			}
+			continue
+		}
+		if tt.err {
+			t.Fatalf("test %d: got error nil, want non nil error", tt.id)
+		}
+		want := NewWithValues(time.Unix(s.ts, 0), testSequenceFrequency, tt.want)
+		want.SetLength(22)
+		if !assertSequencesEqual(s, want) {
+			t.Fatalf("test %d:\ngot  %+v\nwant %+v", tt.id, s, want)
+		}
+	}
+}
+
 func TestSequenceTrimLeft(t *testing.T) {
 	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
 	f := testSequenceFrequency
@@ -303,15 +911,15 @@ func TestSequenceTrimLeft(t *testing.T) {
 		timestamp time.Time
 		want      *Sequence
 	}{
-		{1, shift(s, 7, 0), &Sequence{x.Unix() + 7*int64(f), 140, 128, f, []byte{0xc, 0xf5, 0x3}}},
-		{2, shift(s, 7, 1), &Sequence{x.Unix() + 8*int64(f), 140, 127, f, []byte{0x8, 0xf5, 0x3}}},
-		{3, shift(s, 10, 0), &Sequence{x.Unix() + 10*int64(f), 140, 125, f, []byte{0xf5, 0x3}}},
-		{4, shift(s, 10, 1), &Sequence{x.Unix() + 11*int64(f), 140, 124, f, []byte{0xf1, 0x3}}},
-		{5, shift(s, 12, 0), &Sequence{x.Unix() + 12*int64(f), 140, 123, f, []byte{0xed, 0x3}}},
-		{6, shift(s, 12, 1), &Sequence{x.Unix() + 13*int64(f), 140, 122, f, []byte{0xe9, 0x3}}},
-		{7, shift(s, 130, 0), &Sequence{x.Unix() + 130*int64(f), 140, 5, f, []byte{0x15}}},
-		{8, shift(s, 130, 1), &Sequence{x.Unix() + 131*int64(f), 140, 4, f, []byte{0x11}}},
-		{9, shift(s, 4000, 0), &Sequence{x.Unix() + 4000*int64(f), 140, 0, f, []byte{}}},
+		{1, shift(s, 7, 0), &Sequence{x.Unix() + 7*int64(f), 140, 128, f, []byte{0xc, 0xf5, 0x3}, 0, 0}},
+		{2, shift(s, 7, 1), &Sequence{x.Unix() + 8*int64(f), 140, 127, f, []byte{0x8, 0xf5, 0x3}, 0, 0}},
+		{3, shift(s, 10, 0), &Sequence{x.Unix() + 10*int64(f), 140, 125, f, []byte{0xf5, 0x3}, 0, 0}},
+		{4, shift(s, 10, 1), &Sequence{x.Unix() + 11*int64(f), 140, 124, f, []byte{0xf1, 0x3}, 0, 0}},
+		{5, shift(s, 12, 0), &Sequence{x.Unix() + 12*int64(f), 140, 123, f, []byte{0xed, 0x3}, 0, 0}},
+		{6, shift(s, 12, 1), &Sequence{x.Unix() + 13*int64(f), 140, 122, f, []byte{0xe9, 0x3}, 0, 0}},
+		{7, shift(s, 130, 0), &Sequence{x.Unix() + 130*int64(f), 140, 5, f, []byte{0x15}, 0, 0}},
+		{8, shift(s, 130, 1), &Sequence{x.Unix() + 131*int64(f), 140, 4, f, []byte{0x11}, 0, 0}},
+		{9, shift(s, 4000, 0), &Sequence{x.Unix() + 4000*int64(f), 140, 0, f, []byte{}, 0, 0}},
 	}
 	for _, tt := range tests {
 		got := s.clone()
@@ -329,6 +937,9 @@ func assertSequencesEqual(x, y *Sequence) bool {
 	if x.ts != y.ts || x.frequency != y.frequency || x.length != y.length || x.count != y.count {
 		return false
 	}
+	if x.bits() != y.bits() || x.maxAge != y.maxAge {
+		return false
+	}
 	if !bytes.Equal(x.data, y.data) {
 		return false
 	}
@@ -1,15 +1,28 @@
 package sequence
 
 import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"math"
 	"strconv"
 	"time"
 )
 
-// These flags define which values to include in a serialized output.
+// These flags define which values to include in a serialized output and how it
+// is laid out. By default, empty groups have a count of 0 and null values, which
+// can be changed using SerializeOmitEmpty, SerializeNulls or SerializeZeros. If
+// several of them are set, SerializeOmitEmpty takes precedence over
+// SerializeZeros, which takes precedence over SerializeNulls.
 const (
-	SerializeCount = 1 << iota // number of valid values in group
-	SerializeSum               // sum of values in group
-	SerializeMean              // mean value of group
+	SerializeCount     = 1 << iota // number of valid values in group
+	SerializeSum                   // sum of values in group
+	SerializeMean                  // mean value of group
+	SerializeNDJSON                // one object per line instead of an array
+	SerializePercent               // percentage of active values in group
+	SerializeOmitEmpty             // omit groups without any valid value
+	SerializeNulls                 // represent count of empty groups as null instead of 0
+	SerializeZeros                 // represent values of empty groups as 0 instead of null
 )
 
 const (
@@ -18,38 +31,153 @@ const (
 	serializerCountPrefix = `,"count":`
 	serializerSumPrefix   = `,"sum":`
 	serializerMeanPrefix  = `,"mean":`
+	serializerPctPrefix   = `,"percent":`
 	serializerRowSuffix   = "},"
+	serializerLineSuffix  = "}\n"
 	serializerBaseSuffix  = ']'
 )
 
-// serialize is a convenience function that returns a JSON encoding of the time series
-// using layout as time layout, loc as time location, n as precision level for
-// float values and flag to define which values to include in the serialized output.
-// As a special case, if layout is an empty string time values will be represented
-// as Unix times instead of textual representations. In that case, loc is not used.
-func serialize(q QuerySet, layout string, loc *time.Location, n int, flag int) []byte {
+// serializerChunkSize defines the size of the chunks written by SerializeTo.
+const serializerChunkSize = 32 << 10
+
+// A SerializeOptions defines how a QuerySet is serialized.
+type SerializeOptions struct {
+	// Layout specifies the time layout. As a special case, if Layout is
+	// an empty string time values are represented as Unix times.
+	Layout string
+
+	// Location specifies the time location, UTC if nil.
+	Location *time.Location
+
+	// Precision specifies the precision level of float values.
+	Precision int
+
+	// Count, Sum, Mean and Percent specify which values to include,
+	// see the corresponding Serialize flags.
+	Count, Sum, Mean, Percent bool
+
+	// NDJSON specifies whether to output one object per line instead of
+	// an array.
+	NDJSON bool
+
+	// Empty specifies how empty groups are rendered.
+	Empty EmptyGroups
+
+	// Metadata specifies whether to wrap the array of rows in an object
+	// also holding metadata: {"metadata":{...},"data":[...]}. Metadata
+	// are the time of the first group, the frequency as an ISO 8601
+	// duration, null for irregular groups, and the number of groups.
+	// It is ignored if NDJSON is true.
+	Metadata bool
+
+	// Extra, if not nil, is called for each serialized row and
+	// appends to dst additional comma separated key/value pairs
+	// such as "severity":"high". It may append nothing.
+	Extra func(dst []byte, r Row) []byte
+}
+
+// An EmptyGroups defines how groups without any valid value are serialized.
+type EmptyGroups int
+
+const (
+	EmptyDefault EmptyGroups = iota // count of 0 and null values
+	EmptyOmit                       // groups are omitted
+	EmptyNulls                      // count and values are null
+	EmptyZeros                      // count and values are 0
+)
+
+// RFC3339Options returns the options of a self-describing encoding, suitable for
+// public APIs: RFC 3339 UTC times, count and mean values with 4 decimal places
+// and metadata.
+func RFC3339Options() SerializeOptions {
+	return SerializeOptions{
+		Layout:    time.RFC3339,
+		Location:  time.UTC,
+		Precision: 4,
+		Count:     true,
+		Mean:      true,
+		Metadata:  true,
+	}
+}
+
+// serializeOptions converts the arguments of Serialize to a SerializeOptions.
+func serializeOptions(layout string, loc *time.Location, n int, flag int) SerializeOptions {
+	opts := SerializeOptions{
+		Layout:    layout,
+		Location:  loc,
+		Precision: n,
+		Count:     flag&SerializeCount != 0,
+		Sum:       flag&SerializeSum != 0,
+		Mean:      flag&SerializeMean != 0,
+		Percent:   flag&SerializePercent != 0,
+		NDJSON:    flag&SerializeNDJSON != 0,
+	}
+	switch {
+	case flag&SerializeOmitEmpty != 0:
+		opts.Empty = EmptyOmit
+	case flag&SerializeZeros != 0:
+		opts.Empty = EmptyZeros
+	case flag&SerializeNulls != 0:
+		opts.Empty = EmptyNulls
+	}
+	return opts
+}
+
+// serializeTo returns a JSON encoding of q according to opts. If w is not nil, the
+// encoding is written to w in chunks of about serializerChunkSize bytes instead of
+// being returned.
+func serializeTo(w io.Writer, q QuerySet, opts SerializeOptions) ([]byte, error) {
+	ndjson := opts.NDJSON
+	layout, loc, n := opts.Layout, opts.Location, opts.Precision
+	if loc == nil {
+		loc = time.UTC
+	}
+	var head, tail string
+	if opts.Metadata && !ndjson {
+		head, tail = serializerMetadata(q, layout, loc), "}"
+	}
 	if len(q.Count) == 0 {
-		return []byte("[]")
+		empty := head + "[]" + tail
+		if ndjson {
+			empty = ""
+		}
+		if w != nil {
+			_, err := io.WriteString(w, empty)
+			return nil, err
+		}
+		return []byte(empty), nil
+	}
+	rowSuffix := serializerRowSuffix
+	if ndjson {
+		rowSuffix = serializerLineSuffix
 	}
-	var count, sum, mean bool
+	count, sum, mean, percent := opts.Count, opts.Sum, opts.Mean, opts.Percent
 	var rowNull string
+	omitEmpty := opts.Empty == EmptyOmit
+	nullCount, nullInt, nullFloat := "0", "null", "null"
+	switch opts.Empty {
+	case EmptyZeros:
+		nullInt, nullFloat = "0", strconv.FormatFloat(0, 'f', n, 64)
+	case EmptyNulls:
+		nullCount = "null"
+	}
 	approxRowSize := 10
-	if flag&SerializeCount != 0 {
-		rowNull += serializerCountPrefix + "0"
+	if count {
+		rowNull += serializerCountPrefix + nullCount
 		approxRowSize += 14
-		count = true
 	}
-	if flag&SerializeSum != 0 {
-		rowNull += serializerSumPrefix + "null"
+	if sum {
+		rowNull += serializerSumPrefix + nullInt
 		approxRowSize += 12
-		sum = true
 	}
-	if flag&SerializeMean != 0 {
-		rowNull += serializerMeanPrefix + "null"
+	if mean {
+		rowNull += serializerMeanPrefix + nullFloat
 		approxRowSize += 10 + n
-		mean = true
 	}
-	rowNull += serializerRowSuffix
+	if percent {
+		rowNull += serializerPctPrefix + nullFloat
+		approxRowSize += 14 + n
+	}
 	var formattedTime bool
 	var t time.Time
 	var ts int64
@@ -62,11 +190,40 @@ func serialize(q QuerySet, layout string, loc *time.Location, n int, flag int) [
 		ts = q.Timestamp
 		approxRowSize += 10
 	}
-	buf := make([]byte, 0, 2+len(q.Count)*approxRowSize)
-	buf = append(buf, serializerBasePrefix)
+	size := 2 + len(head) + len(tail) + len(q.Count)*approxRowSize
+	if w != nil && size > serializerChunkSize+approxRowSize {
+		size = serializerChunkSize + approxRowSize
+	}
+	buf := make([]byte, 0, size)
+	if !ndjson {
+		buf = append(buf, head...)
+		buf = append(buf, serializerBasePrefix)
+	}
 	for i := 0; i < len(q.Count); i++ {
+		if q.Timestamps != nil {
+			ts = q.Timestamps[i]
+			if formattedTime {
+				t = time.Unix(ts, 0).In(loc)
+			}
+		}
+		if omitEmpty && q.Count[i] == 0 {
+			if formattedTime {
+				t = t.Add(time.Duration(q.Frequency) * time.Second)
+			} else {
+				ts += q.Frequency
+			}
+			continue
+		}
+		if w != nil && len(buf) >= serializerChunkSize {
+			if _, err := w.Write(buf); err != nil {
+				return nil, err
+			}
+			buf = buf[:0]
+		}
 		buf = append(buf, serializerRowPrefix...)
+		rowTs := ts
 		if formattedTime {
+			rowTs = t.Unix()
 			buf = append(buf, t.Format(layout)...)
 			t = t.Add(time.Duration(q.Frequency) * time.Second)
 		} else {
@@ -88,11 +245,37 @@ func serialize(q QuerySet, layout string, loc *time.Location, n int, flag int) [
 				buf = append(buf, serializerMeanPrefix...)
 				buf = strconv.AppendFloat(buf, float64(q.Sum[i])/float64(q.Count[i]), 'f', n, 64)
 			}
-			buf = append(buf, serializerRowSuffix...)
+			if percent {
+				buf = append(buf, serializerPctPrefix...)
+				buf = strconv.AppendFloat(buf, 100*float64(q.Sum[i])/float64(q.Count[i]), 'f', n, 64)
+			}
 		}
+		if opts.Extra != nil {
+			r := Row{Timestamp: rowTs, Sum: q.Sum[i], Count: q.Count[i], Mean: math.NaN()}
+			if r.Count != 0 {
+				r.Mean = float64(r.Sum) / float64(r.Count)
+			}
+			buf = append(buf, ',')
+			k := len(buf)
+			if buf = opts.Extra(buf, r); len(buf) == k {
+				buf = buf[:k-1]
+			}
+		}
+		buf = append(buf, rowSuffix...)
 	}
-	buf[len(buf)-1] = serializerBaseSuffix
-	return buf
+	if !ndjson {
+		if buf[len(buf)-1] == serializerBasePrefix {
+			buf = append(buf, serializerBaseSuffix)
+		} else {
+			buf[len(buf)-1] = serializerBaseSuffix
+		}
+		buf = append(buf, tail...)
+	}
+	if w != nil {
+		_, err := w.Write(buf)
+		return nil, err
+	}
+	return buf, nil
 }
 
 // Serialize is a convenience method that returns a JSON encoding of the time series
@@ -101,5 +284,151 @@ func serialize(q QuerySet, layout string, loc *time.Location, n int, flag int) [
 // As a special case, if layout is an empty string time values will be represented
 // as Unix times instead of textual representations. In that case, loc is not used.
 func (q QuerySet) Serialize(layout string, loc *time.Location, n int, flag int) []byte {
-	return serialize(q, layout, loc, n, flag)
+	return q.SerializeWith(serializeOptions(layout, loc, n, flag))
+}
+
+// SerializeTo works like Serialize but writes the encoding to w incrementally
+// instead of building it entirely in memory. It returns the first error returned
+// by w.
+func (q QuerySet) SerializeTo(w io.Writer, layout string, loc *time.Location, n int, flag int) error {
+	return q.SerializeWithTo(w, serializeOptions(layout, loc, n, flag))
+}
+
+// SerializeWith returns a JSON encoding of the time series according to opts.
+func (q QuerySet) SerializeWith(opts SerializeOptions) []byte {
+	buf, _ := serializeTo(nil, q, opts)
+	return buf
+}
+
+// SerializeWithTo works like SerializeWith but writes the encoding to w
+// incrementally, see SerializeTo.
+func (q QuerySet) SerializeWithTo(w io.Writer, opts SerializeOptions) error {
+	_, err := serializeTo(w, q, opts)
+	return err
+}
+
+// A ValuesOptions defines how raw values are serialized.
+type ValuesOptions struct {
+	// Layout specifies the time layout. As a special case, if Layout is
+	// an empty string time values are represented as Unix times.
+	Layout string
+
+	// Location specifies the time location, UTC if nil.
+	Location *time.Location
+
+	// CSV specifies whether to output CSV records with a header instead
+	// of JSON.
+	CSV bool
+
+	// Labels specifies whether to represent StateInactive, StateActive
+	// and StateUnknown as "down", "up" and "unknown". Other values are
+	// represented as strings holding their number.
+	Labels bool
+}
+
+// stateLabels holds the labels used by SerializeValues.
+var stateLabels = [...]string{StateInactive: "down", StateActive: "up", StateUnknown: "unknown"}
+
+// serializerMetadata returns the beginning of an encoding holding metadata, up to
+// the array of rows.
+func serializerMetadata(q QuerySet, layout string, loc *time.Location) string {
+	start := q.Timestamp
+	if len(q.Timestamps) > 0 {
+		start = q.Timestamps[0]
+	}
+	buf := []byte(`{"metadata":{"start":`)
+	if layout == "" {
+		buf = strconv.AppendInt(buf, start, 10)
+	} else {
+		buf = strconv.AppendQuote(buf, time.Unix(start, 0).In(loc).Format(layout))
+	}
+	buf = append(buf, `,"frequency":`...)
+	if q.Timestamps != nil || q.Frequency <= 0 {
+		buf = append(buf, "null"...)
+	} else {
+		buf = strconv.AppendQuote(buf, isoDuration(q.Frequency))
+	}
+	buf = append(buf, `,"groups":`...)
+	buf = strconv.AppendInt(buf, int64(len(q.Count)), 10)
+	return string(append(buf, `},"data":`...))
+}
+
+// isoDuration returns the ISO 8601 representation of a duration of n seconds,
+// days being 24 hours long, for instance PT5M or P1DT12H.
+func isoDuration(n int64) string {
+	if n == 0 {
+		return "PT0S"
+	}
+	b := []byte{'P'}
+	if d := n / 86400; d > 0 {
+		b = append(strconv.AppendInt(b, d, 10), 'D')
+	}
+	if n%86400 != 0 {
+		b = append(b, 'T')
+	}
+	if h := n % 86400 / 3600; h > 0 {
+		b = append(strconv.AppendInt(b, h, 10), 'H')
+	}
+	if m := n % 3600 / 60; m > 0 {
+		b = append(strconv.AppendInt(b, m, 10), 'M')
+	}
+	if s := n % 60; s > 0 {
+		b = append(strconv.AppendInt(b, s, 10), 'S')
+	}
+	return string(b)
+}
+
+// SerializeValues returns an encoding of values, as returned by Sequence.Values()
+// or Sequence.All(), ts being the Unix time of the first value and f the frequency
+// of the sequence. Each value is encoded as a (date, state) pair, JSON objects
+// having the form {"date":...,"state":...}.
+func SerializeValues(values []uint8, ts int64, f uint16, opts ValuesOptions) []byte {
+	loc := opts.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	date := func(i int) string {
+		t := ts + int64(i)*int64(f)
+		if opts.Layout == "" {
+			return strconv.FormatInt(t, 10)
+		}
+		return time.Unix(t, 0).In(loc).Format(opts.Layout)
+	}
+	state := func(v uint8) string {
+		if opts.Labels && int(v) < len(stateLabels) {
+			return stateLabels[v]
+		}
+		return strconv.Itoa(int(v))
+	}
+	if opts.CSV {
+		var b bytes.Buffer
+		w := csv.NewWriter(&b)
+		w.Write([]string{"date", "state"})
+		for i, v := range values {
+			w.Write([]string{date(i), state(v)})
+		}
+		w.Flush()
+		return b.Bytes()
+	}
+	buf := make([]byte, 0, 2+len(values)*32)
+	buf = append(buf, serializerBasePrefix)
+	for i, v := range values {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, serializerRowPrefix...)
+		if opts.Layout == "" {
+			buf = append(buf, date(i)...)
+		} else {
+			buf = strconv.AppendQuote(buf, date(i))
+		}
+		buf = append(buf, `,"state":`...)
+		if opts.Labels {
+			buf = strconv.AppendQuote(buf, state(v))
+		} else {
+			buf = strconv.AppendUint(buf, uint64(v), 10)
+		}
+		buf = append(buf, '}')
+	}
+	return append(buf, serializerBaseSuffix)
 }
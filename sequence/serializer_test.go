@@ -2,6 +2,8 @@ package sequence
 
 import (
 	"bytes"
+	"errors"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -9,7 +11,7 @@ import (
 
 func TestSerialize(t *testing.T) {
 	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
-	q := QuerySet{x.Unix(), 300, []int64{5, 0, 1}, []int64{5, 0, 4}}
+	q := QuerySet{x.Unix(), 300, []int64{5, 0, 1}, []int64{5, 0, 4}, nil, nil, nil, nil}
 	tests := []struct {
 		id        int
 		layout    string
@@ -56,6 +58,30 @@ func TestSerialize(t *testing.T) {
 				"]",
 			},
 		},
+		{
+			4,
+			"15:04",
+			1,
+			SerializeMean | SerializePercent,
+			[]string{
+				"[",
+				`{"date":"03:04","mean":1.0,"percent":100.0},`,
+				`{"date":"03:09","mean":null,"percent":null},`,
+				`{"date":"03:14","mean":0.2,"percent":25.0}`,
+				"]",
+			},
+		},
+		{
+			5,
+			"",
+			2,
+			SerializeCount | SerializeMean | SerializeNDJSON,
+			[]string{
+				`{"date":946782245,"count":5,"mean":1.00}` + "\n",
+				`{"date":946782545,"count":0,"mean":null}` + "\n",
+				`{"date":946782845,"count":4,"mean":0.25}` + "\n",
+			},
+		},
 	}
 	for _, tt := range tests {
 		got := q.Serialize(tt.layout, time.UTC, tt.precision, tt.flag)
@@ -64,3 +90,324 @@ func TestSerialize(t *testing.T) {
 		}
 	}
 }
+
+func TestSerializeEmptyGroups(t *testing.T) {
+	q := QuerySet{946782245, 300, []int64{5, 0, 1}, []int64{5, 0, 4}, nil, nil, nil, nil}
+	tests := []struct {
+		q    QuerySet
+		flag int
+		want string
+	}{
+		{
+			q,
+			SerializeCount | SerializeSum | SerializeMean | SerializeOmitEmpty,
+			`[{"date":946782245,"count":5,"sum":5,"mean":1.00},{"date":946782845,"count":4,"sum":1,"mean":0.25}]`,
+		},
+		{
+			q,
+			SerializeCount | SerializeMean | SerializeOmitEmpty | SerializeNDJSON,
+			`{"date":946782245,"count":5,"mean":1.00}` + "\n" + `{"date":946782845,"count":4,"mean":0.25}` + "\n",
+		},
+		{
+			q,
+			SerializeCount | SerializeSum | SerializeMean | SerializeNulls,
+			`[{"date":946782245,"count":5,"sum":5,"mean":1.00},{"date":946782545,"count":null,"sum":null,"mean":null},{"date":946782845,"count":4,"sum":1,"mean":0.25}]`,
+		},
+		{
+			q,
+			SerializeCount | SerializeSum | SerializeMean | SerializePercent | SerializeZeros,
+			`[{"date":946782245,"count":5,"sum":5,"mean":1.00,"percent":100.00},{"date":946782545,"count":0,"sum":0,"mean":0.00,"percent":0.00},{"date":946782845,"count":4,"sum":1,"mean":0.25,"percent":25.00}]`,
+		},
+		{
+			q,
+			SerializeCount | SerializeMean | SerializeNulls | SerializeZeros,
+			`[{"date":946782245,"count":5,"mean":1.00},{"date":946782545,"count":0,"mean":0.00},{"date":946782845,"count":4,"mean":0.25}]`,
+		},
+		{
+			QuerySet{946782245, 300, []int64{0, 0}, []int64{0, 0}, nil, nil, nil, nil},
+			SerializeCount | SerializeOmitEmpty,
+			`[]`,
+		},
+		{
+			QuerySet{946782245, 300, []int64{0, 0}, []int64{0, 0}, nil, nil, nil, nil},
+			SerializeCount | SerializeOmitEmpty | SerializeNDJSON,
+			``,
+		},
+	}
+	for i, tt := range tests {
+		if got := tt.q.Serialize("", time.UTC, 2, tt.flag); string(got) != tt.want {
+			t.Fatalf("test %d:\ngot  %s\nwant %s", i+1, got, tt.want)
+		}
+	}
+	n := 5000
+	q = QuerySet{Timestamp: 946782245, Frequency: 60, Sum: make([]int64, n), Count: make([]int64, n)}
+	for i := 0; i < n/2; i++ {
+		q.Sum[i], q.Count[i] = 1, 1
+	}
+	flag := SerializeCount | SerializeOmitEmpty
+	var w chunkWriter
+	if err := q.SerializeTo(&w, "2006-01-02 15:04:05", time.UTC, 2, flag); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if want := q.Serialize("2006-01-02 15:04:05", time.UTC, 2, flag); !bytes.Equal(w.Bytes(), want) {
+		t.Fatal("output differs from Serialize")
+	}
+}
+
+func TestSerializeWith(t *testing.T) {
+	q := QuerySet{946782245, 300, []int64{5, 0, 1}, []int64{5, 0, 4}, nil, nil, nil, nil}
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		paris = time.FixedZone("CET", 3600)
+	}
+	tests := []struct {
+		layout    string
+		loc       *time.Location
+		precision int
+		flag      int
+		opts      SerializeOptions
+	}{
+		{
+			"2006-01-02 15:04",
+			paris,
+			2,
+			SerializeCount | SerializeMean,
+			SerializeOptions{Layout: "2006-01-02 15:04", Location: paris, Precision: 2, Count: true, Mean: true},
+		},
+		{
+			"",
+			nil,
+			1,
+			SerializeSum | SerializePercent | SerializeNDJSON | SerializeZeros,
+			SerializeOptions{Precision: 1, Sum: true, Percent: true, NDJSON: true, Empty: EmptyZeros},
+		},
+		{
+			"15:04",
+			time.UTC,
+			0,
+			SerializeCount | SerializeOmitEmpty | SerializeNulls,
+			SerializeOptions{Layout: "15:04", Count: true, Empty: EmptyOmit},
+		},
+		{
+			"",
+			nil,
+			3,
+			SerializeCount | SerializeSum | SerializeNulls,
+			SerializeOptions{Precision: 3, Count: true, Sum: true, Empty: EmptyNulls},
+		},
+	}
+	for i, tt := range tests {
+		want := q.Serialize(tt.layout, tt.loc, tt.precision, tt.flag)
+		if got := q.SerializeWith(tt.opts); !bytes.Equal(got, want) {
+			t.Fatalf("test %d:\ngot  %s\nwant %s", i+1, got, want)
+		}
+		var w bytes.Buffer
+		if err := q.SerializeWithTo(&w, tt.opts); err != nil || !bytes.Equal(w.Bytes(), want) {
+			t.Fatalf("test %d:\ngot  %s (error %v)\nwant %s", i+1, w.Bytes(), err, want)
+		}
+	}
+}
+
+func TestSerializeExtra(t *testing.T) {
+	q := QuerySet{946782245, 300, []int64{5, 0, 1}, []int64{5, 0, 4}, nil, nil, nil, nil}
+	extra := func(dst []byte, r Row) []byte {
+		if r.Count == 0 {
+			return dst
+		}
+		dst = append(dst, `"ts":`...)
+		dst = strconv.AppendInt(dst, r.Timestamp, 10)
+		dst = append(dst, `,"breach":`...)
+		return strconv.AppendBool(dst, r.Mean < 0.9)
+	}
+	tests := []struct {
+		opts SerializeOptions
+		want string
+	}{
+		{
+			SerializeOptions{Count: true, Extra: extra},
+			`[{"date":946782245,"count":5,"ts":946782245,"breach":false},{"date":946782545,"count":0},{"date":946782845,"count":4,"ts":946782845,"breach":true}]`,
+		},
+		{
+			SerializeOptions{Layout: "15:04", Mean: true, Precision: 1, NDJSON: true, Extra: extra},
+			`{"date":"03:04","mean":1.0,"ts":946782245,"breach":false}` + "\n" +
+				`{"date":"03:09","mean":null}` + "\n" +
+				`{"date":"03:14","mean":0.2,"ts":946782845,"breach":true}` + "\n",
+		},
+	}
+	for i, tt := range tests {
+		if got := q.SerializeWith(tt.opts); string(got) != tt.want {
+			t.Fatalf("test %d:\ngot  %s\nwant %s", i+1, got, tt.want)
+		}
+	}
+}
+
+func TestSerializeMetadata(t *testing.T) {
+	q := QuerySet{946782245, 300, []int64{5, 0, 1}, []int64{5, 0, 4}, nil, nil, nil, nil}
+	tests := []struct {
+		q    QuerySet
+		opts SerializeOptions
+		want string
+	}{
+		{
+			q,
+			RFC3339Options(),
+			`{"metadata":{"start":"2000-01-02T03:04:05Z","frequency":"PT5M","groups":3},"data":[` +
+				`{"date":"2000-01-02T03:04:05Z","count":5,"mean":1.0000},` +
+				`{"date":"2000-01-02T03:09:05Z","count":0,"mean":null},` +
+				`{"date":"2000-01-02T03:14:05Z","count":4,"mean":0.2500}]}`,
+		},
+		{
+			QuerySet{946782245, 5400, []int64{}, []int64{}, nil, nil, nil, nil},
+			SerializeOptions{Count: true, Metadata: true},
+			`{"metadata":{"start":946782245,"frequency":"PT1H30M","groups":0},"data":[]}`,
+		},
+		{
+			QuerySet{946684800, 0, []int64{5}, []int64{5}, []int64{946684800}, nil, nil, nil},
+			SerializeOptions{Count: true, Metadata: true},
+			`{"metadata":{"start":946684800,"frequency":null,"groups":1},"data":[{"date":946684800,"count":5}]}`,
+		},
+		{
+			q,
+			SerializeOptions{Count: true, Metadata: true, NDJSON: true, Empty: EmptyOmit},
+			`{"date":946782245,"count":5}` + "\n" + `{"date":946782845,"count":4}` + "\n",
+		},
+	}
+	for i, tt := range tests {
+		if got := tt.q.SerializeWith(tt.opts); string(got) != tt.want {
+			t.Fatalf("test %d:\ngot  %s\nwant %s", i+1, got, tt.want)
+		}
+	}
+	durations := []struct {
+		n    int64
+		want string
+	}{
+		{0, "PT0S"},
+		{45, "PT45S"},
+		{300, "PT5M"},
+		{3600, "PT1H"},
+		{3661, "PT1H1M1S"},
+		{86400, "P1D"},
+		{129600, "P1DT12H"},
+	}
+	for i, tt := range durations {
+		if got := isoDuration(tt.n); got != tt.want {
+			t.Fatalf("test %d: got %s, want %s", i+1, got, tt.want)
+		}
+	}
+}
+
+func TestSerializeValues(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, 60, []uint8{1, 0, 2})
+	tests := []struct {
+		values []uint8
+		opts   ValuesOptions
+		want   string
+	}{
+		{
+			s.All(),
+			ValuesOptions{},
+			`[{"date":946782245,"state":1},{"date":946782305,"state":0},{"date":946782365,"state":2}]`,
+		},
+		{
+			s.All(),
+			ValuesOptions{Layout: "15:04:05", Labels: true},
+			`[{"date":"03:04:05","state":"up"},{"date":"03:05:05","state":"down"},{"date":"03:06:05","state":"unknown"}]`,
+		},
+		{
+			[]uint8{3, 1},
+			ValuesOptions{Labels: true},
+			`[{"date":946782245,"state":"3"},{"date":946782305,"state":"up"}]`,
+		},
+		{
+			s.All(),
+			ValuesOptions{CSV: true},
+			"date,state\n946782245,1\n946782305,0\n946782365,2\n",
+		},
+		{
+			[]uint8{1, 2},
+			ValuesOptions{Layout: "Jan 2, 15:04", CSV: true, Labels: true},
+			"date,state\n\"Jan 2, 03:04\",up\n\"Jan 2, 03:05\",unknown\n",
+		},
+		{
+			[]uint8{},
+			ValuesOptions{},
+			`[]`,
+		},
+		{
+			[]uint8{},
+			ValuesOptions{CSV: true},
+			"date,state\n",
+		},
+	}
+	for i, tt := range tests {
+		if got := SerializeValues(tt.values, s.Timestamp(), s.Frequency(), tt.opts); string(got) != tt.want {
+			t.Fatalf("test %d:\ngot  %s\nwant %s", i+1, got, tt.want)
+		}
+	}
+}
+
+func TestSerializeTimestamps(t *testing.T) {
+	q := QuerySet{946684800, 0, []int64{5, 0}, []int64{5, 0}, []int64{946684800, 949363200}, nil, nil, nil}
+	want := `[{"date":"2000-01","count":5},{"date":"2000-02","count":0}]`
+	if got := q.Serialize("2006-01", time.UTC, 0, SerializeCount); string(got) != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	want = `[{"date":946684800,"sum":5},{"date":949363200,"sum":null}]`
+	if got := q.Serialize("", time.UTC, 0, SerializeSum); string(got) != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestSerializeTo(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	n := 5000
+	q := QuerySet{Timestamp: x.Unix(), Frequency: 60, Sum: make([]int64, n), Count: make([]int64, n)}
+	for i := 0; i < n; i++ {
+		q.Sum[i], q.Count[i] = int64(i%3), int64(i%5)
+	}
+	flag := SerializeCount | SerializeSum | SerializeMean
+	for _, q := range []QuerySet{q, {}} {
+		for _, layout := range []string{"", "2006-01-02 15:04:05"} {
+			var w chunkWriter
+			if err := q.SerializeTo(&w, layout, time.UTC, 2, flag); err != nil {
+				t.Fatalf("got error %s, want error nil", err)
+			}
+			if want := q.Serialize(layout, time.UTC, 2, flag); !bytes.Equal(w.Bytes(), want) {
+				t.Fatalf("layout %q: output differs from Serialize", layout)
+			}
+			if len(q.Count) > 0 && w.writes < 2 {
+				t.Fatalf("layout %q: got %d writes, want more than 1", layout, w.writes)
+			}
+		}
+	}
+	var w bytes.Buffer
+	if err := q.SerializeTo(&w, "", time.UTC, 2, flag|SerializeNDJSON); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if lines := bytes.Count(w.Bytes(), []byte("\n")); lines != n {
+		t.Fatalf("got %d lines, want %d", lines, n)
+	}
+	if got := (QuerySet{}).Serialize("", time.UTC, 2, SerializeNDJSON); len(got) != 0 {
+		t.Fatalf("got %q, want empty output", got)
+	}
+	if err := q.SerializeTo(failingWriter{}, "", time.UTC, 2, SerializeCount); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+type chunkWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
@@ -0,0 +1,130 @@
+package sequence
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// An SLAReport holds the result of an SLA computation.
+type SLAReport struct {
+	// Uptime specifies the percentage of active values,
+	// NaN if there is no value to account for.
+	Uptime float64
+
+	// Downtime specifies the time spent in StateInactive, as well
+	// as in StateUnknown under the UnknownDown policy.
+	Downtime time.Duration
+
+	// Excluded specifies the time covered by stored values
+	// falling into excluded periods.
+	Excluded time.Duration
+
+	// Budget specifies the downtime that can still occur
+	// without breaching the target, negative if the target
+	// is breached.
+	Budget time.Duration
+
+	// Breached reports whether the uptime is below the
+	// target.
+	Breached bool
+}
+
+// SLA computes an SLA report on the values stored in s whose timestamps are in
+// window, ignoring those in one of the excluded periods such as maintenance
+// windows. StateUnknown values are accounted for according to p, other values
+// than StateActive and StateInactive are ignored. The uptime is compared to
+// target, a percentage. It returns an error if window is empty, if target is not
+// between 0 and 100 or if p is not a valid policy.
+func (s *Sequence) SLA(window Period, excluded []Period, target float64, p UnknownPolicy) (SLAReport, error) {
+	if window.End <= window.Start || !(target >= 0 && target <= 100) {
+		return SLAReport{}, errors.New("invalid arguments")
+	}
+	if p >= unknownPolicyInvalid {
+		return SLAReport{}, errors.New("invalid unknown policy")
+	}
+
+	f := int64(s.frequency)
+	ranges := s.excludedOffsets(excluded)
+
+	var up, down, skipped int64
+	if x, y, ok := s.offsets(time.Unix(window.Start, 0), time.Unix(window.End-1, 0)); ok {
+		s.walk(x, y, func(j, n int64, v uint8) bool {
+			end := j + n
+			for _, r := range ranges {
+				a, b := r[0], r[1]
+				if a < j {
+					a = j
+				}
+				if b > end {
+					b = end
+				}
+				if a < b {
+					n -= b - a
+					skipped += b - a
+				}
+			}
+			if v == StateUnknown {
+				switch p {
+				case UnknownDown:
+					v = StateInactive
+				case UnknownUp:
+					v = StateActive
+				}
+			}
+			switch v {
+			case StateActive:
+				up += n
+			case StateInactive:
+				down += n
+			}
+			return true
+		})
+	}
+
+	r := SLAReport{
+		Uptime:   math.NaN(),
+		Downtime: time.Duration(down*f) * time.Second,
+		Excluded: time.Duration(skipped*f) * time.Second,
+	}
+	total := up + down
+	if total > 0 {
+		r.Uptime = 100 * float64(up) / float64(total)
+		r.Breached = r.Uptime < target
+	}
+	allowed := (1 - target/100) * float64(total*f) * float64(time.Second)
+	r.Budget = time.Duration(allowed) - r.Downtime
+	return r, nil
+}
+
+// excludedOffsets returns the half-open intervals of offsets of s whose
+// timestamps are in one of periods, sorted and without overlaps.
+func (s *Sequence) excludedOffsets(periods []Period) [][2]int64 {
+	f := int64(s.frequency)
+	ranges := make([][2]int64, 0, len(periods))
+	for _, p := range periods {
+		if p.End <= s.ts || p.End <= p.Start {
+			continue
+		}
+		if p.Start < s.ts {
+			p.Start = s.ts
+		}
+		a, b := ceilInt64(p.Start-s.ts, f)/f, ceilInt64(p.End-s.ts, f)/f
+		if a < b {
+			ranges = append(ranges, [2]int64{a, b})
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if k := len(merged) - 1; k >= 0 && r[0] <= merged[k][1] {
+			if r[1] > merged[k][1] {
+				merged[k][1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
@@ -0,0 +1,56 @@
+package sequence
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSequenceSLA(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	f := int64(s.frequency)
+	window := Period{s.ts, s.ts + 20*f}
+	tests := []struct {
+		id       int
+		window   Period
+		excluded []Period
+		target   float64
+		policy   UnknownPolicy
+		want     SLAReport
+	}{
+		{1, window, nil, 50, UnknownExcluded, SLAReport{62.5, 360 * time.Second, 0, 120 * time.Second, false}},
+		{2, window, nil, 75, UnknownExcluded, SLAReport{62.5, 360 * time.Second, 0, -120 * time.Second, true}},
+		{3, window, []Period{{s.ts + 5*f, s.ts + 10*f}}, 50, UnknownExcluded, SLAReport{100 * 10.0 / 11, 60 * time.Second, 300 * time.Second, 270 * time.Second, false}},
+		{4, window, []Period{{s.ts + 4*f + 30, s.ts + 8*f}, {s.ts + 6*f, s.ts + 12*f}}, 50, UnknownUp, SLAReport{100 * 12.0 / 13, 60 * time.Second, 420 * time.Second, 330 * time.Second, false}},
+		{5, window, []Period{{s.ts - 10*f, s.ts + 20*f}}, 50, UnknownDown, SLAReport{math.NaN(), 0, 1200 * time.Second, 0, false}},
+		{6, Period{s.ts + 3*f + 1, s.ts + 6*f + 1}, nil, 0, UnknownDown, SLAReport{100.0 / 3, 120 * time.Second, 0, 60 * time.Second, false}},
+		{7, Period{s.ts + 30*f, s.ts + 40*f}, nil, 50, UnknownExcluded, SLAReport{math.NaN(), 0, 0, 0, false}},
+	}
+	for _, tt := range tests {
+		got, err := s.SLA(tt.window, tt.excluded, tt.target, tt.policy)
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", tt.id, err)
+		}
+		uptime := got.Uptime == tt.want.Uptime || math.IsNaN(got.Uptime) && math.IsNaN(tt.want.Uptime)
+		if !uptime || got.Downtime != tt.want.Downtime || got.Excluded != tt.want.Excluded || got.Budget != tt.want.Budget || got.Breached != tt.want.Breached {
+			t.Fatalf("test %d:\ngot  %+v\nwant %+v", tt.id, got, tt.want)
+		}
+	}
+	tests2 := []struct {
+		window Period
+		target float64
+		policy UnknownPolicy
+	}{
+		{Period{s.ts, s.ts}, 50, UnknownExcluded},
+		{window, -1, UnknownExcluded},
+		{window, 101, UnknownExcluded},
+		{window, math.NaN(), UnknownExcluded},
+		{window, 50, unknownPolicyInvalid},
+	}
+	for i, tt := range tests2 {
+		if _, err := s.SLA(tt.window, nil, tt.target, tt.policy); err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", i+1)
+		}
+	}
+}
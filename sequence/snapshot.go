@@ -0,0 +1,259 @@
+package sequence
+
+import (
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// A Snapshotter periodically dumps a store in the background. A Snapshotter
+// can be used simultaneously from multiple goroutines.
+type Snapshotter struct {
+	// OnError, if not nil, is called from the background goroutine
+	// with the error of each failed snapshot.
+	OnError func(error)
+
+	write    func() error
+	interval time.Duration
+	mu       sync.Mutex // serializes snapshots
+	state    sync.Mutex // guards stop and done
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewSnapshotter returns a Snapshotter dumping s every interval to the file
+// named path. Each snapshot is written to a temporary file of the same directory,
+// synced and renamed to path, so that path always holds a complete dump.
+func NewSnapshotter(s *Store, interval time.Duration, path string) *Snapshotter {
+	return &Snapshotter{
+		write: func() error {
+			return writeFileAtomic(path, s.DumpTo)
+		},
+		interval: interval,
+	}
+}
+
+// NewSnapshotterFunc returns a Snapshotter dumping s every interval to the
+// writers returned by open, one per snapshot. Writers are closed once the dump
+// is written, an error returned by Close failing the snapshot.
+func NewSnapshotterFunc(s *Store, interval time.Duration, open func() (io.WriteCloser, error)) *Snapshotter {
+	return &Snapshotter{
+		write: func() error {
+			w, err := open()
+			if err != nil {
+				return err
+			}
+			err = s.DumpTo(w)
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+			return err
+		},
+		interval: interval,
+	}
+}
+
+// Start starts dumping the store in the background, the first snapshot being
+// taken after one interval. It returns an error if the interval is not positive
+// or if the snapshotter is already started.
+func (x *Snapshotter) Start() error {
+	if x.interval <= 0 {
+		return errors.New("invalid interval")
+	}
+	x.state.Lock()
+	defer x.state.Unlock()
+	if x.stop != nil {
+		return errors.New("already started")
+	}
+	x.stop = make(chan struct{})
+	x.done = make(chan struct{})
+	go x.run(x.stop, x.done)
+	return nil
+}
+
+// Stop stops dumping the store in the background, waiting for an ongoing
+// snapshot to complete. It has no effect if the snapshotter is not started.
+// Stop does not take a final snapshot, see Snapshot.
+func (x *Snapshotter) Stop() {
+	x.state.Lock()
+	defer x.state.Unlock()
+	if x.stop == nil {
+		return
+	}
+	close(x.stop)
+	<-x.done
+	x.stop, x.done = nil, nil
+}
+
+// Snapshot dumps the store immediately and returns the resulting error, if
+// any. It waits for an ongoing background snapshot to complete first.
+func (x *Snapshotter) Snapshot() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.write()
+}
+
+func (x *Snapshotter) run(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(x.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := x.Snapshot(); err != nil && x.OnError != nil {
+				x.OnError(err)
+			}
+		}
+	}
+}
+
+// writeFileAtomic calls fn with a temporary file of the directory of path and,
+// if fn succeeds, syncs the file, renames it to path and syncs the directory. The
+// temporary file is removed if an error occurs.
+func writeFileAtomic(path string, fn func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := fn(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	if d, err := os.Open(filepath.Dir(path)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// A Snapshot is a read-only, point-in-time view of a store, as returned by
+// Store.Snapshot. A Snapshot can be used simultaneously from multiple goroutines.
+type Snapshot struct {
+	s      *Store // content of the snapshot
+	origin *Store
+	shared []*Sequence // sequences of origin sharing their data with s
+}
+
+// Snapshot returns a read-only, point-in-time view of the store. The data of the
+// sequences is shared with the store and only copied by the store before being
+// modified, so that taking a snapshot only holds the lock of the store for the
+// time needed to copy its keys, and the snapshot can be queried and dumped while
+// statements are executed against the store, which never waits for readers of
+// the snapshot. Close should be called once the snapshot is no longer needed.
+func (s *Store) Snapshot() *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshotUnsafe()
+}
+
+// snapshotUnsafe returns a snapshot of the store. This method is not
+// goroutine-safe. The caller is responsible for properly acquiring / releasing
+// the lock on the store.
+func (s *Store) snapshotUnsafe() *Snapshot {
+	x := &Snapshot{s: NewStore(), origin: s, shared: make([]*Sequence, 0, len(s.m))}
+	x.s.m = make(map[string]*Sequence, len(s.m))
+	for k, v := range s.m {
+		c := *v
+		x.s.m[k] = &c
+		x.shared = append(x.shared, v)
+		s.shared[v] = append(s.shared[v], x)
+	}
+	x.s.labels = maps.Clone(s.labels)
+	return x
+}
+
+// Close releases the sequences shared by the snapshot with its store, after
+// which the snapshot is empty. It has no effect if the snapshot is already closed.
+func (x *Snapshot) Close() {
+	s := x.origin
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	x.s.mu.Lock()
+	defer x.s.mu.Unlock()
+	for _, v := range x.shared {
+		refs := slices.DeleteFunc(s.shared[v], func(r *Snapshot) bool {
+			return r == x
+		})
+		if len(refs) == 0 {
+			delete(s.shared, v)
+		} else {
+			s.shared[v] = refs
+		}
+	}
+	x.shared = nil
+	x.s.m = make(map[string]*Sequence)
+	x.s.labels = make(map[string]map[string]string)
+}
+
+// Get works like Store.Get on the snapshot.
+func (x *Snapshot) Get(key string) (*Sequence, bool) {
+	return x.s.Get(key)
+}
+
+// Labels works like Store.Labels on the snapshot.
+func (x *Snapshot) Labels(key string) (map[string]string, bool) {
+	return x.s.Labels(key)
+}
+
+// Keys works like Store.Keys on the snapshot.
+func (x *Snapshot) Keys() []string {
+	return x.s.Keys()
+}
+
+// Len works like Store.Len on the snapshot.
+func (x *Snapshot) Len() int {
+	return x.s.Len()
+}
+
+// Range works like Store.Range on the snapshot.
+func (x *Snapshot) Range(fn func(key string, x *Sequence) bool) {
+	x.s.Range(fn)
+}
+
+// Query works like Store.Query on the snapshot.
+func (x *Snapshot) Query(key string, start time.Time, end time.Time, d time.Duration, opts ...QueryOption) (QuerySet, error) {
+	return x.s.Query(key, start, end, d, opts...)
+}
+
+// QueryMulti works like Store.QueryMulti on the snapshot.
+func (x *Snapshot) QueryMulti(keys []string, start time.Time, end time.Time, d time.Duration, opts ...QueryOption) (map[string]QuerySet, error) {
+	return x.s.QueryMulti(keys, start, end, d, opts...)
+}
+
+// Dump works like Store.Dump on the snapshot, recording the dump in the
+// statistics of its store.
+func (x *Snapshot) Dump() ([]byte, error) {
+	data, err := x.s.Dump()
+	if err == nil {
+		x.origin.dumped()
+	}
+	return data, err
+}
+
+// DumpTo works like Store.DumpTo on the snapshot, recording the dump in the
+// statistics of its store.
+func (x *Snapshot) DumpTo(w io.Writer) error {
+	err := x.s.DumpTo(w)
+	if err == nil {
+		x.origin.dumped()
+	}
+	return err
+}
@@ -0,0 +1,257 @@
+package sequence
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+	err error
+}
+
+func (w nopWriteCloser) Close() error {
+	return w.err
+}
+
+func TestSnapshotter(t *testing.T) {
+	store := NewStore()
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store.Add("k1", NewWithValues(x, testSequenceFrequency, []uint8{1, 1, 0}))
+	path := filepath.Join(t.TempDir(), "store.dump")
+	s := NewSnapshotter(store, 5*time.Millisecond, path)
+	if err := s.Start(); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if err := s.Start(); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	var data []byte
+	for i := 0; i < 200 && len(data) == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+		data, _ = os.ReadFile(path)
+	}
+	s.Stop()
+	s.Stop()
+	dst := NewStore()
+	if err := dst.Load(data); err != nil || len(dst.m) != 1 {
+		t.Fatalf("got error %v and %d sequences, want error nil and 1 sequence", err, len(dst.m))
+	}
+
+	store.Add("k2", New(x, testSequenceFrequency))
+	if err := s.Snapshot(); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	data, _ = os.ReadFile(path)
+	if err := dst.Load(data); err != nil || len(dst.m) != 2 {
+		t.Fatalf("got error %v and %d sequences, want error nil and 2 sequences", err, len(dst.m))
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Fatalf("got %d files, want 1", len(entries))
+	}
+
+	s = NewSnapshotter(store, 5*time.Millisecond, filepath.Join(path, "invalid"))
+	if err := s.Snapshot(); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if err := NewSnapshotter(store, 0, path).Start(); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestSnapshotterFunc(t *testing.T) {
+	store := NewStore()
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store.Add("k1", NewWithValues(x, testSequenceFrequency, []uint8{1, 1, 0}))
+	var buf bytes.Buffer
+	s := NewSnapshotterFunc(store, time.Hour, func() (io.WriteCloser, error) {
+		buf.Reset()
+		return nopWriteCloser{Writer: &buf}, nil
+	})
+	if err := s.Snapshot(); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	dst := NewStore()
+	if err := dst.Load(buf.Bytes()); err != nil || len(dst.m) != 1 {
+		t.Fatalf("got error %v and %d sequences, want error nil and 1 sequence", err, len(dst.m))
+	}
+
+	errClose := errors.New("close")
+	writers := []func() (io.WriteCloser, error){
+		func() (io.WriteCloser, error) { return nil, errors.New("open") },
+		func() (io.WriteCloser, error) { return nopWriteCloser{Writer: failingWriter{}}, nil },
+		func() (io.WriteCloser, error) { return nopWriteCloser{Writer: io.Discard, err: errClose}, nil },
+	}
+	for i, open := range writers {
+		var mu sync.Mutex
+		var errs []error
+		s := NewSnapshotterFunc(store, time.Millisecond, open)
+		s.OnError = func(err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}
+		s.Start()
+		for j := 0; j < 200; j++ {
+			mu.Lock()
+			n := len(errs)
+			mu.Unlock()
+			if n > 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		s.Stop()
+		if len(errs) == 0 {
+			t.Fatalf("test %d: got no error, want at least 1", i+1)
+		}
+		if i == 2 && errs[0] != errClose {
+			t.Fatalf("test %d: got error %v, want %v", i+1, errs[0], errClose)
+		}
+	}
+}
+
+func TestStoreSnapshot(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	f := time.Duration(testSequenceFrequency) * time.Second
+	store := NewStore()
+	store.Add("k1", NewWithValues(x, testSequenceFrequency, []uint8{1, 1, 0}))
+	store.Add("k2", NewWithValues(x, testSequenceFrequency, []uint8{0}))
+	store.Add("k3", NewWithValues(x, testSequenceFrequency, []uint8{1}))
+	store.SetLabels("k1", map[string]string{"host": "h1"})
+	snap := store.Snapshot()
+	other := store.Snapshot()
+	want, _ := snap.Dump()
+
+	store.Execute(Statement{Key: "k1", Timestamp: x.Add(3 * f), Value: StateActive, Type: StatementAdd})
+	store.Update("k2", func(v *Sequence) error { v.Invert(); return nil })
+	store.TrimLeft(x.Add(f))
+	store.Delete("k3")
+	store.Add("k4", New(x, testSequenceFrequency))
+	store.SetLabels("k1", nil)
+	if err := store.Rename("k1", "k5"); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	store.Execute(Statement{Key: "k5", Timestamp: x.Add(4 * f), Value: StateActive, Type: StatementAdd})
+
+	for _, v := range []*Snapshot{snap, other} {
+		got, err := v.Dump()
+		if err != nil {
+			t.Fatalf("got error %s, want error nil", err)
+		}
+		if !assertDumpsEqual(t, got, want) {
+			t.Fatal("snapshot was modified")
+		}
+	}
+	if keys := snap.Keys(); len(keys) != 3 || snap.Len() != 3 {
+		t.Fatalf("got %v, want 3 keys", keys)
+	}
+	if got, ok := snap.Get("k1"); !ok || !slices.Equal(got.All(), []uint8{1, 1, 0}) {
+		t.Fatalf("got %v, want [1 1 0]", got.All())
+	}
+	if got, _ := snap.Labels("k1"); got["host"] != "h1" {
+		t.Fatalf("got %v, want labels of k1", got)
+	}
+	if got, _ := store.Get("k5"); !slices.Equal(got.All(), []uint8{1, 0, 1, 1}) {
+		t.Fatalf("got %v, want [1 0 1 1]", got.All())
+	}
+	if store.Stats().LastDump.IsZero() {
+		t.Fatal("dump of the snapshot should be recorded")
+	}
+
+	snap.Close()
+	snap.Close()
+	if snap.Len() != 0 {
+		t.Fatalf("got %d sequences, want 0", snap.Len())
+	}
+	other.Close()
+	if n := len(store.shared); n != 0 {
+		t.Fatalf("got %d shared sequences, want 0", n)
+	}
+}
+
+// blockingWriter is an io.Writer blocking until release is closed.
+type blockingWriter struct {
+	bytes.Buffer
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.started) })
+	<-w.release
+	return w.Buffer.Write(p)
+}
+
+func TestStoreSnapshotDumpToConcurrentWrites(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	f := time.Duration(testSequenceFrequency) * time.Second
+	store := NewStore()
+	store.Add("k1", NewWithValues(x, testSequenceFrequency, []uint8{1, 1, 0}))
+	snap := store.Snapshot()
+	defer snap.Close()
+	want, _ := snap.Dump()
+
+	w := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- snap.DumpTo(w)
+	}()
+	<-w.started
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		store.Execute(Statement{Key: "k1", Timestamp: x.Add(3 * f), Value: StateActive, Type: StatementAdd})
+		store.Add("k2", New(x, testSequenceFrequency))
+		store.Execute(Statement{Key: "k1", Timestamp: x.Add(4 * f), Value: StateActive, Type: StatementAdd})
+	}()
+	select {
+	case <-done:
+		close(w.release)
+	case <-time.After(5 * time.Second):
+		close(w.release)
+		<-done
+		t.Fatal("writes blocked by the dump of the snapshot")
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if !assertDumpsEqual(t, w.Bytes(), want) {
+		t.Fatal("snapshot was modified")
+	}
+	if got, _ := store.Get("k1"); !slices.Equal(got.All(), []uint8{1, 1, 0, 1, 1}) {
+		t.Fatalf("got %v, want [1 1 0 1 1]", got.All())
+	}
+}
+
+// assertDumpsEqual reports whether dumps a and b hold the same sequences and
+// labels, regardless of the order of their records.
+func assertDumpsEqual(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	m1, l1, err := decodeDump(bytes.NewReader(a), false)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	m2, l2, err := decodeDump(bytes.NewReader(b), false)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if len(m1) != len(m2) || len(l1) != len(l2) {
+		return false
+	}
+	for k, v := range m1 {
+		if w, ok := m2[k]; !ok || !assertSequencesEqual(v, w) || !maps.Equal(l1[k], l2[k]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,253 @@
+package sequence
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// Transitions returns the number of state changes between consecutive values
+// of s using start and end as closed interval filter. StateUnknown values are
+// ignored: a gap in the data between two identical states is not a change. It
+// returns an error if start is after end.
+func (s *Sequence) Transitions(start, end time.Time) (int, error) {
+	if start.After(end) {
+		return 0, errors.New("invalid arguments")
+	}
+	x, y, ok := s.offsets(start, end)
+	if !ok {
+		return 0, nil
+	}
+	n := 0
+	last := StateUnknown
+	s.walk(x, y, func(j, count int64, v uint8) bool {
+		if v == StateUnknown {
+			return true
+		}
+		if last != StateUnknown && v != last {
+			n++
+		}
+		last = v
+		return true
+	})
+	return n, nil
+}
+
+// A Transition represents a change between two known states of a sequence.
+type Transition struct {
+	// T specifies the timestamp of the first
+	// value in the new state.
+	T time.Time
+
+	// From and To hold the previous and the
+	// new state.
+	From uint8
+	To   uint8
+}
+
+// TransitionEvents returns the state changes between consecutive values of s
+// using start and end as closed interval filter, in chronological order. As with
+// Transitions, StateUnknown values are ignored. It returns an error if start is
+// after end.
+func (s *Sequence) TransitionEvents(start, end time.Time) ([]Transition, error) {
+	if start.After(end) {
+		return nil, errors.New("invalid arguments")
+	}
+	events := []Transition{}
+	x, y, ok := s.offsets(start, end)
+	if !ok {
+		return events, nil
+	}
+	f := int64(s.frequency)
+	last := StateUnknown
+	s.walk(x, y, func(j, n int64, v uint8) bool {
+		if v == StateUnknown {
+			return true
+		}
+		if last != StateUnknown && v != last {
+			events = append(events, Transition{T: time.Unix(s.ts+j*f, 0), From: last, To: v})
+		}
+		last = v
+		return true
+	})
+	return events, nil
+}
+
+// LongestRun returns the duration and the start time of the longest
+// uninterrupted series of values x in s using start and end as closed interval
+// filter. Series are clipped to the interval and the earliest one is returned
+// in case of a tie. If there is no such value, the duration is 0 and the start
+// time is the zero time. It returns an error if start is after end.
+func (s *Sequence) LongestRun(x uint8, start, end time.Time) (time.Duration, time.Time, error) {
+	if start.After(end) {
+		return 0, time.Time{}, errors.New("invalid arguments")
+	}
+	a, b, ok := s.offsets(start, end)
+	if !ok {
+		return 0, time.Time{}, nil
+	}
+	var best, first, current, j0 int64
+	s.walk(a, b, func(j, n int64, v uint8) bool {
+		if v != x {
+			current = 0
+			return true
+		}
+		if current == 0 {
+			j0 = j
+		}
+		current += n
+		if current > best {
+			best, first = current, j0
+		}
+		return true
+	})
+	if best == 0 {
+		return 0, time.Time{}, nil
+	}
+	f := int64(s.frequency)
+	return time.Duration(best*f) * time.Second, time.Unix(s.ts+first*f, 0), nil
+}
+
+// DurationByState returns the time spent in each state by s using start and end
+// as closed interval filter, each value accounting for the frequency of s. Only
+// stored values are taken into account and states without any value are omitted.
+// It returns an error if start is after end.
+func (s *Sequence) DurationByState(start, end time.Time) (map[uint8]time.Duration, error) {
+	if start.After(end) {
+		return nil, errors.New("invalid arguments")
+	}
+	m := make(map[uint8]time.Duration)
+	x, y, ok := s.offsets(start, end)
+	if !ok {
+		return m, nil
+	}
+	f := time.Duration(s.frequency) * time.Second
+	s.walk(x, y, func(j, n int64, v uint8) bool {
+		m[v] += time.Duration(n) * f
+		return true
+	})
+	return m, nil
+}
+
+// UnknownGaps returns the periods during which the values stored in s are
+// StateUnknown using start and end as closed interval filter, in chronological
+// order. Periods are clipped to the interval and values that are not stored yet
+// are not taken into account. It returns an error if start is after end.
+func (s *Sequence) UnknownGaps(start, end time.Time) ([]Period, error) {
+	if start.After(end) {
+		return nil, errors.New("invalid arguments")
+	}
+	gaps := []Period{}
+	x, y, ok := s.offsets(start, end)
+	if !ok {
+		return gaps, nil
+	}
+	f := int64(s.frequency)
+	s.walk(x, y, func(j, n int64, v uint8) bool {
+		if v != StateUnknown {
+			return true
+		}
+		p := Period{Start: s.ts + j*f, End: s.ts + (j+n)*f}
+		if k := len(gaps) - 1; k >= 0 && gaps[k].End == p.Start {
+			gaps[k].End = p.End
+			return true
+		}
+		gaps = append(gaps, p)
+		return true
+	})
+	return gaps, nil
+}
+
+// An UnknownPolicy defines how StateUnknown values are accounted for by
+// methods computing statistics on the known states of a sequence.
+type UnknownPolicy uint8
+
+// Unknown policies.
+const (
+	UnknownExcluded UnknownPolicy = iota // StateUnknown values are ignored
+	UnknownDown                          // StateUnknown values count as StateInactive
+	UnknownUp                            // StateUnknown values count as StateActive
+	unknownPolicyInvalid
+)
+
+// Availability returns the ratio of StateActive values to StateActive and
+// StateInactive values stored in s using start and end as closed interval filter,
+// accounting for StateUnknown values according to p. Other values are ignored.
+// It returns NaN if there is no value to account for and an error if start is after
+// end or if p is not a valid policy.
+func (s *Sequence) Availability(start, end time.Time, p UnknownPolicy) (float64, error) {
+	if start.After(end) {
+		return 0, errors.New("invalid arguments")
+	}
+	if p >= unknownPolicyInvalid {
+		return 0, errors.New("invalid unknown policy")
+	}
+	var up, total int64
+	if x, y, ok := s.offsets(start, end); ok {
+		s.walk(x, y, func(j, n int64, v uint8) bool {
+			if v == StateUnknown {
+				switch p {
+				case UnknownDown:
+					v = StateInactive
+				case UnknownUp:
+					v = StateActive
+				}
+			}
+			switch v {
+			case StateActive:
+				up += n
+				total += n
+			case StateInactive:
+				total += n
+			}
+			return true
+		})
+	}
+	if total == 0 {
+		return math.NaN(), nil
+	}
+	return float64(up) / float64(total), nil
+}
+
+// FirstOccurrence returns the timestamp of the first value x stored in s at or
+// after since. The second return value is false if there is none.
+func (s *Sequence) FirstOccurrence(x uint8, since time.Time) (time.Time, bool) {
+	f := int64(s.frequency)
+	a := int64(0)
+	if t := since.Unix(); t > s.ts {
+		a = ceilInt64(t-s.ts, f) / f
+	}
+	j0 := int64(-1)
+	s.walk(a, int64(s.count)-1, func(j, n int64, v uint8) bool {
+		if v == x {
+			j0 = j
+			return false
+		}
+		return true
+	})
+	if j0 < 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(s.ts+j0*f, 0), true
+}
+
+// LastOccurrence returns the timestamp of the last value x stored in s at or
+// before until. The second return value is false if there is none.
+func (s *Sequence) LastOccurrence(x uint8, until time.Time) (time.Time, bool) {
+	t := until.Unix()
+	if t < s.ts {
+		return time.Time{}, false
+	}
+	f := int64(s.frequency)
+	j0 := int64(-1)
+	s.walk(0, (t-s.ts)/f, func(j, n int64, v uint8) bool {
+		if v == x {
+			j0 = j + n - 1
+		}
+		return true
+	})
+	if j0 < 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(s.ts+j0*f, 0), true
+}
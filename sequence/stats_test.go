@@ -0,0 +1,267 @@
+package sequence
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSequenceTransitions(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	s.Backfill(shift(s, 15, 0), StateActive)
+	tests := []struct {
+		id    int
+		start time.Time
+		end   time.Time
+		want  int
+	}{
+		{1, shift(s, -5, 0), shift(s, 25, -1), 3},
+		{2, shift(s, 0, 0), shift(s, 9, 0), 1},
+		{3, shift(s, 4, 1), shift(s, 14, 0), 1},
+		{4, shift(s, 16, 0), shift(s, 18, 0), 0},
+		{5, shift(s, 14, 0), shift(s, 19, 0), 1},
+		{6, shift(s, 20, 0), shift(s, 30, 0), 0},
+	}
+	for _, tt := range tests {
+		prefix := fmt.Sprintf("test %d (%s, %s)", tt.id, tt.start, tt.end)
+		got, err := s.Transitions(tt.start, tt.end)
+		if err != nil {
+			t.Fatalf("%s: got error %s, want error nil", prefix, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%s: got %d, want %d", prefix, got, tt.want)
+		}
+	}
+	if _, err := s.Transitions(shift(s, 1, 0), shift(s, 0, 0)); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestSequenceLongestRun(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, []uint8{1, 1, 0, 0, 1, 1, 1, 2, 0, 0, 0, 0})
+	s.Backfill(shift(s, 7, 0), StateActive)
+	f := time.Duration(s.frequency) * time.Second
+	type result struct {
+		d time.Duration
+		t time.Time
+	}
+	tests := []struct {
+		id    int
+		value uint8
+		start time.Time
+		end   time.Time
+		want  result
+	}{
+		{1, StateActive, shift(s, -5, 0), shift(s, 20, 0), result{4 * f, shift(s, 4, 0)}},
+		{2, StateInactive, shift(s, -5, 0), shift(s, 20, 0), result{4 * f, shift(s, 8, 0)}},
+		{3, StateInactive, shift(s, 0, 0), shift(s, 9, 0), result{2 * f, shift(s, 2, 0)}},
+		{4, StateActive, shift(s, 5, 0), shift(s, 6, 0), result{2 * f, shift(s, 5, 0)}},
+		{5, StateUnknown, shift(s, -5, 0), shift(s, 20, 0), result{0, time.Time{}}},
+		{6, StateActive, shift(s, 20, 0), shift(s, 30, 0), result{0, time.Time{}}},
+	}
+	for _, tt := range tests {
+		prefix := fmt.Sprintf("test %d (%s, %s)", tt.id, tt.start, tt.end)
+		var got result
+		var err error
+		got.d, got.t, err = s.LongestRun(tt.value, tt.start, tt.end)
+		if err != nil {
+			t.Fatalf("%s: got error %s, want error nil", prefix, err)
+		}
+		if got.d != tt.want.d || !got.t.Equal(tt.want.t) {
+			t.Fatalf("%s: got %+v, want %+v", prefix, got, tt.want)
+		}
+	}
+	if _, _, err := s.LongestRun(StateActive, shift(s, 1, 0), shift(s, 0, 0)); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestSequenceDurationByState(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	f := time.Duration(s.frequency) * time.Second
+	tests := []struct {
+		id    int
+		start time.Time
+		end   time.Time
+		want  map[uint8]time.Duration
+	}{
+		{1, shift(s, -5, 0), shift(s, 25, -1), map[uint8]time.Duration{0: 6 * f, 1: 10 * f, 2: 4 * f}},
+		{2, shift(s, 3, 1), shift(s, 6, 0), map[uint8]time.Duration{0: 2 * f, 1: f}},
+		{3, shift(s, 16, 0), shift(s, 16, 0), map[uint8]time.Duration{2: f}},
+		{4, shift(s, 20, 0), shift(s, 30, 0), map[uint8]time.Duration{}},
+	}
+	for _, tt := range tests {
+		prefix := fmt.Sprintf("test %d (%s, %s)", tt.id, tt.start, tt.end)
+		got, err := s.DurationByState(tt.start, tt.end)
+		if err != nil {
+			t.Fatalf("%s: got error %s, want error nil", prefix, err)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: got %v, want %v", prefix, got, tt.want)
+		}
+		for k, v := range tt.want {
+			if got[k] != v {
+				t.Fatalf("%s: got %v, want %v", prefix, got, tt.want)
+			}
+		}
+	}
+	if _, err := s.DurationByState(shift(s, 1, 0), shift(s, 0, 0)); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestSequenceAvailability(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	tests := []struct {
+		id     int
+		start  time.Time
+		end    time.Time
+		policy UnknownPolicy
+		want   float64
+	}{
+		{1, shift(s, -5, 0), shift(s, 25, -1), UnknownExcluded, 10.0 / 16},
+		{2, shift(s, -5, 0), shift(s, 25, -1), UnknownDown, 10.0 / 20},
+		{3, shift(s, -5, 0), shift(s, 25, -1), UnknownUp, 14.0 / 20},
+		{4, shift(s, 3, 1), shift(s, 6, 0), UnknownExcluded, 1.0 / 3},
+		{5, shift(s, 15, 0), shift(s, 18, 0), UnknownUp, 1},
+	}
+	for _, tt := range tests {
+		prefix := fmt.Sprintf("test %d (%s, %s)", tt.id, tt.start, tt.end)
+		got, err := s.Availability(tt.start, tt.end, tt.policy)
+		if err != nil {
+			t.Fatalf("%s: got error %s, want error nil", prefix, err)
+		}
+		if got != tt.want {
+			t.Fatalf("%s: got %f, want %f", prefix, got, tt.want)
+		}
+	}
+	if got, _ := s.Availability(shift(s, 15, 0), shift(s, 18, 0), UnknownExcluded); !math.IsNaN(got) {
+		t.Fatalf("got %f, want NaN", got)
+	}
+	if _, err := s.Availability(shift(s, 1, 0), shift(s, 0, 0), UnknownExcluded); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if _, err := s.Availability(shift(s, 0, 0), shift(s, 1, 0), unknownPolicyInvalid); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestSequenceTransitionEvents(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	tests := []struct {
+		id    int
+		start time.Time
+		end   time.Time
+		want  []Transition
+	}{
+		{
+			1,
+			shift(s, -5, 0),
+			shift(s, 25, -1),
+			[]Transition{
+				{shift(s, 5, 0), StateActive, StateInactive},
+				{shift(s, 10, 0), StateInactive, StateActive},
+				{shift(s, 19, 0), StateActive, StateInactive},
+			},
+		},
+		{2, shift(s, 6, 0), shift(s, 12, 0), []Transition{{shift(s, 10, 0), StateInactive, StateActive}}},
+		{3, shift(s, 5, 0), shift(s, 9, 0), []Transition{}},
+	}
+	for _, tt := range tests {
+		prefix := fmt.Sprintf("test %d (%s, %s)", tt.id, tt.start, tt.end)
+		got, err := s.TransitionEvents(tt.start, tt.end)
+		if err != nil {
+			t.Fatalf("%s: got error %s, want error nil", prefix, err)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s:\ngot  %v\nwant %v", prefix, got, tt.want)
+		}
+		for i := range got {
+			if !got[i].T.Equal(tt.want[i].T) || got[i].From != tt.want[i].From || got[i].To != tt.want[i].To {
+				t.Fatalf("%s:\ngot  %v\nwant %v", prefix, got, tt.want)
+			}
+		}
+		if n, _ := s.Transitions(tt.start, tt.end); n != len(got) {
+			t.Fatalf("%s: got %d, want %d", prefix, n, len(got))
+		}
+	}
+	if _, err := s.TransitionEvents(shift(s, 1, 0), shift(s, 0, 0)); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestSequenceOccurrence(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	type result struct {
+		t  time.Time
+		ok bool
+	}
+	tests := []struct {
+		id    int
+		value uint8
+		t     time.Time
+		first result
+		last  result
+	}{
+		{1, StateInactive, shift(s, -5, 0), result{shift(s, 5, 0), true}, result{}},
+		{2, StateInactive, shift(s, 7, 1), result{shift(s, 8, 0), true}, result{shift(s, 7, 0), true}},
+		{3, StateActive, shift(s, 12, 0), result{shift(s, 12, 0), true}, result{shift(s, 12, 0), true}},
+		{4, StateActive, shift(s, 16, 0), result{}, result{shift(s, 14, 0), true}},
+		{5, StateInactive, shift(s, 30, 0), result{}, result{shift(s, 19, 0), true}},
+		{6, StateNotUsed, shift(s, 0, 0), result{}, result{}},
+	}
+	for _, tt := range tests {
+		var got result
+		got.t, got.ok = s.FirstOccurrence(tt.value, tt.t)
+		if got.ok != tt.first.ok || !got.t.Equal(tt.first.t) {
+			t.Fatalf("test %d: got %+v, want %+v", tt.id, got, tt.first)
+		}
+		got.t, got.ok = s.LastOccurrence(tt.value, tt.t)
+		if got.ok != tt.last.ok || !got.t.Equal(tt.last.t) {
+			t.Fatalf("test %d: got %+v, want %+v", tt.id, got, tt.last)
+		}
+	}
+}
+
+func TestSequenceUnknownGaps(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, []uint8{2, 2, 1, 2, 2, 2, 0, 2})
+	s.AddRun(shift(s, 8, 0), 3, StateUnknown)
+	f := int64(s.frequency)
+	tests := []struct {
+		id    int
+		start time.Time
+		end   time.Time
+		want  []Period
+	}{
+		{1, shift(s, -5, 0), shift(s, 20, 0), []Period{{s.ts, s.ts + 2*f}, {s.ts + 3*f, s.ts + 6*f}, {s.ts + 7*f, s.ts + 11*f}}},
+		{2, shift(s, 1, 0), shift(s, 4, 0), []Period{{s.ts + f, s.ts + 2*f}, {s.ts + 3*f, s.ts + 5*f}}},
+		{3, shift(s, 2, 0), shift(s, 2, 0), []Period{}},
+		{4, shift(s, 30, 0), shift(s, 40, 0), []Period{}},
+	}
+	for _, tt := range tests {
+		prefix := fmt.Sprintf("test %d (%s, %s)", tt.id, tt.start, tt.end)
+		got, err := s.UnknownGaps(tt.start, tt.end)
+		if err != nil {
+			t.Fatalf("%s: got error %s, want error nil", prefix, err)
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s:\ngot  %v\nwant %v", prefix, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Fatalf("%s:\ngot  %v\nwant %v", prefix, got, tt.want)
+			}
+		}
+	}
+	if _, err := s.UnknownGaps(shift(s, 1, 0), shift(s, 0, 0)); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
@@ -1,17 +1,40 @@
 package sequence
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/binary"
 	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"maps"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unsafe"
 )
 
-// Statement types.
+// Statement types. StatementAdd executes Sequence.AddRun using the count of
+// the statement, a count of 0 standing for a single value. StatementTrimLeft
+// executes Sequence.TrimLeft using the timestamp of the statement,
+// StatementSetLength executes Sequence.Resize using its length and
+// StatementDelete removes its key from the store, if it exists, ignoring the
+// other fields.
 const (
 	StatementAdd uint8 = iota
 	StatementRoll
+	StatementRollReplace
+	StatementTrimLeft
+	StatementDelete
+	StatementSetLength
 	statementUnknown
 )
 
@@ -25,6 +48,37 @@ type Statement struct {
 	CreateWithTimestamp time.Time
 	CreateWithFrequency uint16
 	CreateWithLength    uint32
+	Length              uint32
+	Count               uint32
+
+	// OnlyIfChanged and OnlyIfOlderThan make statements writing values
+	// conditional: the statement is skipped, resulting in ErrSkipped, if
+	// the last value of the sequence equals Value or if the last value is
+	// less than OnlyIfOlderThan older than Timestamp, respectively. A zero
+	// value disables the corresponding condition.
+	OnlyIfChanged   bool
+	OnlyIfOlderThan time.Duration
+}
+
+// skips reports whether the conditions of statement are not met by x.
+func (statement Statement) skips(x *Sequence) bool {
+	switch statement.Type {
+	case StatementAdd, StatementRoll, StatementRollReplace:
+	default:
+		return false
+	}
+	if x.count == 0 {
+		return false
+	}
+	_, v, _ := x.last()
+	if statement.OnlyIfChanged && v == statement.Value&(1<<x.bits()-1) {
+		return true
+	}
+	if d := int64(statement.OnlyIfOlderThan / time.Second); d > 0 {
+		t := x.ts + int64(x.count-1)*int64(x.frequency)
+		return statement.Timestamp.Unix()-t < d
+	}
+	return false
 }
 
 // A BatchResult provides detailed information about statements executed in batch.
@@ -57,16 +111,244 @@ func (b batchResult) HasErrors() bool {
 	return len(b.errors) > 0
 }
 
+// A CreatePolicy defines constraints on the parameters used to create sequences
+// from statements. A zero value disables the corresponding constraint.
+type CreatePolicy struct {
+	// MinFrequency and MaxFrequency specify the range of accepted
+	// frequencies. As in statements, a frequency of 0 stands for 1.
+	MinFrequency uint16
+	MaxFrequency uint16
+
+	// MinLength and MaxLength specify the range of accepted lengths.
+	// As in statements, a length of 0 stands for MaxSequenceLength.
+	MinLength uint32
+	MaxLength uint32
+
+	// MaxHorizon specifies the maximum time span of a sequence,
+	// its length multiplied by its frequency.
+	MaxHorizon time.Duration
+}
+
+// check returns an error if statement violates the policy.
+func (p CreatePolicy) check(statement Statement) error {
+	f := statement.CreateWithFrequency
+	if f == 0 {
+		f = 1
+	}
+	if f < p.MinFrequency || p.MaxFrequency > 0 && f > p.MaxFrequency {
+		return ErrInvalidFrequency
+	}
+	length := statement.CreateWithLength
+	if length == 0 {
+		length = MaxSequenceLength
+	}
+	if length < p.MinLength || p.MaxLength > 0 && length > p.MaxLength {
+		return ErrInvalidLength
+	}
+	if p.MaxHorizon > 0 && int64(length)*int64(f) > int64(p.MaxHorizon/time.Second) {
+		return ErrInvalidHorizon
+	}
+	return nil
+}
+
+// A RetentionPolicy defines how long a store keeps the values of its sequences.
+// A zero value disables the corresponding constraint.
+type RetentionPolicy struct {
+	// MaxAge specifies the maximum age of the values, relative to the
+	// time the policy is applied.
+	MaxAge time.Duration
+
+	// MaxLength specifies the maximum number of values of a sequence,
+	// the oldest values being discarded first.
+	MaxLength uint32
+}
+
+// apply applies the policy to x at time now and reports whether x was trimmed.
+func (p RetentionPolicy) apply(x *Sequence, now time.Time) bool {
+	count := x.count
+	if p.MaxAge > 0 {
+		x.TrimLeft(now.Add(-p.MaxAge))
+	}
+	if p.MaxLength > 0 && x.count > p.MaxLength {
+		x.drop(int64(x.count - p.MaxLength))
+	}
+	return x.count != count
+}
+
+// A retentionRule associates a retention policy to the keys matching a glob.
+type retentionRule struct {
+	glob   string
+	policy RetentionPolicy
+}
+
+// Label matcher types.
+const (
+	MatchEqual uint8 = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+	matchUnknown
+)
+
+// A LabelMatcher represents a condition on the value of the label Name of a
+// sequence. Regular expressions use the syntax of package regexp and must match
+// the whole value.
+type LabelMatcher struct {
+	Name  string
+	Value string
+	Type  uint8
+}
+
+// compile returns a function reporting whether a label value satisfies the
+// matcher, or an error if the matcher is not valid.
+func (m LabelMatcher) compile() (func(v string) bool, error) {
+	switch m.Type {
+	case MatchEqual:
+		return func(v string) bool { return v == m.Value }, nil
+	case MatchNotEqual:
+		return func(v string) bool { return v != m.Value }, nil
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + m.Value + ")$")
+		if err != nil {
+			return nil, err
+		}
+		if m.Type == MatchRegexp {
+			return re.MatchString, nil
+		}
+		return func(v string) bool { return !re.MatchString(v) }, nil
+	}
+	return nil, errors.New("unknown matcher type")
+}
+
+// Hooks intercept the statements executed against a store, for instance to
+// validate keys, enrich statements or collect metrics by family of keys. Hooks
+// may be called concurrently from multiple goroutines and must not call the
+// methods of the store.
+type Hooks struct {
+	// Before, if not nil, is called before a statement is executed and may
+	// modify it. The statement is rejected with the returned error, if any.
+	Before func(statement *Statement) error
+
+	// After, if not nil, is called once a statement is executed, or rejected
+	// by Before, with the resulting error.
+	After func(statement Statement, err error)
+}
+
+// before calls the Before hook, if any.
+func (h *Hooks) before(statement *Statement) error {
+	if h.Before == nil {
+		return nil
+	}
+	return h.Before(statement)
+}
+
+// after calls the After hook, if any.
+func (h *Hooks) after(statement Statement, err error) {
+	if h.After != nil {
+		h.After(statement, err)
+	}
+}
+
 // A Store represents a collection of Sequences. A Store can be used simultaneously
 // from multiple goroutines.
+//
+// The map of sequences is guarded by mu while the content of each sequence is
+// guarded by one of the stripes, selected by hashing its key, so that statements
+// executed against existing sequences only hold the read lock of mu and do not
+// wait for operations on sequences of other stripes. Reading a sequence requires
+// holding the read locks of mu and of its stripe, or the write lock of mu.
 type Store struct {
-	m  map[string]*Sequence
-	mu sync.RWMutex
+	m       map[string]*Sequence
+	mu      sync.RWMutex
+	stripes [storeStripes]sync.RWMutex
+	policy  CreatePolicy
+
+	// retention holds the retention rules of the store, in the order
+	// their globs were first set.
+	retention []retentionRule
+
+	// gen is incremented on every modification of the store, modified
+	// holds the generation of the last modification of each sequence and
+	// tombstones the generation of the deletion of each deleted key.
+	gen        uint64
+	modified   map[*Sequence]uint64
+	tombstones map[string]uint64
+	track      sync.Mutex // guards gen and modified under the read lock of mu
+
+	// sorted holds the keys of the store in ascending order if indexed
+	// is true. The index is invalidated when keys are added or removed
+	// and rebuilt on demand.
+	sorted  []string
+	indexed bool
+	index   sync.Mutex // guards sorted and indexed under the read lock of mu
+
+	wal *WAL
+
+	stats storeCounters
+
+	// hooks holds the hooks of the store, nil if no hooks are set.
+	hooks atomic.Pointer[Hooks]
+
+	// watchers holds the watchers of the store, replaced as a whole when
+	// watchers are added or removed.
+	watchers atomic.Pointer[[]*Watcher]
+	watch    sync.Mutex // serializes updates of watchers
+
+	// shared holds the sequences whose data is shared with snapshots of the
+	// store, which is copied before being modified, see Snapshot.
+	shared map[*Sequence][]*Snapshot
+	cow    sync.Mutex // guards shared under the read lock of mu
+
+	// labels holds the labels of the keys having labels.
+	labels map[string]map[string]string
+}
+
+// A storeCounters holds the counters of a store reported by Stats.
+type storeCounters struct {
+	executed [statementUnknown]atomic.Uint64 // by statement type
+	errors   atomic.Uint64
+	skipped  atomic.Uint64
+	lastDump atomic.Int64 // Unix time in nanoseconds, 0 if never dumped
+}
+
+// StoreStats holds runtime metrics of a store, as returned by Store.Stats.
+type StoreStats struct {
+	// Sequences, TotalValues and TotalBytes hold the values returned by
+	// Len, TotalValues and TotalBytes.
+	Sequences   int
+	TotalValues int64
+	TotalBytes  int64
+
+	// Executed is the number of statements successfully executed, of which
+	// Adds, Rolls and RollReplaces are the number of statements of the
+	// corresponding types.
+	Executed     uint64
+	Adds         uint64
+	Rolls        uint64
+	RollReplaces uint64
+
+	// Errors is the number of statements that failed and Skipped the
+	// number of conditional statements skipped, see Statement.
+	Errors  uint64
+	Skipped uint64
+
+	// LastDump is the time of the last successful dump of the store, the
+	// zero time if the store was never dumped.
+	LastDump time.Time
 }
 
+// storeStripes is the number of stripes of a store.
+const storeStripes = 64
+
 // NewStore creates and intializes a new Store.
 func NewStore() *Store {
-	return &Store{m: make(map[string]*Sequence)}
+	return &Store{
+		m:          make(map[string]*Sequence),
+		modified:   make(map[*Sequence]uint64),
+		tombstones: make(map[string]uint64),
+		labels:     make(map[string]map[string]string),
+		shared:     make(map[*Sequence][]*Snapshot),
+	}
 }
 
 // New creates and adds a new Sequence to the store using key as its identifier. If a
@@ -74,7 +356,7 @@ func NewStore() *Store {
 // Sequence.
 func (s *Store) New(t time.Time, f uint16, key string) {
 	s.mu.Lock()
-	s.m[key] = New(t, f)
+	s.setUnsafe(key, New(t, f))
 	s.mu.Unlock()
 }
 
@@ -83,17 +365,56 @@ func (s *Store) New(t time.Time, f uint16, key string) {
 // Sequence.
 func (s *Store) Add(key string, x *Sequence) {
 	s.mu.Lock()
-	s.m[key] = x.clone()
+	s.setUnsafe(key, x.clone())
 	s.mu.Unlock()
 }
 
 // Delete removes key from the store.
 func (s *Store) Delete(key string) {
 	s.mu.Lock()
-	delete(s.m, key)
+	s.deleteUnsafe(key)
 	s.mu.Unlock()
 }
 
+// Rename associates the sequence and the labels of oldKey to newKey under a
+// single lock, without copying the sequence, and removes oldKey from the store.
+// It returns an error if oldKey does not exist or if newKey already exists.
+func (s *Store) Rename(oldKey, newKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	x, ok := s.m[oldKey]
+	if !ok {
+		return errors.New("key does not exist")
+	}
+	if oldKey == newKey {
+		return nil
+	}
+	if _, ok := s.m[newKey]; ok {
+		return errors.New("key already exists")
+	}
+	labels := s.labels[oldKey]
+	s.preserveUnsafe(x)
+	s.deleteUnsafe(oldKey)
+	s.setUnsafe(newKey, x)
+	s.setLabelsUnsafe(newKey, labels)
+	return nil
+}
+
+// DeleteFunc removes the keys satisfying selector from the store under a single
+// lock, see KeysFunc, and returns the number of removed keys.
+func (s *Store) DeleteFunc(selector func(key string) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for k := range s.m {
+		if selector(k) {
+			s.deleteUnsafe(k)
+			n++
+		}
+	}
+	return n
+}
+
 // Get returns a copy of the Sequence associated to key. The second return value is
 // true if the key exists in the store and false if not.
 func (s *Store) Get(key string) (*Sequence, bool) {
@@ -103,41 +424,458 @@ func (s *Store) Get(key string) (*Sequence, bool) {
 	if !ok {
 		return nil, false
 	}
+	mu := keyLock(s, key)
+	mu.RLock()
+	defer mu.RUnlock()
 	return x.clone(), true
 }
 
+// GetMulti returns copies of the sequences associated to keys under a single
+// lock, by key. Keys that don't exist are omitted from the result.
+func (s *Store) GetMulti(keys []string) map[string]*Sequence {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m := make(map[string]*Sequence, len(keys))
+	for _, k := range keys {
+		x, ok := s.m[k]
+		if !ok {
+			continue
+		}
+		mu := keyLock(s, k)
+		mu.RLock()
+		m[k] = x.clone()
+		mu.RUnlock()
+	}
+	return m
+}
+
+// Update calls fn with the sequence associated to key, allowing to modify it in
+// place using methods not covered by statements, and returns the error returned
+// by fn. The sequence must not be retained after fn returns, and fn must not call
+// methods of the store. It returns an error if the key does not exist. Updates
+// are not appended to the write-ahead log of the store, if any.
+func (s *Store) Update(key string, fn func(x *Sequence) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	x, ok := s.m[key]
+	if !ok {
+		return errors.New("key does not exist")
+	}
+	mu := keyLock(s, key)
+	mu.Lock()
+	defer mu.Unlock()
+	s.preserveUnsafe(x)
+	defer s.touchUnsafe(x)
+	return fn(x)
+}
+
 // Query executes Sequence.Query() on the sequence associated to key, returning an
 // error if the key does not exist or if the underlying operation returned an error.
-func (s *Store) Query(key string, start time.Time, end time.Time, d time.Duration) (QuerySet, error) {
+func (s *Store) Query(key string, start time.Time, end time.Time, d time.Duration, opts ...QueryOption) (QuerySet, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	x, ok := s.m[key]
 	if !ok {
 		return QuerySet{}, errors.New("key does not exist")
 	}
-	return x.Query(start, end, d)
+	mu := keyLock(s, key)
+	mu.RLock()
+	defer mu.RUnlock()
+	return x.Query(start, end, d, opts...)
+}
+
+// QueryContext works like Query but returns the error of ctx, without executing
+// the query, if ctx is done.
+func (s *Store) QueryContext(ctx context.Context, key string, start time.Time, end time.Time, d time.Duration, opts ...QueryOption) (QuerySet, error) {
+	if err := ctx.Err(); err != nil {
+		return QuerySet{}, err
+	}
+	return s.Query(key, start, end, d, opts...)
+}
+
+// QueryInto executes Sequence.QueryInto() on the sequence associated to key, returning
+// an error if the key does not exist or if the underlying operation returned an error.
+func (s *Store) QueryInto(key string, dst *QuerySet, start time.Time, end time.Time, d time.Duration, opts ...QueryOption) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	x, ok := s.m[key]
+	if !ok {
+		return errors.New("key does not exist")
+	}
+	mu := keyLock(s, key)
+	mu.RLock()
+	defer mu.RUnlock()
+	return x.QueryInto(dst, start, end, d, opts...)
+}
+
+// QueryMulti executes Sequence.Query() on the sequences associated to keys under a
+// single lock, returning the query sets by key. Keys that don't exist are omitted
+// from the result. It returns an error if one of the underlying operations returned
+// an error.
+func (s *Store) QueryMulti(keys []string, start time.Time, end time.Time, d time.Duration, opts ...QueryOption) (map[string]QuerySet, error) {
+	return s.QueryMultiContext(context.Background(), keys, start, end, d, opts...)
+}
+
+// QueryMultiContext works like QueryMulti but stops and returns the error of ctx
+// once ctx is done.
+func (s *Store) QueryMultiContext(ctx context.Context, keys []string, start time.Time, end time.Time, d time.Duration, opts ...QueryOption) (map[string]QuerySet, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m := make(map[string]QuerySet, len(keys))
+	for _, k := range keys {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		x, ok := s.m[k]
+		if !ok {
+			continue
+		}
+		mu := keyLock(s, k)
+		mu.RLock()
+		qs, err := x.Query(start, end, d, opts...)
+		mu.RUnlock()
+		if err != nil {
+			return nil, err
+		}
+		m[k] = qs
+	}
+	return m, nil
+}
+
+// QueryMultiFunc works like QueryMulti but queries the sequences whose key
+// satisfies selector, see KeysFunc.
+func (s *Store) QueryMultiFunc(selector func(key string) bool, start time.Time, end time.Time, d time.Duration, opts ...QueryOption) (map[string]QuerySet, error) {
+	s.rlockAll()
+	defer s.runlockAll()
+	m := make(map[string]QuerySet)
+	for k, x := range s.m {
+		if !selector(k) {
+			continue
+		}
+		qs, err := x.Query(start, end, d, opts...)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = qs
+	}
+	return m, nil
+}
+
+// QueryAggregate executes Sequence.Query() on the sequences whose key satisfies
+// selector under a single lock and returns the combination of the query sets as
+// defined by CombineQuerySets. It returns an error if no key satisfies selector,
+// if the query sets are not compatible or if one of the underlying operations
+// returned an error.
+func (s *Store) QueryAggregate(selector func(key string) bool, start time.Time, end time.Time, d time.Duration, opts ...QueryOption) (QuerySet, error) {
+	s.rlockAll()
+	defer s.runlockAll()
+	var qs QuerySet
+	found := false
+	for k, x := range s.m {
+		if !selector(k) {
+			continue
+		}
+		v, err := x.Query(start, end, d, opts...)
+		if err != nil {
+			return QuerySet{}, err
+		}
+		if !found {
+			qs = QuerySet{Timestamp: v.Timestamp, Frequency: v.Frequency, Sum: v.Sum, Count: v.Count}
+			found = true
+			continue
+		}
+		if err := checkQuerySets([]QuerySet{qs, v}); err != nil {
+			return QuerySet{}, err
+		}
+		for i := range v.Count {
+			qs.Sum[i] += v.Sum[i]
+			qs.Count[i] += v.Count[i]
+		}
+	}
+	if !found {
+		return QuerySet{}, errors.New("no matching key")
+	}
+	return qs, nil
+}
+
+// SetCreatePolicy sets the policy applied to sequences created by statements.
+// Statements violating the policy are rejected with ErrInvalidFrequency,
+// ErrInvalidLength or ErrInvalidHorizon.
+func (s *Store) SetCreatePolicy(p CreatePolicy) {
+	s.mu.Lock()
+	s.policy = p
+	s.mu.Unlock()
 }
 
 // Execute executes a statement against the store, returning an error if the
 // statement cannot be executed or if the underlying operation returned an error.
 func (s *Store) Execute(statement Statement) error {
+	return execute(s, statement.Key, statement)
+}
+
+// execute executes a statement against the store using key as identifier
+// instead of statement.Key, calling hooks if any. Statement.Key is set to key
+// before calling hooks, which may modify it. Accepting keys as a slice of bytes
+// allows callers to execute statements against existing sequences without
+// allocating when no hooks and no write-ahead log are set.
+func execute[K string | []byte](s *Store, key K, statement Statement) error {
+	h := s.hooks.Load()
+	if h == nil {
+		return executeKey(s, key, statement)
+	}
+	statement.Key = string(key)
+	return s.executeHooked(h, statement)
+}
+
+// executeHooked executes a statement against the store, calling the hooks of h
+// around its execution.
+func (s *Store) executeHooked(h *Hooks, statement Statement) error {
+	err := h.before(&statement)
+	if err != nil {
+		s.fail(err)
+	} else {
+		err = executeKey(s, statement.Key, statement)
+	}
+	h.after(statement, err)
+	return err
+}
+
+// executeKey executes a statement against the store using key as identifier
+// without calling hooks.
+func executeKey[K string | []byte](s *Store, key K, statement Statement) error {
+	if ok, err := executeShared(s, key, statement); ok {
+		return err
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.executeUnsafe(statement)
+	if s.wal != nil {
+		_, ok := s.m[string(key)]
+		if err := s.admit(statement, ok); err != nil {
+			return s.fail(err)
+		}
+		statement.Key = string(key)
+		if err := s.wal.append(statement); err != nil {
+			return s.fail(err)
+		}
+	}
+	return executeKeyUnsafe(s, key, statement)
 }
 
 // Batch executes multiple statements against the store. Individual errors are non
 // blocking but can be inspected through BatchResult.
 func (s *Store) Batch(statements []Statement) BatchResult {
+	result := batchResult{errors: make(map[int]error), n: len(statements)}
+	h := s.hooks.Load()
+	if h == nil {
+		s.batch(statements, nil, result)
+		return result
+	}
+	statements = slices.Clone(statements)
+	pending := make([]Statement, 0, len(statements))
+	indices := make([]int, 0, len(statements))
+	for i := range statements {
+		if err := h.before(&statements[i]); err != nil {
+			result.errors[i] = s.fail(err)
+			continue
+		}
+		pending = append(pending, statements[i])
+		indices = append(indices, i)
+	}
+	s.batch(pending, indices, result)
+	for i, v := range statements {
+		h.after(v, result.errors[i])
+	}
+	return result
+}
+
+// batchContextSize is the number of statements executed by BatchContext between
+// two checks of its context.
+const batchContextSize = 256
+
+// BatchContext works like Batch but executes the statements in chunks, releasing
+// the lock of the store between chunks, and stops once ctx is done. Statements
+// that are not executed because ctx is done result in the error of ctx.
+func (s *Store) BatchContext(ctx context.Context, statements []Statement) BatchResult {
+	if ctx.Done() == nil {
+		return s.Batch(statements)
+	}
+	result := batchResult{errors: make(map[int]error), n: len(statements)}
+	for i := 0; i < len(statements); i += batchContextSize {
+		if err := ctx.Err(); err != nil {
+			for j := i; j < len(statements); j++ {
+				result.errors[j] = err
+			}
+			break
+		}
+		chunk := s.Batch(statements[i:min(i+batchContextSize, len(statements))]).(batchResult)
+		for j, err := range chunk.errors {
+			result.errors[i+j] = err
+		}
+	}
+	return result
+}
+
+// batch executes statements against the store without calling hooks, recording
+// errors in result at the indices of the statements, or at indices[i] for the
+// statement i if indices is not nil.
+func (s *Store) batch(statements []Statement, indices []int, result batchResult) {
+	index := func(i int) int {
+		if indices == nil {
+			return i
+		}
+		return indices[i]
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	result := batchResult{errors: make(map[int]error), n: len(statements)}
+	rejected := make(map[int]bool)
+	if s.wal != nil && len(statements) > 0 {
+		// Statements are admitted against the keys of the store as
+		// modified by the preceding statements of the batch, so that
+		// rejected statements are not logged.
+		exists := make(map[string]bool)
+		logged := make([]Statement, 0, len(statements))
+		for i, v := range statements {
+			ok, seen := exists[v.Key]
+			if !seen {
+				_, ok = s.m[v.Key]
+			}
+			if err := s.admit(v, ok); err != nil {
+				result.errors[index(i)] = s.fail(err)
+				rejected[i] = true
+				continue
+			}
+			exists[v.Key] = v.Type != StatementDelete
+			logged = append(logged, v)
+		}
+		if err := s.wal.append(logged...); err != nil {
+			for i := range statements {
+				if !rejected[i] {
+					result.errors[index(i)] = err
+				}
+			}
+			s.stats.errors.Add(uint64(len(logged)))
+			return
+		}
+	}
 	for i, v := range statements {
+		if rejected[i] {
+			continue
+		}
 		if err := s.executeUnsafe(v); err != nil {
-			result.errors[i] = err
+			result.errors[index(i)] = err
 		}
 	}
-	return result
+}
+
+// Transaction executes multiple statements against the store atomically: if one
+// of the statements cannot be executed, no sequence of the store is modified and
+// the error of the first failing statement is returned. Statements are applied to
+// copies of the sequences they target, which replace the sequences of the store
+// once all the statements are executed. Conditional statements skipped with
+// ErrSkipped do not fail the transaction. Hooks are called as in Batch, the After
+// hook receiving the error of the transaction.
+func (s *Store) Transaction(statements []Statement) error {
+	h := s.hooks.Load()
+	if h != nil {
+		statements = slices.Clone(statements)
+	}
+	err := s.transaction(statements, h)
+	if h != nil {
+		for _, v := range statements {
+			h.after(v, err)
+		}
+	}
+	return err
+}
+
+// transaction executes statements atomically, calling the Before hook of h if h
+// is not nil.
+func (s *Store) transaction(statements []Statement, h *Hooks) error {
+	if h != nil {
+		for i := range statements {
+			if err := h.before(&statements[i]); err != nil {
+				return s.fail(err)
+			}
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.transactionUnsafe(statements)
+}
+
+// transactionUnsafe executes statements atomically, logging them as a single
+// record to the write-ahead log of the store, if any, so that they are replayed
+// atomically as well. This method is not goroutine-safe. The caller is
+// responsible for properly acquiring / releasing the lock on the store.
+func (s *Store) transactionUnsafe(statements []Statement) error {
+	tx := NewStore()
+	tx.policy = s.policy
+	for _, v := range statements {
+		if x, ok := s.m[v.Key]; ok {
+			if _, ok := tx.m[v.Key]; !ok {
+				tx.m[v.Key] = x.clone()
+			}
+		}
+	}
+	executed := make([]Statement, 0, len(statements))
+	for _, v := range statements {
+		if _, ok := tx.m[v.Key]; !ok && v.Type == StatementDelete {
+			continue
+		}
+		err := tx.executeUnsafe(v)
+		if err == ErrSkipped {
+			continue
+		}
+		if err != nil {
+			return s.fail(err)
+		}
+		executed = append(executed, v)
+	}
+	if s.wal != nil {
+		if err := s.wal.appendTransaction(statements); err != nil {
+			return s.fail(err)
+		}
+	}
+	for _, v := range executed {
+		x, ok := tx.m[v.Key]
+		switch {
+		case !ok:
+			s.deleteUnsafe(v.Key)
+		case tx.modified[x] > 0 && s.m[v.Key] != x:
+			s.setUnsafe(v.Key, x)
+		}
+	}
+	for i := range tx.stats.executed {
+		s.stats.executed[i].Add(tx.stats.executed[i].Load())
+	}
+	s.stats.skipped.Add(tx.stats.skipped.Load())
+	for _, v := range executed {
+		notify(s, v.Key, v)
+	}
+	return nil
+}
+
+// SetHooks sets the hooks called around the execution of statements using
+// Execute or Batch, replacing the current hooks, if any. Passing zero hooks
+// removes the current hooks. Statements replayed from a write-ahead log, which
+// are logged once processed by hooks, do not go through hooks.
+func (s *Store) SetHooks(h Hooks) {
+	if h.Before == nil && h.After == nil {
+		s.hooks.Store(nil)
+		return
+	}
+	s.hooks.Store(&h)
+}
+
+// SetWAL attaches l to the store, so that statements executed using Execute or
+// Batch are appended to l before being executed. Statements that cannot be
+// appended are not executed and result in the error returned by l. Other
+// modifications of the store, such as Add or Delete, are not logged. Passing a
+// nil WAL detaches the current log, if any.
+func (s *Store) SetWAL(l *WAL) {
+	s.mu.Lock()
+	s.wal = l
+	s.mu.Unlock()
 }
 
 // Keys returns the identifiers known in the store.
@@ -153,62 +891,451 @@ func (s *Store) Keys() []string {
 	return keys
 }
 
-// Dump allows to export the store as a slice of bytes.
-func (s *Store) Dump() ([]byte, error) {
-	var buf bytes.Buffer
+// KeysFunc returns the identifiers known in the store satisfying selector, in no
+// specific order. Keys can for instance be selected using a regular expression,
+// passing the MatchString method of a *regexp.Regexp as selector.
+func (s *Store) KeysFunc(selector func(key string) bool) []string {
 	s.mu.RLock()
-	container := make([]byte, binary.MaxVarintLen64)
-	for k, v := range s.m {
-		for _, data := range [][]byte{[]byte(k), v.Bytes()} {
-			n := binary.PutVarint(container, int64(len(data)))
-			_, err := buf.Write(container[:n])
-			if err != nil {
-				return nil, err
-			}
-			_, err = buf.Write(data)
-			if err != nil {
-				return nil, err
-			}
+	defer s.mu.RUnlock()
+	var keys []string
+	for k := range s.m {
+		if selector(k) {
+			keys = append(keys, k)
 		}
 	}
-	s.mu.RUnlock()
-	return buf.Bytes(), nil
+	return keys
 }
 
-// Load loads the content of a store previously exported using the Dump method.
-func (s *Store) Load(data []byte) error {
-	i := 0
-	var err error
-	s.mu.Lock()
-	s.m = make(map[string]*Sequence)
-	for i < len(data) {
-		v, n := binary.Varint(data[i:])
-		i += n
-		key := string(data[i : i+int(v)])
-		i += int(v)
-		v, n = binary.Varint(data[i:])
-		i += n
-		s.m[key], err = FromBytes(data[i : i+int(v)])
-		if err != nil {
-			return err
+// KeysWithPrefix returns the identifiers known in the store starting with p, in
+// ascending order. Keys are looked up in a sorted index, rebuilt after keys are
+// added or removed, which makes repeated lookups on stable sets of keys cheap.
+func (s *Store) KeysWithPrefix(p string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := s.sortedKeys()
+	i, j := prefixRange(keys, p)
+	return slices.Clone(keys[i:j])
+}
+
+// KeysMatching returns the identifiers known in the store matching glob, as
+// defined by path.Match, in ascending order. Only the keys starting with the
+// literal prefix of glob are matched, see KeysWithPrefix. It returns an error if
+// glob is malformed.
+func (s *Store) KeysMatching(glob string) ([]string, error) {
+	if _, err := path.Match(glob, ""); err != nil {
+		return nil, err
+	}
+	prefix := glob
+	if i := strings.IndexAny(glob, `*?[\`); i >= 0 {
+		prefix = glob[:i]
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := s.sortedKeys()
+	i, j := prefixRange(keys, prefix)
+	var matches []string
+	for _, k := range keys[i:j] {
+		if ok, _ := path.Match(glob, k); ok {
+			matches = append(matches, k)
 		}
-		i += int(v)
 	}
-	s.mu.Unlock()
-	return nil
+	return matches, nil
 }
 
-// Shrink aims at freeing up memory by resetting the store's underlying structures
-// to the minimum required capacity. This is mainly useful for frequently updated
-// collections of rolling sequences that are kept in memory indefinitely. The operation
-// may lead to many allocations and ultimately result in larger memory usage as new
-// values are added to the sequences.
-func (s *Store) Shrink() {
+// SetLabels associates a copy of labels to key, replacing its current labels.
+// Passing empty labels removes the labels of the key. It returns an error if the
+// key does not exist.
+func (s *Store) SetLabels(key string, labels map[string]string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	m := make(map[string]*Sequence, len(s.m))
-	for k := range s.m {
-		s.m[k].Shrink()
+	x, ok := s.m[key]
+	if !ok {
+		return errors.New("key does not exist")
+	}
+	s.setLabelsUnsafe(key, maps.Clone(labels))
+	s.touchUnsafe(x)
+	return nil
+}
+
+// Labels returns a copy of the labels associated to key. The second return value
+// is true if the key exists in the store and false if not.
+func (s *Store) Labels(key string) (map[string]string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.m[key]; !ok {
+		return nil, false
+	}
+	return maps.Clone(s.labels[key]), true
+}
+
+// KeysWithLabels returns the identifiers known in the store whose labels satisfy
+// all matchers, in ascending order. A missing label is considered as having an
+// empty value. It returns an error if one of the matchers is not valid.
+func (s *Store) KeysWithLabels(matchers ...LabelMatcher) ([]string, error) {
+	fns := make([]func(string) bool, len(matchers))
+	for i, v := range matchers {
+		fn, err := v.compile()
+		if err != nil {
+			return nil, err
+		}
+		fns[i] = fn
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys []string
+	for _, k := range s.sortedKeys() {
+		labels := s.labels[k]
+		ok := true
+		for i, v := range matchers {
+			if !fns[i](labels[v.Name]) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// Range calls fn sequentially for each key and sequence of the store, in no
+// specific order, stopping if fn returns false. Sequences are not copied: they
+// must not be modified nor retained after fn returns, and fn must not call
+// methods modifying the store.
+func (s *Store) Range(fn func(key string, x *Sequence) bool) {
+	s.rlockAll()
+	defer s.runlockAll()
+	for k, x := range s.m {
+		if !fn(k, x) {
+			return
+		}
+	}
+}
+
+// Len returns the number of sequences of the store.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.m)
+}
+
+// TotalValues returns the number of values stored in the sequences of the store.
+func (s *Store) TotalValues() int64 {
+	s.rlockAll()
+	defer s.runlockAll()
+	var n int64
+	for _, x := range s.m {
+		n += int64(x.count)
+	}
+	return n
+}
+
+// TotalBytes returns the number of bytes of the encoded values of the sequences
+// of the store, not taking into account the headers of the sequences nor the
+// unused capacity of their underlying structures, see SizeBytes.
+func (s *Store) TotalBytes() int64 {
+	s.rlockAll()
+	defer s.runlockAll()
+	var n int64
+	for _, x := range s.m {
+		n += int64(len(x.data))
+	}
+	return n
+}
+
+// SizeBytes returns the approximate number of bytes of memory used by the
+// sequences of the store and their keys. The overhead of the underlying map is
+// not taken into account.
+func (s *Store) SizeBytes() int {
+	s.rlockAll()
+	defer s.runlockAll()
+	n := 0
+	for k, v := range s.m {
+		n += int(unsafe.Sizeof(k)+unsafe.Sizeof(v)) + len(k) + v.SizeBytes()
+	}
+	return n
+}
+
+// Stats returns runtime metrics of the store. Statements are counted since
+// the creation of the store, including the ones replayed from a write-ahead log.
+func (s *Store) Stats() StoreStats {
+	s.rlockAll()
+	defer s.runlockAll()
+	stats := StoreStats{
+		Sequences:    len(s.m),
+		Adds:         s.stats.executed[StatementAdd].Load(),
+		Rolls:        s.stats.executed[StatementRoll].Load(),
+		RollReplaces: s.stats.executed[StatementRollReplace].Load(),
+		Errors:       s.stats.errors.Load(),
+		Skipped:      s.stats.skipped.Load(),
+	}
+	for _, x := range s.m {
+		stats.TotalValues += int64(x.count)
+		stats.TotalBytes += int64(len(x.data))
+	}
+	for i := range s.stats.executed {
+		stats.Executed += s.stats.executed[i].Load()
+	}
+	if t := s.stats.lastDump.Load(); t != 0 {
+		stats.LastDump = time.Unix(0, t)
+	}
+	return stats
+}
+
+// Dump allows to export the store as a slice of bytes.
+func (s *Store) Dump() ([]byte, error) {
+	s.rlockAll()
+	defer s.runlockAll()
+	return s.dumpUnsafe(context.Background(), nil)
+}
+
+// DumpKeys works like Dump but only exports the sequences associated to keys.
+// Keys that don't exist are omitted from the dump.
+func (s *Store) DumpKeys(keys []string) ([]byte, error) {
+	s.rlockAll()
+	defer s.runlockAll()
+	set := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		set[k] = true
+	}
+	return s.dumpUnsafe(context.Background(), func(key string) bool { return set[key] })
+}
+
+// DumpFunc works like Dump but only exports the sequences whose key satisfies
+// selector, see KeysFunc.
+func (s *Store) DumpFunc(selector func(key string) bool) ([]byte, error) {
+	s.rlockAll()
+	defer s.runlockAll()
+	return s.dumpUnsafe(context.Background(), selector)
+}
+
+// DumpContext works like Dump but stops and returns the error of ctx once ctx
+// is done.
+func (s *Store) DumpContext(ctx context.Context) ([]byte, error) {
+	s.rlockAll()
+	defer s.runlockAll()
+	return s.dumpUnsafe(ctx, nil)
+}
+
+// Load loads the content of a store previously exported using the Dump method.
+// Dumps compressed with gzip, such as those written by DumpGzip, are
+// decompressed transparently, and dumps produced by previous versions of the
+// package, without header nor checksum, are still supported. It returns
+// ErrInvalidChecksum if the checksum of the dump does not match its content.
+func (s *Store) Load(data []byte) error {
+	m, labels, err := decodeDump(bytes.NewReader(data), false)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.replaceUnsafe(m, labels)
+	s.mu.Unlock()
+	return nil
+}
+
+// DumpTo works like Dump but writes the records of the dump to w incrementally
+// instead of building the dump in memory. The output can be loaded using Load or
+// LoadFrom. It returns the first error returned by w.
+func (s *Store) DumpTo(w io.Writer) error {
+	s.rlockAll()
+	defer s.runlockAll()
+	return s.dumpToUnsafe(context.Background(), w)
+}
+
+// DumpToContext works like DumpTo but stops and returns the error of ctx once
+// ctx is done, in which case the output written to w is not a valid dump.
+func (s *Store) DumpToContext(ctx context.Context, w io.Writer) error {
+	s.rlockAll()
+	defer s.runlockAll()
+	return s.dumpToUnsafe(ctx, w)
+}
+
+// dumpToUnsafe works like dumpUnsafe but writes the dump of all sequences to w.
+// This method is not goroutine-safe. The caller is responsible for properly
+// acquiring / releasing the lock on the store.
+func (s *Store) dumpToUnsafe(ctx context.Context, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	h := crc32.NewIEEE()
+	mw := io.MultiWriter(bw, h)
+	buf := appendDumpHeader(nil)
+	var scratch []byte
+	for k, v := range s.m {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := mw.Write(buf); err != nil {
+			return err
+		}
+		scratch = v.AppendBytes(scratch[:0])
+		buf = appendRecord(buf[:0], k, scratch)
+		buf = appendLabels(buf, k, s.labels[k])
+	}
+	buf = append(buf, dumpEnd)
+	h.Write(buf)
+	buf = binary.LittleEndian.AppendUint32(buf, h.Sum32())
+	if _, err := bw.Write(buf); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	s.dumped()
+	return nil
+}
+
+// DumpGzip works like DumpTo but compresses the dump with gzip using level as
+// compression level, see compress/gzip. The output can be loaded using Load or
+// LoadFrom. It returns an error if level is not valid or the first error returned
+// by w.
+func (s *Store) DumpGzip(w io.Writer, level int) error {
+	zw, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return err
+	}
+	if err := s.DumpTo(zw); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// LoadFrom works like Load but reads the dump from r incrementally, until r
+// returns io.EOF. The store is left untouched if an error occurs.
+func (s *Store) LoadFrom(r io.Reader) error {
+	m, labels, err := decodeDump(r, false)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.replaceUnsafe(m, labels)
+	s.mu.Unlock()
+	return nil
+}
+
+// DumpSince works like Dump but only exports the sequences modified since
+// generation gen, along with the keys deleted since then, and returns the
+// current generation of the store, to be used as gen by the next call. Using 0
+// as gen exports all the sequences. Applying the output to a store holding the
+// content of the store at generation gen, using LoadIncremental, results in the
+// content of the store at the returned generation.
+func (s *Store) DumpSince(gen uint64) ([]byte, uint64, error) {
+	s.rlockAll()
+	defer s.runlockAll()
+	buf := appendDumpHeader(nil)
+	var scratch []byte
+	for k, v := range s.m {
+		if s.modified[v] <= gen {
+			continue
+		}
+		scratch = v.AppendBytes(scratch[:0])
+		buf = appendRecord(buf, k, scratch)
+		buf = appendLabels(buf, k, s.labels[k])
+	}
+	for k, g := range s.tombstones {
+		if g > gen {
+			buf = appendTombstone(buf, k)
+		}
+	}
+	s.dumped()
+	return appendDumpTrailer(buf), s.gen, nil
+}
+
+// LoadIncremental applies a dump previously exported using the DumpSince method
+// to the store, replacing the sequences it holds and removing the keys deleted
+// since the generation it was exported from, while leaving the other keys
+// untouched. Full dumps are accepted and only replace the sequences they hold.
+// The labels of the replaced sequences are replaced by those of the dump.
+func (s *Store) LoadIncremental(data []byte) error {
+	m, labels, err := decodeDump(bytes.NewReader(data), true)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range m {
+		if v == nil {
+			s.deleteUnsafe(k)
+		} else {
+			s.setUnsafe(k, v)
+			s.setLabelsUnsafe(k, labels[k])
+		}
+	}
+	return nil
+}
+
+// PruneTombstones discards the deletions of keys tracked up to generation gen,
+// which are then no longer exported by DumpSince. As deleted keys are otherwise
+// tracked indefinitely, it is meant to be called once every consumer of
+// incremental dumps caught up with gen.
+func (s *Store) PruneTombstones(gen uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, g := range s.tombstones {
+		if g <= gen {
+			delete(s.tombstones, k)
+		}
+	}
+}
+
+// DumpPartition allows to export the subset of the store made of the keys belonging
+// to partition p out of n as a slice of bytes. Keys are assigned to partitions by
+// hashing, which allows to back up and restore large stores one partition at a time.
+// The output can be loaded using Load or LoadPartition. It returns an error if p is
+// not a valid partition.
+func (s *Store) DumpPartition(p, n int) ([]byte, error) {
+	if n < 1 || p < 0 || p >= n {
+		return nil, errors.New("invalid partition")
+	}
+	s.rlockAll()
+	defer s.runlockAll()
+	return s.dumpUnsafe(context.Background(), func(key string) bool {
+		return partition(key, n) == p
+	})
+}
+
+// LoadPartition loads the content of partition p out of n previously exported
+// using the DumpPartition method, replacing the keys of the store belonging to the
+// partition while leaving the other keys untouched. It returns an error if p is not
+// a valid partition or if data holds keys that do not belong to the partition.
+func (s *Store) LoadPartition(p, n int, data []byte) error {
+	if n < 1 || p < 0 || p >= n {
+		return errors.New("invalid partition")
+	}
+	m, labels, err := decodeDump(bytes.NewReader(data), false)
+	if err != nil {
+		return err
+	}
+	for k := range m {
+		if partition(k, n) != p {
+			return errors.New("key does not belong to partition")
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.m {
+		if _, ok := m[k]; !ok && partition(k, n) == p {
+			s.deleteUnsafe(k)
+		}
+	}
+	for k, v := range m {
+		s.setUnsafe(k, v)
+		s.setLabelsUnsafe(k, labels[k])
+	}
+	return nil
+}
+
+// Shrink aims at freeing up memory by resetting the store's underlying structures
+// to the minimum required capacity. This is mainly useful for frequently updated
+// collections of rolling sequences that are kept in memory indefinitely. The operation
+// may lead to many allocations and ultimately result in larger memory usage as new
+// values are added to the sequences.
+func (s *Store) Shrink() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := make(map[string]*Sequence, len(s.m))
+	for k := range s.m {
+		s.preserveUnsafe(s.m[k])
+		s.m[k].Shrink()
 		m[k] = s.m[k]
 	}
 	s.m = m
@@ -219,8 +1346,250 @@ func (s *Store) Shrink() {
 func (s *Store) TrimLeft(t time.Time) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	for _, x := range s.m {
+		s.preserveUnsafe(x)
+		if x.TrimLeft(t) == nil {
+			s.touchUnsafe(x)
+		}
+	}
+}
+
+// SetRetention sets the retention policy applied by ApplyRetention to the keys
+// matching glob, as defined by path.Match, replacing the policy previously set
+// for glob, if any. Keys are applied the policy of the first glob they match, in
+// the order globs were first set, so that "*" can be used as a fallback. Setting
+// a zero policy removes the policy of glob. It returns an error if glob is
+// malformed.
+func (s *Store) SetRetention(glob string, p RetentionPolicy) error {
+	if _, err := path.Match(glob, ""); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.retention, func(r retentionRule) bool { return r.glob == glob })
+	switch {
+	case p == RetentionPolicy{}:
+		if i >= 0 {
+			s.retention = slices.Delete(s.retention, i, i+1)
+		}
+	case i >= 0:
+		s.retention[i].policy = p
+	default:
+		s.retention = append(s.retention, retentionRule{glob, p})
+	}
+	return nil
+}
+
+// ApplyRetention trims the sequences of the store according to the retention
+// policies set using SetRetention, discarding values older than now minus their
+// maximum age and the oldest values in excess of their maximum length. It returns
+// the number of trimmed sequences.
+func (s *Store) ApplyRetention(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.retention) == 0 {
+		return 0
+	}
+	n := 0
+	for k, x := range s.m {
+		if s.retainUnsafe(k, x, now) {
+			n++
+		}
+	}
+	return n
+}
+
+// retainUnsafe applies to x, the sequence associated to key, the retention policy
+// of the first rule matching key at time now and reports whether x was trimmed.
+// This method is not goroutine-safe. The caller is responsible for properly
+// acquiring / releasing the lock on the store.
+func (s *Store) retainUnsafe(key string, x *Sequence, now time.Time) bool {
+	for _, r := range s.retention {
+		if ok, _ := path.Match(r.glob, key); !ok {
+			continue
+		}
+		s.preserveUnsafe(x)
+		if !r.policy.apply(x, now) {
+			return false
+		}
+		s.touchUnsafe(x)
+		return true
+	}
+	return false
+}
+
+// dumpUnsafe exports the sequences of the store whose key satisfies filter as a
+// slice of bytes, stopping once ctx is done. If filter is nil all sequences are
+// exported. This method is not goroutine-safe. The caller is responsible for
+// properly acquiring / releasing the lock on the store.
+func (s *Store) dumpUnsafe(ctx context.Context, filter func(key string) bool) ([]byte, error) {
+	buf := appendDumpHeader(nil)
+	var scratch []byte
+	for k, v := range s.m {
+		if filter != nil && !filter(k) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		scratch = v.AppendBytes(scratch[:0])
+		buf = appendRecord(buf, k, scratch)
+		buf = appendLabels(buf, k, s.labels[k])
+	}
+	s.dumped()
+	return appendDumpTrailer(buf), nil
+}
+
+// dumped records the time of a successful dump of the store.
+func (s *Store) dumped() {
+	s.stats.lastDump.Store(time.Now().UnixNano())
+}
+
+// setUnsafe associates x to key, replacing any existing sequence. This method is
+// not goroutine-safe. The caller is responsible for properly acquiring / releasing
+// the lock on the store.
+func (s *Store) setUnsafe(key string, x *Sequence) {
+	if old, ok := s.m[key]; ok {
+		delete(s.modified, old)
+		delete(s.shared, old)
+	} else {
+		s.indexed, s.sorted = false, nil
+	}
+	s.m[key] = x
+	delete(s.tombstones, key)
+	s.touchUnsafe(x)
+}
+
+// deleteUnsafe removes key from the store, tracking the deletion if the key
+// exists, and reports whether the key existed. This method is not goroutine-safe.
+// The caller is responsible for properly acquiring / releasing the lock on the
+// store.
+func (s *Store) deleteUnsafe(key string) bool {
+	x, ok := s.m[key]
+	if !ok {
+		return false
+	}
+	delete(s.m, key)
+	delete(s.modified, x)
+	delete(s.shared, x)
+	delete(s.labels, key)
+	s.indexed, s.sorted = false, nil
+	s.gen++
+	s.tombstones[key] = s.gen
+	return true
+}
+
+// setLabelsUnsafe associates labels to key, which must exist, without copying
+// them. Empty labels remove the labels of the key. This method is not
+// goroutine-safe. The caller is responsible for properly acquiring / releasing
+// the lock on the store.
+func (s *Store) setLabelsUnsafe(key string, labels map[string]string) {
+	if len(labels) == 0 {
+		delete(s.labels, key)
+		return
+	}
+	s.labels[key] = labels
+}
+
+// preserveUnsafe copies the data of x, a sequence of the store about to be
+// modified, if it is shared with snapshots, so that they are not affected by the
+// modification. Snapshots are not locked, as they hold their own copy of x
+// referencing the shared data, which is never modified. This method is not
+// goroutine-safe. The caller is responsible for properly acquiring / releasing
+// the locks on the store and on the sequence.
+func (s *Store) preserveUnsafe(x *Sequence) {
+	s.cow.Lock()
+	_, ok := s.shared[x]
+	delete(s.shared, x)
+	s.cow.Unlock()
+	if ok {
+		x.data = slices.Clone(x.data)
+	}
+}
+
+// touchUnsafe records a modification of x, a sequence of the store. This method
+// is not goroutine-safe. The caller is responsible for properly acquiring /
+// releasing the lock on the store.
+func (s *Store) touchUnsafe(x *Sequence) {
+	s.track.Lock()
+	s.gen++
+	s.modified[x] = s.gen
+	s.track.Unlock()
+}
+
+// sortedKeys returns the keys of the store in ascending order, rebuilding the
+// index if needed. The returned slice must not be modified. This method is not
+// goroutine-safe. The caller is responsible for properly acquiring / releasing
+// the read lock on the store.
+func (s *Store) sortedKeys() []string {
+	s.index.Lock()
+	defer s.index.Unlock()
+	if !s.indexed {
+		s.sorted = make([]string, 0, len(s.m))
+		for k := range s.m {
+			s.sorted = append(s.sorted, k)
+		}
+		sort.Strings(s.sorted)
+		s.indexed = true
+	}
+	return s.sorted
+}
+
+// prefixRange returns the bounds of the range of keys, sorted in ascending
+// order, starting with p.
+func prefixRange(keys []string, p string) (int, int) {
+	i := sort.SearchStrings(keys, p)
+	j := i + sort.Search(len(keys)-i, func(k int) bool {
+		return !strings.HasPrefix(keys[i+k], p)
+	})
+	return i, j
+}
+
+// rlockAll acquires the read locks of the store and of all its stripes, which
+// allows to read all its sequences.
+func (s *Store) rlockAll() {
+	s.mu.RLock()
+	for i := range s.stripes {
+		s.stripes[i].RLock()
+	}
+}
+
+// runlockAll releases the locks acquired by rlockAll.
+func (s *Store) runlockAll() {
+	for i := range s.stripes {
+		s.stripes[i].RUnlock()
+	}
+	s.mu.RUnlock()
+}
+
+// keyLock returns the stripe of s guarding the content of the sequence
+// associated to key.
+func keyLock[K string | []byte](s *Store, key K) *sync.RWMutex {
+	return &s.stripes[partition(key, storeStripes)]
+}
+
+// replaceUnsafe replaces the content of the store with m and the labels of its
+// keys with labels. This method is not goroutine-safe. The caller is responsible
+// for properly acquiring / releasing the lock on the store.
+func (s *Store) replaceUnsafe(m map[string]*Sequence, labels map[string]map[string]string) {
 	for k := range s.m {
-		s.m[k].TrimLeft(t)
+		if _, ok := m[k]; !ok {
+			s.deleteUnsafe(k)
+		}
+	}
+	s.m = m
+	s.modified = make(map[*Sequence]uint64, len(m))
+	clear(s.shared)
+	s.labels = make(map[string]map[string]string, len(labels))
+	for k, v := range labels {
+		if _, ok := m[k]; ok && len(v) > 0 {
+			s.labels[k] = v
+		}
+	}
+	s.indexed, s.sorted = false, nil
+	for k, x := range m {
+		delete(s.tombstones, k)
+		s.touchUnsafe(x)
 	}
 }
 
@@ -229,26 +1598,333 @@ func (s *Store) TrimLeft(t time.Time) {
 // This method is not goroutine-safe. The caller is responsible for properly
 // acquiring / releasing the lock on the store.
 func (s *Store) executeUnsafe(statement Statement) error {
+	return executeKeyUnsafe(s, statement.Key, statement)
+}
+
+// executeShared executes a statement against the sequence associated to key, if
+// it exists, holding the read lock of the store and the lock of the stripe of key
+// only, so that statements against sequences of other stripes can be executed
+// concurrently. The first return value is false if the key does not exist or if
+// the statement is a deletion, in which case the statement is not executed.
+func executeShared[K string | []byte](s *Store, key K, statement Statement) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	x, ok := s.m[string(key)]
+	if !ok || statement.Type == StatementDelete {
+		return false, nil
+	}
 	if statement.Type >= statementUnknown {
-		return errors.New("unknown statement type")
+		return true, s.fail(errors.New("unknown statement type"))
 	}
-	x, ok := s.m[statement.Key]
-	if !ok {
-		if !statement.CreateIfNotExists {
-			return errors.New("key does not exist")
+	mu := keyLock(s, key)
+	mu.Lock()
+	defer mu.Unlock()
+	if s.wal != nil {
+		statement.Key = string(key)
+		if err := s.wal.append(statement); err != nil {
+			return true, s.fail(err)
+		}
+	}
+	return true, applyUnsafe(s, key, x, statement)
+}
+
+// executeKeyUnsafe executes a statement against the store using key as identifier
+// instead of statement.Key. Accepting keys as a slice of bytes allows callers to
+// look up existing sequences without allocating. This function is not goroutine-safe.
+// The caller is responsible for properly acquiring / releasing the lock on the store.
+func executeKeyUnsafe[K string | []byte](s *Store, key K, statement Statement) error {
+	x, ok := s.m[string(key)]
+	if err := s.admit(statement, ok); err != nil {
+		return s.fail(err)
+	}
+	if statement.Type == StatementDelete {
+		if s.deleteUnsafe(string(key)) {
+			s.stats.executed[statement.Type].Add(1)
+			notify(s, key, statement)
 		}
+		return nil
+	}
+	if !ok {
 		x = New(statement.CreateWithTimestamp, statement.CreateWithFrequency)
 		if statement.CreateWithLength > 0 {
-			x.SetLength(statement.CreateWithLength)
+			if err := x.Resize(statement.CreateWithLength); err != nil {
+				return s.fail(err)
+			}
 		}
-		s.m[statement.Key] = x
+		s.setUnsafe(string(key), x)
+	}
+	return applyUnsafe(s, key, x, statement)
+}
+
+// admit returns an error if statement is rejected by the store regardless of the
+// content of the sequence it targets, exists reporting whether its key exists:
+// if its type is unknown, if its key does not exist and cannot be created or if
+// creating the key violates the create policy of the store. Statements are
+// admitted before being logged, so that rejected statements, which may be
+// accepted by a store using a different policy, are never replayed. This method
+// is not goroutine-safe. The caller is responsible for properly acquiring /
+// releasing the lock on the store.
+func (s *Store) admit(statement Statement, exists bool) error {
+	if statement.Type >= statementUnknown {
+		return errors.New("unknown statement type")
+	}
+	if exists || statement.Type == StatementDelete {
+		return nil
+	}
+	if !statement.CreateIfNotExists {
+		return errors.New("key does not exist")
 	}
+	return s.policy.check(statement)
+}
+
+// applyUnsafe applies statement to x, the sequence of the store associated to
+// key, and notifies the watchers of the store. This function is not
+// goroutine-safe. The caller is responsible for properly acquiring / releasing
+// the locks on the store and on the sequence.
+func applyUnsafe[K string | []byte](s *Store, key K, x *Sequence, statement Statement) error {
+	if statement.skips(x) {
+		s.stats.skipped.Add(1)
+		return ErrSkipped
+	}
+	s.preserveUnsafe(x)
 	var err error
 	switch statement.Type {
 	case StatementAdd:
-		err = x.Add(statement.Timestamp, statement.Value)
+		err = x.AddRun(statement.Timestamp, max(statement.Count, 1), statement.Value)
 	case StatementRoll:
 		err = x.Roll(statement.Timestamp, statement.Value)
+	case StatementRollReplace:
+		err = x.RollReplace(statement.Timestamp, statement.Value)
+	case StatementTrimLeft:
+		err = x.TrimLeft(statement.Timestamp)
+	case StatementSetLength:
+		err = x.Resize(statement.Length)
 	}
+	if err != nil {
+		return s.fail(err)
+	}
+	s.touchUnsafe(x)
+	s.stats.executed[statement.Type].Add(1)
+	notify(s, key, statement)
+	return nil
+}
+
+// fail records the failure of a statement and returns err.
+func (s *Store) fail(err error) error {
+	s.stats.errors.Add(1)
 	return err
 }
+
+// Dumps start with a header made of a magic string and the version of the
+// format, and end with an end marker followed by the CRC-32 checksum of the
+// preceding bytes. As the first byte of the magic string and the end marker
+// would be decoded as negative key sizes, they cannot start a record.
+const (
+	dumpMagic   = "\x89RLD"
+	dumpVersion = 2
+	dumpEnd     = 0x01
+)
+
+// Magic bytes of gzip streams. As they would be decoded as a negative key size,
+// they cannot start an uncompressed dump.
+const (
+	gzipID1 = 0x1f
+	gzipID2 = 0x8b
+)
+
+// appendDumpHeader appends the header of a dump to dst and returns the extended
+// buffer.
+func appendDumpHeader(dst []byte) []byte {
+	dst = append(dst, dumpMagic...)
+	return append(dst, dumpVersion)
+}
+
+// appendDumpTrailer appends the end marker and the checksum of a dump to dst,
+// which must hold the dump from its header, and returns the extended buffer.
+func appendDumpTrailer(dst []byte) []byte {
+	dst = append(dst, dumpEnd)
+	return binary.LittleEndian.AppendUint32(dst, crc32.ChecksumIEEE(dst))
+}
+
+// appendRecord appends to dst the record of a dump associating key to data, a
+// sequence represented as a slice of bytes, and returns the extended buffer.
+func appendRecord(dst []byte, key string, data []byte) []byte {
+	dst = binary.AppendVarint(dst, int64(len(key)))
+	dst = append(dst, key...)
+	dst = binary.AppendVarint(dst, int64(len(data)))
+	return append(dst, data...)
+}
+
+// appendTombstone appends to dst the record of an incremental dump representing
+// the deletion of key, made of the key and a sequence size of -1, and returns
+// the extended buffer.
+func appendTombstone(dst []byte, key string) []byte {
+	dst = binary.AppendVarint(dst, int64(len(key)))
+	dst = append(dst, key...)
+	return binary.AppendVarint(dst, -1)
+}
+
+// appendLabels appends to dst the record of a dump associating labels to key,
+// made of the key, a sequence size of -2 and the labels, and returns the
+// extended buffer. Labels are sorted by name and dst is returned unchanged if
+// labels is empty.
+func appendLabels(dst []byte, key string, labels map[string]string) []byte {
+	if len(labels) == 0 {
+		return dst
+	}
+	dst = binary.AppendVarint(dst, int64(len(key)))
+	dst = append(dst, key...)
+	dst = binary.AppendVarint(dst, -2)
+	dst = binary.AppendVarint(dst, int64(len(labels)))
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		dst = binary.AppendVarint(dst, int64(len(k)))
+		dst = append(dst, k...)
+		dst = binary.AppendVarint(dst, int64(len(labels[k])))
+		dst = append(dst, labels[k]...)
+	}
+	return dst
+}
+
+// A dumpReader reads a dump, maintaining the checksum of the bytes read.
+type dumpReader struct {
+	r *bufio.Reader
+	h hash.Hash32
+}
+
+func (r *dumpReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.h.Write(p[:n])
+	return n, err
+}
+
+func (r *dumpReader) ReadByte() (byte, error) {
+	c, err := r.r.ReadByte()
+	if err == nil {
+		r.h.Write([]byte{c})
+	}
+	return c, err
+}
+
+// decodeDump decodes the sequences and labels of a store previously exported
+// using the Dump method, read from r until r returns io.EOF. Keys deleted in
+// incremental dumps are associated to nil sequences if tombstones is true and
+// ignored otherwise.
+func decodeDump(r io.Reader, tombstones bool) (map[string]*Sequence, map[string]map[string]string, error) {
+	br := bufio.NewReader(r)
+	if b, _ := br.Peek(2); len(b) == 2 && b[0] == gzipID1 && b[1] == gzipID2 {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, errors.New("cannot decode the dump")
+		}
+		br = bufio.NewReader(zr)
+	}
+	dr := &dumpReader{r: br, h: crc32.NewIEEE()}
+	versioned := false
+	if b, _ := br.Peek(len(dumpMagic)); string(b) == dumpMagic {
+		header := make([]byte, len(dumpMagic)+1)
+		if _, err := io.ReadFull(dr, header); err != nil {
+			return nil, nil, errors.New("cannot decode the dump")
+		}
+		if v := header[len(dumpMagic)]; v < 1 || v > dumpVersion {
+			return nil, nil, errors.New("unsupported format version")
+		}
+		versioned = true
+	}
+	m := make(map[string]*Sequence)
+	labels := make(map[string]map[string]string)
+	var buf bytes.Buffer
+	for {
+		b, err := br.Peek(1)
+		if err == io.EOF && !versioned {
+			break
+		}
+		if err == nil && versioned && b[0] == dumpEnd {
+			dr.ReadByte()
+			sum := dr.h.Sum32()
+			var trailer [4]byte
+			if _, err := io.ReadFull(br, trailer[:]); err != nil {
+				return nil, nil, errors.New("cannot decode the dump")
+			}
+			if binary.LittleEndian.Uint32(trailer[:]) != sum {
+				return nil, nil, ErrInvalidChecksum
+			}
+			if _, err := br.Peek(1); err != io.EOF {
+				return nil, nil, errors.New("cannot decode the dump")
+			}
+			break
+		}
+		key, err := readDumpString(dr, &buf)
+		if err != nil {
+			return nil, nil, err
+		}
+		n, err := binary.ReadVarint(dr)
+		switch {
+		case err != nil || n < -2:
+			return nil, nil, errors.New("cannot decode the dump")
+		case n == -1:
+			if tombstones {
+				m[key] = nil
+			} else {
+				delete(m, key)
+			}
+			delete(labels, key)
+		case n == -2:
+			count, err := binary.ReadVarint(dr)
+			if err != nil || count < 0 {
+				return nil, nil, errors.New("cannot decode the dump")
+			}
+			l := make(map[string]string)
+			for i := int64(0); i < count; i++ {
+				name, err := readDumpString(dr, &buf)
+				if err != nil {
+					return nil, nil, err
+				}
+				if l[name], err = readDumpString(dr, &buf); err != nil {
+					return nil, nil, err
+				}
+			}
+			labels[key] = l
+		default:
+			buf.Reset()
+			if _, err := io.CopyN(&buf, dr, n); err != nil {
+				return nil, nil, errors.New("cannot decode the dump")
+			}
+			x, err := FromBytes(buf.Bytes())
+			if err != nil {
+				return nil, nil, err
+			}
+			m[key] = x
+		}
+	}
+	return m, labels, nil
+}
+
+// readDumpString reads a string of a dump, made of its size and its bytes,
+// using buf as scratch buffer.
+func readDumpString(r *dumpReader, buf *bytes.Buffer) (string, error) {
+	n, err := binary.ReadVarint(r)
+	if err != nil || n < 0 {
+		return "", errors.New("cannot decode the dump")
+	}
+	buf.Reset()
+	if _, err := io.CopyN(buf, r, n); err != nil {
+		return "", errors.New("cannot decode the dump")
+	}
+	return buf.String(), nil
+}
+
+// partition returns the partition of key out of n using the 32-bit FNV-1a
+// hash of the key.
+func partition[K string | []byte](key K, n int) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % uint32(n))
+}
@@ -1,9 +1,20 @@
 package sequence
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"maps"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -49,6 +60,38 @@ func TestStoredDelete(t *testing.T) {
 	}
 }
 
+func TestStoreRename(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	store.Add("k1", NewWithValues(x, testSequenceFrequency, []uint8{1, 1, 0}))
+	store.New(x, testSequenceFrequency, "k2")
+	store.SetLabels("k1", map[string]string{"host": "h1"})
+	want := store.m["k1"]
+	if err := store.Rename("k1", "k3"); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if _, ok := store.m["k1"]; ok {
+		t.Fatal("key k1 should not exist in store")
+	}
+	if got := store.m["k3"]; got != want {
+		t.Fatalf("got %p, want %p", got, want)
+	}
+	if got, _ := store.Labels("k3"); got["host"] != "h1" {
+		t.Fatalf("got %v, want labels of k1", got)
+	}
+	if keys := store.KeysWithPrefix("k"); !slices.Equal(keys, []string{"k2", "k3"}) {
+		t.Fatalf("got %v, want [k2 k3]", keys)
+	}
+	if err := store.Rename("k3", "k3"); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	for _, v := range [][2]string{{"k1", "k4"}, {"k3", "k2"}} {
+		if err := store.Rename(v[0], v[1]); err == nil {
+			t.Fatalf("%s to %s: got error nil, want non nil error", v[0], v[1])
+		}
+	}
+}
+
 func TestStoreGet(t *testing.T) {
 	store := NewStore()
 	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
@@ -70,6 +113,51 @@ func TestStoreGet(t *testing.T) {
 	}
 }
 
+func TestStoreGetMulti(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	store.Add("k1", NewWithValues(x, testSequenceFrequency, []uint8{1, 1, 0}))
+	store.Add("k2", NewWithValues(x, testSequenceFrequency, []uint8{0}))
+	store.Add("k3", NewWithValues(x, testSequenceFrequency, []uint8{1}))
+	m := store.GetMulti([]string{"k1", "k3", "k4"})
+	if len(m) != 2 {
+		t.Fatalf("got %d sequences, want 2", len(m))
+	}
+	for _, k := range []string{"k1", "k3"} {
+		if m[k] == store.m[k] || !assertSequencesEqual(m[k], store.m[k]) {
+			t.Fatalf("%s: got %+v, want a copy of %+v", k, m[k], store.m[k])
+		}
+	}
+}
+
+func TestStoreUpdate(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	store.Add("k1", NewWithValues(x, testSequenceFrequency, []uint8{1, 1, 0, 1}))
+	_, gen, _ := store.DumpSince(0)
+	t2 := x.Add(2 * time.Duration(testSequenceFrequency) * time.Second)
+	err := store.Update("k1", func(x *Sequence) error {
+		return x.TrimLeft(t2)
+	})
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	want := NewWithValues(t2, testSequenceFrequency, []uint8{0, 1})
+	if got := store.m["k1"]; !assertSequencesEqual(got, want) {
+		t.Fatalf("\ngot  %+v\nwant %+v", got, want)
+	}
+	if _, next, _ := store.DumpSince(gen); next == gen {
+		t.Fatal("got unchanged generation, want update to be tracked")
+	}
+	errUpdate := errors.New("update")
+	if err := store.Update("k1", func(x *Sequence) error { return errUpdate }); err != errUpdate {
+		t.Fatalf("got error %v, want %v", err, errUpdate)
+	}
+	if err := store.Update("k2", func(x *Sequence) error { return nil }); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
 func TestStoreDumpLoad(t *testing.T) {
 	src := NewStore()
 	t1, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
@@ -99,6 +187,331 @@ func TestStoreDumpLoad(t *testing.T) {
 	}
 }
 
+func TestStoreDumpKeysFunc(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	src := NewStore()
+	for _, k := range []string{"host1.icmp", "host1.http", "host2.icmp"} {
+		src.Add(k, NewWithValues(x, testSequenceFrequency, []uint8{1, 0}))
+	}
+	src.SetLabels("host1.http", map[string]string{"probe": "http"})
+	a, err := src.DumpKeys([]string{"host1.http", "host2.icmp", "host1.http", "host3.icmp"})
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	b, err := src.DumpFunc(func(key string) bool { return key != "host1.icmp" })
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	for _, dump := range [][]byte{a, b} {
+		dst := NewStore()
+		if err := dst.Load(dump); err != nil {
+			t.Fatalf("got error %s, want error nil", err)
+		}
+		if keys := dst.KeysWithPrefix(""); !slices.Equal(keys, []string{"host1.http", "host2.icmp"}) {
+			t.Fatalf("got %v, want [host1.http host2.icmp]", keys)
+		}
+		if got, _ := dst.Labels("host1.http"); got["probe"] != "http" {
+			t.Fatalf("got %v, want labels of host1.http", got)
+		}
+	}
+}
+
+func TestStoreDumpIntegrity(t *testing.T) {
+	src := NewStore()
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	src.Add("k1", NewWithValues(x, testSequenceFrequency, newSliceOfValues(12, 0)))
+	src.Add("k2", NewWithValues(x, testSequenceFrequency, []uint8{0, 1, 1, 2}))
+	dump, _ := src.Dump()
+	if !bytes.HasPrefix(dump, []byte("\x89RLD\x02")) {
+		t.Fatalf("got prefix %x, want 89524c4402", dump[:min(5, len(dump))])
+	}
+	var w bytes.Buffer
+	src.DumpTo(&w)
+	if n, m := w.Len(), len(dump); n != m {
+		t.Fatalf("got %d bytes, want %d", n, m)
+	}
+	dst := NewStore()
+	dst.Add("k", New(x, testSequenceFrequency))
+	for i := 1; i < len(dump); i++ {
+		if err := dst.Load(dump[:i]); err == nil {
+			t.Fatalf("truncated at %d: got error nil, want non nil error", i)
+		}
+		corrupted := bytes.Clone(dump)
+		corrupted[i-1] ^= 0x04
+		if err := dst.Load(corrupted); err == nil {
+			t.Fatalf("byte %d: got error nil, want non nil error", i-1)
+		}
+	}
+	if _, ok := dst.m["k"]; !ok || len(dst.m) != 1 {
+		t.Fatal("store was modified")
+	}
+	corrupted := bytes.Clone(dump)
+	corrupted[len(corrupted)-1] ^= 0x01
+	if err := dst.Load(corrupted); err != ErrInvalidChecksum {
+		t.Fatalf("got error %v, want ErrInvalidChecksum", err)
+	}
+	if err := dst.Load(append(bytes.Clone(dump), 0x00)); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	unsupported := bytes.Clone(dump)
+	unsupported[4] = 3
+	if err := dst.Load(unsupported); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+
+	var legacy []byte
+	for k, v := range src.m {
+		legacy = appendRecord(legacy, k, v.Bytes())
+	}
+	for i, data := range [][]byte{legacy, nil} {
+		if err := dst.Load(data); err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", i+1, err)
+		}
+	}
+	if err := dst.Load(legacy); err != nil || len(dst.m) != 2 {
+		t.Fatalf("got error %v and %d sequences, want error nil and 2 sequences", err, len(dst.m))
+	}
+	if err := dst.Load(legacy[:len(legacy)-1]); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	empty, _ := NewStore().Dump()
+	if err := dst.Load(empty); err != nil || len(dst.m) != 0 {
+		t.Fatalf("got error %v and %d sequences, want error nil and 0 sequences", err, len(dst.m))
+	}
+}
+
+func TestStoreDumpSince(t *testing.T) {
+	src := NewStore()
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	for i := 0; i < 10; i++ {
+		src.New(x, testSequenceFrequency, fmt.Sprintf("k%d", i))
+	}
+	full, gen, err := src.DumpSince(0)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	dst := NewStore()
+	if err := dst.Load(full); err != nil || len(dst.m) != 10 {
+		t.Fatalf("got error %v and %d sequences, want error nil and 10 sequences", err, len(dst.m))
+	}
+
+	src.Execute(Statement{Key: "k1", Timestamp: x, Value: StateActive, Type: StatementAdd})
+	src.Execute(Statement{Key: "k2", Timestamp: x, Value: StateActive, Type: StatementAdd})
+	src.Execute(Statement{Key: "k2", Timestamp: x, Value: StateActive, Type: StatementAdd})
+	src.Execute(Statement{Key: "new", Timestamp: x, Type: StatementAdd, CreateIfNotExists: true, CreateWithTimestamp: x, CreateWithFrequency: 60})
+	src.Delete("k3")
+	src.Delete("k4")
+	src.Add("k4", NewWithValues(x, testSequenceFrequency, []uint8{1, 0}))
+	src.Delete("unknown")
+	diff, next, err := src.DumpSince(gen)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if next <= gen {
+		t.Fatalf("got generation %d, want more than %d", next, gen)
+	}
+	m, _, err := decodeDump(bytes.NewReader(diff), true)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if got := len(m); got != 5 {
+		t.Fatalf("got %d records, want 5", got)
+	}
+	if x, ok := m["k3"]; !ok || x != nil {
+		t.Fatalf("got %v, %t, want deletion of k3", x, ok)
+	}
+	if err := dst.LoadIncremental(diff); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if n, m := len(dst.m), len(src.m); n != m {
+		t.Fatalf("got %d sequences, want %d", n, m)
+	}
+	for k := range src.m {
+		if v, ok := dst.m[k]; !ok || !assertSequencesEqual(src.m[k], v) {
+			t.Fatalf("key %q: got %+v, want %+v", k, v, src.m[k])
+		}
+	}
+	if diff, gen, _ := src.DumpSince(next); gen != next || len(diff) != 10 {
+		t.Fatalf("got generation %d and %d bytes, want generation %d and 10 bytes", gen, len(diff), next)
+	}
+
+	other := NewStore()
+	if err := other.Load(diff); err != nil || len(other.m) != 4 {
+		t.Fatalf("got error %v and %d sequences, want error nil and 4 sequences", err, len(other.m))
+	}
+	if err := other.LoadFrom(bytes.NewReader(diff)); err != nil || len(other.m) != 4 {
+		t.Fatalf("got error %v and %d sequences, want error nil and 4 sequences", err, len(other.m))
+	}
+
+	src.TrimLeft(x.Add(time.Minute))
+	if diff, _, _ := src.DumpSince(next); bytes.Equal(diff, appendDumpTrailer(appendDumpHeader(nil))) {
+		t.Fatal("got empty dump, want trimmed sequences")
+	}
+	src.PruneTombstones(next)
+	if _, ok := src.tombstones["k3"]; ok {
+		t.Fatal("got tombstone for k3, want none")
+	}
+	if err := src.Load(full); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if _, ok := src.tombstones["new"]; !ok {
+		t.Fatal("got no tombstone for new, want one")
+	}
+}
+
+func TestStoreDumpToLoadFrom(t *testing.T) {
+	src := NewStore()
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	for i := 0; i < 2000; i++ {
+		src.Add(fmt.Sprintf("k%d", i), NewWithValues(x, testSequenceFrequency, newSliceOfValues(i%50+1, uint8(i%2))))
+	}
+	src.Add("", New(x, testSequenceFrequency))
+	var w chunkWriter
+	if err := src.DumpTo(&w); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if w.writes < 2 {
+		t.Fatalf("got %d writes, want more than 1", w.writes)
+	}
+	for i, load := range []func(*Store, []byte) error{
+		func(s *Store, data []byte) error { return s.Load(data) },
+		func(s *Store, data []byte) error { return s.LoadFrom(iotest.OneByteReader(bytes.NewReader(data))) },
+	} {
+		dst := NewStore()
+		if err := load(dst, w.Bytes()); err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", i+1, err)
+		}
+		if n, m := len(dst.m), len(src.m); n != m {
+			t.Fatalf("test %d: got %d sequences, want %d", i+1, n, m)
+		}
+		for k := range src.m {
+			if v, ok := dst.m[k]; !ok || !assertSequencesEqual(src.m[k], v) {
+				t.Fatalf("test %d: key %q: got %+v, want %+v", i+1, k, v, src.m[k])
+			}
+		}
+	}
+
+	dst := NewStore()
+	dst.Add("k", New(x, testSequenceFrequency))
+	data := w.Bytes()
+	invalid := [][]byte{
+		data[:len(data)-1],
+		{0x01},
+		{0x02, 'k', 0xfe, 0xff, 0xff, 0xff, 0x0f},
+		{0x02, 'k', 0x02, 0x00, 0x00},
+	}
+	for i, data := range invalid {
+		if err := dst.LoadFrom(bytes.NewReader(data)); err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", i+1)
+		}
+		if _, ok := dst.m["k"]; !ok || len(dst.m) != 1 {
+			t.Fatalf("test %d: store was modified", i+1)
+		}
+	}
+	if err := dst.LoadFrom(bytes.NewReader(nil)); err != nil || len(dst.m) != 0 {
+		t.Fatalf("got error %v and %d sequences, want error nil and 0 sequences", err, len(dst.m))
+	}
+	if err := src.DumpTo(failingWriter{}); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestStoreDumpGzip(t *testing.T) {
+	src := NewStore()
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	for i := 0; i < 200; i++ {
+		src.Add(fmt.Sprintf("k%d", i), NewWithValues(x, testSequenceFrequency, newSliceOfValues(i%50+1, uint8(i%2))))
+	}
+	raw, _ := src.Dump()
+	var buf bytes.Buffer
+	if err := src.DumpGzip(&buf, gzip.BestCompression); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	data := buf.Bytes()
+	if len(data) >= len(raw) {
+		t.Fatalf("got %d bytes, want less than %d", len(data), len(raw))
+	}
+	for i, load := range []func(*Store, []byte) error{
+		func(s *Store, data []byte) error { return s.Load(data) },
+		func(s *Store, data []byte) error { return s.LoadFrom(iotest.OneByteReader(bytes.NewReader(data))) },
+		func(s *Store, data []byte) error { return s.LoadPartition(0, 1, data) },
+	} {
+		dst := NewStore()
+		if err := load(dst, data); err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", i+1, err)
+		}
+		if n, m := len(dst.m), len(src.m); n != m {
+			t.Fatalf("test %d: got %d sequences, want %d", i+1, n, m)
+		}
+		for k := range src.m {
+			if v, ok := dst.m[k]; !ok || !assertSequencesEqual(src.m[k], v) {
+				t.Fatalf("test %d: key %q: got %+v, want %+v", i+1, k, v, src.m[k])
+			}
+		}
+	}
+	dst := NewStore()
+	for i, data := range [][]byte{data[:len(data)-1], data[:len(data)/2], {0x1f, 0x8b}} {
+		if err := dst.Load(data); err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", i+1)
+		}
+		if err := dst.LoadFrom(bytes.NewReader(data)); err == nil {
+			t.Fatalf("test %d: got error nil, want non nil error", i+1)
+		}
+	}
+	if err := src.DumpGzip(&buf, 42); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if err := src.DumpGzip(failingWriter{}, gzip.DefaultCompression); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestStoreDumpLoadPartition(t *testing.T) {
+	src := NewStore()
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	for i := 0; i < 20; i++ {
+		src.Add(fmt.Sprintf("k%d", i), NewWithValues(x, testSequenceFrequency, newSliceOfValues(i+1, 1)))
+	}
+	dst := NewStore()
+	dst.Add("k0", New(x, testSequenceFrequency))
+	dst.Add("other", New(x, testSequenceFrequency))
+	n := 3
+	dumps := make([][]byte, n)
+	for p := 0; p < n; p++ {
+		var err error
+		dumps[p], err = src.DumpPartition(p, n)
+		if err != nil {
+			t.Fatalf("got error %s, want error nil", err)
+		}
+		if err := dst.LoadPartition(p, n, dumps[p]); err != nil {
+			t.Fatalf("got error %s, want error nil", err)
+		}
+	}
+	if _, ok := dst.m["other"]; ok {
+		t.Fatal("key should not exist in store")
+	}
+	if n, m := len(dst.m), len(src.m); n != m {
+		t.Fatalf("got %d, want %d", n, m)
+	}
+	for k := range src.m {
+		v, ok := dst.m[k]
+		if !ok {
+			t.Fatalf("key %s should exist in store", k)
+		}
+		if !assertSequencesEqual(v, src.m[k]) {
+			t.Fatalf("\ngot  %+v\nwant %+v", v, src.m[k])
+		}
+	}
+	if err := dst.LoadPartition(0, n, dumps[1]); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	for _, p := range [][2]int{{-1, 3}, {3, 3}, {0, 0}} {
+		if _, err := src.DumpPartition(p[0], p[1]); err == nil {
+			t.Fatalf("partition %v: got error nil, want non nil error", p)
+		}
+	}
+}
+
 func TestStoreKeys(t *testing.T) {
 	store := NewStore()
 	t1, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
@@ -124,6 +537,225 @@ func TestStoreKeys(t *testing.T) {
 	}
 }
 
+func TestStoreKeysWithPrefix(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	for _, k := range []string{"dc1.rack1.host1.icmp", "dc1.rack1.host1.http", "dc1.rack2.host3.icmp", "dc2.rack1.host1.icmp", "dc10.rack1.host1.icmp"} {
+		store.New(x, testSequenceFrequency, k)
+	}
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"dc1.", []string{"dc1.rack1.host1.http", "dc1.rack1.host1.icmp", "dc1.rack2.host3.icmp"}},
+		{"dc1", []string{"dc1.rack1.host1.http", "dc1.rack1.host1.icmp", "dc1.rack2.host3.icmp", "dc10.rack1.host1.icmp"}},
+		{"dc2.rack1.host1.icmp", []string{"dc2.rack1.host1.icmp"}},
+		{"dc3", []string{}},
+	}
+	for i, tt := range tests {
+		if got := store.KeysWithPrefix(tt.prefix); !slices.Equal(got, tt.want) {
+			t.Fatalf("test %d: got %v, want %v", i+1, got, tt.want)
+		}
+	}
+	store.New(x, testSequenceFrequency, "dc2.rack1.host2.icmp")
+	store.Delete("dc2.rack1.host1.icmp")
+	if got, want := store.KeysWithPrefix("dc2"), []string{"dc2.rack1.host2.icmp"}; !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestStoreKeysMatching(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	for _, k := range []string{"dc1.rack1.host1.icmp", "dc1.rack1.host1.http", "dc1.rack2.host3.icmp", "dc2.rack1.host1.icmp"} {
+		store.New(x, testSequenceFrequency, k)
+	}
+	tests := []struct {
+		glob string
+		want []string
+	}{
+		{"dc1.*.icmp", []string{"dc1.rack1.host1.icmp", "dc1.rack2.host3.icmp"}},
+		{"*.rack1.*", []string{"dc1.rack1.host1.http", "dc1.rack1.host1.icmp", "dc2.rack1.host1.icmp"}},
+		{"dc?.rack1.host1.icmp", []string{"dc1.rack1.host1.icmp", "dc2.rack1.host1.icmp"}},
+		{"dc1.rack[2-3].*", []string{"dc1.rack2.host3.icmp"}},
+		{"dc1.rack1.host1.icmp", []string{"dc1.rack1.host1.icmp"}},
+		{"dc3.*", nil},
+	}
+	for i, tt := range tests {
+		got, err := store.KeysMatching(tt.glob)
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", i+1, err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Fatalf("test %d: got %v, want %v", i+1, got, tt.want)
+		}
+	}
+	if _, err := store.KeysMatching("dc1.[rack"); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestStoreLabels(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	labels := map[string]map[string]string{
+		"k1": {"dc": "dc1", "probe": "icmp"},
+		"k2": {"dc": "dc1", "probe": "http"},
+		"k3": {"dc": "dc2", "probe": "icmp"},
+		"k4": nil,
+	}
+	for k, v := range labels {
+		store.New(x, testSequenceFrequency, k)
+		if err := store.SetLabels(k, v); err != nil {
+			t.Fatalf("got error %s, want error nil", err)
+		}
+	}
+	if err := store.SetLabels("k5", labels["k1"]); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	labels["k1"]["dc"] = "dc3"
+	if got, ok := store.Labels("k1"); !ok || got["dc"] != "dc1" {
+		t.Fatalf("got %v, want labels of k1 not to be modified", got)
+	}
+	tests := []struct {
+		matchers []LabelMatcher
+		want     []string
+	}{
+		{nil, []string{"k1", "k2", "k3", "k4"}},
+		{[]LabelMatcher{{Name: "dc", Value: "dc1", Type: MatchEqual}}, []string{"k1", "k2"}},
+		{[]LabelMatcher{{Name: "dc", Value: "dc1", Type: MatchNotEqual}}, []string{"k3", "k4"}},
+		{[]LabelMatcher{{Name: "dc", Value: "", Type: MatchEqual}}, []string{"k4"}},
+		{[]LabelMatcher{{Name: "probe", Value: "icmp|http", Type: MatchRegexp}}, []string{"k1", "k2", "k3"}},
+		{[]LabelMatcher{{Name: "probe", Value: "icm", Type: MatchRegexp}}, nil},
+		{[]LabelMatcher{{Name: "probe", Value: "h.*", Type: MatchNotRegexp}}, []string{"k1", "k3", "k4"}},
+		{[]LabelMatcher{{Name: "dc", Value: "dc1", Type: MatchEqual}, {Name: "probe", Value: "icmp", Type: MatchEqual}}, []string{"k1"}},
+	}
+	for i, tt := range tests {
+		got, err := store.KeysWithLabels(tt.matchers...)
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", i+1, err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Fatalf("test %d: got %v, want %v", i+1, got, tt.want)
+		}
+	}
+	for _, v := range []LabelMatcher{{Name: "dc", Value: "(", Type: MatchRegexp}, {Name: "dc", Type: matchUnknown}} {
+		if _, err := store.KeysWithLabels(v); err == nil {
+			t.Fatal("got error nil, want non nil error")
+		}
+	}
+	dump, err := store.Dump()
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	dst := NewStore()
+	if err := dst.Load(dump); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	for _, k := range []string{"k1", "k2", "k3", "k4"} {
+		want, _ := store.Labels(k)
+		if got, ok := dst.Labels(k); !ok || !maps.Equal(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+	store.Delete("k1")
+	if _, ok := store.Labels("k1"); ok {
+		t.Fatal("labels of k1 should not exist")
+	}
+}
+
+func TestStoreRange(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	for i := 0; i < 5; i++ {
+		store.Add(fmt.Sprintf("k%d", i), NewWithValues(x, testSequenceFrequency, newSliceOfValues(i+1, 1)))
+	}
+	seen := make(map[string]bool)
+	store.Range(func(key string, x *Sequence) bool {
+		if x != store.m[key] {
+			t.Fatalf("key %q: got a copy, want the stored sequence", key)
+		}
+		seen[key] = true
+		return true
+	})
+	if len(seen) != 5 {
+		t.Fatalf("got %d keys, want 5", len(seen))
+	}
+	n := 0
+	store.Range(func(key string, x *Sequence) bool {
+		n++
+		return n < 2
+	})
+	if n != 2 {
+		t.Fatalf("got %d calls, want 2", n)
+	}
+}
+
+func TestStoreLen(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	if n, values, size := store.Len(), store.TotalValues(), store.TotalBytes(); n != 0 || values != 0 || size != 0 {
+		t.Fatalf("got %d, %d, %d, want 0, 0, 0", n, values, size)
+	}
+	store.Add("k1", NewWithValues(x, testSequenceFrequency, []uint8{1, 1, 1, 0}))
+	store.Add("k2", NewWithValues(x, testSequenceFrequency, newSliceOfValues(200, 0)))
+	store.New(x, testSequenceFrequency, "k3")
+	if n, values, size := store.Len(), store.TotalValues(), store.TotalBytes(); n != 3 || values != 204 || size != 4 {
+		t.Fatalf("got %d, %d, %d, want 3, 204, 4", n, values, size)
+	}
+}
+
+func TestStoreStats(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	if got := store.Stats(); got != (StoreStats{}) {
+		t.Fatalf("got %+v, want %+v", got, StoreStats{})
+	}
+	store.Add("k1", NewWithValues(x, testSequenceFrequency, []uint8{1, 1, 1, 0}))
+	statements := []Statement{
+		{Key: "k1", Timestamp: x.Add(4 * time.Duration(testSequenceFrequency) * time.Second), Value: StateActive, Type: StatementAdd},
+		{Key: "k1", Timestamp: x, Value: StateActive, Type: StatementAdd},
+		{Key: "k2", Timestamp: x, Value: StateActive, Type: StatementRoll, CreateIfNotExists: true, CreateWithTimestamp: x},
+		{Key: "k2", Timestamp: x, Value: StateInactive, Type: StatementRollReplace},
+		{Key: "k3", Timestamp: x, Value: StateActive, Type: StatementAdd},
+		{Key: "k1", Type: statementUnknown},
+	}
+	for _, v := range statements {
+		store.Execute(v)
+	}
+	before := time.Now()
+	if _, err := store.Dump(); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	got := store.Stats()
+	if got.LastDump.Before(before) || got.LastDump.After(time.Now()) {
+		t.Fatalf("got last dump %s, want between %s and now", got.LastDump, before)
+	}
+	got.LastDump = time.Time{}
+	want := StoreStats{Sequences: 2, TotalValues: 6, TotalBytes: 4, Executed: 3, Adds: 1, Rolls: 1, RollReplaces: 1, Errors: 3}
+	if got != want {
+		t.Fatalf("\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestStoreSizeBytes(t *testing.T) {
+	store := NewStore()
+	if n := store.SizeBytes(); n != 0 {
+		t.Fatalf("got %d, want 0", n)
+	}
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	store.Add("k1", s)
+	n := store.SizeBytes()
+	if min := s.SizeBytes() + len("k1"); n <= min {
+		t.Fatalf("got %d, want more than %d", n, min)
+	}
+	store.Add("key2", s)
+	if got, want := store.SizeBytes(), 2*n+2; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+}
+
 func TestStoreExecuteUnknownStatement(t *testing.T) {
 	x := time.Now()
 	statement := Statement{
@@ -166,12 +798,13 @@ func TestStoreExecute(t *testing.T) {
 		id        string
 		statement Statement
 	}{
-		{"Add1", Statement{"k1", x.Add(time.Duration(8*f) * time.Second), StateActive, StatementAdd, true, x, f, 0}},
-		{"Add2", Statement{"k1", x.Add(time.Duration(8*f) * time.Second), StateActive, StatementAdd, true, x, f, 10}},
-		{"Add3", Statement{"k1", x.Add(-time.Duration(f) * time.Second), StateActive, StatementAdd, true, x, f, 0}},
-		{"Roll1", Statement{"k1", x.Add(time.Duration(8*f) * time.Second), StateActive, StatementRoll, true, x, f, 0}},
-		{"Roll2", Statement{"k1", x.Add(time.Duration(8*f) * time.Second), StateActive, StatementRoll, true, x, f, 5}},
-		{"Roll3", Statement{"k1", x.Add(-time.Duration(f) * time.Second), StateActive, StatementRoll, true, x, f, 0}},
+		{"Add1", Statement{"k1", x.Add(time.Duration(8*f) * time.Second), StateActive, StatementAdd, true, x, f, 0, 0, 0, false, 0}},
+		{"Add2", Statement{"k1", x.Add(time.Duration(8*f) * time.Second), StateActive, StatementAdd, true, x, f, 10, 0, 0, false, 0}},
+		{"Add3", Statement{"k1", x.Add(-time.Duration(f) * time.Second), StateActive, StatementAdd, true, x, f, 0, 0, 0, false, 0}},
+		{"Roll1", Statement{"k1", x.Add(time.Duration(8*f) * time.Second), StateActive, StatementRoll, true, x, f, 0, 0, 0, false, 0}},
+		{"Roll2", Statement{"k1", x.Add(time.Duration(8*f) * time.Second), StateActive, StatementRoll, true, x, f, 5, 0, 0, false, 0}},
+		{"Roll3", Statement{"k1", x.Add(-time.Duration(f) * time.Second), StateActive, StatementRoll, true, x, f, 0, 0, 0, false, 0}},
+		{"RollReplace1", Statement{"k1", x.Add(time.Duration(8*f) * time.Second), StateActive, StatementRollReplace, true, x, f, 5, 0, 0, false, 0}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.id, func(t *testing.T) {
@@ -180,10 +813,13 @@ func TestStoreExecute(t *testing.T) {
 			if tt.statement.CreateWithLength > 0 {
 				want.seq.SetLength(tt.statement.CreateWithLength)
 			}
-			if tt.statement.Type == StatementAdd {
+			switch tt.statement.Type {
+			case StatementAdd:
 				want.err = want.seq.Add(tt.statement.Timestamp, tt.statement.Value)
-			} else {
+			case StatementRoll:
 				want.err = want.seq.Roll(tt.statement.Timestamp, tt.statement.Value)
+			case StatementRollReplace:
+				want.err = want.seq.RollReplace(tt.statement.Timestamp, tt.statement.Value)
 			}
 			store := NewStore()
 			got.err = store.Execute(tt.statement)
@@ -208,6 +844,269 @@ func TestStoreExecute(t *testing.T) {
 	}
 }
 
+func TestStoreExecuteRun(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	f := time.Duration(testSequenceFrequency) * time.Second
+	store := NewStore()
+	statements := []Statement{
+		{Key: "k1", Timestamp: x, Value: StateActive, Type: StatementAdd, CreateIfNotExists: true, CreateWithTimestamp: x, CreateWithFrequency: testSequenceFrequency, Count: 120},
+		{Key: "k1", Timestamp: x.Add(120 * f), Value: StateInactive, Type: StatementAdd},
+		{Key: "k1", Timestamp: x.Add(121 * f), Value: StateActive, Type: StatementAdd, Count: 1},
+	}
+	for _, v := range statements {
+		if err := store.Execute(v); err != nil {
+			t.Fatalf("got error %s, want error nil", err)
+		}
+	}
+	want := append(newSliceOfValues(120, StateActive), StateInactive, StateActive)
+	if got := store.m["k1"].All(); !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if err := store.Execute(Statement{Key: "k1", Timestamp: x, Value: StateActive, Type: StatementAdd, Count: 10}); err != ErrAlreadySet {
+		t.Fatalf("got error %v, want ErrAlreadySet", err)
+	}
+}
+
+func TestStoreExecuteConditional(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	store.Add("k1", NewWithValues(x, 60, []uint8{1, 1, 0}))
+	tests := []struct {
+		statement Statement
+		want      error
+	}{
+		{Statement{Key: "k1", Timestamp: x.Add(3 * time.Minute), Value: StateInactive, OnlyIfChanged: true}, ErrSkipped},
+		{Statement{Key: "k1", Timestamp: x.Add(3 * time.Minute), Value: StateActive, OnlyIfChanged: true}, nil},
+		{Statement{Key: "k1", Timestamp: x.Add(5 * time.Minute), Value: StateActive, OnlyIfOlderThan: 3 * time.Minute}, ErrSkipped},
+		{Statement{Key: "k1", Timestamp: x.Add(6 * time.Minute), Value: StateInactive, OnlyIfOlderThan: 3 * time.Minute}, nil},
+		{Statement{Key: "k1", Timestamp: x.Add(7 * time.Minute), Value: StateInactive, Type: StatementRoll, OnlyIfChanged: true}, ErrSkipped},
+		{Statement{Key: "k2", Timestamp: x, Value: StateActive, OnlyIfChanged: true, CreateIfNotExists: true, CreateWithTimestamp: x}, nil},
+	}
+	for i, tt := range tests {
+		if err := store.Execute(tt.statement); err != tt.want {
+			t.Fatalf("test %d: got error %v, want %v", i+1, err, tt.want)
+		}
+	}
+	if got, want := store.m["k1"].All(), []uint8{1, 1, 0, 1, 2, 2, 0}; !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if stats := store.Stats(); stats.Skipped != 3 || stats.Errors != 0 {
+		t.Fatalf("got %d skipped and %d errors, want 3 and 0", stats.Skipped, stats.Errors)
+	}
+}
+
+func TestStoreExecuteAdministrative(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	f := time.Duration(testSequenceFrequency) * time.Second
+	store := NewStore()
+	store.Add("k1", NewWithValues(x, testSequenceFrequency, []uint8{1, 1, 0, 0, 1}))
+	store.Add("k2", NewWithValues(x, testSequenceFrequency, []uint8{1}))
+	statements := []Statement{
+		{Key: "k1", Timestamp: x.Add(f), Type: StatementTrimLeft},
+		{Key: "k1", Length: 3, Type: StatementSetLength},
+		{Key: "k2", Type: StatementDelete},
+		{Key: "k3", Type: StatementDelete},
+	}
+	for _, v := range statements {
+		if err := store.Execute(v); err != nil {
+			t.Fatalf("got error %s, want error nil", err)
+		}
+	}
+	if got, want := store.m["k1"].All(), []uint8{1, 0, 0}; !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if n := store.m["k1"].Length(); n != 3 {
+		t.Fatalf("got length %d, want 3", n)
+	}
+	if _, ok := store.m["k2"]; ok {
+		t.Fatal("key k2 should not exist in store")
+	}
+	for _, v := range []Statement{
+		{Key: "k1", Type: StatementSetLength},
+		{Key: "k1", Timestamp: x, Type: StatementTrimLeft},
+		{Key: "k3", Timestamp: x, Type: StatementTrimLeft},
+	} {
+		if err := store.Execute(v); err == nil {
+			t.Fatal("got error nil, want non nil error")
+		}
+	}
+}
+
+func TestStoreExecuteDeleteMissing(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	store.Add("k1", NewWithValues(x, testSequenceFrequency, []uint8{1}))
+	store.Add("k2", NewWithValues(x, testSequenceFrequency, []uint8{1}))
+	w := store.Watch(nil, 10)
+	store.Execute(Statement{Key: "k1", Type: StatementDelete})
+	store.Execute(Statement{Key: "k3", Type: StatementDelete})
+	store.Batch([]Statement{{Key: "k1", Type: StatementDelete}})
+	if err := store.Transaction([]Statement{
+		{Key: "k2", Type: StatementDelete},
+		{Key: "k4", Type: StatementDelete},
+	}); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	w.Close()
+	var got []string
+	for e := range w.C {
+		got = append(got, e.Key)
+	}
+	if want := []string{"k1", "k2"}; !slices.Equal(got, want) {
+		t.Fatalf("got events for %v, want %v", got, want)
+	}
+	if n := store.Stats().Executed; n != 2 {
+		t.Fatalf("got %d executed statements, want 2", n)
+	}
+}
+
+func TestStoreExecuteStripes(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	store.New(x, testSequenceFrequency, "k0")
+	other := "k1"
+	for i := 2; partition(other, storeStripes) == partition("k0", storeStripes); i++ {
+		other = fmt.Sprintf("k%d", i)
+	}
+	store.New(x, testSequenceFrequency, other)
+
+	// Holds the locks of a query on k0 while executing statements.
+	store.mu.RLock()
+	keyLock(store, "k0").RLock()
+	done := make(chan error)
+	go func() {
+		done <- store.Execute(Statement{Key: other, Timestamp: x, Value: StateActive, Type: StatementAdd})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("got error %s, want error nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("statement against another stripe is blocked")
+	}
+	go func() {
+		done <- store.Execute(Statement{Key: "k0", Timestamp: x, Value: StateActive, Type: StatementAdd})
+	}()
+	select {
+	case <-done:
+		t.Fatal("statement against the same stripe is not blocked")
+	case <-time.After(10 * time.Millisecond):
+	}
+	keyLock(store, "k0").RUnlock()
+	store.mu.RUnlock()
+	if err := <-done; err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if values, _ := store.m["k0"].LastN(1); len(values) != 1 || values[0] != StateActive {
+		t.Fatalf("got %v, want [%d]", values, StateActive)
+	}
+}
+
+func TestStoreExecuteCreatePolicy(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	store.SetCreatePolicy(CreatePolicy{
+		MinFrequency: 10,
+		MaxFrequency: 3600,
+		MinLength:    2,
+		MaxLength:    100000,
+		MaxHorizon:   7 * 24 * time.Hour,
+	})
+	tests := []struct {
+		id        int
+		frequency uint16
+		length    uint32
+		want      error
+	}{
+		{1, 60, 1440, nil},
+		{2, 0, 1440, ErrInvalidFrequency},
+		{3, 5, 1440, ErrInvalidFrequency},
+		{4, 7200, 10, ErrInvalidFrequency},
+		{5, 60, 0, ErrInvalidLength},
+		{6, 60, 1, ErrInvalidLength},
+		{7, 60, 100001, ErrInvalidLength},
+		{8, 60, 10081, ErrInvalidHorizon},
+		{9, 60, 10080, nil},
+	}
+	for _, tt := range tests {
+		statement := Statement{
+			Key:                 fmt.Sprintf("k%d", tt.id),
+			Timestamp:           x,
+			Value:               StateActive,
+			CreateIfNotExists:   true,
+			CreateWithTimestamp: x,
+			CreateWithFrequency: tt.frequency,
+			CreateWithLength:    tt.length,
+		}
+		err := store.Execute(statement)
+		if !errors.Is(err, tt.want) {
+			t.Fatalf("test %d: got error %v, want %v", tt.id, err, tt.want)
+		}
+		if _, ok := store.m[statement.Key]; ok != (tt.want == nil) {
+			t.Fatalf("test %d: got %t, want %t", tt.id, ok, tt.want == nil)
+		}
+	}
+}
+
+func TestStoreExecuteCreatePolicyDefaultFrequency(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	store.SetCreatePolicy(CreatePolicy{MinFrequency: 1})
+	statement := Statement{
+		Key:                 "key",
+		Timestamp:           x,
+		Value:               StateActive,
+		CreateIfNotExists:   true,
+		CreateWithTimestamp: x,
+	}
+	if err := store.Execute(statement); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+}
+
+func TestStoreApplyRetention(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	for _, k := range []string{"a.k1", "a.k2", "b.k1", "c.k1"} {
+		store.Add(k, NewWithValues(x, 60, newSliceOfValues(10, StateActive)))
+	}
+	if n := store.ApplyRetention(x.Add(time.Hour)); n != 0 {
+		t.Fatalf("got %d, want 0", n)
+	}
+	rules := []struct {
+		glob   string
+		policy RetentionPolicy
+	}{
+		{"a.k1", RetentionPolicy{MaxLength: 2}},
+		{"a.*", RetentionPolicy{MaxAge: 5 * time.Minute}},
+		{"c.*", RetentionPolicy{MaxLength: 1}},
+		{"*", RetentionPolicy{MaxLength: 20}},
+		{"a.*", RetentionPolicy{MaxAge: 3 * time.Minute}},
+		{"c.*", RetentionPolicy{}},
+	}
+	for _, r := range rules {
+		if err := store.SetRetention(r.glob, r.policy); err != nil {
+			t.Fatalf("got error %s, want error nil", err)
+		}
+	}
+	if err := store.SetRetention("[a", RetentionPolicy{MaxLength: 1}); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if n := store.ApplyRetention(x.Add(10 * time.Minute)); n != 2 {
+		t.Fatalf("got %d, want 2", n)
+	}
+	want := map[string]uint32{"a.k1": 2, "a.k2": 3, "b.k1": 10, "c.k1": 10}
+	for k, v := range want {
+		if got := store.m[k].count; got != v {
+			t.Fatalf("%s: got %d values, want %d", k, got, v)
+		}
+	}
+	if got, want := store.m["a.k2"].Timestamp(), x.Add(7*time.Minute).Unix(); got != want {
+		t.Fatalf("got timestamp %d, want %d", got, want)
+	}
+}
+
 func TestStoreBatch(t *testing.T) {
 	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
 	f := testSequenceFrequency
@@ -216,12 +1115,12 @@ func TestStoreBatch(t *testing.T) {
 		err error
 	}
 	statements := []Statement{
-		{"k1", x.Add(time.Duration(8*f) * time.Second), StateActive, StatementAdd, true, x, f, 0},
-		{"k2", x.Add(time.Duration(8*f) * time.Second), StateActive, StatementAdd, true, x, f, 10},
-		{"k3", x.Add(-time.Duration(f) * time.Second), StateActive, StatementAdd, true, x, f, 0},
-		{"k4", x.Add(time.Duration(8*f) * time.Second), StateActive, StatementRoll, true, x, f, 0},
-		{"k5", x.Add(time.Duration(8*f) * time.Second), StateActive, StatementRoll, true, x, f, 5},
-		{"k6", x.Add(-time.Duration(f) * time.Second), StateActive, StatementRoll, true, x, f, 0},
+		{"k1", x.Add(time.Duration(8*f) * time.Second), StateActive, StatementAdd, true, x, f, 0, 0, 0, false, 0},
+		{"k2", x.Add(time.Duration(8*f) * time.Second), StateActive, StatementAdd, true, x, f, 10, 0, 0, false, 0},
+		{"k3", x.Add(-time.Duration(f) * time.Second), StateActive, StatementAdd, true, x, f, 0, 0, 0, false, 0},
+		{"k4", x.Add(time.Duration(8*f) * time.Second), StateActive, StatementRoll, true, x, f, 0, 0, 0, false, 0},
+		{"k5", x.Add(time.Duration(8*f) * time.Second), StateActive, StatementRoll, true, x, f, 5, 0, 0, false, 0},
+		{"k6", x.Add(-time.Duration(f) * time.Second), StateActive, StatementRoll, true, x, f, 0, 0, 0, false, 0},
 	}
 	store := NewStore()
 	errors := store.Batch(statements).ErrorVars()
@@ -257,6 +1156,147 @@ func TestStoreBatch(t *testing.T) {
 	}
 }
 
+func TestStoreHooks(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	wal, err := OpenWAL(filepath.Join(t.TempDir(), "store.wal"), SyncNever)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	defer wal.Close()
+	store.SetWAL(wal)
+	errInvalidKey := errors.New("invalid key")
+	counts := make(map[string]int)
+	store.SetHooks(Hooks{
+		Before: func(statement *Statement) error {
+			if !strings.HasPrefix(statement.Key, "host.") {
+				return errInvalidKey
+			}
+			statement.CreateIfNotExists = true
+			statement.CreateWithTimestamp = x
+			return nil
+		},
+		After: func(statement Statement, err error) {
+			if err == nil {
+				counts[statement.Key]++
+			}
+		},
+	})
+	if err := store.Execute(Statement{Key: "host.1", Timestamp: x, Value: StateActive}); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if err := store.Execute(Statement{Key: "link.1", Timestamp: x, Value: StateActive}); err != errInvalidKey {
+		t.Fatalf("got error %v, want %v", err, errInvalidKey)
+	}
+	statements := []Statement{
+		{Key: "link.2", Timestamp: x, Value: StateActive},
+		{Key: "host.2", Timestamp: x, Value: StateActive},
+		{Key: "host.1", Timestamp: x, Value: StateInactive},
+	}
+	result := store.Batch(statements)
+	errs := result.ErrorVars()
+	if errs[0] != errInvalidKey || errs[1] != nil || errs[2] == nil {
+		t.Fatalf("got errors %v, want [%v <nil> non nil error]", errs, errInvalidKey)
+	}
+	if statements[1].CreateIfNotExists {
+		t.Fatal("statements of the caller should not be modified")
+	}
+	if counts["host.1"] != 1 || counts["host.2"] != 1 || len(counts) != 2 {
+		t.Fatalf("got %v, want map[host.1:1 host.2:1]", counts)
+	}
+	dst := NewStore()
+	if n, err := wal.Replay(dst); err != nil || n != 3 {
+		t.Fatalf("got %d statements and error %v, want 3 and error nil", n, err)
+	}
+	if keys := dst.KeysWithPrefix(""); !slices.Equal(keys, []string{"host.1", "host.2"}) {
+		t.Fatalf("got %v, want [host.1 host.2]", keys)
+	}
+	store.SetHooks(Hooks{})
+	if err := store.Execute(Statement{Key: "link.1", Timestamp: x, Value: StateActive}); err == nil || err == errInvalidKey {
+		t.Fatalf("got error %v, want key does not exist", err)
+	}
+}
+
+func TestStoreTransaction(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	f := time.Duration(testSequenceFrequency) * time.Second
+	store := NewStore()
+	store.Add("k1", NewWithValues(x, testSequenceFrequency, []uint8{1, 1}))
+	store.Add("k2", NewWithValues(x, testSequenceFrequency, []uint8{0}))
+	want, _ := store.Dump()
+	statements := []Statement{
+		{Key: "k1", Timestamp: x.Add(2 * f), Value: StateActive, Type: StatementAdd},
+		{Key: "k2", Type: StatementDelete},
+		{Key: "k3", Timestamp: x, Value: StateActive, Type: StatementAdd, CreateIfNotExists: true, CreateWithTimestamp: x},
+		{Key: "k1", Timestamp: x, Value: StateInactive, Type: StatementAdd},
+	}
+	if err := store.Transaction(statements); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	got, _ := store.Dump()
+	if !assertDumpsEqual(t, got, want) {
+		t.Fatal("store was modified")
+	}
+	statements[3] = Statement{Key: "k1", Timestamp: x.Add(3 * f), Value: StateActive, Type: StatementAdd, OnlyIfChanged: true}
+	watcher := store.Watch(nil, 10)
+	defer watcher.Close()
+	if err := store.Transaction(statements); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if got := store.m["k1"].All(); !slices.Equal(got, []uint8{1, 1, 1}) {
+		t.Fatalf("got %v, want [1 1 1]", got)
+	}
+	if keys := store.KeysWithPrefix(""); !slices.Equal(keys, []string{"k1", "k3"}) {
+		t.Fatalf("got %v, want [k1 k3]", keys)
+	}
+	if n := len(watcher.C); n != 3 {
+		t.Fatalf("got %d events, want 3", n)
+	}
+	if stats := store.Stats(); stats.Executed != 3 || stats.Skipped != 1 {
+		t.Fatalf("got %d executed and %d skipped, want 3 and 1", stats.Executed, stats.Skipped)
+	}
+}
+
+func TestStoreContext(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	store.Add("k1", NewWithValues(x, testSequenceFrequency, []uint8{1, 1, 0}))
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := store.DumpContext(ctx); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if _, err := store.QueryMultiContext(ctx, []string{"k1"}, x, x.Add(time.Hour), time.Hour); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	statements := make([]Statement, 2*batchContextSize)
+	for i := range statements {
+		statements[i] = Statement{Key: "k2", Timestamp: x.Add(time.Duration(i) * time.Second), Value: StateActive, CreateIfNotExists: true, CreateWithTimestamp: x}
+	}
+	if result := store.BatchContext(ctx, statements); result.HasErrors() {
+		t.Fatalf("got errors %v, want no errors", result.ErrorVars())
+	}
+	cancel()
+	if _, err := store.DumpContext(ctx); err != context.Canceled {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	if err := store.DumpToContext(ctx, io.Discard); err != context.Canceled {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	if _, err := store.QueryContext(ctx, "k1", x, x.Add(time.Hour), time.Hour); err != context.Canceled {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	if _, err := store.QueryMultiContext(ctx, []string{"k1"}, x, x.Add(time.Hour), time.Hour); err != context.Canceled {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	errs := store.BatchContext(ctx, statements[:1]).ErrorVars()
+	if len(errs) != 1 || errs[0] != context.Canceled {
+		t.Fatalf("got errors %v, want [%v]", errs, context.Canceled)
+	}
+	if n := store.m["k2"].count; n != 2*batchContextSize {
+		t.Fatalf("got %d values, want %d", n, 2*batchContextSize)
+	}
+}
+
 func TestBatchResultErrorVars(t *testing.T) {
 	e1 := errors.New("e1")
 	e2 := errors.New("e2")
@@ -329,6 +1369,118 @@ func TestStoreQuery(t *testing.T) {
 	}
 }
 
+func TestStoreQueryInto(t *testing.T) {
+	f := int64(testSequenceFrequency)
+	store := NewStore()
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, testSequenceFrequency, testValues)
+	store.Add("k1", s)
+	start, end, d := shift(s, -5, -1), shift(s, 25, -1), time.Duration(f*5)*time.Second
+	var got QuerySet
+	if err := store.QueryInto("k1", &got, start, end, d); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if want, _ := s.Query(start, end, d); !assertQuerySetEqual(got, want) {
+		t.Fatalf("\ngot  %+v\nwant %+v", got, want)
+	}
+	if err := store.QueryInto("k2", &got, start, end, d); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestStoreQueryMulti(t *testing.T) {
+	f := int64(testSequenceFrequency)
+	store := NewStore()
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s1 := NewWithValues(x, testSequenceFrequency, testValues)
+	s2 := NewWithValues(x, testSequenceFrequency, []uint8{0, 1, 1})
+	store.Add("k1", s1)
+	store.Add("k2", s2)
+	start, end, d := shift(s1, -5, -1), shift(s1, 25, -1), time.Duration(f*5)*time.Second
+	got, err := store.QueryMulti([]string{"k1", "k2", "k3"}, start, end, d)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d query sets, want 2", len(got))
+	}
+	for k, s := range map[string]*Sequence{"k1": s1, "k2": s2} {
+		want, _ := s.Query(start, end, d)
+		if !assertQuerySetEqual(got[k], want) {
+			t.Fatalf("%s:\ngot  %+v\nwant %+v", k, got[k], want)
+		}
+	}
+	if _, err := store.QueryMulti([]string{"k1"}, end, start, d); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestStoreSelectorFunc(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	store := NewStore()
+	for _, k := range []string{"dc1.host1.http", "dc1.host2.http", "dc1.host1.icmp", "dc2.host1.http"} {
+		store.Add(k, NewWithValues(x, testSequenceFrequency, []uint8{0, 1, 1}))
+	}
+	selector := regexp.MustCompile(`^dc1\..*\.http$`).MatchString
+	want := []string{"dc1.host1.http", "dc1.host2.http"}
+	got := store.KeysFunc(selector)
+	sort.Strings(got)
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	qs, err := store.QueryMultiFunc(selector, x, x.Add(time.Hour), time.Hour)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if len(qs) != 2 {
+		t.Fatalf("got %d query sets, want 2", len(qs))
+	}
+	for _, k := range want {
+		if q, ok := qs[k]; !ok || q.Count[0] != 3 || q.Sum[0] != 2 {
+			t.Fatalf("%s: got %+v, want count 3 and sum 2", k, q)
+		}
+	}
+	if _, err := store.QueryMultiFunc(selector, x.Add(time.Hour), x, time.Hour); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if n := store.DeleteFunc(selector); n != 2 {
+		t.Fatalf("got %d deleted keys, want 2", n)
+	}
+	got = store.Keys()
+	sort.Strings(got)
+	if want := []string{"dc1.host1.icmp", "dc2.host1.http"}; !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestStoreQueryAggregate(t *testing.T) {
+	f := int64(testSequenceFrequency)
+	store := NewStore()
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s1 := NewWithValues(x, testSequenceFrequency, testValues)
+	s2 := NewWithValues(x, testSequenceFrequency, []uint8{0, 1, 1})
+	store.Add("web1", s1)
+	store.Add("web2", s2)
+	store.Add("db1", NewWithValues(x, 2*testSequenceFrequency, []uint8{1}))
+	start, end, d := shift(s1, -5, -1), shift(s1, 25, -1), time.Duration(f*5)*time.Second
+	web := func(key string) bool { return strings.HasPrefix(key, "web") }
+	got, err := store.QueryAggregate(web, start, end, d)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	q1, _ := s1.Query(start, end, d)
+	q2, _ := s2.Query(start, end, d)
+	if want, _ := CombineQuerySets(q1, q2); !assertQuerySetEqual(got, want) {
+		t.Fatalf("\ngot  %+v\nwant %+v", got, want)
+	}
+	if _, err := store.QueryAggregate(func(key string) bool { return key == "none" }, start, end, d); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if _, err := store.QueryAggregate(func(key string) bool { return true }, start, end, d); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
 func newSliceOfValues(n int, x uint8) []uint8 {
 	s := make([]uint8, n)
 	if x == 0 {
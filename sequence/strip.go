@@ -0,0 +1,57 @@
+package sequence
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Strip returns a terminal friendly representation of the values stored in s
+// whose timestamps are between start and end, one character per value: '█' for
+// StateActive, '░' for StateInactive, '?' for StateUnknown and the hexadecimal
+// digit of other values. Positions without any stored value are represented by
+// spaces. The method returns an error if the interval filter and the sequence
+// don't overlap.
+func (s *Sequence) Strip(start, end time.Time) (string, error) {
+	if start.After(end) {
+		return "", errors.New("invalid arguments")
+	}
+	x, y, ok := s.offsets(start, end)
+	if !ok {
+		return "", errors.New("out of bounds")
+	}
+	var b strings.Builder
+	b.Grow(int(y-x+1) * len("█"))
+	p := x
+	s.walk(x, y, func(j, n int64, v uint8) bool {
+		b.WriteString(strings.Repeat(stripSymbol(v), int(n)))
+		p = j + n
+		return true
+	})
+	b.WriteString(strings.Repeat(" ", int(y+1-p)))
+	return b.String(), nil
+}
+
+// StripValues works like Strip but represents values, as returned by
+// Sequence.Values() or Sequence.All().
+func StripValues(values []uint8) string {
+	var b strings.Builder
+	for _, v := range values {
+		b.WriteString(stripSymbol(v))
+	}
+	return b.String()
+}
+
+// stripSymbol returns the representation of v used by Strip.
+func stripSymbol(v uint8) string {
+	switch v {
+	case StateActive:
+		return "█"
+	case StateInactive:
+		return "░"
+	case StateUnknown:
+		return "?"
+	}
+	return strconv.FormatUint(uint64(v), 16)
+}
@@ -0,0 +1,38 @@
+package sequence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStrip(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, 60, []uint8{1, 1, 0, 2, 2, 1})
+	s.SetLength(8)
+	tests := []struct {
+		start, end time.Time
+		want       string
+	}{
+		{x, x.Add(7 * time.Minute), "██░??█  "},
+		{x.Add(time.Minute), x.Add(3 * time.Minute), "█░?"},
+		{x.Add(-time.Hour), x.Add(90 * time.Second), "██"},
+	}
+	for i, tt := range tests {
+		got, err := s.Strip(tt.start, tt.end)
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", i+1, err)
+		}
+		if got != tt.want {
+			t.Fatalf("test %d: got %q, want %q", i+1, got, tt.want)
+		}
+	}
+	if _, err := s.Strip(x.Add(time.Hour), x.Add(2*time.Hour)); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if _, err := s.Strip(x.Add(time.Minute), x); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if got, want := StripValues([]uint8{0, 1, 2, 3, 12}), "░█?3c"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
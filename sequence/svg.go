@@ -0,0 +1,121 @@
+package sequence
+
+import (
+	"errors"
+	"html"
+	"math"
+	"strconv"
+	"time"
+)
+
+// An SVGOptions defines how a timeline is rendered by SVG and SVGValues.
+type SVGOptions struct {
+	// Width and Height specify the size of the image in pixels,
+	// 300 and 20 if 0.
+	Width, Height int
+
+	// Colors specifies the fill color of each state, states without
+	// a color using the default one.
+	Colors map[uint8]string
+}
+
+// defaultSVGColors holds the colors used for states without a user defined one.
+var defaultSVGColors = map[uint8]string{
+	StateInactive: "#e5534b",
+	StateActive:   "#3fb950",
+	StateUnknown:  "#c9d1d9",
+}
+
+// defaultSVGColor is the color of states without a color.
+const defaultSVGColor = "#8b949e"
+
+// SVG returns an SVG image representing the values stored in s whose timestamps
+// are between start and end as a timeline of colored bands, one per series of
+// identical values. The timeline spans the interval, positions without any
+// stored value being left blank. The method returns an error if the interval
+// filter and the sequence don't overlap.
+func (s *Sequence) SVG(start, end time.Time, opts SVGOptions) ([]byte, error) {
+	if start.After(end) {
+		return nil, errors.New("invalid arguments")
+	}
+	x, y, ok := s.offsets(start, end)
+	if !ok {
+		return nil, errors.New("out of bounds")
+	}
+	return renderSVG(y-x+1, opts, func(fn func(j, n int64, v uint8) bool) {
+		s.walk(x, y, func(j, n int64, v uint8) bool {
+			return fn(j-x, n, v)
+		})
+	}), nil
+}
+
+// SVGValues works like SVG but renders values, as returned by Sequence.Values()
+// or Sequence.All().
+func SVGValues(values []uint8, opts SVGOptions) []byte {
+	return renderSVG(int64(len(values)), opts, func(fn func(j, n int64, v uint8) bool) {
+		for i := 0; i < len(values); {
+			j := i + 1
+			for j < len(values) && values[j] == values[i] {
+				j++
+			}
+			if !fn(int64(i), int64(j-i), values[i]) {
+				return
+			}
+			i = j
+		}
+	})
+}
+
+// renderSVG returns an SVG image of a timeline of total positions, walk calling
+// its argument for each series of values.
+func renderSVG(total int64, opts SVGOptions, walk func(fn func(j, n int64, v uint8) bool)) []byte {
+	width, height := opts.Width, opts.Height
+	if width <= 0 {
+		width = 300
+	}
+	if height <= 0 {
+		height = 20
+	}
+	w, h := strconv.Itoa(width), strconv.Itoa(height)
+	buf := []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="` + w + `" height="` + h +
+		`" viewBox="0 0 ` + w + " " + h + `" preserveAspectRatio="none">`)
+	scale := float64(width) / float64(total)
+	var band struct {
+		j, n int64
+		v    uint8
+	}
+	appendBand := func() {
+		if band.n == 0 {
+			return
+		}
+		color, ok := opts.Colors[band.v]
+		if !ok {
+			if color, ok = defaultSVGColors[band.v]; !ok {
+				color = defaultSVGColor
+			}
+		}
+		buf = append(buf, `<rect x="`...)
+		buf = appendSVGFloat(buf, float64(band.j)*scale)
+		buf = append(buf, `" width="`...)
+		buf = appendSVGFloat(buf, float64(band.n)*scale)
+		buf = append(buf, `" height="`+h+`" fill="`...)
+		buf = append(buf, html.EscapeString(color)...)
+		buf = append(buf, `"/>`...)
+	}
+	walk(func(j, n int64, v uint8) bool {
+		if band.n > 0 && band.v == v && band.j+band.n == j {
+			band.n += n
+			return true
+		}
+		appendBand()
+		band.j, band.n, band.v = j, n, v
+		return true
+	})
+	appendBand()
+	return append(buf, "</svg>"...)
+}
+
+// appendSVGFloat appends x rounded to 2 decimal places to dst.
+func appendSVGFloat(dst []byte, x float64) []byte {
+	return strconv.AppendFloat(dst, math.Round(x*100)/100, 'f', -1, 64)
+}
@@ -0,0 +1,71 @@
+package sequence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSVG(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 15:04:05", testSequenceTimestamp)
+	s := NewWithValues(x, 60, []uint8{1, 1, 0, 2, 2, 1})
+	s.SetLength(8)
+	const header = `<svg xmlns="http://www.w3.org/2000/svg" width="80" height="10" viewBox="0 0 80 10" preserveAspectRatio="none">`
+	tests := []struct {
+		start, end time.Time
+		opts       SVGOptions
+		want       string
+	}{
+		{
+			x,
+			x.Add(7 * time.Minute),
+			SVGOptions{Width: 80, Height: 10},
+			header +
+				`<rect x="0" width="20" height="10" fill="#3fb950"/>` +
+				`<rect x="20" width="10" height="10" fill="#e5534b"/>` +
+				`<rect x="30" width="20" height="10" fill="#c9d1d9"/>` +
+				`<rect x="50" width="10" height="10" fill="#3fb950"/>` +
+				`</svg>`,
+		},
+		{
+			x.Add(time.Minute),
+			x.Add(3 * time.Minute),
+			SVGOptions{Width: 80, Height: 10, Colors: map[uint8]string{StateUnknown: `"grey"`}},
+			header +
+				`<rect x="0" width="26.67" height="10" fill="#3fb950"/>` +
+				`<rect x="26.67" width="26.67" height="10" fill="#e5534b"/>` +
+				`<rect x="53.33" width="26.67" height="10" fill="&#34;grey&#34;"/>` +
+				`</svg>`,
+		},
+	}
+	for i, tt := range tests {
+		got, err := s.SVG(tt.start, tt.end, tt.opts)
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", i+1, err)
+		}
+		if string(got) != tt.want {
+			t.Fatalf("test %d:\ngot  %s\nwant %s", i+1, got, tt.want)
+		}
+	}
+	if _, err := s.SVG(x.Add(time.Hour), x.Add(2*time.Hour), SVGOptions{}); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if _, err := s.SVG(x.Add(time.Minute), x, SVGOptions{}); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestSVGValues(t *testing.T) {
+	got := SVGValues([]uint8{0, 0, 5, 1}, SVGOptions{})
+	want := `<svg xmlns="http://www.w3.org/2000/svg" width="300" height="20" viewBox="0 0 300 20" preserveAspectRatio="none">` +
+		`<rect x="0" width="150" height="20" fill="#e5534b"/>` +
+		`<rect x="150" width="75" height="20" fill="#8b949e"/>` +
+		`<rect x="225" width="75" height="20" fill="#3fb950"/>` +
+		`</svg>`
+	if string(got) != want {
+		t.Fatalf("\ngot  %s\nwant %s", got, want)
+	}
+	want = `<svg xmlns="http://www.w3.org/2000/svg" width="300" height="20" viewBox="0 0 300 20" preserveAspectRatio="none"></svg>`
+	if got := SVGValues(nil, SVGOptions{}); string(got) != want {
+		t.Fatalf("\ngot  %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,304 @@
+package sequence
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// A SyncPolicy specifies when a WAL commits its content to stable storage.
+type SyncPolicy uint8
+
+// Sync policies.
+const (
+	// SyncAlways commits the log before executing statements, so that
+	// executed statements survive crashes of the operating system.
+	SyncAlways SyncPolicy = iota
+
+	// SyncNever leaves committing the log to the operating system, unless
+	// WAL.Sync is called. Executed statements survive crashes of the
+	// process but not necessarily crashes of the operating system.
+	SyncNever
+)
+
+// A WAL is a write-ahead log of the statements executed against a store,
+// allowing to recover the statements executed since the last snapshot of the
+// store after a crash. A WAL can be used simultaneously from multiple goroutines.
+//
+// The log is made of a header, holding the generation of the log, and of
+// records, each record holding the size of its payload shifted left by one bit,
+// the low bit being set for transactions, the payload and its CRC-32 checksum.
+// The payload of a record is a statement encoded as in MarshalProto, or for a
+// transaction a sequence of such statements each preceded by its size, so that
+// a transaction is either replayed as a whole or not at all.
+type WAL struct {
+	f      *os.File
+	policy SyncPolicy
+	size   int64
+	gen    uint64
+	buf    []byte
+	mu     sync.Mutex
+}
+
+const (
+	walMagic   = "\x89RLW"
+	walVersion = 3
+	walHeader  = len(walMagic) + 1 + 8
+
+	// walMaxRecord is the maximum size of the payload of a record.
+	walMaxRecord = 1 << 24
+)
+
+// OpenWAL opens the log named path, creating it if it does not exist. Records
+// following the last complete and valid record, typically a record partially
+// written during a crash, are discarded. It returns an error if the file is not
+// a log or if the underlying operations returned an error.
+func OpenWAL(path string, policy SyncPolicy) (*WAL, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	l := &WAL{f: f, policy: policy}
+	if err := l.open(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// open validates the header of the log, writing it if the log is empty, and
+// truncates the log after its last valid record.
+func (l *WAL) open() error {
+	info, err := l.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return l.reset(0)
+	}
+	header := make([]byte, walHeader)
+	if _, err := io.ReadFull(l.f, header); err != nil || string(header[:len(walMagic)]) != walMagic {
+		return errors.New("invalid log")
+	}
+	if header[len(walMagic)] != walVersion {
+		return errors.New("unsupported format version")
+	}
+	l.gen = binary.LittleEndian.Uint64(header[len(walMagic)+1:])
+	size, err := l.scan(nil)
+	if err != nil {
+		return err
+	}
+	if size != info.Size() {
+		if err := l.f.Truncate(size); err != nil {
+			return err
+		}
+	}
+	l.size = size
+	_, err = l.f.Seek(size, io.SeekStart)
+	return err
+}
+
+// scan reads the records of the log, calling fn, if not nil, with each valid
+// statement, and returns the offset following the last valid record.
+func (l *WAL) scan(fn func(statements []Statement)) (int64, error) {
+	r := bufio.NewReader(io.NewSectionReader(l.f, int64(walHeader), 1<<62))
+	offset := int64(walHeader)
+	var buf []byte
+	var statements []Statement
+	for {
+		h, err := binary.ReadUvarint(r)
+		n := h >> 1
+		if err != nil || n > walMaxRecord {
+			return offset, nil
+		}
+		if uint64(cap(buf)) < n+4 {
+			buf = make([]byte, n+4)
+		}
+		buf = buf[:n+4]
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return offset, nil
+		}
+		if crc32.ChecksumIEEE(buf[:n]) != binary.LittleEndian.Uint32(buf[n:]) {
+			return offset, nil
+		}
+		statements = statements[:0]
+		if h&1 == 0 {
+			statement, err := UnmarshalProtoStatement(buf[:n])
+			if err != nil {
+				return offset, nil
+			}
+			statements = append(statements, statement)
+		} else {
+			for p := buf[:n]; len(p) > 0; {
+				size, k := binary.Uvarint(p)
+				if k <= 0 || size > uint64(len(p)-k) {
+					return offset, nil
+				}
+				statement, err := UnmarshalProtoStatement(p[k : k+int(size)])
+				if err != nil {
+					return offset, nil
+				}
+				statements = append(statements, statement)
+				p = p[k+int(size):]
+			}
+		}
+		if fn != nil {
+			fn(statements)
+		}
+		offset += int64(uvarintSize(h)) + int64(n) + 4
+	}
+}
+
+// Replay executes the statements of the log against s, in order, and returns
+// the number of statements read. Errors returned by statements are ignored, as
+// they were when the statements were originally executed, and statements logged
+// by Store.Transaction are executed atomically. Replay is meant to be
+// called on startup, after loading the last snapshot of the store and before
+// attaching the log to the store with Store.SetWAL.
+func (l *WAL) Replay(s *Store) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := 0
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := l.scan(func(statements []Statement) {
+		if len(statements) == 1 {
+			s.executeUnsafe(statements[0])
+		} else {
+			s.transactionUnsafe(statements)
+		}
+		n += len(statements)
+	})
+	return n, err
+}
+
+// Sync commits the content of the log to stable storage.
+func (l *WAL) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Sync()
+}
+
+// Generation returns the generation of the log, 0 for a new log, which is
+// incremented each time the log is truncated. Snapshots of a store can record
+// the generation of the log following their truncation of the log, so that the
+// statements they already include are not replayed if a crash occurs before the
+// log is truncated.
+func (l *WAL) Generation() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.gen
+}
+
+// Truncate discards all the records of the log and increments its generation.
+// It is meant to be called once a snapshot of the store including all the logged
+// statements is written, while preventing the execution of new statements.
+func (l *WAL) Truncate() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.reset(l.gen + 1)
+}
+
+// truncate discards all the records of the log and sets its generation to gen.
+func (l *WAL) truncate(gen uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.reset(gen)
+}
+
+// reset discards all the records of the log and writes its header using gen as
+// generation. The records are discarded and committed before the header is
+// written, so that the records of a generation never survive a crash under a
+// more recent generation.
+func (l *WAL) reset(gen uint64) error {
+	if err := l.f.Truncate(int64(walHeader)); err != nil {
+		return err
+	}
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	header := append([]byte(walMagic), walVersion)
+	header = binary.LittleEndian.AppendUint64(header, gen)
+	if _, err := l.f.WriteAt(header, 0); err != nil {
+		return err
+	}
+	if _, err := l.f.Seek(int64(walHeader), io.SeekStart); err != nil {
+		return err
+	}
+	l.size = int64(walHeader)
+	l.gen = gen
+	return l.f.Sync()
+}
+
+// Close commits the content of the log to stable storage and closes it.
+func (l *WAL) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.f.Sync()
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// append writes statements to the log and commits them according to the sync
+// policy of the log. On error, the log is truncated after its last committed
+// record, as statements are not executed.
+func (l *WAL) append(statements ...Statement) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	buf := l.buf[:0]
+	for _, v := range statements {
+		data := v.MarshalProto()
+		buf = binary.AppendUvarint(buf, uint64(len(data))<<1)
+		buf = append(buf, data...)
+		buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(data))
+	}
+	return l.write(buf)
+}
+
+// appendTransaction works like append but writes statements as a single record,
+// so that they are replayed atomically.
+func (l *WAL) appendTransaction(statements []Statement) error {
+	if len(statements) == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var payload []byte
+	for _, v := range statements {
+		data := v.MarshalProto()
+		payload = binary.AppendUvarint(payload, uint64(len(data)))
+		payload = append(payload, data...)
+	}
+	if len(payload) > walMaxRecord {
+		return errors.New("transaction too large")
+	}
+	buf := binary.AppendUvarint(l.buf[:0], uint64(len(payload))<<1|1)
+	buf = append(buf, payload...)
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(payload))
+	return l.write(buf)
+}
+
+// write writes buf, holding complete records, to the log and commits it
+// according to the sync policy of the log. On error, the log is truncated after
+// its last committed record. The caller is responsible for properly acquiring /
+// releasing the lock on the log.
+func (l *WAL) write(buf []byte) error {
+	l.buf = buf
+	_, err := l.f.Write(buf)
+	if err == nil && l.policy == SyncAlways {
+		err = l.f.Sync()
+	}
+	if err != nil {
+		l.f.Truncate(l.size)
+		l.f.Seek(l.size, io.SeekStart)
+		return err
+	}
+	l.size += int64(len(buf))
+	return nil
+}
@@ -0,0 +1,160 @@
+package sequence
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.wal")
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	create := func(key string, offset int, value uint8) Statement {
+		return Statement{
+			Key:                 key,
+			Timestamp:           x.Add(time.Duration(offset) * time.Minute),
+			Value:               value,
+			Type:                StatementAdd,
+			CreateIfNotExists:   true,
+			CreateWithTimestamp: x,
+			CreateWithFrequency: 60,
+			CreateWithLength:    100,
+		}
+	}
+	for i, policy := range []SyncPolicy{SyncAlways, SyncNever} {
+		os.Remove(path)
+		l, err := OpenWAL(path, policy)
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", i+1, err)
+		}
+		src := NewStore()
+		src.SetWAL(l)
+		src.Execute(create("k1", 0, StateActive))
+		src.Execute(create("k1", 0, StateActive))
+		src.Batch([]Statement{create("k1", 1, StateInactive), create("k2", 3, StateActive)})
+		NewKeyedStore[int](src, KeyCodecFunc[int](func(dst []byte, k int) []byte {
+			return append(dst, "k3"...)
+		})).Execute(0, create("", 2, StateUnknown))
+		src.Execute(Statement{Key: "unknown", Type: StatementAdd})
+		if err := l.Close(); err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", i+1, err)
+		}
+
+		l, err = OpenWAL(path, policy)
+		if err != nil {
+			t.Fatalf("test %d: got error %s, want error nil", i+1, err)
+		}
+		dst := NewStore()
+		n, err := l.Replay(dst)
+		if err != nil || n != 5 {
+			t.Fatalf("test %d: got %d statements and error %v, want 5 statements and error nil", i+1, n, err)
+		}
+		if n, m := len(dst.m), len(src.m); n != m {
+			t.Fatalf("test %d: got %d sequences, want %d", i+1, n, m)
+		}
+		for k := range src.m {
+			if v, ok := dst.m[k]; !ok || !assertSequencesEqual(src.m[k], v) {
+				t.Fatalf("test %d: key %q: got %+v, want %+v", i+1, k, v, src.m[k])
+			}
+		}
+		l.Close()
+	}
+
+	info, _ := os.Stat(path)
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte{0x10, 0x0a, 0x02})
+	f.Close()
+	l, err := OpenWAL(path, SyncAlways)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if got, _ := os.Stat(path); got.Size() != info.Size() {
+		t.Fatalf("got size %d, want %d", got.Size(), info.Size())
+	}
+	store := NewStore()
+	store.SetWAL(l)
+	if err := store.Execute(create("k4", 0, StateActive)); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if n, _ := l.Replay(NewStore()); n != 6 {
+		t.Fatalf("got %d statements, want 6", n)
+	}
+	if err := l.Truncate(); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if n, _ := l.Replay(NewStore()); n != 0 {
+		t.Fatalf("got %d statements, want 0", n)
+	}
+	l.Close()
+	l, err = OpenWAL(path, SyncAlways)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	if gen := l.Generation(); gen != 1 {
+		t.Fatalf("got generation %d, want 1", gen)
+	}
+	l.Close()
+	if err := store.Execute(create("k5", 0, StateActive)); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+	if r := store.Batch([]Statement{create("k5", 0, StateActive)}); !r.HasErrors() {
+		t.Fatal("got no errors, want errors")
+	}
+	if _, ok := store.m["k5"]; ok {
+		t.Fatal("key k5 should not exist in store")
+	}
+
+	invalid := filepath.Join(t.TempDir(), "invalid.wal")
+	os.WriteFile(invalid, []byte("invalid"), 0o644)
+	if _, err := OpenWAL(invalid, SyncAlways); err == nil {
+		t.Fatal("got error nil, want non nil error")
+	}
+}
+
+func TestWALTransaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.wal")
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	create := func(key string) Statement {
+		return Statement{
+			Key:                 key,
+			Timestamp:           x,
+			Value:               StateActive,
+			CreateIfNotExists:   true,
+			CreateWithTimestamp: x,
+		}
+	}
+	l, err := OpenWAL(path, SyncAlways)
+	if err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	src := NewStore()
+	src.SetWAL(l)
+	src.Execute(create("k1"))
+	if err := src.Transaction([]Statement{create("k2"), create("k3"), {Key: "k1", Type: StatementDelete}}); err != nil {
+		t.Fatalf("got error %s, want error nil", err)
+	}
+	l.Close()
+
+	info, _ := os.Stat(path)
+	for _, size := range []int64{info.Size(), info.Size() - 1, info.Size() - 10} {
+		os.Truncate(path, size)
+		l, err := OpenWAL(path, SyncAlways)
+		if err != nil {
+			t.Fatalf("size %d: got error %s, want error nil", size, err)
+		}
+		dst := NewStore()
+		l.Replay(dst)
+		l.Close()
+		want := []string{"k1"}
+		if size == info.Size() {
+			want = []string{"k2", "k3"}
+		}
+		keys := dst.Keys()
+		slices.Sort(keys)
+		if !slices.Equal(keys, want) {
+			t.Fatalf("size %d: got keys %v, want %v", size, keys, want)
+		}
+	}
+}
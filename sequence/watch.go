@@ -0,0 +1,115 @@
+package sequence
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A ChangeEvent describes a statement successfully executed against a store.
+type ChangeEvent struct {
+	Key       string
+	Timestamp time.Time
+	Value     uint8
+	Type      uint8
+	Count     uint32
+}
+
+// A Watcher delivers the change events of the keys of a store satisfying a
+// selector, as returned by Store.Watch. A Watcher can be used simultaneously
+// from multiple goroutines.
+type Watcher struct {
+	// C delivers the change events. It is closed by Close.
+	C <-chan ChangeEvent
+
+	c        chan ChangeEvent
+	selector func(key string) bool
+	store    *Store
+	dropped  atomic.Uint64
+	mu       sync.Mutex // guards c and closed
+	closed   bool
+}
+
+// Watch returns a Watcher delivering the events of the statements executed
+// against the keys of the store satisfying selector, or against all keys if
+// selector is nil. Events are buffered up to size events and delivered without
+// blocking the store: events that do not fit in the buffer are dropped, see
+// Dropped. Events of statements executed concurrently against sequences of
+// different stripes may be delivered out of order. Modifications of the store
+// other than statements, such as Add or Delete, are not notified. The selector
+// is called while holding the lock of the store and must not call its methods.
+func (s *Store) Watch(selector func(key string) bool, size int) *Watcher {
+	c := make(chan ChangeEvent, max(size, 0))
+	w := &Watcher{C: c, c: c, selector: selector, store: s}
+	s.watch.Lock()
+	defer s.watch.Unlock()
+	var watchers []*Watcher
+	if p := s.watchers.Load(); p != nil {
+		watchers = slices.Clone(*p)
+	}
+	watchers = append(watchers, w)
+	s.watchers.Store(&watchers)
+	return w
+}
+
+// Close stops the delivery of events and closes C. It has no effect if the
+// watcher is already closed.
+func (w *Watcher) Close() {
+	s := w.store
+	s.watch.Lock()
+	if p := s.watchers.Load(); p != nil {
+		watchers := slices.DeleteFunc(slices.Clone(*p), func(v *Watcher) bool {
+			return v == w
+		})
+		s.watchers.Store(&watchers)
+	}
+	s.watch.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.c)
+	}
+}
+
+// Dropped returns the number of events dropped because the buffer of the
+// watcher was full.
+func (w *Watcher) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+// send delivers e without blocking, unless the watcher is closed.
+func (w *Watcher) send(e ChangeEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.c <- e:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// notify delivers the event of statement, executed against key, to the
+// watchers of s whose selector is satisfied by key.
+func notify[K string | []byte](s *Store, key K, statement Statement) {
+	p := s.watchers.Load()
+	if p == nil || len(*p) == 0 {
+		return
+	}
+	e := ChangeEvent{
+		Key:       string(key),
+		Timestamp: statement.Timestamp,
+		Value:     statement.Value,
+		Type:      statement.Type,
+		Count:     statement.Count,
+	}
+	for _, w := range *p {
+		if w.selector == nil || w.selector(e.Key) {
+			w.send(e)
+		}
+	}
+}
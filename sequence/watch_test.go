@@ -0,0 +1,56 @@
+package sequence
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreWatch(t *testing.T) {
+	x, _ := time.Parse("2006-01-02 03:04:05", testSequenceTimestamp)
+	store := NewStore()
+	all := store.Watch(nil, 10)
+	hosts := store.Watch(func(key string) bool { return strings.HasPrefix(key, "host.") }, 1)
+	statements := []Statement{
+		{Key: "host.1", Timestamp: x, Value: StateActive, Type: StatementAdd, CreateIfNotExists: true, CreateWithTimestamp: x},
+		{Key: "host.1", Timestamp: x, Value: StateActive, Type: StatementAdd},
+		{Key: "host.2", Timestamp: x, Value: StateInactive, Type: StatementRoll, CreateIfNotExists: true, CreateWithTimestamp: x},
+		{Key: "link.1", Timestamp: x, Value: StateInactive, Type: StatementRoll, CreateIfNotExists: true, CreateWithTimestamp: x},
+	}
+	store.Batch(statements)
+	ks := NewKeyedStore[string](store, KeyCodecFunc[string](func(dst []byte, k string) []byte { return append(dst, k...) }))
+	ks.Execute("link.1", Statement{Timestamp: x.Add(time.Minute), Value: StateActive, Type: StatementRollReplace})
+	all.Close()
+	var got []ChangeEvent
+	for e := range all.C {
+		got = append(got, e)
+	}
+	want := []ChangeEvent{
+		{Key: "host.1", Timestamp: x, Value: StateActive, Type: StatementAdd},
+		{Key: "host.2", Timestamp: x, Value: StateInactive, Type: StatementRoll},
+		{Key: "link.1", Timestamp: x, Value: StateInactive, Type: StatementRoll},
+		{Key: "link.1", Timestamp: x.Add(time.Minute), Value: StateActive, Type: StatementRollReplace},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("event %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if e := <-hosts.C; e.Key != "host.1" {
+		t.Fatalf("got key %s, want host.1", e.Key)
+	}
+	if n := hosts.Dropped(); n != 1 {
+		t.Fatalf("got %d dropped events, want 1", n)
+	}
+	hosts.Close()
+	hosts.Close()
+	if _, ok := <-hosts.C; ok {
+		t.Fatal("channel should be closed")
+	}
+	if n := len(*store.watchers.Load()); n != 0 {
+		t.Fatalf("got %d watchers, want 0", n)
+	}
+}